@@ -9,18 +9,25 @@ type CLI struct {
 	Add            commands.AddCmd            `help:"Add media to database"                               cmd:""`
 	Check          commands.CheckCmd          `help:"Check for missing files and mark as deleted"         cmd:""`
 	Print          commands.PrintCmd          `help:"Print media information"                             cmd:""`
+	Export         commands.ExportCmd         `help:"Export media as CSV, JSONL, Markdown or HTML"        cmd:""`
 	Search         commands.SearchCmd         `help:"Search media using FTS"                              cmd:""`
 	SearchCaptions commands.SearchCaptionsCmd `help:"Search captions using FTS"                           cmd:"" aliases:"sc"`
 	Playlists      commands.PlaylistsCmd      `help:"List scan roots (playlists)"                         cmd:""`
+	Volumes        commands.VolumesCmd        `help:"List scan roots and whether they are mounted"        cmd:""`
+	Feeds          commands.FeedsCmd          `help:"Subscribe to podcast/RSS feeds"                      cmd:""`
 	SearchDB       commands.SearchDBCmd       `help:"Search arbitrary database table"                     cmd:"" aliases:"sdb"`
 	MediaCheck     commands.MediaCheckCmd     `help:"Check media files for corruption"                    cmd:"" aliases:"mc"`
+	Verify         commands.VerifyCmd         `help:"Verify checksums to detect silent corruption"        cmd:""`
 	FilesInfo      commands.FilesInfoCmd      `help:"Show information about files"                        cmd:"" aliases:"fs"`
 	DiskUsage      commands.DiskUsageCmd      `help:"Show disk usage aggregation"                         cmd:"" aliases:"du"`
 	Dedupe         commands.DedupeCmd         `help:"Dedupe similar media"                                cmd:"" aliases:"dedupe-media" name:"dedupe"`
 	BigDirs        commands.BigDirsCmd        `help:"Show big directories aggregation"                    cmd:"" aliases:"bigdirs,bd"`
+	FreeSpace      commands.FreeSpaceCmd      `help:"Plan which files to delete to free space"            cmd:""                        name:"free-space"`
 	Categorize     commands.CategorizeCmd     `help:"Auto-group media into categories"                    cmd:""`
+	TagWrite       commands.TagWriteCmd       `help:"Edit tags in the database and media files"           cmd:""                        name:"tag-write"`
 	SimilarFiles   commands.SimilarFilesCmd   `help:"Find similar files"                                  cmd:"" aliases:"sf"`
 	SimilarFolders commands.SimilarFoldersCmd `help:"Find similar folders"                                cmd:"" aliases:"sh"`
+	Organize       commands.OrganizeCmd       `help:"Move files into a library layout from metadata"      cmd:""`
 	Watch          commands.WatchCmd          `help:"Watch videos with mpv"                               cmd:""`
 	Listen         commands.ListenCmd         `help:"Listen to audio with mpv"                            cmd:""`
 	Stats          commands.StatsCmd          `help:"Show library statistics"                             cmd:""`
@@ -30,6 +37,8 @@ type CLI struct {
 	Serve          commands.ServeCmd          `help:"Start Web UI server"                                 cmd:""`
 	Optimize       commands.OptimizeCmd       `help:"Optimize database (VACUUM, ANALYZE, FTS optimize)"   cmd:""`
 	Repair         commands.RepairCmd         `help:"Repair malformed database using sqlite3"             cmd:""`
+	RemapPaths     commands.RemapPathsCmd     `help:"Replace a path prefix in every table"                cmd:""                        name:"remap-paths"`
+	Rebalance      commands.RebalanceCmd      `help:"Move folders between disks to even out free space"   cmd:""`
 	Readme         commands.ReadmeCmd         `help:"Generate README.md content"                          cmd:""`
 	RegexSort      commands.RegexSortCmd      `help:"Sort by splitting lines and sorting words"           cmd:"" aliases:"rs"`
 	ClusterSort    commands.ClusterSortCmd    `help:"Group items by similarity"                           cmd:"" aliases:"cs"`
@@ -41,7 +50,10 @@ type CLI struct {
 	Stop           commands.StopCmd           `help:"Stop mpv playback"                                   cmd:""`
 	Pause          commands.PauseCmd          `help:"Toggle mpv pause state"                              cmd:"" aliases:"play"`
 	Seek           commands.SeekCmd           `help:"Seek mpv playback"                                   cmd:"" aliases:"ffwd,rewind"`
+	Cast           commands.CastCmd           `help:"Chromecast devices"                                  cmd:""`
 	MergeDBs       commands.MergeDBsCmd       `help:"Merge multiple SQLite databases"                     cmd:"" aliases:"mergedbs"     name:"merge-dbs"`
+	Import         commands.ImportCmd         `help:"Import playstate from Jellyfin, Plex or Kodi"        cmd:""`
+	Sync           commands.SyncCmd           `help:"Sync playstate between two libraries"                cmd:""`
 	Explode        commands.ExplodeCmd        `help:"Create symlinks for all subcommands (busybox-style)" cmd:""`
 	Update         commands.UpdateCmd         `help:"Check for and install updates from GitHub"           cmd:""`
 	Version        commands.VersionCmd        `help:"Show version and build information"                  cmd:""`
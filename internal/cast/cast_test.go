@@ -0,0 +1,300 @@
+package cast_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/cast"
+	"github.com/chapmanjacobd/discoteca/internal/cast/casttest"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	in := &cast.Message{
+		SourceID:      "sender-0",
+		DestinationID: "receiver-0",
+		Namespace:     "urn:x-cast:com.google.cast.receiver",
+		PayloadUTF8:   `{"type":"GET_STATUS","requestId":1}`,
+	}
+	var buf bytes.Buffer
+	if err := cast.WriteMessage(&buf, in); err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.BigEndian.Uint32(buf.Bytes()); int(size) != buf.Len()-4 {
+		t.Errorf("Frame length %d does not match body %d", size, buf.Len()-4)
+	}
+
+	out, err := cast.ReadMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.SourceID != in.SourceID || out.DestinationID != in.DestinationID || out.Namespace != in.Namespace ||
+		out.PayloadUTF8 != in.PayloadUTF8 || out.PayloadType != cast.PayloadString {
+		t.Errorf("Round trip mismatch: %+v", out)
+	}
+
+	// Known protobuf encoding of a minimal CastMessage
+	want := []byte{
+		0x08, 0x00, 0x12, 0x01, 'a', 0x1a, 0x01, 'b', 0x22, 0x01, 'c', 0x28, 0x00, 0x32, 0x02, '{', '}',
+	}
+	got, _ := (&cast.Message{SourceID: "a", DestinationID: "b", Namespace: "c", PayloadUTF8: "{}"}).MarshalBinary()
+	if !bytes.Equal(got, want) {
+		t.Errorf("MarshalBinary() = %x, want %x", got, want)
+	}
+}
+
+func dial(t *testing.T, r *casttest.Receiver) *cast.Client {
+	t.Helper()
+	c, err := cast.Dial(context.Background(), r.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClientPlayback(t *testing.T) {
+	r := casttest.NewReceiver(t)
+	var loaded cast.MediaInfo
+	r.OnLoad = func(m cast.MediaInfo) error {
+		loaded = m
+		return nil
+	}
+	ctx := context.Background()
+	c := dial(t, r)
+
+	media := cast.MediaInfo{
+		ContentID:   "http://192.168.1.2:5555/api/raw?path=%2Fmedia%2Fa.mp4&token=x",
+		ContentType: "video/mp4",
+		Duration:    300,
+	}
+	st, err := c.Load(ctx, media, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.PlayerState != cast.StatePlaying || st.CurrentTime != 30 {
+		t.Errorf("Unexpected status after load: %+v", st)
+	}
+	if loaded.ContentID != media.ContentID || loaded.StreamType != "BUFFERED" {
+		t.Errorf("Receiver got %+v", loaded)
+	}
+
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := r.Status(); state != cast.StatePaused {
+		t.Errorf("Expected paused, got %s", state)
+	}
+	if err := c.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := r.Status(); state != cast.StatePlaying {
+		t.Errorf("Expected playing, got %s", state)
+	}
+
+	if err := c.Seek(ctx, -10, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, pos := r.Status(); pos != 20 {
+		t.Errorf("Expected relative seek to 20, got %v", pos)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Path != "/media/a.mp4" || status.Position != 20 || status.Duration != 300 || status.Paused {
+		t.Errorf("Unexpected player status: %+v", status)
+	}
+
+	want := []string{"GET_STATUS", "LAUNCH", "LOAD", "GET_STATUS", "PAUSE", "GET_STATUS", "PLAY", "GET_STATUS", "SEEK"}
+	if got := r.Commands(); !slices.Equal(got[:len(want)], want) {
+		t.Errorf("Receiver commands = %v, want prefix %v", got, want)
+	}
+}
+
+func TestClientWait(t *testing.T) {
+	r := casttest.NewReceiver(t)
+	ctx := context.Background()
+	c := dial(t, r)
+
+	if _, err := c.Load(ctx, cast.MediaInfo{ContentID: "http://x/a.mp3", ContentType: "audio/mpeg"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan cast.MediaStatus, 1)
+	go func() {
+		st, err := c.Wait(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- st
+	}()
+	r.Advance(42)
+	time.Sleep(50 * time.Millisecond)
+	r.Finish()
+
+	select {
+	case st := <-done:
+		if st.IdleReason != cast.IdleFinished || st.CurrentTime != 42 {
+			t.Errorf("Expected FINISHED at 42s, got %+v", st)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not return after the item finished")
+	}
+}
+
+func TestClientControlFromAnotherSender(t *testing.T) {
+	r := casttest.NewReceiver(t)
+	ctx := context.Background()
+	sender := dial(t, r)
+	if _, err := sender.Load(ctx, cast.MediaInfo{ContentID: "http://x/a.mp4", ContentType: "video/mp4"}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		_, err := sender.Wait(ctx)
+		waitErr <- err
+	}()
+
+	controller := dial(t, r)
+	if err := controller.Attach(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := controller.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-waitErr:
+		if !errors.Is(err, cast.ErrSessionClosed) {
+			t.Errorf("Expected ErrSessionClosed, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait did not notice the session was stopped")
+	}
+
+	idle := dial(t, r)
+	if err := idle.Attach(ctx); !errors.Is(err, cast.ErrNoSession) {
+		t.Errorf("Expected ErrNoSession after stop, got %v", err)
+	}
+}
+
+func TestClientLoadFailed(t *testing.T) {
+	r := casttest.NewReceiver(t)
+	r.OnLoad = func(cast.MediaInfo) error { return errors.New("unreachable") }
+	c := dial(t, r)
+
+	_, err := c.Load(context.Background(), cast.MediaInfo{ContentID: "http://x/a.mkv"}, 0)
+	if err == nil || !strings.Contains(err.Error(), "LOAD_FAILED") {
+		t.Errorf("Expected LOAD_FAILED, got %v", err)
+	}
+}
+
+// mdnsResponse builds an answer for one device, as a Chromecast would send it
+func mdnsResponse(instance, friendlyName, model, target string, ip net.IP, port uint16) []byte {
+	name := func(s string) []byte {
+		var b []byte
+		for label := range strings.SplitSeq(strings.TrimSuffix(s, "."), ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		return append(b, 0)
+	}
+	record := func(owner string, rtype uint16, rdata []byte) []byte {
+		b := name(owner)
+		b = binary.BigEndian.AppendUint16(b, rtype)
+		b = binary.BigEndian.AppendUint16(b, 1)
+		b = binary.BigEndian.AppendUint32(b, 120)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(rdata)))
+		return append(b, rdata...)
+	}
+
+	var txt []byte
+	for _, kv := range []string{"id=abc123", "md=" + model, "fn=" + friendlyName} {
+		txt = append(txt, byte(len(kv)))
+		txt = append(txt, kv...)
+	}
+	srv := binary.BigEndian.AppendUint16(nil, 0)
+	srv = binary.BigEndian.AppendUint16(srv, 0)
+	srv = binary.BigEndian.AppendUint16(srv, port)
+	srv = append(srv, name(target)...)
+
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400)
+	binary.BigEndian.PutUint16(msg[6:], 1)
+	binary.BigEndian.PutUint16(msg[10:], 3)
+	msg = append(msg, record("_googlecast._tcp.local", 12, name(instance))...)
+	msg = append(msg, record(instance, 16, txt)...)
+	msg = append(msg, record(instance, 33, srv)...)
+	msg = append(msg, record(target, 1, ip.To4())...)
+	return msg
+}
+
+func TestDiscover(t *testing.T) {
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	go func() {
+		buf := make([]byte, 1500)
+		n, from, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if !bytes.Contains(buf[:n], []byte("\x0b_googlecast\x04_tcp\x05local\x00")) {
+			return
+		}
+		pc.WriteTo(mdnsResponse(
+			"Chromecast-abc123._googlecast._tcp.local", "Living Room", "Chromecast",
+			"abc123.local", net.IPv4(192, 168, 1, 20), 8009,
+		), from)
+		pc.WriteTo(mdnsResponse(
+			"Group-def._googlecast._tcp.local", "Everywhere", "Google Cast Group",
+			"abc123.local", net.IPv4(192, 168, 1, 20), 32187,
+		), from)
+	}()
+
+	d := &cast.Discoverer{Addr: pc.LocalAddr().String(), Timeout: 500 * time.Millisecond}
+	devices, err := d.Discover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("Expected 2 devices, got %+v", devices)
+	}
+	if got := devices[0]; got.Name != "Living Room" || got.Model != "Chromecast" || got.Addr() != "192.168.1.20:8009" ||
+		got.Group {
+		t.Errorf("Unexpected device: %+v", got)
+	}
+	if got := devices[1]; got.Name != "Everywhere" || !got.Group || got.Port != 32187 {
+		t.Errorf("Unexpected group: %+v", got)
+	}
+
+	if d, err := cast.Match(devices, "everywhere"); err != nil || !d.Group {
+		t.Errorf("Match by name failed: %+v %v", d, err)
+	}
+	if _, err := cast.Match(devices, "Kitchen"); err == nil || !strings.Contains(err.Error(), "Living Room") {
+		t.Errorf("Expected not-found error listing devices, got %v", err)
+	}
+}
+
+func TestFindByAddress(t *testing.T) {
+	d, err := cast.Find(context.Background(), "10.0.0.5")
+	if err != nil || d.Addr() != "10.0.0.5:8009" {
+		t.Errorf("Find(ip) = %+v, %v", d, err)
+	}
+	d, err = cast.Find(context.Background(), "10.0.0.5:32000")
+	if err != nil || d.Port != 32000 {
+		t.Errorf("Find(ip:port) = %+v, %v", d, err)
+	}
+}
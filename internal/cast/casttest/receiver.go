@@ -0,0 +1,301 @@
+// Package casttest provides a fake Chromecast running the Default Media Receiver
+package casttest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"math/big"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/cast"
+)
+
+const (
+	sessionID   = "fake-session"
+	transportID = "fake-transport"
+
+	nsConnection = "urn:x-cast:com.google.cast.tp.connection"
+	nsHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	nsReceiver   = "urn:x-cast:com.google.cast.receiver"
+	nsMedia      = "urn:x-cast:com.google.cast.media"
+)
+
+// Receiver is a TLS CASTV2 endpoint that understands the receiver and media namespaces
+type Receiver struct {
+	Addr string
+	// OnLoad, when set, runs for every LOAD; an error makes the load fail
+	OnLoad func(media cast.MediaInfo) error
+
+	ln      net.Listener
+	writeMu sync.Mutex
+
+	mu             sync.Mutex
+	conns          []net.Conn
+	appRunning     bool
+	media          *cast.MediaInfo
+	mediaSessionID int
+	playerState    string
+	idleReason     string
+	currentTime    float64
+	commands       []string
+}
+
+// NewReceiver starts a fake receiver that is closed when the test ends
+func NewReceiver(t testing.TB) *Receiver {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Receiver{Addr: ln.Addr().String(), ln: ln, playerState: cast.StateIdle}
+	t.Cleanup(r.Close)
+	go r.accept()
+	return r
+}
+
+// Close stops the receiver and drops all senders
+func (r *Receiver) Close() {
+	r.ln.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c := range r.conns {
+		c.Close()
+	}
+}
+
+// Commands lists the receiver and media message types received, in order
+func (r *Receiver) Commands() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.commands)
+}
+
+// Status returns the playback state and position
+func (r *Receiver) Status() (state string, currentTime float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.playerState, r.currentTime
+}
+
+// Advance moves the playhead as if media had played for seconds, and tells every sender
+func (r *Receiver) Advance(seconds float64) {
+	r.mu.Lock()
+	r.currentTime += seconds
+	status := r.mediaStatusLocked(0)
+	r.mu.Unlock()
+	r.broadcast(transportID, nsMedia, status)
+}
+
+// Finish ends the current item as if it played to the end
+func (r *Receiver) Finish() {
+	r.mu.Lock()
+	r.playerState, r.idleReason, r.currentTime = cast.StateIdle, cast.IdleFinished, 0
+	status := r.mediaStatusLocked(0)
+	r.mu.Unlock()
+	r.broadcast(transportID, nsMedia, status)
+}
+
+func (r *Receiver) accept() {
+	for {
+		conn, err := r.ln.Accept()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		r.conns = append(r.conns, conn)
+		r.mu.Unlock()
+		go r.serve(conn)
+	}
+}
+
+func (r *Receiver) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		r.mu.Lock()
+		r.conns = slices.DeleteFunc(r.conns, func(c net.Conn) bool { return c == conn })
+		r.mu.Unlock()
+	}()
+
+	for {
+		msg, err := cast.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+		var req map[string]any
+		if json.Unmarshal([]byte(msg.PayloadUTF8), &req) != nil {
+			continue
+		}
+		reqType, _ := req["type"].(string)
+
+		switch msg.Namespace {
+		case nsHeartbeat:
+			if reqType == "PING" {
+				r.write(conn, msg.DestinationID, msg.SourceID, nsHeartbeat, map[string]any{"type": "PONG"})
+			}
+		case nsReceiver:
+			r.handleReceiver(conn, msg, reqType, req)
+		case nsMedia:
+			r.handleMedia(conn, msg, reqType, req)
+		}
+	}
+}
+
+func (r *Receiver) handleReceiver(conn net.Conn, msg *cast.Message, reqType string, req map[string]any) {
+	r.mu.Lock()
+	r.commands = append(r.commands, reqType)
+	stopped := false
+	switch reqType {
+	case "LAUNCH":
+		r.appRunning = true
+	case "STOP":
+		stopped = r.appRunning && req["sessionId"] == sessionID
+		if stopped {
+			r.appRunning = false
+			r.media, r.playerState, r.idleReason = nil, cast.StateIdle, ""
+		}
+	}
+	status := r.receiverStatusLocked(req["requestId"])
+	r.mu.Unlock()
+
+	r.write(conn, msg.DestinationID, msg.SourceID, nsReceiver, status)
+	if stopped {
+		r.broadcast(transportID, nsConnection, map[string]any{"type": "CLOSE"})
+	}
+}
+
+func (r *Receiver) handleMedia(conn net.Conn, msg *cast.Message, reqType string, req map[string]any) {
+	r.mu.Lock()
+	r.commands = append(r.commands, reqType)
+	if !r.appRunning || msg.DestinationID != transportID {
+		r.mu.Unlock()
+		r.write(conn, msg.DestinationID, msg.SourceID, nsMedia,
+			map[string]any{"type": "INVALID_REQUEST", "requestId": req["requestId"], "reason": "INVALID_COMMAND"})
+		return
+	}
+
+	if reqType == "LOAD" {
+		var media cast.MediaInfo
+		raw, _ := json.Marshal(req["media"])
+		_ = json.Unmarshal(raw, &media)
+		onLoad := r.OnLoad
+		r.mu.Unlock()
+		if onLoad != nil {
+			if err := onLoad(media); err != nil {
+				r.write(conn, msg.DestinationID, msg.SourceID, nsMedia,
+					map[string]any{"type": "LOAD_FAILED", "requestId": req["requestId"]})
+				return
+			}
+		}
+		r.mu.Lock()
+		r.media = &media
+		r.mediaSessionID++
+		r.playerState, r.idleReason = cast.StatePlaying, ""
+		r.currentTime, _ = req["currentTime"].(float64)
+	}
+
+	switch reqType {
+	case "PAUSE":
+		r.playerState = cast.StatePaused
+	case "PLAY":
+		r.playerState = cast.StatePlaying
+	case "SEEK":
+		r.currentTime, _ = req["currentTime"].(float64)
+	case "STOP":
+		r.playerState, r.idleReason = cast.StateIdle, cast.IdleCancelled
+	}
+	reply := r.mediaStatusLocked(req["requestId"])
+	changed := reqType != "GET_STATUS"
+	r.mu.Unlock()
+
+	r.write(conn, msg.DestinationID, msg.SourceID, nsMedia, reply)
+	if changed {
+		r.mu.Lock()
+		others := slices.DeleteFunc(slices.Clone(r.conns), func(c net.Conn) bool { return c == conn })
+		broadcast := r.mediaStatusLocked(0)
+		r.mu.Unlock()
+		for _, c := range others {
+			r.write(c, transportID, "*", nsMedia, broadcast)
+		}
+	}
+}
+
+func (r *Receiver) receiverStatusLocked(requestID any) map[string]any {
+	apps := []map[string]any{}
+	if r.appRunning {
+		apps = append(apps, map[string]any{
+			"appId":       cast.MediaReceiverAppID,
+			"displayName": "Default Media Receiver",
+			"sessionId":   sessionID,
+			"transportId": transportID,
+			"namespaces":  []map[string]string{{"name": nsMedia}},
+		})
+	}
+	return map[string]any{
+		"type":      "RECEIVER_STATUS",
+		"requestId": requestID,
+		"status":    map[string]any{"applications": apps, "volume": map[string]any{"level": 1.0}},
+	}
+}
+
+func (r *Receiver) mediaStatusLocked(requestID any) map[string]any {
+	statuses := []map[string]any{}
+	if r.media != nil {
+		st := map[string]any{
+			"mediaSessionId": r.mediaSessionID,
+			"playerState":    r.playerState,
+			"currentTime":    r.currentTime,
+			"media":          r.media,
+		}
+		if r.idleReason != "" {
+			st["idleReason"] = r.idleReason
+		}
+		statuses = append(statuses, st)
+	}
+	return map[string]any{"type": "MEDIA_STATUS", "requestId": requestID, "status": statuses}
+}
+
+func (r *Receiver) broadcast(src, namespace string, payload map[string]any) {
+	r.mu.Lock()
+	conns := slices.Clone(r.conns)
+	r.mu.Unlock()
+	for _, c := range conns {
+		r.write(c, src, "*", namespace, payload)
+	}
+}
+
+func (r *Receiver) write(conn net.Conn, src, dst, namespace string, payload map[string]any) {
+	data, _ := json.Marshal(payload)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	_ = cast.WriteMessage(conn, &cast.Message{
+		SourceID:      src,
+		DestinationID: dst,
+		Namespace:     namespace,
+		PayloadUTF8:   string(data),
+	})
+}
+
+func selfSigned(t testing.TB) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
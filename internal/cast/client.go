@@ -0,0 +1,629 @@
+// Package cast is a Chromecast (CASTV2) sender: device discovery over mDNS and
+// control of the Default Media Receiver over the TLS protobuf channel
+package cast
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/player"
+)
+
+const (
+	// BackendName identifies cast sessions in player.Status
+	BackendName = "chromecast"
+	// DefaultPort is the CASTV2 port of single devices; groups advertise their own port
+	DefaultPort = 8009
+	// MediaReceiverAppID is the Default Media Receiver
+	MediaReceiverAppID = "CC1AD845"
+
+	nsConnection = "urn:x-cast:com.google.cast.tp.connection"
+	nsHeartbeat  = "urn:x-cast:com.google.cast.tp.heartbeat"
+	nsReceiver   = "urn:x-cast:com.google.cast.receiver"
+	nsMedia      = "urn:x-cast:com.google.cast.media"
+
+	senderID   = "sender-0"
+	receiverID = "receiver-0"
+)
+
+// Player states reported in MediaStatus
+const (
+	StateIdle      = "IDLE"
+	StatePlaying   = "PLAYING"
+	StatePaused    = "PAUSED"
+	StateBuffering = "BUFFERING"
+)
+
+// Idle reasons reported in MediaStatus
+const (
+	IdleFinished    = "FINISHED"
+	IdleCancelled   = "CANCELLED"
+	IdleInterrupted = "INTERRUPTED"
+	IdleError       = "ERROR"
+)
+
+var (
+	// ErrNoSession is returned when no media app is running on the device
+	ErrNoSession = errors.New("no cast session")
+	// ErrSessionClosed is returned when the receiver app goes away mid-playback
+	ErrSessionClosed = errors.New("cast session closed")
+
+	requestTimeout    = 10 * time.Second
+	heartbeatInterval = 5 * time.Second
+	statusInterval    = 2 * time.Second
+)
+
+// Application is a receiver app running on the device
+type Application struct {
+	AppID       string `json:"appId"`
+	DisplayName string `json:"displayName"`
+	SessionID   string `json:"sessionId"`
+	TransportID string `json:"transportId"`
+	StatusText  string `json:"statusText"`
+	Namespaces  []struct {
+		Name string `json:"name"`
+	} `json:"namespaces"`
+}
+
+func (a Application) supportsMedia() bool {
+	if a.AppID == MediaReceiverAppID {
+		return true
+	}
+	for _, ns := range a.Namespaces {
+		if ns.Name == nsMedia {
+			return true
+		}
+	}
+	return false
+}
+
+// ReceiverStatus describes the device
+type ReceiverStatus struct {
+	Applications []Application `json:"applications"`
+	Volume       struct {
+		Level float64 `json:"level"`
+		Muted bool    `json:"muted"`
+	} `json:"volume"`
+}
+
+// MediaMetadata is the GenericMediaMetadata shown on the receiver
+type MediaMetadata struct {
+	MetadataType int    `json:"metadataType"`
+	Title        string `json:"title,omitempty"`
+}
+
+// MediaInfo describes what to load
+type MediaInfo struct {
+	ContentID   string         `json:"contentId"`
+	ContentType string         `json:"contentType"`
+	StreamType  string         `json:"streamType"`
+	Duration    float64        `json:"duration,omitempty"`
+	Metadata    *MediaMetadata `json:"metadata,omitempty"`
+}
+
+// MediaStatus is the media receiver's playback state
+type MediaStatus struct {
+	MediaSessionID int        `json:"mediaSessionId"`
+	PlayerState    string     `json:"playerState"`
+	IdleReason     string     `json:"idleReason,omitempty"`
+	CurrentTime    float64    `json:"currentTime"`
+	Media          *MediaInfo `json:"media,omitempty"`
+}
+
+type envelope struct {
+	Type      string          `json:"type"`
+	RequestID int64           `json:"requestId,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Status    json.RawMessage `json:"status,omitempty"`
+}
+
+// Client is a connection to one cast device. It implements player.Controller
+type Client struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	nextID  atomic.Int64
+
+	mu          sync.Mutex
+	pending     map[int64]chan envelope
+	transportID string
+	sessionID   string
+	media       MediaStatus
+	haveMedia   bool
+	appClosed   bool
+	updates     chan struct{}
+	done        chan struct{}
+	err         error
+}
+
+var _ player.Controller = (*Client)(nil)
+
+// Dial connects to a device at host:port. Receivers present self-signed device
+// certificates, so the TLS channel is not verified
+func Dial(ctx context.Context, addr string) (*Client, error) {
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: 5 * time.Second},
+		Config:    &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // cast devices use self-signed certificates
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		pending: make(map[int64]chan envelope),
+		updates: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go c.readLoop()
+	go c.heartbeat()
+
+	if err := c.send(receiverID, nsConnection, map[string]any{"type": "CONNECT"}); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close disconnects without stopping playback
+func (c *Client) Close() error {
+	c.mu.Lock()
+	transportID := c.transportID
+	c.mu.Unlock()
+	if transportID != "" {
+		_ = c.send(transportID, nsConnection, map[string]any{"type": "CLOSE"})
+	}
+	_ = c.send(receiverID, nsConnection, map[string]any{"type": "CLOSE"})
+	return c.conn.Close()
+}
+
+// LocalAddr is the address the device sees us on, which is where it can reach a media server
+func (c *Client) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *Client) Name() string { return BackendName }
+
+func (c *Client) send(dst, namespace string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return WriteMessage(c.conn, &Message{
+		SourceID:      senderID,
+		DestinationID: dst,
+		Namespace:     namespace,
+		PayloadType:   PayloadString,
+		PayloadUTF8:   string(data),
+	})
+}
+
+// request sends payload with a fresh requestId and waits for the matching reply
+func (c *Client) request(ctx context.Context, dst, namespace string, payload map[string]any) (envelope, error) {
+	id := c.nextID.Add(1)
+	payload["requestId"] = id
+	ch := make(chan envelope, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return envelope{}, c.err
+	}
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(dst, namespace, payload); err != nil {
+		return envelope{}, err
+	}
+
+	timer := time.NewTimer(requestTimeout)
+	defer timer.Stop()
+	select {
+	case env := <-ch:
+		return env, nil
+	case <-c.done:
+		return envelope{}, c.closedErr()
+	case <-timer.C:
+		return envelope{}, fmt.Errorf("cast: %s timed out", payload["type"])
+	case <-ctx.Done():
+		return envelope{}, ctx.Err()
+	}
+}
+
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.mu.Lock()
+		if err == nil || errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			err = ErrSessionClosed
+		}
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+	}()
+
+	for {
+		var msg *Message
+		msg, err = ReadMessage(c.conn)
+		if err != nil {
+			return
+		}
+		var env envelope
+		if json.Unmarshal([]byte(msg.PayloadUTF8), &env) != nil {
+			continue
+		}
+
+		switch {
+		case msg.Namespace == nsHeartbeat && env.Type == "PING":
+			_ = c.send(msg.SourceID, nsHeartbeat, map[string]any{"type": "PONG"})
+			continue
+		case msg.Namespace == nsConnection && env.Type == "CLOSE":
+			c.mu.Lock()
+			if msg.SourceID == c.transportID || msg.SourceID == receiverID {
+				c.appClosed = true
+			}
+			c.mu.Unlock()
+			c.notify()
+			continue
+		case msg.Namespace == nsMedia && env.Type == "MEDIA_STATUS":
+			c.updateMedia(env.Status)
+		case msg.Namespace == nsReceiver && env.Type == "RECEIVER_STATUS":
+			c.updateReceiver(env.Status)
+		}
+
+		if env.RequestID != 0 {
+			c.mu.Lock()
+			ch, ok := c.pending[env.RequestID]
+			c.mu.Unlock()
+			if ok {
+				ch <- env
+			}
+		}
+	}
+}
+
+func (c *Client) heartbeat() {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			_ = c.send(receiverID, nsHeartbeat, map[string]any{"type": "PING"})
+		}
+	}
+}
+
+func (c *Client) notify() {
+	select {
+	case c.updates <- struct{}{}:
+	default:
+	}
+}
+
+// updateMedia merges a MEDIA_STATUS; receivers omit unchanged fields such as media
+func (c *Client) updateMedia(raw json.RawMessage) {
+	var statuses []MediaStatus
+	if json.Unmarshal(raw, &statuses) != nil {
+		return
+	}
+	c.mu.Lock()
+	if len(statuses) == 0 {
+		if c.haveMedia {
+			c.media.PlayerState = StateIdle
+		}
+	} else {
+		st := statuses[0]
+		if st.Media == nil && st.MediaSessionID == c.media.MediaSessionID {
+			st.Media = c.media.Media
+		}
+		c.media = st
+		c.haveMedia = true
+	}
+	c.mu.Unlock()
+	c.notify()
+}
+
+// updateReceiver notices when the app we attached to has been stopped
+func (c *Client) updateReceiver(raw json.RawMessage) {
+	var st ReceiverStatus
+	if json.Unmarshal(raw, &st) != nil {
+		return
+	}
+	c.mu.Lock()
+	if c.sessionID != "" {
+		running := false
+		for _, app := range st.Applications {
+			if app.SessionID == c.sessionID {
+				running = true
+			}
+		}
+		c.appClosed = !running
+	}
+	c.mu.Unlock()
+	c.notify()
+}
+
+// ReceiverStatus asks the device which apps are running
+func (c *Client) ReceiverStatus(ctx context.Context) (ReceiverStatus, error) {
+	env, err := c.request(ctx, receiverID, nsReceiver, map[string]any{"type": "GET_STATUS"})
+	if err != nil {
+		return ReceiverStatus{}, err
+	}
+	return parseReceiverStatus(env)
+}
+
+func parseReceiverStatus(env envelope) (ReceiverStatus, error) {
+	var st ReceiverStatus
+	if env.Type != "RECEIVER_STATUS" {
+		return st, fmt.Errorf("cast: unexpected %s %s", env.Type, env.Reason)
+	}
+	err := json.Unmarshal(env.Status, &st)
+	return st, err
+}
+
+// connectApp opens a virtual connection to a running app
+func (c *Client) connectApp(app Application) error {
+	c.mu.Lock()
+	c.transportID = app.TransportID
+	c.sessionID = app.SessionID
+	c.appClosed = false
+	c.mu.Unlock()
+	return c.send(app.TransportID, nsConnection, map[string]any{"type": "CONNECT"})
+}
+
+// launch starts the Default Media Receiver unless it is already running
+func (c *Client) launch(ctx context.Context) error {
+	st, err := c.ReceiverStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, app := range st.Applications {
+		if app.AppID == MediaReceiverAppID {
+			return c.connectApp(app)
+		}
+	}
+
+	env, err := c.request(ctx, receiverID, nsReceiver, map[string]any{"type": "LAUNCH", "appId": MediaReceiverAppID})
+	if err != nil {
+		return err
+	}
+	st, err = parseReceiverStatus(env)
+	if err != nil {
+		return err
+	}
+	for _, app := range st.Applications {
+		if app.AppID == MediaReceiverAppID {
+			return c.connectApp(app)
+		}
+	}
+	return errors.New("cast: media receiver did not start")
+}
+
+// Attach connects to whatever media app is already playing, e.g. one started by another disco process
+func (c *Client) Attach(ctx context.Context) error {
+	st, err := c.ReceiverStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, app := range st.Applications {
+		if app.supportsMedia() {
+			if err := c.connectApp(app); err != nil {
+				return err
+			}
+			_, err := c.MediaStatus(ctx)
+			return err
+		}
+	}
+	return ErrNoSession
+}
+
+// Load launches the media receiver if needed and starts playing media from startTime seconds
+func (c *Client) Load(ctx context.Context, media MediaInfo, startTime float64) (MediaStatus, error) {
+	if err := c.launch(ctx); err != nil {
+		return MediaStatus{}, err
+	}
+	if media.StreamType == "" {
+		media.StreamType = "BUFFERED"
+	}
+
+	c.mu.Lock()
+	c.haveMedia = false
+	c.media = MediaStatus{}
+	c.mu.Unlock()
+
+	env, err := c.mediaRequest(ctx, map[string]any{
+		"type":        "LOAD",
+		"media":       media,
+		"autoplay":    true,
+		"currentTime": startTime,
+	}, false)
+	if err != nil {
+		return MediaStatus{}, err
+	}
+	if env.Type != "MEDIA_STATUS" {
+		return MediaStatus{}, fmt.Errorf("cast: %s %s", env.Type, env.Reason)
+	}
+	return c.lastMedia(), nil
+}
+
+// mediaRequest sends a media namespace command, adding mediaSessionId when the command needs one
+func (c *Client) mediaRequest(ctx context.Context, payload map[string]any, withSession bool) (envelope, error) {
+	c.mu.Lock()
+	transportID, mediaSessionID := c.transportID, c.media.MediaSessionID
+	c.mu.Unlock()
+	if transportID == "" {
+		return envelope{}, ErrNoSession
+	}
+	if withSession {
+		if mediaSessionID == 0 {
+			return envelope{}, ErrNoSession
+		}
+		payload["mediaSessionId"] = mediaSessionID
+	}
+	return c.request(ctx, transportID, nsMedia, payload)
+}
+
+func (c *Client) lastMedia() MediaStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.media
+}
+
+// MediaStatus refreshes and returns the current media state
+func (c *Client) MediaStatus(ctx context.Context) (MediaStatus, error) {
+	if _, err := c.mediaRequest(ctx, map[string]any{"type": "GET_STATUS"}, false); err != nil {
+		return MediaStatus{}, err
+	}
+	return c.lastMedia(), nil
+}
+
+func (c *Client) mediaCommand(ctx context.Context, payload map[string]any) error {
+	env, err := c.mediaRequest(ctx, payload, true)
+	if err != nil {
+		return err
+	}
+	if env.Type != "MEDIA_STATUS" {
+		return fmt.Errorf("cast: %s %s", env.Type, env.Reason)
+	}
+	return nil
+}
+
+// Pause toggles between playing and paused
+func (c *Client) Pause(ctx context.Context) error {
+	st, err := c.MediaStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if st.PlayerState == StatePaused {
+		return c.mediaCommand(ctx, map[string]any{"type": "PLAY"})
+	}
+	return c.mediaCommand(ctx, map[string]any{"type": "PAUSE"})
+}
+
+func (c *Client) Seek(ctx context.Context, seconds float64, relative bool) error {
+	if relative {
+		st, err := c.MediaStatus(ctx)
+		if err != nil {
+			return err
+		}
+		seconds += st.CurrentTime
+	}
+	return c.mediaCommand(ctx, map[string]any{"type": "SEEK", "currentTime": max(seconds, 0)})
+}
+
+// Next stops the current item; a casting watch/listen loop then moves on
+func (c *Client) Next(ctx context.Context) error {
+	return c.mediaCommand(ctx, map[string]any{"type": "STOP"})
+}
+
+// Stop quits the receiver app
+func (c *Client) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	sessionID := c.sessionID
+	c.mu.Unlock()
+	if sessionID == "" {
+		return ErrNoSession
+	}
+	_, err := c.request(ctx, receiverID, nsReceiver, map[string]any{"type": "STOP", "sessionId": sessionID})
+	return err
+}
+
+func (c *Client) Status(ctx context.Context) (player.Status, error) {
+	st, err := c.MediaStatus(ctx)
+	if err != nil {
+		return player.Status{}, err
+	}
+	if st.Media == nil {
+		return player.Status{}, ErrNoSession
+	}
+	return player.Status{
+		Backend:  BackendName,
+		Path:     ContentPath(st.Media.ContentID),
+		Position: st.CurrentTime,
+		Duration: st.Media.Duration,
+		Paused:   st.PlayerState == StatePaused,
+	}, nil
+}
+
+// ContentPath recovers the library path from a disco serve media URL
+func ContentPath(contentID string) string {
+	u, err := url.Parse(contentID)
+	if err != nil {
+		return contentID
+	}
+	if p := u.Query().Get("path"); p != "" {
+		return p
+	}
+	return contentID
+}
+
+// Wait blocks until the loaded item stops playing. The returned status carries the last
+// position seen while playing, since receivers reset currentTime once idle.
+// ErrSessionClosed means the receiver app was stopped or the connection dropped
+func (c *Client) Wait(ctx context.Context) (MediaStatus, error) {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+
+	loaded := c.lastMedia().MediaSessionID
+	var position float64
+	started := false
+	for {
+		c.mu.Lock()
+		st, closed := c.media, c.appClosed
+		c.mu.Unlock()
+
+		if loaded != 0 && st.MediaSessionID != 0 && st.MediaSessionID != loaded {
+			// Another sender loaded something else
+			st.PlayerState, st.IdleReason, st.CurrentTime = StateIdle, IdleInterrupted, position
+			return st, nil
+		}
+		if st.PlayerState != "" && st.PlayerState != StateIdle {
+			started = true
+			position = st.CurrentTime
+		}
+		if st.PlayerState == StateIdle && (started || st.IdleReason != "") {
+			st.CurrentTime = position
+			return st, nil
+		}
+		if closed {
+			st.CurrentTime = position
+			return st, ErrSessionClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			st.CurrentTime = position
+			return st, ctx.Err()
+		case <-c.done:
+			st.CurrentTime = position
+			return st, ErrSessionClosed
+		case <-c.updates:
+		case <-ticker.C:
+			_, _ = c.MediaStatus(ctx)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package cast
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceName = "_googlecast._tcp.local."
+	mdnsAddr    = "224.0.0.251:5353"
+
+	dnsTypeA    = 1
+	dnsTypePTR  = 12
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+	dnsTypeSRV  = 33
+)
+
+// groupModel is the model name cast groups advertise
+const groupModel = "Google Cast Group"
+
+// Device is a cast receiver or speaker group found on the network
+type Device struct {
+	Name  string `json:"name"`
+	ID    string `json:"id"`
+	Model string `json:"model"`
+	Host  string `json:"host"`
+	Port  int    `json:"port"`
+	Group bool   `json:"group"`
+}
+
+// Addr is the CASTV2 host:port of the device
+func (d Device) Addr() string {
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// Discoverer browses for cast devices with mDNS
+type Discoverer struct {
+	// Addr is where the query is sent; defaults to the mDNS multicast group
+	Addr string
+	// Timeout is how long to collect responses
+	Timeout time.Duration
+}
+
+// Discover lists the cast devices and groups on the local network
+func Discover(ctx context.Context) ([]Device, error) {
+	return (&Discoverer{}).Discover(ctx)
+}
+
+// Discover sends a one-shot mDNS query from an ephemeral port, which responders
+// answer with unicast, and collects answers until the timeout
+func (d *Discoverer) Discover(ctx context.Context) ([]Device, error) {
+	addr := cmp.Or(d.Addr, mdnsAddr)
+	timeout := cmp.Or(d.Timeout, 3*time.Second)
+
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.WriteToUDP(buildQuery(serviceName), raddr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	records := newRecordSet()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				break
+			}
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Malformed packets from other responders are ignored
+		_ = records.parse(buf[:n], from.IP)
+	}
+	return records.devices(), nil
+}
+
+// Find returns the device or group whose friendly name or id matches name
+// (case-insensitive), or the first device when name is empty. A host or
+// host:port skips discovery
+func Find(ctx context.Context, name string) (Device, error) {
+	if host, port, ok := parseHostPort(name); ok {
+		return Device{Name: name, Host: host, Port: port}, nil
+	}
+
+	devices, err := Discover(ctx)
+	if err != nil {
+		return Device{}, err
+	}
+	return Match(devices, name)
+}
+
+// Match picks a device by name or id from a discovery result
+func Match(devices []Device, name string) (Device, error) {
+	if len(devices) == 0 {
+		return Device{}, errors.New("no chromecast devices found")
+	}
+	if name == "" {
+		return devices[0], nil
+	}
+	for _, d := range devices {
+		if strings.EqualFold(d.Name, name) || strings.EqualFold(d.ID, name) {
+			return d, nil
+		}
+	}
+	names := make([]string, len(devices))
+	for i, d := range devices {
+		names[i] = d.Name
+	}
+	return Device{}, fmt.Errorf("chromecast %q not found (found: %s)", name, strings.Join(names, ", "))
+}
+
+func parseHostPort(s string) (string, int, bool) {
+	if ip := net.ParseIP(s); ip != nil {
+		return s, DefaultPort, true
+	}
+	host, portStr, err := net.SplitHostPort(s)
+	if err != nil || net.ParseIP(host) == nil {
+		return "", 0, false
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, false
+	}
+	return host, port, true
+}
+
+// buildQuery encodes a PTR question with the unicast-response bit set
+func buildQuery(name string) []byte {
+	b := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(b[4:], 1) // QDCOUNT
+	b = appendName(b, name)
+	b = binary.BigEndian.AppendUint16(b, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(b, 0x8001) // QU, class IN
+}
+
+func appendName(b []byte, name string) []byte {
+	for label := range strings.SplitSeq(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+type srvRecord struct {
+	target string
+	port   int
+}
+
+// recordSet accumulates answers across packets; a device's PTR, SRV, TXT and A
+// records do not always arrive together
+type recordSet struct {
+	instances []string
+	srv       map[string]srvRecord
+	txt       map[string]map[string]string
+	addrs     map[string]string
+	sources   map[string]string
+}
+
+func newRecordSet() *recordSet {
+	return &recordSet{
+		srv:     make(map[string]srvRecord),
+		txt:     make(map[string]map[string]string),
+		addrs:   make(map[string]string),
+		sources: make(map[string]string),
+	}
+}
+
+func (rs *recordSet) parse(msg []byte, from net.IP) error {
+	if len(msg) < 12 {
+		return errors.New("short dns message")
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rr := int(binary.BigEndian.Uint16(msg[6:])) + int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+
+	off := 12
+	for range qd {
+		_, next, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		off = next + 4
+	}
+
+	for range rr {
+		owner, next, err := readName(msg, off)
+		if err != nil {
+			return err
+		}
+		off = next
+		if off+10 > len(msg) {
+			return errors.New("truncated dns record")
+		}
+		rtype := binary.BigEndian.Uint16(msg[off:])
+		rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+		rdata := off + 10
+		off = rdata + rdlen
+		if off > len(msg) {
+			return errors.New("truncated dns rdata")
+		}
+
+		switch rtype {
+		case dnsTypePTR:
+			if !strings.EqualFold(owner, serviceName) {
+				continue
+			}
+			instance, _, err := readName(msg, rdata)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(rs.instances, instance) {
+				rs.instances = append(rs.instances, instance)
+			}
+			if from != nil {
+				rs.sources[instance] = from.String()
+			}
+		case dnsTypeSRV:
+			if rdlen < 7 {
+				continue
+			}
+			target, _, err := readName(msg, rdata+6)
+			if err != nil {
+				return err
+			}
+			rs.srv[owner] = srvRecord{target: target, port: int(binary.BigEndian.Uint16(msg[rdata+4:]))}
+		case dnsTypeTXT:
+			rs.txt[owner] = parseTXT(msg[rdata:off])
+		case dnsTypeA:
+			if rdlen == 4 {
+				rs.addrs[owner] = net.IP(msg[rdata:off]).String()
+			}
+		case dnsTypeAAAA:
+			if rdlen == 16 {
+				if _, ok := rs.addrs[owner]; !ok {
+					rs.addrs[owner] = net.IP(msg[rdata:off]).String()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (rs *recordSet) devices() []Device {
+	var devices []Device
+	for _, instance := range rs.instances {
+		txt := rs.txt[instance]
+		d := Device{
+			Name:  txt["fn"],
+			ID:    txt["id"],
+			Model: txt["md"],
+			Port:  DefaultPort,
+		}
+		if d.Name == "" {
+			d.Name = strings.TrimSuffix(instance, "."+serviceName)
+		}
+		d.Group = d.Model == groupModel
+
+		srv, ok := rs.srv[instance]
+		if ok {
+			d.Port = srv.port
+			d.Host = rs.addrs[srv.target]
+		}
+		if d.Host == "" {
+			d.Host = rs.sources[instance]
+		}
+		if d.Host == "" {
+			continue
+		}
+		devices = append(devices, d)
+	}
+	slices.SortFunc(devices, func(a, b Device) int {
+		return cmp.Or(cmp.Compare(boolInt(a.Group), boolInt(b.Group)), strings.Compare(a.Name, b.Name))
+	})
+	return devices
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// readName decodes a possibly compressed domain name starting at off and
+// returns it with a trailing dot, plus the offset just past it
+func readName(msg []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(msg) {
+			return "", 0, errors.New("truncated dns name")
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case l&0xC0 == 0xC0:
+			if off+1 >= len(msg) {
+				return "", 0, errors.New("truncated dns pointer")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, errors.New("dns compression loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(msg[off:]) & 0x3FFF)
+		default:
+			if off+1+l > len(msg) {
+				return "", 0, errors.New("truncated dns label")
+			}
+			labels = append(labels, string(msg[off+1:off+1+l]))
+			off += 1 + l
+		}
+	}
+}
+
+func parseTXT(rdata []byte) map[string]string {
+	kv := make(map[string]string)
+	for len(rdata) > 0 {
+		l := int(rdata[0])
+		if 1+l > len(rdata) {
+			break
+		}
+		entry := string(rdata[1 : 1+l])
+		rdata = rdata[1+l:]
+		if k, v, ok := strings.Cut(entry, "="); ok {
+			kv[strings.ToLower(k)] = v
+		}
+	}
+	return kv
+}
@@ -0,0 +1,148 @@
+package cast
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Payload types of a CastMessage
+const (
+	PayloadString int32 = 0
+	PayloadBinary int32 = 1
+)
+
+// maxMessageSize is the largest frame receivers send (64KiB)
+const maxMessageSize = 64 << 10
+
+// Message is the CASTV2 CastMessage protobuf. It is small and stable enough that it is
+// encoded by hand rather than pulling in a protobuf runtime
+type Message struct {
+	ProtocolVersion int32
+	SourceID        string
+	DestinationID   string
+	Namespace       string
+	PayloadType     int32
+	PayloadUTF8     string
+	PayloadBinary   []byte
+}
+
+// MarshalBinary encodes the message in protobuf wire format
+func (m *Message) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 64+len(m.Namespace)+len(m.PayloadUTF8)+len(m.PayloadBinary))
+	b = appendVarintField(b, 1, uint64(m.ProtocolVersion))
+	b = appendBytesField(b, 2, []byte(m.SourceID))
+	b = appendBytesField(b, 3, []byte(m.DestinationID))
+	b = appendBytesField(b, 4, []byte(m.Namespace))
+	b = appendVarintField(b, 5, uint64(m.PayloadType))
+	if m.PayloadType == PayloadBinary {
+		b = appendBytesField(b, 7, m.PayloadBinary)
+	} else {
+		b = appendBytesField(b, 6, []byte(m.PayloadUTF8))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes a protobuf-encoded CastMessage, skipping unknown fields
+func (m *Message) UnmarshalBinary(b []byte) error {
+	*m = Message{}
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("cast: malformed field key")
+		}
+		b = b[n:]
+		field, wireType := key>>3, key&7
+
+		switch wireType {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("cast: malformed varint")
+			}
+			b = b[n:]
+			switch field {
+			case 1:
+				m.ProtocolVersion = int32(v)
+			case 5:
+				m.PayloadType = int32(v)
+			}
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return errors.New("cast: malformed length-delimited field")
+			}
+			v := b[n : n+int(l)]
+			b = b[n+int(l):]
+			switch field {
+			case 2:
+				m.SourceID = string(v)
+			case 3:
+				m.DestinationID = string(v)
+			case 4:
+				m.Namespace = string(v)
+			case 6:
+				m.PayloadUTF8 = string(v)
+			case 7:
+				m.PayloadBinary = append([]byte(nil), v...)
+			}
+		case 1:
+			if len(b) < 8 {
+				return errors.New("cast: truncated fixed64")
+			}
+			b = b[8:]
+		case 5:
+			if len(b) < 4 {
+				return errors.New("cast: truncated fixed32")
+			}
+			b = b[4:]
+		default:
+			return fmt.Errorf("cast: unsupported wire type %d", wireType)
+		}
+	}
+	return nil
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// WriteMessage writes a length-prefixed message frame
+func WriteMessage(w io.Writer, m *Message) error {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err = w.Write(append(frame, data...))
+	return err
+}
+
+// ReadMessage reads one length-prefixed message frame
+func ReadMessage(r io.Reader) (*Message, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxMessageSize {
+		return nil, fmt.Errorf("cast: message too large (%d bytes)", n)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	m := &Message{}
+	if err := m.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
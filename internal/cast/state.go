@@ -0,0 +1,45 @@
+package cast
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// State records what a casting disco process is playing, so control
+// commands in other processes know which device to talk to
+type State struct {
+	Device Device `json:"device"`
+	Path   string `json:"path"`
+}
+
+// SaveState writes the now-playing state file
+func SaveState(st State) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(utils.GetCastNowPlayingFile(), data, 0o644)
+}
+
+// LoadState reads the now-playing state file. ok is false when nothing is being cast
+func LoadState() (st State, ok bool, err error) {
+	data, err := os.ReadFile(utils.GetCastNowPlayingFile())
+	if errors.Is(err, fs.ErrNotExist) {
+		return st, false, nil
+	} else if err != nil {
+		return st, false, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, false, err
+	}
+	return st, true, nil
+}
+
+// ClearState removes the now-playing state file
+func ClearState() {
+	_ = os.Remove(utils.GetCastNowPlayingFile())
+}
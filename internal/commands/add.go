@@ -37,6 +37,11 @@ type AddCmd struct {
 
 	ScanPaths []string `kong:"-"`
 	Database  string   `kong:"-"`
+
+	// progress, when set, receives file counts instead of them being printed
+	progress func(done, total int)
+	// rescan extracts files again even when they are unchanged since the last scan
+	rescan bool
 }
 
 type meta struct {
@@ -104,6 +109,13 @@ func (c *AddCmd) flushBatch(ctx context.Context, opts processMediaTypeOptions, b
 		}
 
 		qtx := opts.queries.WithTx(tx)
+		if c.rescan {
+			if deleteErr := c.deleteBatchCaptions(ctx, qtx, mediaBatch); deleteErr != nil {
+				_ = tx.Rollback()
+				lastErr = fmt.Errorf("delete captions failed: %w", deleteErr)
+				continue
+			}
+		}
 		if upsertErr := qtx.BulkUpsertMedia(ctx, mediaBatch); upsertErr != nil {
 			_ = tx.Rollback()
 			lastErr = fmt.Errorf("bulk upsert media failed: %w", upsertErr)
@@ -143,7 +155,7 @@ func (c *AddCmd) loadMetadataCache(ctx context.Context, queries *db.Queries, dbE
 			deleted: m.TimeDeleted.Int64 > 0,
 		}
 	}
-	models.Log.Info("Loaded metadata cache from database", "count", len(metaCache))
+	models.LogFrom(ctx).Info("Loaded metadata cache from database", "count", len(metaCache))
 	return metaCache, nil
 }
 
@@ -222,7 +234,7 @@ func (c *AddCmd) collectFilesToProbe(
 
 		if existing, ok := metaCache[res.Path]; ok {
 			// Record exists, check if it's still valid
-			if !existing.deleted && existing.size == res.Info.Size() && existing.mtime == res.Info.ModTime().Unix() {
+			if !c.rescan && !existing.deleted && existing.size == res.Info.Size() && existing.mtime == res.Info.ModTime().Unix() {
 				skipped++
 				continue
 			}
@@ -250,7 +262,7 @@ func (c *AddCmd) monitorConcurrency(
 	ctx context.Context,
 	state *processState,
 	startWorker func(),
-	monitorDone chan struct{},
+	monitorDone <-chan struct{},
 ) {
 	ticker := time.NewTicker(4500 * time.Millisecond)
 	defer ticker.Stop()
@@ -262,7 +274,6 @@ func (c *AddCmd) monitorConcurrency(
 	for {
 		select {
 		case <-ctx.Done():
-			close(monitorDone)
 			return
 		case <-ticker.C:
 			completed := state.completedJobs.Load()
@@ -299,7 +310,21 @@ func (c *AddCmd) monitorConcurrency(
 	}
 }
 
+// deleteBatchCaptions drops captions that are about to be extracted again
+func (c *AddCmd) deleteBatchCaptions(ctx context.Context, qtx *db.Queries, batch []db.UpsertMediaParams) error {
+	for _, m := range batch {
+		if err := qtx.DeleteCaptions(ctx, m.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (c *AddCmd) reportProgress(opts processMediaTypeOptions, count int, startTime time.Time, state *processState) {
+	if c.progress != nil {
+		c.progress(opts.totalProcessedSoFar+count, opts.totalFiles)
+		return
+	}
 	if count%10 == 0 || count == len(opts.mediaType.files) {
 		etaStr := ""
 		if count > 2 {
@@ -388,7 +413,7 @@ func (c *AddCmd) startExtractionWorkers(
 					ProbeImages:       c.ProbeImages,
 				})
 				if extErr != nil {
-					models.Log.Error("\n  Metadata extraction failed", "path", path, "error", extErr)
+					models.LogFrom(ctx).Error("\n  Metadata extraction failed", "path", path, "error", extErr)
 				} else if res != nil {
 					params.Results <- res
 				}
@@ -401,7 +426,8 @@ func (c *AddCmd) startExtractionWorkers(
 		params.Wg.Go(worker)
 	}
 
-	// For dynamic scaling
+	// For dynamic scaling. Only the waiter below closes monitorDone; the monitor also
+	// stops on cancellation, and closing it there too would panic
 	monitorDone := make(chan struct{})
 	startOne := func() { params.Wg.Go(worker) }
 	go c.monitorConcurrency(ctx, params.State, startOne, monitorDone)
@@ -428,7 +454,7 @@ func (c *AddCmd) writeResultsToDB(
 
 		if len(currentBatch) >= batchSize {
 			if err := c.flushBatch(ctx, opts, currentBatch); err != nil {
-				models.Log.Error("\n  Failed to commit batch", "error", err)
+				models.LogFrom(ctx).Error("\n  Failed to commit batch", "error", err)
 			}
 			for i := range currentBatch {
 				currentBatch[i] = nil
@@ -443,7 +469,7 @@ func (c *AddCmd) writeResultsToDB(
 	// Final flush
 	if len(currentBatch) > 0 {
 		if err := c.flushBatch(ctx, opts, currentBatch); err != nil {
-			models.Log.Error("  Failed to commit final batch", "error", err)
+			models.LogFrom(ctx).Error("  Failed to commit final batch", "error", err)
 		}
 	}
 }
@@ -453,7 +479,7 @@ func (c *AddCmd) processMediaType(ctx context.Context, opts processMediaTypeOpti
 		return 0
 	}
 
-	models.Log.Debug("  Processing media type", "mediaType", opts.mediaType.name, "count", len(opts.mediaType.files))
+	models.LogFrom(ctx).Debug("  Processing media type", "mediaType", opts.mediaType.name, "count", len(opts.mediaType.files))
 
 	startTime := time.Now()
 
@@ -505,39 +531,43 @@ func (c *AddCmd) processScanRoot(ctx context.Context, opts scanRootOptions) (boo
 
 	absRoot, err := filepath.Abs(opts.root)
 	if err != nil {
-		models.Log.Error("Failed to get absolute path", "path", opts.root, "error", err)
+		models.LogFrom(ctx).Error("Failed to get absolute path", "path", opts.root, "error", err)
 		return false, nil
 	}
 	if _, err := os.Stat(absRoot); err != nil {
-		models.Log.Warn("Scan root unavailable, skipping", "path", absRoot, "error", err)
+		models.LogFrom(ctx).Warn("Scan root unavailable, skipping", "path", absRoot, "error", err)
 		return false, nil
 	}
 
-	// Check if this path is a child of an existing playlist root
-	absRootSlash := filepath.ToSlash(absRoot)
-	for _, pl := range opts.existingPlaylists {
-		if pl.Path.Valid {
-			plPathSlash := filepath.ToSlash(pl.Path.String)
-			if strings.HasPrefix(absRootSlash, plPathSlash+"/") {
-				models.Log.Info(
-					"Path is child of existing scan root, skipping",
-					"path",
-					absRoot,
-					"root",
-					pl.Path.String,
-				)
-				return false, nil
+	// A rescan revisits files under roots that are already recorded
+	if !c.rescan {
+		// Check if this path is a child of an existing playlist root
+		absRootSlash := filepath.ToSlash(absRoot)
+		for _, pl := range opts.existingPlaylists {
+			if pl.Path.Valid {
+				plPathSlash := filepath.ToSlash(pl.Path.String)
+				if strings.HasPrefix(absRootSlash, plPathSlash+"/") {
+					models.LogFrom(ctx).Info(
+						"Path is child of existing scan root, skipping",
+						"path",
+						absRoot,
+						"root",
+						pl.Path.String,
+					)
+					return false, nil
+				}
 			}
 		}
-	}
 
-	// Record or update this scan root
-	if _, playlistErr := opts.queries.InsertPlaylist(ctx, db.InsertPlaylistParams{
-		Path:         sql.NullString{String: absRoot, Valid: true},
-		ExtractorKey: sql.NullString{String: "Local", Valid: true},
-	}); playlistErr != nil {
-		models.Log.Warn("Failed to insert playlist root", "path", absRoot, "error", playlistErr)
+		// Record or update this scan root
+		if _, playlistErr := opts.queries.InsertPlaylist(ctx, db.InsertPlaylistParams{
+			Path:         sql.NullString{String: absRoot, Valid: true},
+			ExtractorKey: sql.NullString{String: db.LocalExtractorKey, Valid: true},
+		}); playlistErr != nil {
+			models.LogFrom(ctx).Warn("Failed to insert playlist root", "path", absRoot, "error", playlistErr)
+		}
 	}
+	recordVolume(ctx, opts.queries, absRoot)
 
 	filter := c.getMediaFilter()
 
@@ -557,7 +587,7 @@ func (c *AddCmd) processScanRoot(ctx context.Context, opts scanRootOptions) (boo
 	// Print scanning summary
 	fmt.Printf("\rScan of %s found %d files in %d folders%s\n", absRoot, totalFiles, totalDirs, utils.ClearSeq)
 	if skipped > 0 {
-		models.Log.Info("  Skipped unchanged files", "count", skipped)
+		models.LogFrom(ctx).Info("  Skipped unchanged files", "count", skipped)
 	}
 
 	if len(toProbe) == 0 {
@@ -569,7 +599,7 @@ func (c *AddCmd) processScanRoot(ctx context.Context, opts scanRootOptions) (boo
 		return newFilesFound, nil
 	}
 
-	models.Log.Info("  Extracting metadata", "count", len(toProbe), "initial_parallelism", c.Parallel)
+	models.LogFrom(ctx).Info("  Extracting metadata", "count", len(toProbe), "initial_parallelism", c.Parallel)
 
 	// Group files by media type for separate processing with accurate ETA per media type
 	mediaTypes := groupFilesByMediaType(toProbe)
@@ -620,6 +650,11 @@ func (c *AddCmd) AfterApply() error {
 
 func (c *AddCmd) Run(ctx context.Context) error {
 	models.SetupLogging(c.Verbose)
+	return c.run(ctx)
+}
+
+// run is Run without replacing the global logger, for jobs that log through ctx
+func (c *AddCmd) run(ctx context.Context) error {
 	db.InitFtsConfig()
 	db.SetFtsEnabled(true)
 
@@ -677,17 +712,17 @@ func (c *AddCmd) Run(ctx context.Context) error {
 	fmt.Println()
 	// Refresh FTS after adding new media (always needed for search)
 	if err := db.RebuildFTS(ctx, sqlDB, dbPath); err != nil {
-		models.Log.Error("Failed to rebuild FTS", "error", err)
+		models.LogFrom(ctx).Error("Failed to rebuild FTS", "error", err)
 	}
 
 	// Only refresh folder_stats if new files were added
 	if newFilesAdded {
-		models.Log.Info("Refreshing folder_stats after adding new files...")
+		models.LogFrom(ctx).Info("Refreshing folder_stats after adding new files...")
 		if err := db.RefreshFolderStats(ctx, sqlDB); err != nil {
-			models.Log.Error("Failed to refresh folder_stats", "error", err)
+			models.LogFrom(ctx).Error("Failed to refresh folder_stats", "error", err)
 		}
 	} else {
-		models.Log.Debug("No new files added, skipping folder_stats refresh")
+		models.LogFrom(ctx).Debug("No new files added, skipping folder_stats refresh")
 	}
 
 	return nil
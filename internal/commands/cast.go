@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/cast"
+	"github.com/chapmanjacobd/discoteca/internal/history"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/player"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// CastCmd groups the Chromecast subcommands
+type CastCmd struct {
+	Devices CastDevicesCmd `help:"List Chromecast devices and groups on the network" cmd:""`
+}
+
+type CastDevicesCmd struct {
+	models.CoreFlags `embed:""`
+
+	JSON bool          `help:"Output results as JSON"                short:"j"`
+	Wait time.Duration `help:"How long to wait for devices to answer" default:"3s"`
+}
+
+func (c *CastDevicesCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+
+	devices, err := (&cast.Discoverer{Timeout: c.Wait}).Discover(ctx)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		return utils.PrintJSON(devices)
+	}
+	if len(devices) == 0 {
+		fmt.Println("No Chromecast devices found")
+		return nil
+	}
+	for _, d := range devices {
+		kind := d.Model
+		if d.Group {
+			kind = "group"
+		}
+		fmt.Printf("%s (%s) %s\n", d.Name, kind, d.Addr())
+	}
+	return nil
+}
+
+// castSender plays media on a Chromecast. Files are streamed from a private disco
+// serve listening on the interface the device is reachable from
+type castSender struct {
+	device cast.Device
+	client *cast.Client
+	serve  *ServeCmd
+	server *http.Server
+	base   string
+}
+
+func newCastSender(ctx context.Context, deviceName string, databases []string) (*castSender, error) {
+	device, err := cast.Find(ctx, deviceName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := cast.Dial(ctx, device.Addr())
+	if err != nil {
+		return nil, fmt.Errorf("connect to %s: %w", device.Name, err)
+	}
+
+	host, _, err := net.SplitHostPort(client.LocalAddr().String())
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	initMimeTypes()
+	serve := &ServeCmd{Databases: databases, ReadOnly: true, APIToken: utils.RandomString(32)}
+	serve.checkFfmpeg()
+
+	s := &castSender{
+		device: device,
+		client: client,
+		serve:  serve,
+		server: &http.Server{Handler: serve.Mux(), ReadTimeout: 10 * time.Second},
+		base:   "http://" + ln.Addr().String(),
+	}
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			models.Log.Error("Cast media server stopped", "error", err)
+		}
+	}()
+	models.Log.Info("Casting", "device", device.Name, "addr", device.Addr(), "server", s.base)
+	return s, nil
+}
+
+func (s *castSender) Close() {
+	cast.ClearState()
+	s.client.Close()
+	s.server.Close()
+	s.serve.Close()
+}
+
+// mediaInfo points the receiver at /api/raw, or at the HLS playlist when the file needs transcoding
+func (s *castSender) mediaInfo(m models.MediaWithDB) cast.MediaInfo {
+	title := m.Stem()
+	if m.Title != nil && *m.Title != "" {
+		title = *m.Title
+	}
+	info := cast.MediaInfo{
+		StreamType: "BUFFERED",
+		Duration:   float64(mustInt(m.Duration)),
+		Metadata:   &cast.MediaMetadata{Title: title},
+	}
+
+	// The receiver keeps fetching ranges while it plays, so the URL outlives the media
+	q := s.serve.signMediaURL(m.Path, time.Duration(mustInt(m.Duration))*time.Second+time.Hour)
+	if s.serve.hasFfmpeg && m.Duration != nil && utils.GetTranscodeStrategy(m.Media).NeedsTranscode {
+		info.ContentID = s.base + "/api/hls/playlist?" + q.Encode()
+		info.ContentType = "application/x-mpegURL"
+	} else {
+		info.ContentID = s.base + "/api/raw?" + q.Encode()
+		info.ContentType, _ = s.serve.rawMimeType(m.Media)
+	}
+	return info
+}
+
+// play casts one item and waits for it to end. stop is true when the
+// session was stopped, e.g. by `disco stop`
+func (s *castSender) play(
+	ctx context.Context,
+	flags models.GlobalFlags,
+	m models.MediaWithDB,
+	start string,
+	audioOnly bool,
+) (stop bool, err error) {
+	if err := cast.SaveState(cast.State{Device: s.device, Path: m.Path}); err != nil {
+		models.Log.Warn("Failed to write now-playing file", "error", err)
+	}
+
+	models.Log.Info("Casting", "path", m.Path)
+	if _, err := s.client.Load(ctx, s.mediaInfo(m), castSeconds(start)); err != nil {
+		return errors.Is(err, cast.ErrSessionClosed), err
+	}
+
+	if flags.CastWithLocal {
+		local := playerFor(flags.PlaybackFlags).Command(ctx, []string{m.Path}, player.LaunchOptions{
+			Start:   start,
+			NoVideo: audioOnly,
+		})
+		if _, err := runPlayer(local, nil); err != nil {
+			models.Log.Warn("Local player failed", "error", err)
+		}
+	}
+
+	st, err := s.client.Wait(ctx)
+	if flags.TrackHistory && st.CurrentTime > 0 {
+		done := st.IdleReason == cast.IdleFinished
+		session := history.NewSession(m.DB, m.Path)
+		if err2 := session.Checkpoint(ctx, int(st.CurrentTime), done); err2 != nil {
+			models.Log.Warn("Failed to update history", "error", err2)
+		}
+		_ = session.Close()
+	}
+	if errors.Is(err, cast.ErrSessionClosed) {
+		return true, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if st.IdleReason == cast.IdleError {
+		return false, fmt.Errorf("receiver could not play %s", m.Path)
+	}
+	return false, nil
+}
+
+// castSeconds converts a --start value to seconds
+func castSeconds(s string) float64 {
+	if s == "" || strings.HasSuffix(s, "%") {
+		return 0
+	}
+	if strings.Contains(s, ":") {
+		return utils.FromTimestampSeconds(s)
+	}
+	if f := utils.SafeFloat(s); f != nil {
+		return *f
+	}
+	return 0
+}
+
+// runningCast attaches to the device a casting disco is using, or to the --cast-to
+// device. The client is nil when nothing is being cast
+func runningCast(ctx context.Context, deviceName string) (*cast.Client, error) {
+	st, found, err := cast.LoadState()
+	if err != nil {
+		return nil, err
+	}
+	device := st.Device
+	if !found {
+		if deviceName == "" {
+			return nil, nil
+		}
+		if device, err = cast.Find(ctx, deviceName); err != nil {
+			return nil, err
+		}
+	}
+
+	client, err := cast.Dial(ctx, device.Addr())
+	if err == nil {
+		err = client.Attach(ctx)
+		if err != nil {
+			client.Close()
+		}
+	}
+	switch {
+	case err == nil:
+		return client, nil
+	case found:
+		// The casting process is gone or the device was switched off
+		models.Log.Debug("Clearing stale cast state", "device", device.Name, "error", err)
+		cast.ClearState()
+		return nil, nil
+	case errors.Is(err, cast.ErrNoSession):
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
@@ -0,0 +1,188 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/chapmanjacobd/discoteca/internal/cast"
+	"github.com/chapmanjacobd/discoteca/internal/cast/casttest"
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+func setupCastTest(t *testing.T) (dbPath, songPath string) {
+	t.Helper()
+	models.SetupLogging(0)
+	// The now-playing state file lives in the temp dir
+	t.Setenv("TMPDIR", t.TempDir())
+
+	dir := t.TempDir()
+	dbPath = filepath.Join(dir, "cast.db")
+	songPath = filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(songPath, []byte("ID3 audio data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	if err := db.InitDB(context.Background(), sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec(`INSERT INTO media (path, title, media_type, duration, size, time_created, time_deleted)
+		VALUES (?, 'Song', 'audio', 200, 14, 1700000000, 0)`, songPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbPath, songPath
+}
+
+func TestListenCast(t *testing.T) {
+	dbPath, songPath := setupCastTest(t)
+	r := casttest.NewReceiver(t)
+
+	fetched := make(chan string, 1)
+	r.OnLoad = func(m cast.MediaInfo) error {
+		// Fetch the media the way a receiver would: no headers, no cookies
+		resp, err := http.Get(m.ContentID)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("GET %s: %d", m.ContentID, resp.StatusCode)
+		}
+		fetched <- m.ContentType + " " + string(body)
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			r.Advance(42)
+			time.Sleep(100 * time.Millisecond)
+			if st, ok, _ := cast.LoadState(); !ok || st.Path != songPath {
+				t.Errorf("Expected now-playing state for %s, got %+v", songPath, st)
+			}
+			r.Finish()
+		}()
+		return nil
+	}
+
+	cmd := &commands.ListenCmd{Databases: []string{dbPath}}
+	cmd.Cast = true
+	cmd.CastDevice = r.Addr
+	cmd.TrackHistory = true
+	cmd.HideDeleted = true
+	cmd.SortBy = "path"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := cmd.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case got := <-fetched:
+		if got != "audio/mpeg ID3 audio data" {
+			t.Errorf("Receiver fetched %q", got)
+		}
+	default:
+		t.Fatal("Receiver never loaded the media")
+	}
+
+	if _, ok, _ := cast.LoadState(); ok {
+		t.Error("Expected now-playing state to be cleared")
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	var playCount, done int
+	var playhead int
+	if err := sqlDB.QueryRow("SELECT play_count FROM media WHERE path = ?", songPath).Scan(&playCount); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlDB.QueryRow("SELECT playhead, done FROM history WHERE media_path = ?", songPath).Scan(&playhead, &done); err != nil {
+		t.Fatal(err)
+	}
+	if playCount != 1 || playhead != 42 || done != 1 {
+		t.Errorf("Expected play_count=1 playhead=42 done=1, got %d %d %d", playCount, playhead, done)
+	}
+}
+
+func TestPlaybackControlCast(t *testing.T) {
+	setupCastTest(t)
+	r := casttest.NewReceiver(t)
+	ctx := context.Background()
+
+	sender, err := cast.Dial(ctx, r.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if _, err := sender.Load(ctx, cast.MediaInfo{
+		ContentID:   "http://127.0.0.1:1/api/raw?path=%2Fmusic%2Fsong.mp3",
+		ContentType: "audio/mpeg",
+		Duration:    200,
+	}, 0); err != nil {
+		t.Fatal(err)
+	}
+	device, err := cast.Find(ctx, r.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cast.SaveState(cast.State{Device: device, Path: "/music/song.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// No local player is running
+	flags := models.ControlFlags{MpvSocket: filepath.Join(t.TempDir(), "none.sock"), VlcRC: "127.0.0.1:1"}
+	base := commands.MpvControlBase{ControlFlags: flags}
+
+	if err := (&commands.NowCmd{MpvControlBase: base}).Run(ctx); err != nil {
+		t.Errorf("NowCmd failed: %v", err)
+	}
+
+	if err := (&commands.PauseCmd{MpvControlBase: base}).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := r.Status(); state != cast.StatePaused {
+		t.Errorf("Expected paused, got %s", state)
+	}
+
+	if err := (&commands.SeekCmd{MpvControlBase: base, Time: "00:01:30"}).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, pos := r.Status(); pos != 90 {
+		t.Errorf("Expected seek to 90, got %v", pos)
+	}
+
+	if err := (&commands.StopCmd{MpvControlBase: base}).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.Wait(ctx); !errors.Is(err, cast.ErrSessionClosed) {
+		t.Errorf("Expected the cast session to be closed, got %v", err)
+	}
+
+	// With the session gone, now reports nothing and forgets the stale state
+	if err := (&commands.NowCmd{MpvControlBase: base}).Run(ctx); err == nil {
+		t.Error("Expected NowCmd to report no playback")
+	}
+	if _, ok, _ := cast.LoadState(); ok {
+		t.Error("Expected stale cast state to be cleared")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/chapmanjacobd/discoteca/internal/db"
 	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
 	"github.com/chapmanjacobd/discoteca/internal/utils"
 )
 
@@ -25,6 +26,9 @@ type CheckCmd struct {
 
 	CheckPaths []string `kong:"-"`
 	Databases  []string `kong:"-"`
+
+	// progress, when set, receives the number of files checked
+	progress func(done, total int)
 }
 
 func (c *CheckCmd) AfterApply() error {
@@ -46,7 +50,7 @@ func (c *CheckCmd) AfterApply() error {
 	return nil
 }
 
-func (c *CheckCmd) buildPresenceSet() (map[string]bool, []string, error) {
+func (c *CheckCmd) buildPresenceSet(ctx context.Context) (map[string]bool, []string, error) {
 	if len(c.CheckPaths) == 0 {
 		return nil, nil, nil
 	}
@@ -59,7 +63,7 @@ func (c *CheckCmd) buildPresenceSet() (map[string]bool, []string, error) {
 			return nil, nil, err
 		}
 		absCheckPaths = append(absCheckPaths, absRoot)
-		models.Log.Info("Scanning filesystem for presence set", "path", absRoot)
+		models.LogFrom(ctx).Info("Scanning filesystem for presence set", "path", absRoot)
 		err = filepath.WalkDir(absRoot, func(path string, d os.DirEntry, err error) error {
 			if err == nil && !d.IsDir() {
 				absPath, _ := filepath.Abs(path)
@@ -113,21 +117,38 @@ func (c *CheckCmd) checkDatabase(
 		return err
 	}
 
-	models.Log.Info("Checking files", "count", len(allMedia), "database", dbPath)
+	models.LogFrom(ctx).Info("Checking files", "count", len(allMedia), "database", dbPath)
 
+	// Files on an unmounted volume are offline, not deleted
+	backfillVolumes(ctx, queries)
+	offlineRoots := query.OfflineRoots(ctx, []string{dbPath})
 	missingCount := 0
+	offlineCount := 0
 	now := time.Now().Unix()
 
-	for _, m := range allMedia {
+	for i, m := range allMedia {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if c.progress != nil {
+			c.progress(i, len(allMedia))
+		}
 		if c.checkMedia(m, presenceSet, absCheckPaths) {
+			if query.PathOffline(m.Path, offlineRoots) {
+				offlineCount++
+				if c.DryRun {
+					fmt.Printf("[Dry-run] Offline: %s\n", m.Path)
+				}
+				continue
+			}
 			missingCount++
 			if !c.DryRun {
-				models.Log.Debug("Marking missing file as deleted", "path", m.Path)
+				models.LogFrom(ctx).Debug("Marking missing file as deleted", "path", m.Path)
 				if err := queries.MarkDeleted(ctx, db.MarkDeletedParams{
 					TimeDeleted: sql.NullInt64{Int64: now, Valid: true},
 					Path:        m.Path,
 				}); err != nil {
-					models.Log.Error("Failed to mark file as deleted", "path", m.Path, "error", err)
+					models.LogFrom(ctx).Error("Failed to mark file as deleted", "path", m.Path, "error", err)
 				}
 			} else {
 				fmt.Printf("[Dry-run] Missing: %s\n", m.Path)
@@ -135,12 +156,16 @@ func (c *CheckCmd) checkDatabase(
 		}
 	}
 
+	if c.progress != nil {
+		c.progress(len(allMedia), len(allMedia))
+	}
+
 	if c.DryRun {
-		models.Log.Info("Check complete (dry-run)", "missing", missingCount)
+		models.LogFrom(ctx).Info("Check complete (dry-run)", "missing", missingCount, "offline", offlineCount)
 	} else {
-		models.Log.Info("Check complete", "marked_deleted", missingCount)
+		models.LogFrom(ctx).Info("Check complete", "marked_deleted", missingCount, "offline", offlineCount)
 		if missingCount > 0 {
-			models.Log.Info("Refreshing folder_stats and FTS after marking files deleted...")
+			models.LogFrom(ctx).Info("Refreshing folder_stats and FTS after marking files deleted...")
 			_ = db.RefreshFolderStats(ctx, sqlDB)
 			_ = db.RebuildFTS(ctx, sqlDB, dbPath)
 		}
@@ -150,9 +175,14 @@ func (c *CheckCmd) checkDatabase(
 
 func (c *CheckCmd) Run(ctx context.Context) error {
 	models.SetupLogging(c.Verbose)
+	return c.run(ctx)
+}
+
+// run is Run without replacing the global logger, for jobs that log through ctx
+func (c *CheckCmd) run(ctx context.Context) error {
 	c.CheckPaths = utils.ExpandStdin(c.CheckPaths)
 
-	presenceSet, absCheckPaths, err := c.buildPresenceSet()
+	presenceSet, absCheckPaths, err := c.buildPresenceSet(ctx)
 	if err != nil {
 		return err
 	}
@@ -195,7 +195,11 @@ func (c *DedupeCmd) printSummary(finalCandidates []DedupeDuplicate) {
 }
 
 func (c *DedupeCmd) confirmDeletion() bool {
-	fmt.Print("\nDelete duplicates? [y/N] ")
+	if c.Link != "" {
+		fmt.Printf("\nReplace duplicates with %s links? [y/N] ", c.Link)
+	} else {
+		fmt.Print("\nDelete duplicates? [y/N] ")
+	}
 	var response string
 	_, _ = fmt.Scanln(&response)
 	return strings.ToLower(response) == "y"
@@ -206,6 +210,10 @@ func (c *DedupeCmd) processDuplicates(
 	finalCandidates []DedupeDuplicate,
 	flags models.GlobalFlags,
 ) error {
+	if c.Link != "" {
+		return c.linkDuplicates(ctx, finalCandidates, flags)
+	}
+
 	models.Log.Info("Deleting duplicates...")
 	for _, d := range finalCandidates {
 		if c.DedupeCmd != "" {
@@ -232,6 +240,71 @@ func (c *DedupeCmd) processDuplicates(
 	return nil
 }
 
+// linkDuplicates replaces each duplicate with a link to its keeper so that both paths
+// keep working. Files are only linked when their full SHA-256 hashes match
+func (c *DedupeCmd) linkDuplicates(
+	ctx context.Context,
+	finalCandidates []DedupeDuplicate,
+	flags models.GlobalFlags,
+) error {
+	models.Log.Info("Linking duplicates...")
+	var reclaimed int64
+	var linked, fellBack int
+	for _, d := range finalCandidates {
+		keepInfo, err := os.Stat(d.KeepPath)
+		if err != nil {
+			models.Log.Warn("Failed to stat file", "path", d.KeepPath, "error", err)
+			continue
+		}
+		dupInfo, err := os.Lstat(d.DuplicatePath)
+		if err != nil {
+			models.Log.Warn("Failed to stat file", "path", d.DuplicatePath, "error", err)
+			continue
+		}
+		// A duplicate with other hardlinks keeps its data on disk however it is replaced
+		if !dupInfo.Mode().IsRegular() || os.SameFile(keepInfo, dupInfo) || utils.GetLinkCount(dupInfo) > 1 {
+			models.Log.Debug("Already linked", "path", d.DuplicatePath)
+			continue
+		}
+
+		keepHash, err := utils.FullHashFile(d.KeepPath)
+		if err != nil {
+			models.Log.Warn("Failed to hash file", "path", d.KeepPath, "error", err)
+			continue
+		}
+		dupHash, err := utils.FullHashFile(d.DuplicatePath)
+		if err != nil {
+			models.Log.Warn("Failed to hash file", "path", d.DuplicatePath, "error", err)
+			continue
+		}
+		if keepHash != dupHash {
+			models.Log.Warn("Not linking, contents differ", "keep", d.KeepPath, "duplicate", d.DuplicatePath)
+			continue
+		}
+
+		kind, err := utils.LinkFile(c.Link, d.KeepPath, d.DuplicatePath)
+		if err != nil {
+			models.Log.Warn("Failed to link file", "path", d.DuplicatePath, "error", err)
+			continue
+		}
+		if kind != c.Link {
+			models.Log.Warn("Fell back to another link type", "path", d.DuplicatePath, "wanted", c.Link, "used", kind)
+			fellBack++
+		}
+		linked++
+		reclaimed += dupInfo.Size()
+
+		for _, dbPath := range c.Databases {
+			c.updateDatabaseAfterDedupe(ctx, dbPath, d, flags)
+		}
+	}
+	fmt.Printf("Linked %d files, reclaimed %s\n", linked, utils.FormatSize(reclaimed))
+	if fellBack > 0 {
+		fmt.Printf("%d of them are symlinks because a %s link was not possible\n", fellBack, c.Link)
+	}
+	return nil
+}
+
 func (c *DedupeCmd) updateDatabaseAfterDedupe(
 	ctx context.Context,
 	dbPath string,
@@ -246,13 +319,13 @@ func (c *DedupeCmd) updateDatabaseAfterDedupe(
 
 	var dbErrs []string
 
-	// Mark duplicate as deleted
-	if _, err := sqlDB.ExecContext(
-		ctx,
-		"UPDATE media SET time_deleted = unixepoch() WHERE path = ?",
-		d.DuplicatePath,
-	); err != nil {
-		dbErrs = append(dbErrs, fmt.Sprintf("failed to mark duplicate as deleted: %v", err))
+	// Mark duplicate as deleted, or as deduped when it was replaced by a link
+	dupQuery := "UPDATE media SET time_deleted = unixepoch() WHERE path = ?"
+	if c.Link != "" {
+		dupQuery = "UPDATE media SET is_deduped = 1 WHERE path = ?"
+	}
+	if _, err := sqlDB.ExecContext(ctx, dupQuery, d.DuplicatePath); err != nil {
+		dbErrs = append(dbErrs, fmt.Sprintf("failed to update duplicate: %v", err))
 	}
 
 	// Mark keep file as deduped
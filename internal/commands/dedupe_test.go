@@ -3,6 +3,8 @@ package commands_test
 import (
 	"context"
 	"database/sql"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/chapmanjacobd/discoteca/internal/commands"
@@ -52,3 +54,56 @@ func TestDedupeCmd_Run(t *testing.T) {
 		}
 	})
 }
+
+func TestDedupeCmd_Link(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+
+	dbPath := fixture.DBPath
+	sqlDB, _ := sql.Open("sqlite3", dbPath)
+	db.InitDB(context.Background(), sqlDB)
+
+	f1 := fixture.CreateDummyFile("video1.mp4")
+	f2 := fixture.CreateDummyFile("video2.mp4")
+	f3 := fixture.CreateDummyFile("video3.mp4")
+	os.WriteFile(f3, []byte("other data"), 0o644)
+	// Replacing a file that has another hardlink would reclaim nothing
+	f4 := fixture.CreateDummyFile("video4.mp4")
+	os.Link(f4, filepath.Join(filepath.Dir(f4), "video4-copy.mp4"))
+
+	sqlDB.Exec("INSERT INTO media (path, title, duration, size) VALUES (?, ?, ?, ?)", f1, "Same Title", 100, 10)
+	sqlDB.Exec("INSERT INTO media (path, title, duration, size) VALUES (?, ?, ?, ?)", f2, "Same Title", 100, 10)
+	sqlDB.Exec("INSERT INTO media (path, title, duration, size) VALUES (?, ?, ?, ?)", f3, "Same Title", 100, 10)
+	sqlDB.Exec("INSERT INTO media (path, title, duration, size) VALUES (?, ?, ?, ?)", f4, "Same Title", 100, 10)
+	sqlDB.Close()
+
+	cmd := &commands.DedupeCmd{
+		Databases:   []string{dbPath},
+		CoreFlags:   models.CoreFlags{NoConfirm: true},
+		DedupeFlags: models.DedupeFlags{TitleOnly: true, Link: "hard"},
+	}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("commands.DedupeCmd failed: %v", err)
+	}
+
+	i1, _ := os.Stat(f1)
+	i2, _ := os.Stat(f2)
+	i3, _ := os.Stat(f3)
+	if i2 == nil || !os.SameFile(i1, i2) {
+		t.Error("expected identical duplicate to be hardlinked to the kept file")
+	}
+	if i3 == nil || os.SameFile(i1, i3) || os.SameFile(i2, i3) {
+		t.Error("expected file with different contents to be left alone")
+	}
+	if i4, _ := os.Stat(f4); i4 == nil || os.SameFile(i1, i4) {
+		t.Error("expected a duplicate with other hardlinks to be left alone")
+	}
+
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	var deleted int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM media WHERE time_deleted > 0").Scan(&deleted)
+	if deleted != 0 {
+		t.Errorf("linked duplicates should not be marked deleted, got %d", deleted)
+	}
+}
@@ -0,0 +1,344 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type ExportCmd struct {
+	models.CoreFlags        `embed:""`
+	models.QueryFlags       `embed:""`
+	models.PathFilterFlags  `embed:""`
+	models.FilterFlags      `embed:""`
+	models.MediaFilterFlags `embed:""`
+	models.TimeFilterFlags  `embed:""`
+	models.DeletedFlags     `embed:""`
+	models.SortFlags        `embed:""`
+	models.DisplayFlags     `embed:""`
+	models.AggregateFlags   `embed:""`
+	models.FTSFlags         `embed:""`
+
+	Format   string `help:"Output format"                                                           short:"F" default:"csv" enum:"csv,tsv,jsonl,markdown,html,template"`
+	Template string `help:"Go text/template rendered for each row, e.g. '{{.Path}} {{size .Size}}'"`
+	Output   string `help:"Write to this file instead of stdout"`
+
+	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+}
+
+var (
+	defaultExportMediaColumns  = []string{"path", "title", "media_type", "duration", "size", "play_count", "time_last_played"}
+	defaultExportFolderColumns = []string{"path", "count", "exists_count", "total_size", "total_duration"}
+)
+
+func (c *ExportCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	if c.Template != "" {
+		c.Format = "template"
+	}
+	if c.Format == "" {
+		c.Format = "csv"
+	}
+	flags := models.BuildQueryGlobalFlags(models.BuildQueryOptions{
+		Core:        c.CoreFlags,
+		Query:       c.QueryFlags,
+		PathFilter:  c.PathFilterFlags,
+		Filter:      c.FilterFlags,
+		MediaFilter: c.MediaFilterFlags,
+		TimeFilter:  c.TimeFilterFlags,
+		Deleted:     c.DeletedFlags,
+		Sort:        c.SortFlags,
+		Display:     c.DisplayFlags,
+		FTS:         c.FTSFlags,
+	})
+	flags.AggregateFlags = c.AggregateFlags
+
+	if c.Output == "" {
+		return c.export(ctx, flags, os.Stdout)
+	}
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	if err := c.export(ctx, flags, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	// A failed close can mean the last buffered writes never reached the disk
+	return f.Close()
+}
+
+// export writes every matching row to out
+func (c *ExportCmd) export(ctx context.Context, flags models.GlobalFlags, out io.Writer) error {
+	w := bufio.NewWriter(out)
+
+	aggregated := isAggregated(flags)
+	columns := c.Columns
+	if len(columns) == 0 {
+		columns = defaultExportMediaColumns
+		if aggregated {
+			columns = defaultExportFolderColumns
+		}
+	}
+	e, err := newExporter(w, c.Format, c.Template, columns, len(c.Columns) > 0)
+	if err != nil {
+		return err
+	}
+	if err := e.begin(); err != nil {
+		return err
+	}
+
+	if aggregated {
+		// Folder totals need every file before the first row can be written
+		var media []models.MediaWithDB
+		err = query.StreamMedia(ctx, c.Databases, flags, func(m models.MediaWithDB) error {
+			media = append(media, m)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		folders := query.AggregateMedia(media, flags)
+		query.SortFolders(folders, flags.SortBy, flags.Reverse)
+		for _, f := range folders {
+			f.Files = nil
+			if err := e.row(f); err != nil {
+				return err
+			}
+		}
+	} else {
+		err = query.StreamMedia(ctx, c.Databases, flags, func(m models.MediaWithDB) error {
+			return e.row(m)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := e.end(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// isAggregated reports whether flags ask for folder or group totals instead of files
+func isAggregated(flags models.GlobalFlags) bool {
+	return flags.BigDirs || flags.GroupByExtensions || flags.GroupBySize || flags.Depth > 0 ||
+		flags.Parents ||
+		flags.FoldersOnly ||
+		len(flags.FolderSizes) > 0 ||
+		flags.FolderCounts != ""
+}
+
+// exporter writes rows of media or folder stats in one output format
+type exporter struct {
+	w        *bufio.Writer
+	format   string
+	columns  []string
+	explicit bool // columns were chosen by the user rather than defaulted
+	csv      *csv.Writer
+	tmpl     *template.Template
+	count    int
+}
+
+func newExporter(w *bufio.Writer, format, tmpl string, columns []string, explicit bool) (*exporter, error) {
+	e := &exporter{w: w, format: format, columns: columns, explicit: explicit}
+	switch format {
+	case "csv", "tsv":
+		e.csv = csv.NewWriter(w)
+		if format == "tsv" {
+			e.csv.Comma = '\t'
+		}
+	case "template":
+		if tmpl == "" {
+			return nil, fmt.Errorf("--format template needs --template")
+		}
+		t, err := template.New("export").Funcs(template.FuncMap{
+			"size":     func(v any) string { return utils.FormatSize(utils.GetInt64(deref(v))) },
+			"duration": func(v any) string { return utils.FormatDuration(int(utils.GetInt64(deref(v)))) },
+			"time":     func(v any) string { return utils.FormatTime(utils.GetInt64(deref(v))) },
+			"value":    deref,
+		}).Parse(tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %w", err)
+		}
+		e.tmpl = t
+	}
+	return e, nil
+}
+
+func (e *exporter) begin() error {
+	switch e.format {
+	case "csv", "tsv":
+		return e.csv.Write(e.columns)
+	case "markdown":
+		fmt.Fprintf(e.w, "| %s |\n", strings.Join(e.columns, " | "))
+		fmt.Fprintf(e.w, "|%s\n", strings.Repeat(" --- |", len(e.columns)))
+	case "html":
+		fmt.Fprint(e.w, exportHTMLHead)
+		fmt.Fprint(e.w, "<table>\n<thead><tr>")
+		for _, col := range e.columns {
+			fmt.Fprintf(e.w, "<th>%s</th>", html.EscapeString(col))
+		}
+		fmt.Fprint(e.w, "</tr></thead>\n<tbody>\n")
+	}
+	return nil
+}
+
+func (e *exporter) row(v any) error {
+	e.count++
+	switch e.format {
+	case "template":
+		if err := e.tmpl.Execute(e.w, v); err != nil {
+			return err
+		}
+		return e.w.WriteByte('\n')
+	case "jsonl":
+		enc := json.NewEncoder(e.w)
+		if !e.explicit {
+			return enc.Encode(v)
+		}
+		fields, err := exportFields(v)
+		if err != nil {
+			return err
+		}
+		obj := make(map[string]any, len(e.columns))
+		for _, col := range e.columns {
+			obj[col] = fields[col]
+		}
+		return enc.Encode(obj)
+	}
+
+	fields, err := exportFields(v)
+	if err != nil {
+		return err
+	}
+	human := e.format == "markdown" || e.format == "html"
+	values := make([]string, len(e.columns))
+	for i, col := range e.columns {
+		values[i] = formatExportValue(col, fields[col], human)
+	}
+
+	switch e.format {
+	case "csv", "tsv":
+		return e.csv.Write(values)
+	case "markdown":
+		for i := range values {
+			values[i] = strings.ReplaceAll(values[i], "|", `\|`)
+		}
+		_, err = fmt.Fprintf(e.w, "| %s |\n", strings.Join(values, " | "))
+	case "html":
+		fmt.Fprint(e.w, "<tr>")
+		for _, v := range values {
+			fmt.Fprintf(e.w, "<td>%s</td>", html.EscapeString(v))
+		}
+		_, err = fmt.Fprint(e.w, "</tr>\n")
+	}
+	return err
+}
+
+func (e *exporter) end() error {
+	switch e.format {
+	case "csv", "tsv":
+		e.csv.Flush()
+		return e.csv.Error()
+	case "html":
+		fmt.Fprintf(e.w, "</tbody>\n</table>\n<p>%d rows</p>\n</body>\n</html>\n", e.count)
+	}
+	return nil
+}
+
+const exportHTMLHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>disco export</title>
+<style>
+body { font-family: sans-serif; margin: 1em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.25em 0.5em; text-align: left; }
+th { background: #eee; position: sticky; top: 0; }
+tr:nth-child(even) { background: #f8f8f8; }
+</style>
+</head>
+<body>
+`
+
+// exportFields returns the JSON fields of a media row or folder, keyed by column name
+func exportFields(v any) (map[string]any, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var fields map[string]any
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	if _, ok := v.(models.FolderStats); ok {
+		// Same column names as print uses for folders
+		fields["size"] = fields["total_size"]
+		fields["duration"] = fields["total_duration"]
+	}
+	return fields, nil
+}
+
+// formatExportValue renders a field as text. Human formats show sizes, durations
+// and timestamps the way print does; the others keep raw values for scripts
+func formatExportValue(col string, v any, human bool) string {
+	if v == nil {
+		return ""
+	}
+	n, isNumber := v.(json.Number)
+	if !human || !isNumber {
+		return fmt.Sprint(v)
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return n.String()
+	}
+	switch {
+	case strings.HasSuffix(col, "size"):
+		return utils.FormatSize(i)
+	case strings.HasSuffix(col, "duration") || col == "playhead":
+		return utils.FormatDuration(int(i))
+	case strings.HasPrefix(col, "time_"):
+		return utils.FormatTime(i)
+	}
+	return n.String()
+}
+
+// deref lets templates use optional media fields without printing <nil>
+func deref(v any) any {
+	switch p := v.(type) {
+	case *string:
+		if p == nil {
+			return ""
+		}
+		return *p
+	case *int64:
+		if p == nil {
+			return int64(0)
+		}
+		return *p
+	case *float64:
+		if p == nil {
+			return float64(0)
+		}
+		return *p
+	}
+	return v
+}
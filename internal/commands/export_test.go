@@ -0,0 +1,124 @@
+package commands_test
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+func runExport(t *testing.T, cmd *commands.ExportCmd) string {
+	t.Helper()
+	cmd.Output = filepath.Join(t.TempDir(), "export")
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(cmd.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestExportCmd_Run(t *testing.T) {
+	dbPath, sqlDB := createSyncLibrary(t, "/media/")
+	mustExec(t, sqlDB, "UPDATE media SET size = 2048, duration = 90, title = 'A, with comma' WHERE path = '/media/a.mp4'")
+	mustExec(t, sqlDB, "UPDATE media SET size = 1024 WHERE path = '/media/b.mp4'")
+
+	t.Run("CSV", func(t *testing.T) {
+		out := runExport(t, &commands.ExportCmd{
+			Databases:    []string{dbPath},
+			DisplayFlags: models.DisplayFlags{Columns: []string{"path", "title", "size"}},
+		})
+		records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 || records[0][0] != "path" {
+			t.Fatalf("Unexpected CSV: %q", out)
+		}
+		found := false
+		for _, r := range records[1:] {
+			if r[0] == "/media/a.mp4" {
+				found = r[1] == "A, with comma" && r[2] == "2048"
+			}
+		}
+		if !found {
+			t.Errorf("Missing raw values for a.mp4: %q", out)
+		}
+	})
+
+	t.Run("JSONL", func(t *testing.T) {
+		out := runExport(t, &commands.ExportCmd{
+			Databases:    []string{dbPath},
+			Format:       "jsonl",
+			DisplayFlags: models.DisplayFlags{Columns: []string{"path", "size"}},
+		})
+		lines := strings.Split(strings.TrimSpace(out), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("Expected 2 lines, got %q", out)
+		}
+		var row map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+			t.Fatal(err)
+		}
+		if len(row) != 2 || row["path"] == nil {
+			t.Errorf("Unexpected row %v", row)
+		}
+	})
+
+	t.Run("MarkdownAndHTML", func(t *testing.T) {
+		md := runExport(t, &commands.ExportCmd{Databases: []string{dbPath}, Format: "markdown"})
+		if !strings.HasPrefix(md, "| path |") || !strings.Contains(md, "| 2.0 KB |") {
+			t.Errorf("Unexpected markdown: %q", md)
+		}
+		page := runExport(t, &commands.ExportCmd{Databases: []string{dbPath}, Format: "html"})
+		if !strings.Contains(page, "<!DOCTYPE html>") || !strings.Contains(page, "<td>/media/a.mp4</td>") ||
+			!strings.Contains(page, "<p>2 rows</p>") {
+			t.Errorf("Unexpected HTML: %q", page)
+		}
+	})
+
+	t.Run("Template", func(t *testing.T) {
+		out := runExport(t, &commands.ExportCmd{
+			Databases: []string{dbPath},
+			Template:  "{{.Path}}={{value .Size}}",
+		})
+		if !strings.Contains(out, "/media/a.mp4=2048\n") || !strings.Contains(out, "/media/b.mp4=1024\n") {
+			t.Errorf("Unexpected template output: %q", out)
+		}
+	})
+
+	t.Run("BigDirs", func(t *testing.T) {
+		out := runExport(t, &commands.ExportCmd{
+			Databases:      []string{dbPath},
+			Format:         "tsv",
+			AggregateFlags: models.AggregateFlags{BigDirs: true},
+		})
+		if !strings.HasPrefix(out, "path\tcount\texists_count\ttotal_size") || !strings.Contains(out, "/media\t2\t2\t3072") {
+			t.Errorf("Unexpected folder export: %q", out)
+		}
+	})
+
+	t.Run("SortedAcrossDatabases", func(t *testing.T) {
+		otherPath, other := createSyncLibrary(t, "/other/")
+		mustExec(t, other, "UPDATE media SET size = 1536 WHERE path = '/other/a.mp4'")
+		mustExec(t, other, "UPDATE media SET size = 4096 WHERE path = '/other/b.mp4'")
+
+		out := runExport(t, &commands.ExportCmd{
+			Databases:    []string{dbPath, otherPath},
+			QueryFlags:   models.QueryFlags{All: true},
+			SortFlags:    models.SortFlags{SortBy: "size"},
+			DisplayFlags: models.DisplayFlags{Columns: []string{"size"}},
+		})
+		if want := "size\n1024\n1536\n2048\n4096\n"; out != want {
+			t.Errorf("Expected rows from both databases in size order, got %q", out)
+		}
+	})
+}
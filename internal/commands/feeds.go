@@ -0,0 +1,491 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/feeds"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// FeedsCmd groups the podcast/RSS subscription subcommands
+type FeedsCmd struct {
+	Add    FeedsAddCmd    `help:"Subscribe to RSS/Atom/podcast feeds"     cmd:""`
+	Update FeedsUpdateCmd `help:"Fetch new episodes for subscribed feeds" cmd:""`
+}
+
+type FeedsAddCmd struct {
+	models.CoreFlags `embed:""`
+
+	Database         string   `help:"SQLite database file"                                                        required:"true" arg:""`
+	URLs             []string `help:"Feed URLs"                                                                   required:"true" arg:"" name:"urls"`
+	HoursUpdateDelay int      `help:"Minimum hours between refreshes of these feeds"                                                        default:"24"`
+	DownloadDir      string   `help:"Download enclosures into this directory so they are scanned as local media"`
+	NoUpdate         bool     `help:"Only subscribe; don't fetch episodes now"`
+
+	Client *http.Client `kong:"-"`
+}
+
+type FeedsUpdateCmd struct {
+	models.CoreFlags `embed:""`
+
+	Database string `help:"SQLite database file"                                     required:"true" arg:""`
+	Force    bool   `help:"Refresh feeds even if hours_update_delay has not elapsed"`
+
+	Client *http.Client `kong:"-"`
+}
+
+// feedConfig is stored as JSON in playlists.extractor_config
+type feedConfig struct {
+	DownloadDir string `json:"download_dir,omitempty"`
+	// Enclosure URLs still to be downloaded, so a failed or interrupted download is retried
+	PendingDownloads []string `json:"pending_downloads,omitempty"`
+}
+
+func parseFeedConfig(fp db.FeedPlaylist) feedConfig {
+	var cfg feedConfig
+	if fp.ExtractorConfig.Valid && fp.ExtractorConfig.String != "" {
+		if err := json.Unmarshal([]byte(fp.ExtractorConfig.String), &cfg); err != nil {
+			models.Log.Warn("Invalid feed extractor_config", "feed", fp.Path, "error", err)
+		}
+	}
+	return cfg
+}
+
+func (c *FeedsAddCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	if err := c.AfterApply(); err != nil {
+		return err
+	}
+
+	sqlDB, queries, err := db.ConnectWithInit(ctx, c.Database)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	cfg := feedConfig{}
+	if c.DownloadDir != "" {
+		absDir, err := filepath.Abs(c.DownloadDir)
+		if err != nil {
+			return err
+		}
+		cfg.DownloadDir = absDir
+	}
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, feedURL := range c.URLs {
+		if !strings.HasPrefix(feedURL, "http://") && !strings.HasPrefix(feedURL, "https://") {
+			return fmt.Errorf("feed URL must be http(s): %s", feedURL)
+		}
+
+		if c.Simulate {
+			fmt.Printf("(Simulated) would subscribe to %s\n", feedURL)
+			continue
+		}
+
+		if _, err := queries.UpsertFeedPlaylist(ctx, db.UpsertFeedPlaylistParams{
+			Path:             feedURL,
+			ExtractorConfig:  sql.NullString{String: string(cfgJSON), Valid: true},
+			HoursUpdateDelay: sql.NullInt64{Int64: int64(c.HoursUpdateDelay), Valid: true},
+		}); err != nil {
+			return fmt.Errorf("failed to subscribe to %s: %w", feedURL, err)
+		}
+		models.LogFrom(ctx).Info("Subscribed to feed", "url", feedURL)
+	}
+
+	if c.NoUpdate || c.Simulate {
+		return nil
+	}
+
+	updater := &feedUpdater{
+		dbPath:  c.Database,
+		sqlDB:   sqlDB,
+		queries: queries,
+		client:  c.Client,
+		verbose: c.Verbose,
+	}
+	subscribed, err := queries.GetFeedPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, fp := range subscribed {
+		if !slices.Contains(c.URLs, fp.Path) {
+			continue
+		}
+		if err := updater.update(ctx, fp); err != nil {
+			models.LogFrom(ctx).Error("Failed to update feed", "url", fp.Path, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", fp.Path, err))
+		}
+	}
+
+	if err := updater.finish(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (c *FeedsUpdateCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	return c.run(ctx)
+}
+
+// run is Run without replacing the global logger, for jobs that log through ctx
+func (c *FeedsUpdateCmd) run(ctx context.Context) error {
+	if err := c.AfterApply(); err != nil {
+		return err
+	}
+
+	sqlDB, queries, err := db.ConnectWithInit(ctx, c.Database)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	subscribed, err := queries.GetFeedPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+	if len(subscribed) == 0 {
+		fmt.Println("No feed subscriptions. Use 'disco feeds add' first.")
+		return nil
+	}
+
+	updater := &feedUpdater{
+		dbPath:   c.Database,
+		sqlDB:    sqlDB,
+		queries:  queries,
+		client:   c.Client,
+		simulate: c.Simulate,
+		verbose:  c.Verbose,
+	}
+	now := time.Now()
+	var errs []error
+	for _, fp := range subscribed {
+		if !c.Force && !feedIsDue(fp, now) {
+			models.LogFrom(ctx).Info("Feed not due for refresh, skipping", "url", fp.Path)
+			continue
+		}
+		if err := updater.update(ctx, fp); err != nil {
+			models.LogFrom(ctx).Error("Failed to update feed", "url", fp.Path, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", fp.Path, err))
+		}
+	}
+
+	if err := updater.finish(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// feedIsDue reports whether hours_update_delay has elapsed since the last refresh
+func feedIsDue(fp db.FeedPlaylist, now time.Time) bool {
+	if !fp.TimeModified.Valid || fp.TimeModified.Int64 == 0 {
+		return true
+	}
+	delay := time.Duration(fp.HoursUpdateDelay.Int64) * time.Hour
+	return !now.Before(time.Unix(fp.TimeModified.Int64, 0).Add(delay))
+}
+
+type feedUpdater struct {
+	dbPath   string
+	sqlDB    *sql.DB
+	queries  *db.Queries
+	client   *http.Client
+	simulate bool
+	verbose  int
+
+	// Directories that received new downloads and need a regular scan
+	downloadedDirs []string
+}
+
+func (u *feedUpdater) update(ctx context.Context, fp db.FeedPlaylist) error {
+	fetchCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	feed, err := feeds.Fetch(fetchCtx, u.client, fp.Path)
+	if err != nil {
+		return err
+	}
+
+	title := feed.Title
+	if title == "" {
+		title = fp.Path
+	}
+
+	episodes := resolveEnclosures(fp.Path, feed.Episodes)
+
+	if u.simulate {
+		fmt.Printf("(Simulated) %s: %d episodes\n", title, len(episodes))
+		return nil
+	}
+
+	cfg := parseFeedConfig(fp)
+	newCount, queue, err := u.saveEpisodes(ctx, fp, feed, episodes, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d episodes (%d new)\n", title, len(episodes), newCount)
+	if len(queue) == 0 {
+		return nil
+	}
+
+	feedDir := filepath.Join(cfg.DownloadDir, utils.SanitizeFilename(title))
+	downloaded, failed := u.downloadEpisodes(ctx, feedDir, queue)
+	if downloaded > 0 {
+		u.downloadedDirs = append(u.downloadedDirs, feedDir)
+	}
+
+	cfg.PendingDownloads = failed
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return u.queries.SetFeedConfig(ctx, db.SetFeedConfigParams{
+		ExtractorConfig: sql.NullString{String: string(cfgJSON), Valid: true},
+		ID:              fp.ID,
+	})
+}
+
+// resolveEnclosures drops items without an enclosure and makes relative enclosure URLs absolute
+func resolveEnclosures(feedURL string, episodes []feeds.Episode) []feeds.Episode {
+	base, baseErr := url.Parse(feedURL)
+
+	var out []feeds.Episode
+	for _, ep := range episodes {
+		if ep.EnclosureURL == "" {
+			continue
+		}
+		if baseErr == nil {
+			if ref, err := url.Parse(ep.EnclosureURL); err == nil {
+				ep.EnclosureURL = base.ResolveReference(ref).String()
+			}
+		}
+		out = append(out, ep)
+	}
+	return out
+}
+
+// saveEpisodes stores the episodes and, when the feed downloads enclosures, returns the new
+// ones plus earlier failures as the download queue. The queue is saved as pending in the same
+// transaction, so an interrupted run retries it next time
+func (u *feedUpdater) saveEpisodes(
+	ctx context.Context,
+	fp db.FeedPlaylist,
+	feed *feeds.Feed,
+	episodes []feeds.Episode,
+	cfg feedConfig,
+) (newCount int, queue []feeds.Episode, err error) {
+	tx, err := u.sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	qtx := u.queries.WithTx(tx)
+
+	now := time.Now().Unix()
+	for _, ep := range episodes {
+		isNew, err := qtx.UpsertFeedEpisode(ctx, db.UpsertFeedEpisodeParams{
+			Path:           ep.EnclosureURL,
+			PathTokenized:  utils.ToNullString(utils.PathToTokenized(ep.EnclosureURL)),
+			Title:          utils.ToNullString(ep.Title),
+			Description:    utils.ToNullString(ep.Description),
+			Duration:       sql.NullInt64{Int64: ep.Duration, Valid: ep.Duration > 0},
+			Size:           sql.NullInt64{Int64: ep.EnclosureLength, Valid: ep.EnclosureLength > 0},
+			TimeCreated:    sql.NullInt64{Int64: ep.Published, Valid: ep.Published > 0},
+			MediaType:      utils.ToNullString(ep.MediaType()),
+			TimeDownloaded: utils.ToNullInt64(now),
+		})
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to save episode %s: %w", ep.EnclosureURL, err)
+		}
+		if err := qtx.AddPlaylistItem(ctx, db.AddPlaylistItemParams{
+			PlaylistID: fp.ID,
+			MediaPath:  ep.EnclosureURL,
+		}); err != nil {
+			return 0, nil, fmt.Errorf("failed to link episode %s: %w", ep.EnclosureURL, err)
+		}
+		if isNew {
+			newCount++
+		}
+		if cfg.DownloadDir != "" && (isNew || slices.Contains(cfg.PendingDownloads, ep.EnclosureURL)) {
+			queue = append(queue, ep)
+		}
+	}
+
+	// Pending episodes that left the feed are dropped
+	var cfgJSON sql.NullString
+	if cfg.DownloadDir != "" {
+		cfg.PendingDownloads = nil
+		for _, ep := range queue {
+			cfg.PendingDownloads = append(cfg.PendingDownloads, ep.EnclosureURL)
+		}
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			return 0, nil, err
+		}
+		cfgJSON = sql.NullString{String: string(b), Valid: true}
+	}
+
+	if err := qtx.MarkFeedUpdated(ctx, db.MarkFeedUpdatedParams{
+		Title:           utils.ToNullString(feed.Title),
+		ExtractorConfig: cfgJSON,
+		TimeModified:    utils.ToNullInt64(now),
+		ID:              fp.ID,
+	}); err != nil {
+		return 0, nil, err
+	}
+
+	return newCount, queue, tx.Commit()
+}
+
+// downloadEpisodes fetches the enclosures into feedDir and returns how many were downloaded
+// and the enclosure URLs that failed
+func (u *feedUpdater) downloadEpisodes(
+	ctx context.Context,
+	feedDir string,
+	episodes []feeds.Episode,
+) (downloaded int, failed []string) {
+	if err := os.MkdirAll(feedDir, 0o755); err != nil {
+		models.LogFrom(ctx).Error("Failed to create download directory", "path", feedDir, "error", err)
+		for _, ep := range episodes {
+			failed = append(failed, ep.EnclosureURL)
+		}
+		return 0, failed
+	}
+
+	for _, ep := range episodes {
+		dest := filepath.Join(feedDir, episodeFilename(ep, false))
+		if utils.FileExists(dest) {
+			// Another episode with the same date and title
+			dest = filepath.Join(feedDir, episodeFilename(ep, true))
+		}
+		if utils.FileExists(dest) {
+			continue
+		}
+
+		if err := u.downloadFile(ctx, ep.EnclosureURL, dest); err != nil {
+			models.LogFrom(ctx).Error("Failed to download episode", "url", ep.EnclosureURL, "error", err)
+			failed = append(failed, ep.EnclosureURL)
+			continue
+		}
+		if ep.Published > 0 {
+			published := time.Unix(ep.Published, 0)
+			_ = os.Chtimes(dest, published, published)
+		}
+		models.LogFrom(ctx).Info("Downloaded episode", "path", dest)
+		downloaded++
+	}
+	return downloaded, failed
+}
+
+// episodeFilename names a download after its publish date and title. unique adds a short
+// hash of the GUID, or of the enclosure URL without one, to tell apart same-day namesakes
+func episodeFilename(ep feeds.Episode, unique bool) string {
+	name := ep.Title
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(ep.EnclosureURL), ep.Ext())
+	}
+	if ep.Published > 0 {
+		name = time.Unix(ep.Published, 0).UTC().Format("2006-01-02") + " " + name
+	}
+	name = utils.SanitizeFilename(name)
+	if unique {
+		sum := sha1.Sum([]byte(cmp.Or(ep.GUID, ep.EnclosureURL)))
+		name += "_" + hex.EncodeToString(sum[:4])
+	}
+	return name + ep.Ext()
+}
+
+func (u *feedUpdater) downloadFile(ctx context.Context, src, dest string) error {
+	client := u.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	// Write to a temp file first so a partial download is never scanned
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// finish scans any directories that received downloads so they become regular local media
+func (u *feedUpdater) finish(ctx context.Context) error {
+	if len(u.downloadedDirs) == 0 {
+		return nil
+	}
+
+	// add skips paths nested under an existing scan root, so rescan that root instead
+	roots, err := u.queries.GetPlaylists(ctx)
+	if err != nil {
+		return err
+	}
+	var scanPaths []string
+	for _, dir := range u.downloadedDirs {
+		scanPath := dir
+		for _, pl := range roots {
+			if pl.Path.Valid && strings.HasPrefix(filepath.ToSlash(dir), filepath.ToSlash(pl.Path.String)+"/") {
+				scanPath = pl.Path.String
+				break
+			}
+		}
+		if !slices.Contains(scanPaths, scanPath) {
+			scanPaths = append(scanPaths, scanPath)
+		}
+	}
+
+	addCmd := &AddCmd{
+		Args: append([]string{u.dbPath}, scanPaths...),
+	}
+	addCmd.Verbose = u.verbose
+	if err := addCmd.AfterApply(); err != nil {
+		return err
+	}
+	// The feeds command already set up logging
+	return addCmd.run(ctx)
+}
@@ -0,0 +1,288 @@
+package commands_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+const testFeedXML = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Local Show</title>
+    <item>
+      <title>Pilot</title>
+      <description>The first one</description>
+      <pubDate>Mon, 01 Jan 2024 10:00:00 +0000</pubDate>
+      <enclosure url="/media/pilot.mp3" length="11" type="audio/mpeg"/>
+      <itunes:duration>10:00</itunes:duration>
+    </item>
+    %s
+  </channel>
+</rss>`
+
+const testFeedSecondItem = `<item>
+      <title>Sequel</title>
+      <enclosure url="/media/sequel.mp3" type="audio/mpeg"/>
+    </item>`
+
+type feedServer struct {
+	*httptest.Server
+	fetches   atomic.Int32
+	extra     atomic.Value
+	failMedia atomic.Bool
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	fs := &feedServer{}
+	fs.extra.Store("")
+	fs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/feed.xml":
+			fs.fetches.Add(1)
+			fmt.Fprintf(w, testFeedXML, fs.extra.Load().(string))
+		case r.URL.Path == "/media/sequel.mp3" && fs.failMedia.Load():
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case strings.HasPrefix(r.URL.Path, "/media/"):
+			w.Write([]byte("audio bytes"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(fs.Close)
+	return fs
+}
+
+func TestFeedsAddAndUpdate(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	server := newFeedServer(t)
+	feedURL := server.URL + "/feed.xml"
+
+	addCmd := &commands.FeedsAddCmd{
+		Database:         fixture.DBPath,
+		URLs:             []string{feedURL},
+		HoursUpdateDelay: 24,
+		Client:           server.Client(),
+	}
+	if err := addCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsAddCmd failed: %v", err)
+	}
+
+	dbConn := fixture.GetDB()
+	defer dbConn.Close()
+
+	var title, description, mediaType string
+	var duration, size, timeCreated int64
+	err := dbConn.QueryRow(
+		"SELECT title, description, media_type, duration, size, time_created FROM media WHERE path = ?",
+		server.URL+"/media/pilot.mp3",
+	).Scan(&title, &description, &mediaType, &duration, &size, &timeCreated)
+	if err != nil {
+		t.Fatalf("Episode not inserted: %v", err)
+	}
+	if title != "Pilot" || description != "The first one" || mediaType != "audio" {
+		t.Errorf("Unexpected episode metadata: %q %q %q", title, description, mediaType)
+	}
+	if duration != 600 || size != 11 || timeCreated != 1704103200 {
+		t.Errorf("Unexpected episode numbers: duration=%d size=%d time_created=%d", duration, size, timeCreated)
+	}
+
+	var playlistTitle string
+	var itemCount int
+	if err := dbConn.QueryRow(
+		"SELECT p.title, (SELECT COUNT(*) FROM playlist_items WHERE playlist_id = p.id) FROM playlists p WHERE p.path = ?",
+		feedURL,
+	).Scan(&playlistTitle, &itemCount); err != nil {
+		t.Fatalf("Feed playlist missing: %v", err)
+	}
+	if playlistTitle != "Local Show" || itemCount != 1 {
+		t.Errorf("Expected playlist 'Local Show' with 1 item, got %q with %d", playlistTitle, itemCount)
+	}
+
+	// A new episode appears, but hours_update_delay has not elapsed
+	server.extra.Store(testFeedSecondItem)
+	updateCmd := &commands.FeedsUpdateCmd{Database: fixture.DBPath, Client: server.Client()}
+	if err := updateCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsUpdateCmd failed: %v", err)
+	}
+	if got := server.fetches.Load(); got != 1 {
+		t.Errorf("Expected feed to be skipped before its delay elapsed, got %d fetches", got)
+	}
+
+	updateCmd.Force = true
+	if err := updateCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsUpdateCmd --force failed: %v", err)
+	}
+	var count int
+	dbConn.QueryRow("SELECT COUNT(*) FROM media WHERE path LIKE 'http%'").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 episodes after forced update, got %d", count)
+	}
+}
+
+func TestFeedsUpdate_KeepsDeletedEpisodesDeleted(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	server := newFeedServer(t)
+
+	addCmd := &commands.FeedsAddCmd{
+		Database: fixture.DBPath,
+		URLs:     []string{server.URL + "/feed.xml"},
+		Client:   server.Client(),
+	}
+	if err := addCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsAddCmd failed: %v", err)
+	}
+
+	dbConn := fixture.GetDB()
+	defer dbConn.Close()
+	episode := server.URL + "/media/pilot.mp3"
+	if _, err := dbConn.Exec("UPDATE media SET time_deleted = 1 WHERE path = ?", episode); err != nil {
+		t.Fatal(err)
+	}
+
+	updateCmd := &commands.FeedsUpdateCmd{Database: fixture.DBPath, Force: true, Client: server.Client()}
+	if err := updateCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsUpdateCmd failed: %v", err)
+	}
+
+	var timeDeleted int64
+	dbConn.QueryRow("SELECT time_deleted FROM media WHERE path = ?", episode).Scan(&timeDeleted)
+	if timeDeleted == 0 {
+		t.Error("Expected deleted episode to stay deleted after refresh")
+	}
+}
+
+func TestFeedsAdd_DownloadDir(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	server := newFeedServer(t)
+	downloadDir := filepath.Join(fixture.TempDir, "podcasts")
+
+	addCmd := &commands.FeedsAddCmd{
+		Database:    fixture.DBPath,
+		URLs:        []string{server.URL + "/feed.xml"},
+		DownloadDir: downloadDir,
+		Client:      server.Client(),
+	}
+	if err := addCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsAddCmd failed: %v", err)
+	}
+
+	downloaded := filepath.Join(downloadDir, "Local_Show", "2024-01-01_Pilot.mp3")
+	data, err := os.ReadFile(downloaded)
+	if err != nil {
+		t.Fatalf("Expected enclosure to be downloaded: %v", err)
+	}
+	if string(data) != "audio bytes" {
+		t.Errorf("Unexpected downloaded content %q", data)
+	}
+
+	dbConn := fixture.GetDB()
+	defer dbConn.Close()
+	var count int
+	dbConn.QueryRow("SELECT COUNT(*) FROM media WHERE path = ?", downloaded).Scan(&count)
+	if count != 1 {
+		t.Error("Expected downloaded file to be scanned into media")
+	}
+}
+
+func TestFeedsUpdate_RetriesFailedDownloads(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	server := newFeedServer(t)
+	downloadDir := filepath.Join(fixture.TempDir, "podcasts")
+
+	// A second "Pilot" on the same day must not be mistaken for the first one's file
+	server.extra.Store(`<item>
+      <title>Pilot</title>
+      <guid>pilot-rerun</guid>
+      <pubDate>Mon, 01 Jan 2024 18:00:00 +0000</pubDate>
+      <enclosure url="/media/sequel.mp3" type="audio/mpeg"/>
+    </item>`)
+	server.failMedia.Store(true)
+
+	addCmd := &commands.FeedsAddCmd{
+		Database:    fixture.DBPath,
+		URLs:        []string{server.URL + "/feed.xml"},
+		DownloadDir: downloadDir,
+		Client:      server.Client(),
+	}
+	if err := addCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsAddCmd failed: %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(downloadDir, "Local_Show", "*.mp3"))
+	if len(files) != 1 {
+		t.Fatalf("Expected only the first episode to download, got %v", files)
+	}
+
+	// Subscribing again keeps the failed episode pending
+	readd := &commands.FeedsAddCmd{
+		Database: fixture.DBPath,
+		URLs:     []string{server.URL + "/feed.xml"},
+		NoUpdate: true,
+	}
+	if err := readd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsAddCmd failed: %v", err)
+	}
+
+	// The failed episode is no longer new, but is still downloaded on the next refresh
+	server.failMedia.Store(false)
+	updateCmd := &commands.FeedsUpdateCmd{Database: fixture.DBPath, Force: true, Client: server.Client()}
+	if err := updateCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsUpdateCmd failed: %v", err)
+	}
+	files, _ = filepath.Glob(filepath.Join(downloadDir, "Local_Show", "2024-01-01_Pilot*.mp3"))
+	if len(files) != 2 {
+		t.Errorf("Expected both same-day episodes to be downloaded, got %v", files)
+	}
+
+	// Nothing is left pending, so a later refresh downloads nothing again
+	for _, f := range files {
+		os.Remove(f)
+	}
+	if err := updateCmd.Run(context.Background()); err != nil {
+		t.Fatalf("FeedsUpdateCmd failed: %v", err)
+	}
+	if files, _ = filepath.Glob(filepath.Join(downloadDir, "Local_Show", "*.mp3")); len(files) != 0 {
+		t.Errorf("Expected finished downloads not to be fetched again, got %v", files)
+	}
+}
+
+func TestFeedsAdd_RejectsNonHTTP(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+
+	addCmd := &commands.FeedsAddCmd{
+		Database: fixture.DBPath,
+		URLs:     []string{"/tmp/feed.xml"},
+	}
+	if err := addCmd.Run(context.Background()); err == nil {
+		t.Error("Expected error for non-http feed URL")
+	}
+}
+
+func TestFeedsAdd_ReportsFetchErrors(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	server := newFeedServer(t)
+
+	addCmd := &commands.FeedsAddCmd{
+		Database: fixture.DBPath,
+		URLs:     []string{server.URL + "/missing.xml"},
+		Client:   server.Client(),
+	}
+	if err := addCmd.Run(context.Background()); err == nil {
+		t.Error("Expected an error when the first fetch fails")
+	}
+}
@@ -0,0 +1,423 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type FreeSpaceCmd struct {
+	models.CoreFlags        `embed:""`
+	models.PathFilterFlags  `embed:""`
+	models.FilterFlags      `embed:""`
+	models.MediaFilterFlags `embed:""`
+	models.TimeFilterFlags  `embed:""`
+	models.PostActionFlags  `embed:""`
+
+	Target string   `help:"Amount of space to free (e.g. 500GB)"                                   required:"true"`
+	Mount  string   `help:"Only consider files on the filesystem of this path"`
+	Weight []string `help:"Reason weight: watched, score, duplicate, stale, size-rate or series=N"`
+
+	Databases []string `help:"SQLite database files"                                                  required:"true" arg:"" type:"existingfile"`
+}
+
+// freeSpaceWeights are the default weights of the reasons a file is worth deleting.
+// Each reason contributes weight times a factor between 0 and 1
+var freeSpaceWeights = map[string]float64{
+	"watched":   3,
+	"score":     2,
+	"duplicate": 4,
+	"stale":     1,
+	"size-rate": 1,
+	"series":    2,
+}
+
+// freeSpaceLabels describe a reason when counting it across a folder
+var freeSpaceLabels = map[string]string{
+	"score":     "low score",
+	"duplicate": "duplicate",
+	"stale":     "not played recently",
+	"size-rate": "large per minute",
+}
+
+type freeReason struct{ kind, text string }
+
+// freeCandidate is a file, or a completed folder with all of its files
+type freeCandidate struct {
+	path    string
+	media   []models.MediaWithDB
+	size    int64
+	score   float64
+	reasons []freeReason
+}
+
+type folderProgress struct{ files, watched int }
+
+// freeSpaceLibrary holds what scoring needs to know beyond the candidates themselves
+type freeSpaceLibrary struct {
+	duplicateOf map[string]string
+	keepers     map[string]bool
+	folders     map[string]folderProgress
+	// shared files are hard-linked or deduplicated, so deleting them frees nothing
+	shared map[string]bool
+}
+
+func (c *FreeSpaceCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	target, err := utils.HumanToBytes(c.Target)
+	if err != nil || target <= 0 {
+		return fmt.Errorf("invalid --target: %s", c.Target)
+	}
+	weights, err := parseFreeSpaceWeights(c.Weight)
+	if err != nil {
+		return err
+	}
+
+	flags := models.GlobalFlags{
+		CoreFlags:        c.CoreFlags,
+		PathFilterFlags:  c.PathFilterFlags,
+		FilterFlags:      c.FilterFlags,
+		MediaFilterFlags: c.MediaFilterFlags,
+		TimeFilterFlags:  c.TimeFilterFlags,
+		PostActionFlags:  c.PostActionFlags,
+	}
+	// The plan ranks the whole library, not a page of it
+	flags.All = true
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return err
+	}
+	media = query.FilterMedia(media, flags)
+	media, shared, err := c.onDisk(media)
+	if err != nil {
+		return err
+	}
+
+	library, err := loadFreeSpaceLibrary(ctx, c.Databases)
+	if err != nil {
+		return err
+	}
+	library.shared = shared
+	candidates := scoreFreeSpace(media, library, weights, time.Now())
+	plan, freed := planFreeSpace(candidates, target)
+	if len(plan) == 0 {
+		fmt.Println("No files match any reason to delete")
+		return nil
+	}
+
+	fmt.Println(strings.Join([]string{"#", "size", "total", "score", "path", "reasons"}, "\t"))
+	var total int64
+	var planMedia []models.MediaWithDB
+	for i, fc := range plan {
+		total += fc.size
+		texts := make([]string, len(fc.reasons))
+		for j, r := range fc.reasons {
+			texts[j] = r.text
+		}
+		fmt.Println(strings.Join([]string{
+			strconv.Itoa(i + 1), utils.FormatSize(fc.size), utils.FormatSize(total),
+			strconv.FormatFloat(fc.score, 'f', 1, 64), fc.path, strings.Join(texts, "; "),
+		}, "\t"))
+		planMedia = append(planMedia, fc.media...)
+	}
+	if freed < target {
+		fmt.Printf("\nOnly %s of %s can be freed with these filters and weights\n",
+			utils.FormatSize(freed), utils.FormatSize(target))
+	} else {
+		fmt.Printf("\n%d files, %s of %s\n", len(planMedia), utils.FormatSize(freed), utils.FormatSize(target))
+	}
+
+	action := postActionName(c.PostActionFlags)
+	if action == "" {
+		fmt.Println("Rerun with --trash or --post-action to apply the plan")
+		return nil
+	}
+	if c.Simulate {
+		return nil
+	}
+	if !c.NoConfirm && !utils.Confirm(fmt.Sprintf("%s %d files (%s)?", action, len(planMedia), utils.FormatSize(freed))) {
+		return nil
+	}
+	return ExecutePostAction(ctx, flags, planMedia)
+}
+
+// onDisk drops files that are missing, since deleting them frees nothing, and with
+// --mount files on other filesystems. It also returns the files whose data is shared
+// with another path
+func (c *FreeSpaceCmd) onDisk(media []models.MediaWithDB) ([]models.MediaWithDB, map[string]bool, error) {
+	var mount string
+	if c.Mount != "" {
+		var err error
+		if mount, err = utils.GetMountPoint(c.Mount); err != nil {
+			return nil, nil, err
+		}
+	}
+	mounts := map[string]string{}
+	shared := map[string]bool{}
+	var kept []models.MediaWithDB
+	for _, m := range media {
+		info, err := os.Stat(m.Path)
+		if err != nil {
+			continue
+		}
+		if mount != "" {
+			dir := filepath.Dir(m.Path)
+			mp, ok := mounts[dir]
+			if !ok {
+				mp, _ = utils.GetMountPoint(dir)
+				mounts[dir] = mp
+			}
+			if mp != mount {
+				continue
+			}
+		}
+		if utils.GetLinkCount(info) > 1 || (m.IsDeduped != nil && *m.IsDeduped != 0) {
+			shared[m.Path] = true
+		}
+		kept = append(kept, m)
+	}
+	return kept, shared, nil
+}
+
+func parseFreeSpaceWeights(entries []string) (map[string]float64, error) {
+	weights := make(map[string]float64, len(freeSpaceWeights))
+	for k, v := range freeSpaceWeights {
+		weights[k] = v
+	}
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if _, known := freeSpaceWeights[name]; !ok || !known {
+			return nil, fmt.Errorf("invalid --weight %q, expected one of %s=N",
+				entry, strings.Join(sortedKeys(freeSpaceWeights), ", "))
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid --weight %q: weight must be a number >= 0", entry)
+		}
+		weights[name] = w
+	}
+	return weights, nil
+}
+
+// loadFreeSpaceLibrary reads the hashes and play counts of every file, so that
+// duplicates and completed folders are found even outside of the filtered media
+func loadFreeSpaceLibrary(ctx context.Context, dbs []string) (freeSpaceLibrary, error) {
+	library := freeSpaceLibrary{
+		duplicateOf: map[string]string{},
+		keepers:     map[string]bool{},
+		folders:     map[string]folderProgress{},
+	}
+	groups := map[string][]string{}
+	sha256s := map[string]string{}
+	seen := map[string]bool{}
+	for _, dbPath := range dbs {
+		sqlDB, err := db.Connect(ctx, dbPath)
+		if err != nil {
+			return library, err
+		}
+		rows, err := sqlDB.QueryContext(ctx, `
+			SELECT path, COALESCE(size, 0), COALESCE(fasthash, ''), COALESCE(sha256, ''), COALESCE(play_count, 0)
+			FROM media WHERE COALESCE(time_deleted, 0) = 0`)
+		if err != nil {
+			sqlDB.Close()
+			return library, err
+		}
+		for rows.Next() {
+			var path, fasthash, sha256 string
+			var size, plays int64
+			if err := rows.Scan(&path, &size, &fasthash, &sha256, &plays); err != nil {
+				rows.Close()
+				sqlDB.Close()
+				return library, err
+			}
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			if sha256 != "" {
+				sha256s[path] = sha256
+			}
+			if hash := cmp.Or(fasthash, sha256); hash != "" && size > 0 {
+				key := strconv.FormatInt(size, 10) + ":" + hash
+				groups[key] = append(groups[key], path)
+			}
+			folder := library.folders[filepath.Dir(path)]
+			folder.files++
+			if plays > 0 {
+				folder.watched++
+			}
+			library.folders[filepath.Dir(path)] = folder
+		}
+		err = rows.Err()
+		rows.Close()
+		sqlDB.Close()
+		if err != nil {
+			return library, err
+		}
+	}
+
+	// A fasthash only samples the file, so copies are confirmed by their full hash.
+	// The first copy by path is kept; every other copy is a duplicate of it
+	for _, candidates := range groups {
+		if len(candidates) < 2 {
+			continue
+		}
+		copies := map[string][]string{}
+		for _, p := range candidates {
+			hash, ok := sha256s[p]
+			if !ok {
+				var err error
+				if hash, err = utils.FullHashFile(p); err != nil {
+					continue
+				}
+			}
+			copies[hash] = append(copies[hash], p)
+		}
+		for _, paths := range copies {
+			if len(paths) < 2 {
+				continue
+			}
+			slices.Sort(paths)
+			library.keepers[paths[0]] = true
+			for _, p := range paths[1:] {
+				library.duplicateOf[p] = paths[0]
+			}
+		}
+	}
+	return library, nil
+}
+
+// scoreFreeSpace scores each file by its reasons to be deleted. Files of a completed
+// folder become one candidate for the whole folder
+func scoreFreeSpace(
+	media []models.MediaWithDB,
+	library freeSpaceLibrary,
+	weights map[string]float64,
+	now time.Time,
+) []freeCandidate {
+	// size-rate factor: the share of files with a lower size per second
+	var rates []float64
+	for _, m := range media {
+		if m.Duration != nil && *m.Duration > 0 && m.Size != nil {
+			rates = append(rates, float64(*m.Size)/float64(*m.Duration))
+		}
+	}
+	slices.Sort(rates)
+
+	byFolder := map[string][]freeCandidate{}
+	for _, m := range media {
+		// Deleting the kept copy along with its duplicates would lose the file
+		if library.keepers[m.Path] {
+			continue
+		}
+		fc := freeCandidate{path: m.Path, media: []models.MediaWithDB{m}}
+		if m.Size != nil && !library.shared[m.Path] {
+			fc.size = *m.Size
+		}
+		add := func(kind string, factor float64, text string) {
+			if factor <= 0 || weights[kind] == 0 {
+				return
+			}
+			fc.score += weights[kind] * min(factor, 1)
+			fc.reasons = append(fc.reasons, freeReason{kind, text})
+		}
+
+		if m.PlayCount != nil && *m.PlayCount > 0 {
+			text := "watched once"
+			if *m.PlayCount > 1 {
+				text = fmt.Sprintf("watched %d times", *m.PlayCount)
+			}
+			add("watched", 1, text)
+		}
+		if m.Score != nil {
+			add("score", (5-*m.Score)/4, fmt.Sprintf("score %g", *m.Score))
+		}
+		if original, ok := library.duplicateOf[m.Path]; ok {
+			add("duplicate", 1, "duplicate of "+original)
+		}
+		if m.TimeLastPlayed != nil && *m.TimeLastPlayed > 0 {
+			days := int(now.Sub(time.Unix(*m.TimeLastPlayed, 0)).Hours() / 24)
+			if days >= 30 {
+				add("stale", float64(days)/365, fmt.Sprintf("last played %d days ago", days))
+			}
+		}
+		if m.Duration != nil && *m.Duration > 0 && m.Size != nil && len(rates) > 1 {
+			rate := float64(*m.Size) / float64(*m.Duration)
+			lower, _ := slices.BinarySearch(rates, rate)
+			share := float64(lower) / float64(len(rates)-1)
+			if share >= 0.5 {
+				add("size-rate", share, fmt.Sprintf("%s/min, more than %d%% of files",
+					utils.FormatSize(int64(rate*60)), int(share*100)))
+			}
+		}
+		byFolder[filepath.Dir(m.Path)] = append(byFolder[filepath.Dir(m.Path)], fc)
+	}
+
+	var candidates []freeCandidate
+	for _, dir := range sortedKeys(byFolder) {
+		files := byFolder[dir]
+		progress := library.folders[dir]
+		if weights["series"] > 0 && len(files) >= 2 && len(files) == progress.files && progress.watched == progress.files {
+			candidates = append(candidates, folderCandidate(dir, files, weights["series"]))
+			continue
+		}
+		for _, fc := range files {
+			if fc.score > 0 && fc.size > 0 {
+				candidates = append(candidates, fc)
+			}
+		}
+	}
+	return candidates
+}
+
+// folderCandidate merges the files of a completed folder. Its score is the series
+// weight plus the mean score of its files
+func folderCandidate(dir string, files []freeCandidate, seriesWeight float64) freeCandidate {
+	fc := freeCandidate{path: dir}
+	counts := map[string]int{}
+	var sum float64
+	for _, f := range files {
+		fc.media = append(fc.media, f.media...)
+		fc.size += f.size
+		sum += f.score
+		for _, r := range f.reasons {
+			counts[r.kind]++
+		}
+	}
+	fc.score = seriesWeight + sum/float64(len(files))
+	fc.reasons = []freeReason{{"series", fmt.Sprintf("completed folder, all %d files watched", len(files))}}
+	for _, kind := range sortedKeys(counts) {
+		if label, ok := freeSpaceLabels[kind]; ok {
+			fc.reasons = append(fc.reasons, freeReason{kind, fmt.Sprintf("%d %s", counts[kind], label)})
+		}
+	}
+	return fc
+}
+
+// planFreeSpace takes the highest scoring candidates until target bytes are freed
+func planFreeSpace(candidates []freeCandidate, target int64) ([]freeCandidate, int64) {
+	slices.SortFunc(candidates, func(a, b freeCandidate) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), cmp.Compare(b.size, a.size), cmp.Compare(a.path, b.path))
+	})
+	var plan []freeCandidate
+	var freed int64
+	for _, fc := range candidates {
+		if freed >= target {
+			break
+		}
+		plan = append(plan, fc)
+		freed += fc.size
+	}
+	return plan, freed
+}
@@ -0,0 +1,88 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+func TestFreeSpaceCmd(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	fixture.CreateFileTree(map[string]any{
+		"a.mkv":       "same bytes",
+		"b.mkv":       "same bytes",
+		"c.mkv":       "sampled alike",
+		"d.mkv":       "sampled alike, but not the same",
+		"linked.mkv":  "l",
+		"watched.mkv": "w",
+		"fresh.mkv":   "f",
+		"show":        map[string]any{"e1.mkv": "1", "e2.mkv": "2"},
+	})
+	path := func(rel string) string { return filepath.Join(fixture.TempDir, rel) }
+	if err := os.Link(path("linked.mkv"), path("linked-copy.mkv")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	now := time.Now().Unix()
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	insert := "INSERT INTO media (path, size, fasthash, play_count, time_last_played, score) VALUES (?, ?, ?, ?, ?, ?)"
+	// The kept copy of a duplicate pair and a hard-linked file score highest, but
+	// deleting them would lose data or free nothing
+	sqlDB.Exec(insert, path("a.mkv"), 1000, "same", 1, now, 1)
+	sqlDB.Exec(insert, path("b.mkv"), 1000, "same", 0, 0, nil)
+	sqlDB.Exec(insert, path("linked.mkv"), 1000, nil, 1, now, 1)
+	// A matching fasthash alone is not a duplicate
+	sqlDB.Exec(insert, path("c.mkv"), 2000, "near", 0, 0, nil)
+	sqlDB.Exec(insert, path("d.mkv"), 2000, "near", 0, 0, nil)
+	sqlDB.Exec(insert, path("watched.mkv"), 1000, nil, 1, now, nil)
+	sqlDB.Exec(insert, path("fresh.mkv"), 5000, nil, 0, 0, nil)
+	sqlDB.Exec(insert, path("show/e1.mkv"), 500, nil, 1, now, nil)
+	sqlDB.Exec(insert, path("show/e2.mkv"), 500, nil, 2, now, nil)
+	sqlDB.Close()
+
+	run := func(target string, weights ...string) error {
+		cmd := &commands.FreeSpaceCmd{
+			CoreFlags:       models.CoreFlags{NoConfirm: true},
+			PostActionFlags: models.PostActionFlags{MarkDeleted: true},
+			Target:          target,
+			Weight:          weights,
+			Databases:       []string{fixture.DBPath},
+		}
+		return cmd.Run(context.Background())
+	}
+
+	if err := run("1GB", "bogus=1"); err == nil {
+		t.Error("expected an unknown --weight reason to fail")
+	}
+
+	// The completed folder (series 2 + watched 3) and the duplicate (4) reach the
+	// target before the watched file (3)
+	if err := run("1500B"); err != nil {
+		t.Fatalf("free-space failed: %v", err)
+	}
+
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	want := map[string]bool{
+		"show/e1.mkv": true, "show/e2.mkv": true, "b.mkv": true,
+		"a.mkv": false, "watched.mkv": false, "fresh.mkv": false,
+		"linked.mkv": false, "c.mkv": false, "d.mkv": false,
+	}
+	for rel, deleted := range want {
+		var timeDeleted sql.NullInt64
+		sqlDB.QueryRow("SELECT time_deleted FROM media WHERE path = ?", path(rel)).Scan(&timeDeleted)
+		if got := timeDeleted.Valid && timeDeleted.Int64 > 0; got != deleted {
+			t.Errorf("%s: expected deleted=%v, got %v", rel, deleted, got)
+		}
+	}
+}
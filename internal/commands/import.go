@@ -0,0 +1,558 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// ImportCmd groups the importers for other media servers' databases
+type ImportCmd struct {
+	Jellyfin ImportJellyfinCmd `help:"Import playstate from a Jellyfin jellyfin.db (10.11+) or library.db" cmd:""`
+	Plex     ImportPlexCmd     `help:"Import playstate from com.plexapp.plugins.library.db"                cmd:""`
+	Kodi     ImportKodiCmd     `help:"Import playstate from a Kodi MyVideos or MyMusic database"           cmd:""`
+}
+
+type ImportJellyfinCmd struct {
+	models.CoreFlags   `embed:""`
+	models.ImportFlags `embed:""`
+
+	Source   string `help:"Jellyfin database file" required:"true" arg:"" type:"existingfile"`
+	Database string `help:"Disco database file"    required:"true" arg:""`
+}
+
+type ImportPlexCmd struct {
+	models.CoreFlags   `embed:""`
+	models.ImportFlags `embed:""`
+
+	Source   string `help:"Plex library database file" required:"true" arg:"" type:"existingfile"`
+	Database string `help:"Disco database file"        required:"true" arg:""`
+}
+
+type ImportKodiCmd struct {
+	models.CoreFlags   `embed:""`
+	models.ImportFlags `embed:""`
+
+	Source   string `help:"Kodi MyVideos*.db or MyMusic*.db file" required:"true" arg:"" type:"existingfile"`
+	Database string `help:"Disco database file"                   required:"true" arg:""`
+}
+
+// importedItem is the playstate another server keeps for one file
+type importedItem struct {
+	path       string
+	played     bool
+	playCount  int64
+	playhead   int64   // seconds
+	lastPlayed int64   // unix seconds
+	rating     float64 // 0-10 as used by Jellyfin, Plex and Kodi; 0 means unrated
+	tags       []string
+	plays      []int64 // unix seconds of individual plays, when the server keeps them
+}
+
+// importReader reads items from an open source database
+type importReader func(ctx context.Context, src *sql.DB, user string) ([]importedItem, error)
+
+func (c *ImportJellyfinCmd) Run(ctx context.Context) error {
+	return runImport(ctx, "jellyfin", c.CoreFlags, c.ImportFlags, c.Source, c.Database, readJellyfin)
+}
+
+func (c *ImportPlexCmd) Run(ctx context.Context) error {
+	return runImport(ctx, "plex", c.CoreFlags, c.ImportFlags, c.Source, c.Database, readPlex)
+}
+
+func (c *ImportKodiCmd) Run(ctx context.Context) error {
+	if c.User != "" {
+		models.Log.Warn("Kodi databases have no users; --user is ignored")
+	}
+	return runImport(ctx, "kodi", c.CoreFlags, c.ImportFlags, c.Source, c.Database, readKodi)
+}
+
+func runImport(
+	ctx context.Context,
+	name string,
+	core models.CoreFlags,
+	flags models.ImportFlags,
+	source, dbPath string,
+	read importReader,
+) error {
+	models.SetupLogging(core.Verbose)
+	mapPath, _, err := parsePathMaps(flags.Map)
+	if err != nil {
+		return err
+	}
+
+	// Read-only so a server that is still running (or its WAL) is left alone
+	src, err := sql.Open("sqlite3", "file:"+source+"?mode=ro&_busy_timeout=30000")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	items, err := read(ctx, src, flags.User)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	for i := range items {
+		items[i].path = mapPath(items[i].path)
+	}
+
+	sqlDB, _, err := db.ConnectWithInit(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	res, err := applyImport(ctx, sqlDB, mergeImportedItems(items), core.Simulate)
+	if err != nil {
+		return err
+	}
+	verb := "updated"
+	if core.Simulate {
+		verb = "would update"
+	}
+	fmt.Printf("%s: %d items, %d in library (%s %d), %d not in library, %d history rows\n",
+		name, res.items, res.matched, verb, res.updated, res.missing, res.history)
+	return nil
+}
+
+// mergeImportedItems combines items that map to the same path, such as the same file
+// watched by several users or listed in several Plex libraries
+func mergeImportedItems(items []importedItem) []importedItem {
+	byPath := map[string]int{}
+	var merged []importedItem
+	for _, it := range items {
+		i, ok := byPath[it.path]
+		if !ok {
+			byPath[it.path] = len(merged)
+			merged = append(merged, it)
+			continue
+		}
+		m := &merged[i]
+		m.played = m.played || it.played
+		m.playCount = max(m.playCount, it.playCount)
+		if it.lastPlayed > m.lastPlayed {
+			m.lastPlayed = it.lastPlayed
+			if it.playhead > 0 {
+				m.playhead = it.playhead
+			}
+		}
+		m.rating = max(m.rating, it.rating)
+		for _, tag := range it.tags {
+			if !slices.Contains(m.tags, tag) {
+				m.tags = append(m.tags, tag)
+			}
+		}
+		m.plays = append(m.plays, it.plays...)
+	}
+	return merged
+}
+
+type importResult struct {
+	items   int
+	matched int
+	updated int
+	missing int
+	history int
+}
+
+// applyImport merges imported playstate into media without ever lowering what disco
+// already knows: play counts and last-played times only go up, the playhead is taken
+// when the other server played the file more recently, and existing scores are kept
+func applyImport(ctx context.Context, sqlDB *sql.DB, items []importedItem, simulate bool) (importResult, error) {
+	res := importResult{items: len(items)}
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, it := range items {
+		var playCount, playhead, firstPlayed, lastPlayed sql.NullInt64
+		var score sql.NullFloat64
+		var categories sql.NullString
+		err := tx.QueryRowContext(ctx, `SELECT play_count, playhead, time_first_played, time_last_played, score, categories
+			FROM media WHERE path = ?`, it.path).
+			Scan(&playCount, &playhead, &firstPlayed, &lastPlayed, &score, &categories)
+		if errors.Is(err, sql.ErrNoRows) {
+			models.Log.Debug("Not in library", "path", it.path)
+			res.missing++
+			continue
+		}
+		if err != nil {
+			return res, err
+		}
+		res.matched++
+
+		plays := slices.Clone(it.plays)
+		if len(plays) == 0 && it.lastPlayed > 0 && (it.played || it.playCount > 0 || it.playhead > 0) {
+			plays = []int64{it.lastPlayed}
+		}
+		slices.Sort(plays)
+		plays = slices.Compact(plays)
+
+		// An unfinished play gets a history row but doesn't count as a play
+		count := max(it.playCount, int64(len(it.plays)))
+		if it.played {
+			count = max(count, 1)
+		}
+		last := it.lastPlayed
+		first := int64(0)
+		if len(plays) > 0 {
+			first = plays[0]
+			last = max(last, plays[len(plays)-1])
+		}
+
+		var sets []string
+		var args []any
+		set := func(col string, v any) {
+			sets = append(sets, col+" = ?")
+			args = append(args, v)
+		}
+		if count > playCount.Int64 {
+			set("play_count", count)
+		}
+		if it.playhead > 0 && it.lastPlayed >= lastPlayed.Int64 && it.playhead != playhead.Int64 {
+			set("playhead", it.playhead)
+		}
+		if last > lastPlayed.Int64 {
+			set("time_last_played", last)
+		}
+		if first > 0 && (firstPlayed.Int64 == 0 || first < firstPlayed.Int64) {
+			set("time_first_played", first)
+		}
+		if it.rating > 0 && !score.Valid {
+			set("score", it.rating/2)
+		}
+		if len(it.tags) > 0 {
+			union := unionSets(categories.String, ";"+strings.Join(it.tags, ";")+";")
+			if union != categories.String {
+				set("categories", union)
+			}
+		}
+		if len(sets) > 0 {
+			args = append(args, it.path)
+			if _, err := tx.ExecContext(ctx, "UPDATE media SET "+strings.Join(sets, ", ")+" WHERE path = ?", args...); err != nil {
+				return res, err
+			}
+			res.updated++
+		}
+
+		for _, t := range plays {
+			r, err := tx.ExecContext(ctx, `INSERT INTO history (media_path, time_played, playhead, done)
+				SELECT ?, ?, ?, ? WHERE NOT EXISTS (SELECT 1 FROM history WHERE media_path = ? AND time_played = ?)`,
+				it.path, t, it.playhead, it.played, it.path, t)
+			if err != nil {
+				return res, err
+			}
+			if n, _ := r.RowsAffected(); n > 0 {
+				res.history++
+			}
+		}
+	}
+
+	if simulate {
+		return res, nil
+	}
+	return res, tx.Commit()
+}
+
+// tableExists reports whether the source database has table
+func tableExists(ctx context.Context, src *sql.DB, table string) bool {
+	var n int
+	err := src.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return err == nil && n > 0
+}
+
+// parseImportTime converts the date formats used by other servers to unix seconds.
+// Dates without a zone are read in loc
+func parseImportTime(v any, loc *time.Location) int64 {
+	switch t := v.(type) {
+	case int64:
+		return t
+	case float64:
+		return int64(t)
+	case time.Time:
+		return t.Unix()
+	case []byte:
+		v = string(t)
+	}
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return 0
+	}
+	for _, layout := range []string{
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02T15:04:05.999999999",
+	} {
+		if parsed, err := time.ParseInLocation(layout, s, loc); err == nil {
+			if parsed.Year() <= 1 {
+				return 0
+			}
+			return parsed.Unix()
+		}
+	}
+	return 0
+}
+
+// collectTags adds tags from rows of (path, tag) to the items with that path
+func collectTags(ctx context.Context, src *sql.DB, items []importedItem, query string, args ...any) error {
+	byPath := map[string][]int{}
+	for i, it := range items {
+		byPath[it.path] = append(byPath[it.path], i)
+	}
+	rows, err := src.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path, tag string
+		if err := rows.Scan(&path, &tag); err != nil {
+			return err
+		}
+		for _, i := range byPath[path] {
+			if !slices.Contains(items[i].tags, tag) {
+				items[i].tags = append(items[i].tags, tag)
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// Jellyfin 10.11 moved to an EF Core database (jellyfin.db) with UserData keyed by item
+// id; older releases keep UserDatas in library.db keyed by the item's user data key,
+// which for most items is its PresentationUniqueKey
+func readJellyfin(ctx context.Context, src *sql.DB, user string) ([]importedItem, error) {
+	const ticksPerSecond = 10_000_000
+	var query, tagQuery string
+	var args []any
+	switch {
+	case tableExists(ctx, src, "BaseItems"):
+		query = `SELECT b.Path, u.Played, u.PlayCount, u.PlaybackPositionTicks, u.LastPlayedDate, u.Rating
+			FROM UserData u
+			JOIN BaseItems b ON b.Id = u.ItemId
+			JOIN Users us ON us.Id = u.UserId
+			WHERE b.Path IS NOT NULL`
+		if user != "" {
+			query += " AND us.Username = ?"
+			args = append(args, user)
+		}
+		tagQuery = `SELECT b.Path, v.Value FROM ItemValuesMap m
+			JOIN ItemValues v ON v.ItemValueId = m.ItemValueId
+			JOIN BaseItems b ON b.Id = m.ItemId
+			WHERE v.Type = 4 AND b.Path IS NOT NULL`
+	case tableExists(ctx, src, "TypedBaseItems"):
+		if user != "" {
+			models.Log.Warn("User names are not stored in library.db; importing all users")
+		}
+		query = `SELECT b.Path, u.played, u.playCount, u.playbackPositionTicks, u.lastPlayedDate, u.rating
+			FROM UserDatas u
+			JOIN TypedBaseItems b ON b.PresentationUniqueKey = u.key
+			WHERE b.Path IS NOT NULL`
+		tagQuery = `SELECT b.Path, v.Value FROM ItemValues v
+			JOIN TypedBaseItems b ON b.guid = v.ItemId
+			WHERE v.Type = 4 AND b.Path IS NOT NULL`
+	default:
+		return nil, errors.New("not a Jellyfin database (no BaseItems or TypedBaseItems table)")
+	}
+
+	rows, err := src.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []importedItem
+	for rows.Next() {
+		var it importedItem
+		var played sql.NullBool
+		var playCount, ticks sql.NullInt64
+		var lastPlayed any
+		var rating sql.NullFloat64
+		if err := rows.Scan(&it.path, &played, &playCount, &ticks, &lastPlayed, &rating); err != nil {
+			return nil, err
+		}
+		it.played = played.Bool
+		it.playCount = playCount.Int64
+		it.playhead = ticks.Int64 / ticksPerSecond
+		it.lastPlayed = parseImportTime(lastPlayed, time.UTC)
+		it.rating = rating.Float64
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := collectTags(ctx, src, items, tagQuery); err != nil {
+		models.Log.Warn("Could not read Jellyfin tags", "error", err)
+	}
+	return items, nil
+}
+
+// Plex keeps per-account settings and a view log by metadata guid; files hang off
+// metadata items through media_items and media_parts
+func readPlex(ctx context.Context, src *sql.DB, user string) ([]importedItem, error) {
+	if !tableExists(ctx, src, "metadata_item_settings") {
+		return nil, errors.New("not a Plex library database (no metadata_item_settings table)")
+	}
+	const files = `JOIN metadata_items m ON m.guid = s.guid
+		JOIN media_items mi ON mi.metadata_item_id = m.id
+		JOIN media_parts p ON p.media_item_id = mi.id`
+	userFilter := ""
+	var args []any
+	if user != "" {
+		userFilter = " AND s.account_id = (SELECT id FROM accounts WHERE name = ?)"
+		args = append(args, user)
+	}
+
+	rows, err := src.QueryContext(ctx, `SELECT p.file, s.view_count, s.view_offset, s.last_viewed_at, s.rating
+		FROM metadata_item_settings s `+files+` WHERE p.file IS NOT NULL`+userFilter, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []importedItem
+	index := map[string]int{}
+	for rows.Next() {
+		var it importedItem
+		var viewCount, viewOffset sql.NullInt64
+		var lastViewed any
+		var rating sql.NullFloat64
+		if err := rows.Scan(&it.path, &viewCount, &viewOffset, &lastViewed, &rating); err != nil {
+			return nil, err
+		}
+		it.playCount = viewCount.Int64
+		it.played = viewCount.Int64 > 0
+		it.playhead = viewOffset.Int64 / 1000
+		it.lastPlayed = parseImportTime(lastViewed, time.UTC)
+		it.rating = rating.Float64
+		index[it.path] = len(items)
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Every play, not just the last one
+	if tableExists(ctx, src, "metadata_item_views") {
+		rows, err := src.QueryContext(ctx, `SELECT p.file, s.viewed_at
+			FROM metadata_item_views s `+files+` WHERE p.file IS NOT NULL`+userFilter, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var path string
+			var viewedAt any
+			if err := rows.Scan(&path, &viewedAt); err != nil {
+				return nil, err
+			}
+			t := parseImportTime(viewedAt, time.UTC)
+			if t == 0 {
+				continue
+			}
+			i, ok := index[path]
+			if !ok {
+				index[path] = len(items)
+				items = append(items, importedItem{path: path, played: true, lastPlayed: t})
+				i = index[path]
+			}
+			items[i].plays = append(items[i].plays, t)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Collections (2) and labels (11)
+	err = collectTags(ctx, src, items, `SELECT p.file, t.tag FROM taggings tg
+		JOIN tags t ON t.id = tg.tag_id
+		JOIN media_items mi ON mi.metadata_item_id = tg.metadata_item_id
+		JOIN media_parts p ON p.media_item_id = mi.id
+		WHERE t.tag_type IN (2, 11) AND p.file IS NOT NULL`)
+	if err != nil {
+		models.Log.Warn("Could not read Plex tags", "error", err)
+	}
+	return items, nil
+}
+
+// Kodi video databases track files with a resume bookmark (type 1) and user ratings on
+// movies and episodes; music databases keep play counts on songs. Last-played dates
+// are local time
+func readKodi(ctx context.Context, src *sql.DB, _ string) ([]importedItem, error) {
+	var query, tagQuery string
+	switch {
+	case tableExists(ctx, src, "files") && tableExists(ctx, src, "bookmark"):
+		query = `SELECT p.strPath || f.strFilename, f.playCount, b.timeInSeconds, f.lastPlayed,
+				COALESCE(mv.userrating, ep.userrating)
+			FROM files f
+			JOIN path p ON p.idPath = f.idPath
+			LEFT JOIN bookmark b ON b.idFile = f.idFile AND b.type = 1
+			LEFT JOIN movie mv ON mv.idFile = f.idFile
+			LEFT JOIN episode ep ON ep.idFile = f.idFile`
+		tagQuery = `SELECT p.strPath || f.strFilename, t.name FROM tag_link l
+			JOIN tag t ON t.tag_id = l.tag_id
+			JOIN movie mv ON l.media_type = 'movie' AND mv.idMovie = l.media_id
+			JOIN files f ON f.idFile = mv.idFile
+			JOIN path p ON p.idPath = f.idPath
+			UNION ALL
+			SELECT p.strPath || f.strFilename, t.name FROM tag_link l
+			JOIN tag t ON t.tag_id = l.tag_id
+			JOIN episode ep ON l.media_type = 'tvshow' AND ep.idShow = l.media_id
+			JOIN files f ON f.idFile = ep.idFile
+			JOIN path p ON p.idPath = f.idPath`
+	case tableExists(ctx, src, "song"):
+		query = `SELECT p.strPath || s.strFileName, s.iTimesPlayed, 0, s.lastplayed, s.userrating
+			FROM song s
+			JOIN path p ON p.idPath = s.idPath`
+	default:
+		return nil, errors.New("not a Kodi MyVideos or MyMusic database")
+	}
+
+	rows, err := src.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []importedItem
+	for rows.Next() {
+		var it importedItem
+		var playCount sql.NullInt64
+		var resume sql.NullFloat64
+		var lastPlayed any
+		var rating sql.NullFloat64
+		if err := rows.Scan(&it.path, &playCount, &resume, &lastPlayed, &rating); err != nil {
+			return nil, err
+		}
+		it.playCount = playCount.Int64
+		it.played = playCount.Int64 > 0
+		it.playhead = int64(resume.Float64)
+		it.lastPlayed = parseImportTime(lastPlayed, time.Local)
+		it.rating = rating.Float64
+		items = append(items, it)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if tagQuery != "" {
+		if err := collectTags(ctx, src, items, tagQuery); err != nil {
+			models.Log.Warn("Could not read Kodi tags", "error", err)
+		}
+	}
+	// Kodi lists every scanned file; only keep the ones with something to import
+	return slices.DeleteFunc(items, func(it importedItem) bool {
+		return it.playCount == 0 && it.playhead == 0 && it.rating == 0 && len(it.tags) == 0
+	}), nil
+}
@@ -0,0 +1,180 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// createServerDB creates a database with a minimal copy of another server's schema
+func createServerDB(t *testing.T, name string, statements ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	sqlDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	for _, stmt := range statements {
+		if _, err := sqlDB.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	return path
+}
+
+type importedState struct {
+	playCount, playhead, lastPlayed, history int64
+	score                                    sql.NullFloat64
+	categories                               sql.NullString
+}
+
+func readImportedState(t *testing.T, sqlDB *sql.DB, path string) importedState {
+	t.Helper()
+	var s importedState
+	var playCount, playhead, lastPlayed sql.NullInt64
+	err := sqlDB.QueryRow("SELECT play_count, playhead, time_last_played, score, categories FROM media WHERE path = ?", path).
+		Scan(&playCount, &playhead, &lastPlayed, &s.score, &s.categories)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.playCount, s.playhead, s.lastPlayed = playCount.Int64, playhead.Int64, lastPlayed.Int64
+	sqlDB.QueryRow("SELECT COUNT(*) FROM history WHERE media_path = ?", path).Scan(&s.history)
+	return s
+}
+
+func TestImportCmd_Jellyfin(t *testing.T) {
+	models.SetupLogging(0)
+	source := createServerDB(t, "jellyfin.db",
+		"CREATE TABLE Users (Id TEXT PRIMARY KEY, Username TEXT)",
+		"CREATE TABLE BaseItems (Id TEXT PRIMARY KEY, Path TEXT)",
+		`CREATE TABLE UserData (ItemId TEXT, UserId TEXT, Played INTEGER, PlayCount INTEGER,
+			PlaybackPositionTicks INTEGER, LastPlayedDate TEXT, Rating REAL)`,
+		"CREATE TABLE ItemValues (ItemValueId TEXT PRIMARY KEY, Type INTEGER, Value TEXT)",
+		"CREATE TABLE ItemValuesMap (ItemId TEXT, ItemValueId TEXT)",
+		"INSERT INTO Users VALUES ('u1', 'me'), ('u2', 'guest')",
+		"INSERT INTO BaseItems VALUES ('i1', '/data/movies/a.mp4'), ('i2', '/data/movies/b.mp4'), ('i3', '/data/movies/gone.mp4')",
+		`INSERT INTO UserData VALUES
+			('i1', 'u1', 1, 2, 0, '2024-01-02 03:04:05.1234567Z', 8),
+			('i2', 'u1', 0, 0, 6000000000, '2024-02-01T00:00:00Z', NULL),
+			('i2', 'u2', 1, 9, 0, '2024-03-01T00:00:00Z', NULL),
+			('i3', 'u1', 1, 1, 0, '2024-01-01T00:00:00Z', NULL)`,
+		"INSERT INTO ItemValues VALUES ('v1', 4, 'favorites'), ('v2', 2, 'Drama')",
+		"INSERT INTO ItemValuesMap VALUES ('i1', 'v1'), ('i1', 'v2')",
+	)
+	dbPath, sqlDB := createSyncLibrary(t, "/mnt/movies/")
+
+	cmd := &commands.ImportJellyfinCmd{Source: source, Database: dbPath}
+	cmd.Map = []string{"/data/movies/=/mnt/movies/"}
+	cmd.User = "me"
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	a := readImportedState(t, sqlDB, "/mnt/movies/a.mp4")
+	if a.playCount != 2 || a.lastPlayed != 1704164645 || a.score.Float64 != 4 || a.categories.String != ";favorites;" ||
+		a.history != 1 {
+		t.Errorf("a.mp4: %+v", a)
+	}
+	// The guest's plays are not imported
+	b := readImportedState(t, sqlDB, "/mnt/movies/b.mp4")
+	if b.playCount != 0 || b.playhead != 600 || b.history != 1 || b.score.Valid {
+		t.Errorf("b.mp4: %+v", b)
+	}
+
+	// Importing again adds nothing
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if again := readImportedState(t, sqlDB, "/mnt/movies/a.mp4"); again != a {
+		t.Errorf("Second import changed a.mp4: %+v", again)
+	}
+}
+
+func TestImportCmd_Plex(t *testing.T) {
+	models.SetupLogging(0)
+	source := createServerDB(t, "com.plexapp.plugins.library.db",
+		"CREATE TABLE accounts (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE metadata_items (id INTEGER PRIMARY KEY, guid TEXT)",
+		"CREATE TABLE media_items (id INTEGER PRIMARY KEY, metadata_item_id INTEGER)",
+		"CREATE TABLE media_parts (id INTEGER PRIMARY KEY, media_item_id INTEGER, file TEXT)",
+		`CREATE TABLE metadata_item_settings (account_id INTEGER, guid TEXT, rating REAL, view_offset INTEGER,
+			view_count INTEGER, last_viewed_at INTEGER)`,
+		"CREATE TABLE metadata_item_views (account_id INTEGER, guid TEXT, viewed_at INTEGER)",
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, tag TEXT, tag_type INTEGER)",
+		"CREATE TABLE taggings (metadata_item_id INTEGER, tag_id INTEGER)",
+		"INSERT INTO accounts VALUES (1, 'me')",
+		"INSERT INTO metadata_items VALUES (10, 'plex://movie/a'), (11, 'plex://movie/b')",
+		"INSERT INTO media_items VALUES (20, 10), (21, 11)",
+		"INSERT INTO media_parts VALUES (30, 20, '/plex/a.mp4'), (31, 21, '/plex/b.mp4')",
+		"INSERT INTO metadata_item_settings VALUES (1, 'plex://movie/a', 10, NULL, 3, 3000), (1, 'plex://movie/b', NULL, 95000, 0, 4000)",
+		"INSERT INTO metadata_item_views VALUES (1, 'plex://movie/a', 1000), (1, 'plex://movie/a', 2000), (1, 'plex://movie/a', 3000)",
+		"INSERT INTO tags VALUES (1, 'Watchlist', 11), (2, 'Comedy', 1)",
+		"INSERT INTO taggings VALUES (10, 1), (10, 2)",
+	)
+	dbPath, sqlDB := createSyncLibrary(t, "/mnt/movies/")
+	mustExec(t, sqlDB, "UPDATE media SET score = 3 WHERE path = '/mnt/movies/a.mp4'")
+
+	cmd := &commands.ImportPlexCmd{Source: source, Database: dbPath}
+	cmd.Map = []string{"/plex/=/mnt/movies/"}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	a := readImportedState(t, sqlDB, "/mnt/movies/a.mp4")
+	if a.playCount != 3 || a.lastPlayed != 3000 || a.history != 3 || a.score.Float64 != 3 ||
+		a.categories.String != ";Watchlist;" {
+		t.Errorf("a.mp4: %+v", a)
+	}
+	b := readImportedState(t, sqlDB, "/mnt/movies/b.mp4")
+	if b.playhead != 95 || b.playCount != 0 {
+		t.Errorf("b.mp4: %+v", b)
+	}
+}
+
+func TestImportCmd_Kodi(t *testing.T) {
+	models.SetupLogging(0)
+	source := createServerDB(t, "MyVideos131.db",
+		"CREATE TABLE path (idPath INTEGER PRIMARY KEY, strPath TEXT)",
+		"CREATE TABLE files (idFile INTEGER PRIMARY KEY, idPath INTEGER, strFilename TEXT, playCount INTEGER, lastPlayed TEXT)",
+		"CREATE TABLE bookmark (idBookmark INTEGER PRIMARY KEY, idFile INTEGER, timeInSeconds REAL, type INTEGER)",
+		"CREATE TABLE movie (idMovie INTEGER PRIMARY KEY, idFile INTEGER, userrating INTEGER)",
+		"CREATE TABLE episode (idEpisode INTEGER PRIMARY KEY, idFile INTEGER, idShow INTEGER, userrating INTEGER)",
+		"CREATE TABLE tag (tag_id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE tag_link (tag_id INTEGER, media_id INTEGER, media_type TEXT)",
+		"INSERT INTO path VALUES (1, 'smb://nas/movies/')",
+		"INSERT INTO files VALUES (1, 1, 'a.mp4', 1, '2024-01-02 03:04:05'), (2, 1, 'b.mp4', NULL, NULL)",
+		"INSERT INTO bookmark VALUES (1, 2, 42.5, 1)",
+		"INSERT INTO movie VALUES (1, 1, 6), (2, 2, NULL)",
+		"INSERT INTO tag VALUES (1, 'kids')",
+		"INSERT INTO tag_link VALUES (1, 2, 'movie')",
+	)
+	dbPath, sqlDB := createSyncLibrary(t, "/mnt/movies/")
+
+	cmd := &commands.ImportKodiCmd{Source: source, Database: dbPath}
+	cmd.Map = []string{"smb://nas/movies/=/mnt/movies/"}
+	cmd.Simulate = true
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if a := readImportedState(t, sqlDB, "/mnt/movies/a.mp4"); a.playCount != 0 {
+		t.Errorf("Simulate imported a.mp4: %+v", a)
+	}
+
+	cmd.Simulate = false
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	a := readImportedState(t, sqlDB, "/mnt/movies/a.mp4")
+	if a.playCount != 1 || a.lastPlayed == 0 || a.score.Float64 != 3 || a.history != 1 {
+		t.Errorf("a.mp4: %+v", a)
+	}
+	b := readImportedState(t, sqlDB, "/mnt/movies/b.mp4")
+	if b.playhead != 42 || b.categories.String != ";kids;" || b.history != 0 {
+		t.Errorf("b.mp4: %+v", b)
+	}
+}
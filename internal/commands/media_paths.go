@@ -0,0 +1,95 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// pathTables are the tables besides media that reference a media path
+var pathTables = []string{"history", "captions", "playlist_items", "playstate_changes", "integrity", "media_checks"}
+
+// renameMediaPath moves the media row at oldPath, and the rows of pathTables that
+// reference it, to newPath. A row already at newPath is merged in first, so its plays
+// and history are kept
+func renameMediaPath(ctx context.Context, tx *sql.Tx, oldPath, newPath string) error {
+	if oldPath == newPath {
+		return nil
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM media WHERE path = ?", newPath).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		if err := mergeMediaRow(ctx, tx, newPath, oldPath); err != nil {
+			return err
+		}
+	}
+	// media.path is the parent key of several foreign keys; check them at commit
+	if _, err := tx.ExecContext(ctx, "PRAGMA defer_foreign_keys = ON"); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, "UPDATE media SET path = ?, path_tokenized = ? WHERE path = ?",
+		newPath, utils.ToNullString(utils.PathToTokenized(newPath)), oldPath)
+	if err != nil {
+		return err
+	}
+	for _, table := range pathTables {
+		if _, err := tx.ExecContext(ctx, "UPDATE OR REPLACE "+table+" SET media_path = ? WHERE media_path = ?",
+			newPath, oldPath); err != nil && !isMissingTable(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeMediaRow folds the media row at dupPath into keepPath after dupPath's file was
+// removed as a copy of keepPath: its plays and the rows of pathTables that reference
+// it move over and the row is deleted. Where both paths have a row that allows only
+// one, such as a media-check result, keepPath's row is kept
+func mergeMediaRow(ctx context.Context, tx *sql.Tx, dupPath, keepPath string) error {
+	if dupPath == keepPath {
+		return nil
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM media WHERE path = ?", keepPath).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return renameMediaPath(ctx, tx, dupPath, keepPath)
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE media SET
+			play_count = MAX(COALESCE(media.play_count, 0), COALESCE(d.play_count, 0)),
+			time_last_played = MAX(COALESCE(media.time_last_played, 0), COALESCE(d.time_last_played, 0)),
+			time_first_played = COALESCE(MIN(NULLIF(media.time_first_played, 0), NULLIF(d.time_first_played, 0)),
+				NULLIF(media.time_first_played, 0), d.time_first_played)
+		FROM (SELECT play_count, time_last_played, time_first_played FROM media WHERE path = ?) AS d
+		WHERE media.path = ?`, dupPath, keepPath)
+	if err != nil {
+		return err
+	}
+	for _, table := range pathTables {
+		update := "UPDATE OR IGNORE " + table + " SET media_path = ? WHERE media_path = ?"
+		args := []any{keepPath, dupPath}
+		if table == "captions" {
+			// Captions of a copy repeat those of the kept file
+			update += " AND NOT EXISTS (SELECT 1 FROM captions WHERE media_path = ?)"
+			args = append(args, keepPath)
+		}
+		if _, err := tx.ExecContext(ctx, update, args...); err != nil && !isMissingTable(err) {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE media_path = ?", dupPath); err != nil &&
+			!isMissingTable(err) {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM media WHERE path = ?", dupPath)
+	return err
+}
+
+func isMissingTable(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "no such table")
+}
@@ -2,6 +2,7 @@ package commands
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
 	"os"
@@ -28,6 +29,10 @@ type MediaCheckCmd struct {
 	FullScanIfCorrupt string  `help:"Full scan as second pass if initial scan result more corruption or equal to this threshold. Values greater than 1 are treated as number of seconds"`
 	FullScan          bool    `help:"Decode the full media file"`
 	AudioScan         bool    `help:"Count errors in audio track only"`
+	SkipChecked       string  `help:"Skip media checked more recently than this (e.g. 30days)"`
+
+	// progress, when set, receives the number of files checked
+	progress func(done, total int)
 }
 
 func (c *MediaCheckCmd) Run(ctx context.Context) error {
@@ -43,26 +48,79 @@ func (c *MediaCheckCmd) Run(ctx context.Context) error {
 	deleteThreshold, _ := utils.FloatFromPercent(c.DeleteCorrupt)
 	fullScanThreshold, _ := utils.FloatFromPercent(c.FullScanIfCorrupt)
 
+	var skipSeconds int64
+	if c.SkipChecked != "" {
+		var err error
+		if skipSeconds, err = utils.HumanToSeconds(c.SkipChecked); err != nil || skipSeconds <= 0 {
+			return fmt.Errorf("invalid --skip-checked: %s", c.SkipChecked)
+		}
+	}
+	limit := 0
+	if skipSeconds > 0 && !flags.All {
+		// Recently checked media are dropped after the query, so the limit applies afterwards
+		limit = flags.Limit
+		flags.All = true
+	}
+
+	conns := map[string]*sql.DB{}
+	defer func() {
+		for _, sqlDB := range conns {
+			sqlDB.Close()
+		}
+	}()
+	for _, dbPath := range c.Databases {
+		sqlDB, _, err := db.ConnectWithInit(ctx, dbPath)
+		if err != nil {
+			return err
+		}
+		conns[dbPath] = sqlDB
+	}
+
 	return RunQuery(ctx, c.Databases, flags, func(media []models.MediaWithDB) error {
 		if len(media) == 0 {
 			return errors.New("no media found")
 		}
+		if skipSeconds > 0 {
+			var err error
+			media, err = skipRecentlyChecked(ctx, conns, media, time.Now().Unix()-skipSeconds)
+			if err != nil {
+				return err
+			}
+			if limit > 0 && len(media) > limit {
+				media = media[:limit]
+			}
+			if len(media) == 0 {
+				fmt.Printf("All media were checked within %s\n", c.SkipChecked)
+				return nil
+			}
+		}
 
-		for _, m := range media {
+		for i, m := range media {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if c.progress != nil {
+				c.progress(i, len(media))
+			}
 			c.checkSingleMedia(ctx, checkMediaOptions{
 				m:                 m,
+				sqlDB:             conns[m.DB],
 				gap:               gap,
 				deleteThreshold:   deleteThreshold,
 				fullScanThreshold: fullScanThreshold,
 				simulate:          flags.Simulate,
 			})
 		}
+		if c.progress != nil {
+			c.progress(len(media), len(media))
+		}
 		return nil
 	})
 }
 
 type checkMediaOptions struct {
 	m                 models.MediaWithDB
+	sqlDB             *sql.DB
 	gap               float64
 	deleteThreshold   float64
 	fullScanThreshold float64
@@ -71,6 +129,7 @@ type checkMediaOptions struct {
 
 func (c *MediaCheckCmd) checkSingleMedia(ctx context.Context, opts checkMediaOptions) {
 	var corruption float64
+	scanType := "quick"
 	duration := 0.0
 	m := opts.m
 	if m.Duration != nil {
@@ -78,11 +137,13 @@ func (c *MediaCheckCmd) checkSingleMedia(ctx context.Context, opts checkMediaOpt
 	}
 
 	if c.FullScan {
+		scanType = "full"
 		var err error
 		corruption, err = metadata.DecodeFullScan(ctx, m.Path)
 		if err != nil {
-			models.Log.Error("Full scan failed", "path", m.Path, "error", err)
-			corruption = 0.5
+			// e.g. audio-only files; a guess saved as a full scan would read as a result
+			models.LogFrom(ctx).Error("Full scan failed", "path", m.Path, "error", err)
+			return
 		}
 	} else {
 		if duration == 0 {
@@ -92,17 +153,18 @@ func (c *MediaCheckCmd) checkSingleMedia(ctx context.Context, opts checkMediaOpt
 			corruption = metadata.DecodeQuickScan(ctx, m.Path, scans, c.ChunkSize)
 
 			if opts.fullScanThreshold > 0 && corruption >= opts.fullScanThreshold {
-				models.Log.Info(
+				models.LogFrom(ctx).Info(
 					"Corruption threshold reached, performing full scan",
 					"path",
 					m.Path,
 					"corruption",
 					corruption,
 				)
-				var err error
-				corruption, err = metadata.DecodeFullScan(ctx, m.Path)
+				full, err := metadata.DecodeFullScan(ctx, m.Path)
 				if err != nil {
-					models.Log.Error("Full scan failed", "path", m.Path, "error", err)
+					models.LogFrom(ctx).Error("Full scan failed, keeping the quick scan", "path", m.Path, "error", err)
+				} else {
+					corruption, scanType = full, "full"
 				}
 			}
 		}
@@ -110,6 +172,16 @@ func (c *MediaCheckCmd) checkSingleMedia(ctx context.Context, opts checkMediaOpt
 
 	fmt.Printf("%.2f%%\t%s\n", corruption*100, m.Path)
 
+	if !opts.simulate {
+		if _, err := opts.sqlDB.ExecContext(ctx, `INSERT INTO media_checks
+			(media_path, corruption, scan_type, time_checked) VALUES (?, ?, ?, ?)
+			ON CONFLICT (media_path) DO UPDATE SET corruption = excluded.corruption,
+				scan_type = excluded.scan_type, time_checked = excluded.time_checked`,
+			m.Path, corruption, scanType, time.Now().Unix()); err != nil {
+			models.LogFrom(ctx).Error("Failed to save media check", "path", m.Path, "error", err)
+		}
+	}
+
 	if opts.deleteThreshold > 0 && corruption >= opts.deleteThreshold {
 		c.handleCorruptFile(ctx, m, corruption, opts.simulate)
 	}
@@ -121,13 +193,13 @@ func (c *MediaCheckCmd) handleCorruptFile(
 	corruption float64,
 	simulate bool,
 ) {
-	models.Log.Warn("Deleting corrupt file", "path", m.Path, "corruption", corruption)
+	models.LogFrom(ctx).Warn("Deleting corrupt file", "path", m.Path, "corruption", corruption)
 	if simulate {
 		return
 	}
 
 	if err := os.Remove(m.Path); err != nil {
-		models.Log.Error("Failed to delete corrupt file", "path", m.Path, "error", err)
+		models.LogFrom(ctx).Error("Failed to delete corrupt file", "path", m.Path, "error", err)
 		return
 	}
 
@@ -143,6 +215,43 @@ func (c *MediaCheckCmd) handleCorruptFile(
 		Path:        m.Path,
 		TimeDeleted: utils.ToNullInt64(time.Now().Unix()),
 	}); err != nil {
-		models.Log.Warn("Failed to mark deleted in DB", "path", m.Path, "error", err)
+		models.LogFrom(ctx).Warn("Failed to mark deleted in DB", "path", m.Path, "error", err)
+	}
+}
+
+// skipRecentlyChecked drops media whose last media-check was at or after cutoff
+func skipRecentlyChecked(
+	ctx context.Context,
+	conns map[string]*sql.DB,
+	media []models.MediaWithDB,
+	cutoff int64,
+) ([]models.MediaWithDB, error) {
+	checked := map[string]map[string]bool{}
+	for dbPath, sqlDB := range conns {
+		rows, err := sqlDB.QueryContext(ctx, "SELECT media_path FROM media_checks WHERE time_checked >= ?", cutoff)
+		if err != nil {
+			return nil, err
+		}
+		checked[dbPath] = map[string]bool{}
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			checked[dbPath][path] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var kept []models.MediaWithDB
+	for _, m := range media {
+		if !checked[m.DB][m.Path] {
+			kept = append(kept, m)
+		}
 	}
+	return kept, nil
 }
@@ -2,9 +2,20 @@ package commands_test
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
 	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
 	"github.com/chapmanjacobd/discoteca/internal/testutils"
 )
 
@@ -35,5 +46,104 @@ func TestMediaCheckCmd_Run(t *testing.T) {
 			FullScan:  true,
 		}
 		cmd.Run(context.Background())
+
+		// The dummy file can't be decoded, so the failed full scan is not saved
+		sqlDB := fixture.GetDB()
+		defer sqlDB.Close()
+		var full int
+		sqlDB.QueryRow("SELECT COUNT(*) FROM media_checks WHERE scan_type = 'full'").Scan(&full)
+		if full != 0 {
+			t.Error("expected a failed full scan not to be saved")
+		}
 	})
 }
+
+func TestMediaCheckCmd_SavedResults(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	fixture.CreateFileTree(map[string]any{"new.mp4": "n", "recent.mp4": "r", "bad.mp4": "b"})
+	path := func(rel string) string { return filepath.Join(fixture.TempDir, rel) }
+
+	now := time.Now().Unix()
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	sqlDB.Exec("INSERT INTO media (path, media_type) VALUES (?, 'video'), (?, 'video'), (?, 'video')",
+		path("new.mp4"), path("recent.mp4"), path("bad.mp4"))
+	sqlDB.Exec(`INSERT INTO media_checks (media_path, corruption, scan_type, time_checked)
+		VALUES (?, 0, 'full', ?), (?, 0.12, 'quick', ?)`, path("recent.mp4"), now, path("bad.mp4"), now-90*86400)
+	sqlDB.Close()
+
+	// Without a duration nothing is decoded, so the result is the 50% placeholder
+	cmd := &commands.MediaCheckCmd{Databases: []string{fixture.DBPath}, SkipChecked: "30days"}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("media-check failed: %v", err)
+	}
+
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	check := func(p string) (float64, string) {
+		var corruption float64
+		var scanType string
+		sqlDB.QueryRow("SELECT corruption, scan_type FROM media_checks WHERE media_path = ?", p).
+			Scan(&corruption, &scanType)
+		return corruption, scanType
+	}
+	if corruption, scanType := check(path("new.mp4")); corruption != 0.5 || scanType != "quick" {
+		t.Errorf("expected the unchecked file to be saved, got %v %q", corruption, scanType)
+	}
+	if corruption, scanType := check(path("recent.mp4")); corruption != 0 || scanType != "full" {
+		t.Errorf("expected the recently checked file to be skipped, got %v %q", corruption, scanType)
+	}
+	if corruption, _ := check(path("bad.mp4")); corruption != 0.5 {
+		t.Errorf("expected the file checked long ago to be checked again, got %v", corruption)
+	}
+
+	sqlDB.Exec("DELETE FROM media_checks WHERE media_path = ?", path("new.mp4"))
+	search := func(filters models.FilterFlags) []string {
+		media, err := query.MediaQuery(context.Background(), []string{fixture.DBPath},
+			models.GlobalFlags{FilterFlags: filters})
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var paths []string
+		for _, m := range media {
+			paths = append(paths, filepath.Base(m.Path))
+		}
+		slices.Sort(paths)
+		return paths
+	}
+	if got := search(models.FilterFlags{Corrupt: []string{">5%"}}); !slices.Equal(got, []string{"bad.mp4"}) {
+		t.Errorf("expected --corrupt >5%% to match the damaged file, got %v", got)
+	}
+	if got := search(models.FilterFlags{Corrupt: []string{"<1%"}}); !slices.Equal(got, []string{"recent.mp4"}) {
+		t.Errorf("expected --corrupt <1%% to match the clean file, got %v", got)
+	}
+	if got := search(models.FilterFlags{Unchecked: true}); !slices.Equal(got, []string{"new.mp4"}) {
+		t.Errorf("expected --unchecked to match the unchecked file, got %v", got)
+	}
+	if _, err := query.MediaQuery(context.Background(), []string{fixture.DBPath},
+		models.GlobalFlags{FilterFlags: models.FilterFlags{Corrupt: []string{">five%"}}}); err == nil {
+		t.Error("expected an invalid --corrupt to be an error")
+	}
+
+	serve := SetupTestServeCmd(fixture.DBPath)
+	defer serve.Close()
+	damagedFacet := func(target string) []models.FilterBin {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header.Set("X-Disco-Token", serve.APIToken)
+		w := httptest.NewRecorder()
+		serve.Mux().ServeHTTP(w, req)
+		var bins models.FilterBinsResponse
+		json.NewDecoder(w.Body).Decode(&bins)
+		return bins.Damaged
+	}
+	want := []models.FilterBin{{Label: "damaged", Value: 1}, {Label: "ok", Value: 1}, {Label: "unchecked", Value: 1}}
+	if got := damagedFacet("/api/filter-bins"); !slices.Equal(got, want) {
+		t.Errorf("expected damaged facet %v, got %v", want, got)
+	}
+	// The facet counts only the media matching the other filters
+	want = []models.FilterBin{{Label: "damaged", Value: 1}}
+	if got := damagedFacet("/api/filter-bins?paths=" + url.QueryEscape(path("bad.mp4"))); !slices.Equal(got, want) {
+		t.Errorf("expected filtered damaged facet %v, got %v", want, got)
+	}
+}
@@ -18,11 +18,22 @@ type MergeDBsCmd struct {
 
 	TargetDB  string   `help:"Target SQLite database file"  required:"true" arg:""`
 	SourceDBs []string `help:"Source SQLite database files" required:"true" arg:"" type:"existingfile"`
+
+	policies map[string]map[string]mergePolicy
+	mapPath  func(string) string
 }
 
 func (c *MergeDBsCmd) Run(ctx context.Context) error {
 	models.SetupLogging(c.Verbose)
 
+	var err error
+	if c.policies, err = parseMergePolicies(c.MergePolicy); err != nil {
+		return err
+	}
+	if c.mapPath, _, err = parsePathMaps(c.PathMap); err != nil {
+		return err
+	}
+
 	targetConn, err := db.Connect(ctx, c.TargetDB)
 	if err != nil {
 		return fmt.Errorf("failed to connect to target DB %s: %w", c.TargetDB, err)
@@ -98,7 +109,14 @@ func (c *MergeDBsCmd) getTables(ctx context.Context, conn *sql.DB) ([]string, er
 	return tables, nil
 }
 
+// libraryTables describe the database they are in rather than its media. Copying them
+// would give two libraries the same disco sync id and cursors
+var libraryTables = []string{"_maintenance_meta", "playstate_changes", "sync_peers"}
+
 func (c *MergeDBsCmd) shouldProcessTable(table string) bool {
+	if slices.Contains(libraryTables, table) {
+		return false
+	}
 	if len(c.OnlyTables) > 0 {
 		return slices.Contains(c.OnlyTables, table)
 	}
@@ -122,15 +140,27 @@ func (c *MergeDBsCmd) mergeTable(ctx context.Context, srcConn, targetConn *sql.D
 		return nil
 	}
 
-	insertQuery := c.buildInsertQuery(ctx, targetConn, table, selectedCols)
-
-	return c.copyRows(ctx, copyRowsParams{
+	p := copyRowsParams{
 		srcConn:      srcConn,
 		targetConn:   targetConn,
 		table:        table,
 		selectedCols: selectedCols,
-		insertQuery:  insertQuery,
-	})
+	}
+	if c.needsRowMerge(table) {
+		report, err := c.mergeRows(ctx, p)
+		if err != nil {
+			return err
+		}
+		if c.dryRun() {
+			fmt.Println(report)
+		} else {
+			models.Log.Info("Merged rows", "report", report.String())
+		}
+		return nil
+	}
+
+	p.insertQuery = c.buildInsertQuery(ctx, targetConn, table, selectedCols)
+	return c.copyRows(ctx, p)
 }
 
 func (c *MergeDBsCmd) selectColumns(srcCols, targetCols []string) []string {
@@ -0,0 +1,385 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Column merge policies for rows that already exist in the target
+const (
+	policySource = "source" // take the source value
+	policyKeep   = "keep"   // keep the target value
+	policyMax    = "max"
+	policyMin    = "min" // smallest value that is set; 0 and NULL mean unset
+	policySum    = "sum"
+	policyNewest = "newest" // source value if its timestamp column is newer
+	policyUnion  = "union"  // union of ;-delimited sets such as categories
+)
+
+type mergePolicy struct {
+	kind   string
+	column string // timestamp column for newest
+}
+
+// mediaMergePolicies are the defaults for media with --upsert, so merging two libraries
+// doesn't lose play counts or replace a newer playhead with an older one
+var mediaMergePolicies = map[string]mergePolicy{
+	"play_count":        {kind: policyMax},
+	"playhead":          {kind: policyNewest, column: "time_last_played"},
+	"time_first_played": {kind: policyMin},
+	"time_last_played":  {kind: policyMax},
+	"time_created":      {kind: policyMin},
+	"time_downloaded":   {kind: policyMin},
+	"score":             {kind: policyMax},
+	"categories":        {kind: policyUnion},
+	// Whether the file exists is up to the library it is merged into
+	"time_deleted": {kind: policyKeep},
+}
+
+// defaultMergeKeys identify duplicate rows in tables whose primary key is a rowid
+// that means nothing in another database
+var defaultMergeKeys = map[string][]string{
+	"history":  {"media_path", "time_played"},
+	"captions": {"media_path", "time", "text"},
+}
+
+// pathColumns hold media paths that --path-map rewrites
+var pathColumns = map[string][]string{
+	"media":          {"path"},
+	"history":        {"media_path"},
+	"captions":       {"media_path"},
+	"playlist_items": {"media_path"},
+	"playlists":      {"path"},
+}
+
+func parseMergePolicy(s string) (mergePolicy, error) {
+	kind, column, _ := strings.Cut(s, ":")
+	switch kind {
+	case policySource, policyKeep, policyMax, policyMin, policySum, policyUnion:
+		if column != "" {
+			return mergePolicy{}, fmt.Errorf("policy %s takes no column: %q", kind, s)
+		}
+	case policyNewest:
+		if column == "" {
+			return mergePolicy{}, fmt.Errorf("policy newest needs a timestamp column, e.g. newest:time_last_played")
+		}
+	default:
+		return mergePolicy{}, fmt.Errorf("unknown merge policy %q", s)
+	}
+	return mergePolicy{kind: kind, column: column}, nil
+}
+
+// parseMergePolicies parses --merge-policy into policies per table. Columns without
+// a table prefix belong to media
+func parseMergePolicies(specs []string) (map[string]map[string]mergePolicy, error) {
+	policies := map[string]map[string]mergePolicy{}
+	for _, spec := range specs {
+		column, kind, ok := strings.Cut(spec, "=")
+		if !ok || column == "" {
+			return nil, fmt.Errorf("merge policy must look like column=policy: %q", spec)
+		}
+		table := "media"
+		if t, c, ok := strings.Cut(column, "."); ok {
+			table, column = t, c
+		}
+		p, err := parseMergePolicy(kind)
+		if err != nil {
+			return nil, err
+		}
+		if policies[table] == nil {
+			policies[table] = map[string]mergePolicy{}
+		}
+		policies[table][column] = p
+	}
+	return policies, nil
+}
+
+// tablePolicies returns the conflict policies for table. Policies only apply with
+// --upsert; otherwise conflicting rows are replaced or, with --ignore, skipped
+func (c *MergeDBsCmd) tablePolicies(table string) map[string]mergePolicy {
+	if !c.Upsert {
+		return nil
+	}
+	policies := map[string]mergePolicy{}
+	if table == "media" {
+		for col, p := range mediaMergePolicies {
+			policies[col] = p
+		}
+	}
+	for col, p := range c.policies[table] {
+		policies[col] = p
+	}
+	return policies
+}
+
+// needsRowMerge reports whether table has to be merged row by row rather than with a
+// single INSERT statement
+func (c *MergeDBsCmd) needsRowMerge(table string) bool {
+	_, dedupe := defaultMergeKeys[table]
+	return c.dryRun() || len(c.PathMap) > 0 || dedupe || len(c.tablePolicies(table)) > 0
+}
+
+func (c *MergeDBsCmd) dryRun() bool {
+	return c.MergeDryRun || c.Simulate
+}
+
+// mergeReport counts what merging one table did, or would do with --dry-run
+type mergeReport struct {
+	table      string
+	inserted   int
+	updated    int
+	unchanged  int
+	duplicates int
+	columns    map[string]int
+}
+
+func (r mergeReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %d new, %d updated", r.table, r.inserted, r.updated)
+	if len(r.columns) > 0 {
+		var cols []string
+		for _, col := range sortedKeys(r.columns) {
+			cols = append(cols, fmt.Sprintf("%s %d", col, r.columns[col]))
+		}
+		fmt.Fprintf(&sb, " (%s)", strings.Join(cols, ", "))
+	}
+	fmt.Fprintf(&sb, ", %d unchanged", r.unchanged)
+	if r.duplicates > 0 {
+		fmt.Fprintf(&sb, ", %d duplicates skipped", r.duplicates)
+	}
+	return sb.String()
+}
+
+// mergeRows merges a table one row at a time: source paths are rewritten, rows that
+// match an existing target row by key are combined column by column, and everything
+// runs in one transaction that --dry-run rolls back
+func (c *MergeDBsCmd) mergeRows(ctx context.Context, p copyRowsParams) (mergeReport, error) {
+	report := mergeReport{table: p.table, columns: map[string]int{}}
+
+	keys := c.resolvePrimaryKeys(ctx, p.targetConn, p.table)
+	dedupe := false
+	if len(c.PrimaryKeys) == 0 && len(c.BusinessKeys) == 0 {
+		if k, ok := defaultMergeKeys[p.table]; ok {
+			keys, dedupe = k, true
+			// The rowid would clash with unrelated target rows
+			p.selectedCols = slices.DeleteFunc(slices.Clone(p.selectedCols), func(col string) bool { return col == "id" })
+		} else if len(keys) == 0 {
+			keys, _ = c.getPrimaryKeyColumns(ctx, p.targetConn, p.table)
+		}
+	}
+	var keyIdx []int
+	for _, k := range keys {
+		i := slices.Index(p.selectedCols, k)
+		if i < 0 {
+			keyIdx = nil
+			break
+		}
+		keyIdx = append(keyIdx, i)
+	}
+	var pathIdx []int
+	for _, col := range pathColumns[p.table] {
+		if i := slices.Index(p.selectedCols, col); i >= 0 {
+			pathIdx = append(pathIdx, i)
+		}
+	}
+	policies := c.tablePolicies(p.table)
+
+	whereClause := ""
+	if len(c.Where) > 0 {
+		whereClause = " WHERE " + strings.Join(c.Where, " AND ")
+	}
+	cols := strings.Join(p.selectedCols, ", ")
+	rows, err := p.srcConn.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s%s", cols, p.table, whereClause))
+	if err != nil {
+		return report, fmt.Errorf("failed to select from source: %w", err)
+	}
+	defer rows.Close()
+
+	tx, err := p.targetConn.BeginTx(ctx, nil)
+	if err != nil {
+		return report, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	insert := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		p.table, cols, strings.Repeat("?, ", len(p.selectedCols)-1)+"?")
+	var keyWhere []string
+	for _, k := range keys {
+		keyWhere = append(keyWhere, k+" IS ?")
+	}
+	lookup := fmt.Sprintf("SELECT %s FROM %s WHERE %s", cols, p.table, strings.Join(keyWhere, " AND "))
+
+	src := make([]any, len(p.selectedCols))
+	srcPtrs := make([]any, len(src))
+	for i := range src {
+		srcPtrs[i] = &src[i]
+	}
+	dst := make([]any, len(p.selectedCols))
+	dstPtrs := make([]any, len(dst))
+	for i := range dst {
+		dstPtrs[i] = &dst[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(srcPtrs...); err != nil {
+			return report, err
+		}
+		for _, i := range pathIdx {
+			if s, ok := normalizeValue(src[i]).(string); ok {
+				src[i] = c.mapPath(s)
+			}
+		}
+
+		found := false
+		if len(keyIdx) > 0 {
+			keyVals := make([]any, len(keyIdx))
+			for j, i := range keyIdx {
+				keyVals[j] = src[i]
+			}
+			err := tx.QueryRowContext(ctx, lookup, keyVals...).Scan(dstPtrs...)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return report, err
+			}
+			found = err == nil
+		}
+
+		if !found {
+			if _, err := tx.ExecContext(ctx, insert, src...); err != nil {
+				return report, fmt.Errorf("failed to insert into %s: %w", p.table, err)
+			}
+			report.inserted++
+			continue
+		}
+		if dedupe || c.Ignore {
+			report.duplicates++
+			continue
+		}
+
+		var sets []string
+		var args []any
+		for i, col := range p.selectedCols {
+			if slices.Contains(keyIdx, i) {
+				continue
+			}
+			merged := mergeValue(policies[col], src, dst, p.selectedCols, i)
+			if !valuesEqual(merged, dst[i]) {
+				sets = append(sets, col+" = ?")
+				args = append(args, merged)
+				report.columns[col]++
+			}
+		}
+		if len(sets) == 0 {
+			report.unchanged++
+			continue
+		}
+		for _, i := range keyIdx {
+			args = append(args, dst[i])
+		}
+		update := fmt.Sprintf("UPDATE %s SET %s WHERE %s", p.table, strings.Join(sets, ", "), strings.Join(keyWhere, " AND "))
+		if _, err := tx.ExecContext(ctx, update, args...); err != nil {
+			return report, fmt.Errorf("failed to update %s: %w", p.table, err)
+		}
+		report.updated++
+	}
+	if err := rows.Err(); err != nil {
+		return report, err
+	}
+
+	if c.dryRun() {
+		return report, nil
+	}
+	return report, tx.Commit()
+}
+
+// mergeValue combines the source and target values of column i
+func mergeValue(p mergePolicy, src, dst []any, cols []string, i int) any {
+	s, d := normalizeValue(src[i]), normalizeValue(dst[i])
+	switch p.kind {
+	case policyKeep:
+		return d
+	case policyMax:
+		if sn, ok := toFloat(s); ok {
+			if dn, ok := toFloat(d); !ok || sn > dn {
+				return s
+			}
+		}
+		return d
+	case policyMin:
+		sn, sok := toFloat(s)
+		dn, dok := toFloat(d)
+		if sok && sn != 0 && (!dok || dn == 0 || sn < dn) {
+			return s
+		}
+		return d
+	case policySum:
+		sn, _ := toFloat(s)
+		dn, _ := toFloat(d)
+		if _, isInt := s.(int64); isInt || s == nil {
+			if _, isInt := d.(int64); isInt || d == nil {
+				return int64(sn + dn)
+			}
+		}
+		return sn + dn
+	case policyNewest:
+		t := slices.Index(cols, p.column)
+		if t < 0 {
+			return s
+		}
+		st, _ := toFloat(normalizeValue(src[t]))
+		dt, _ := toFloat(normalizeValue(dst[t]))
+		if st > dt {
+			return s
+		}
+		return d
+	case policyUnion:
+		return unionSets(d, s)
+	default:
+		return s
+	}
+}
+
+// unionSets merges ;-delimited sets, keeping the target's order
+func unionSets(target, source any) any {
+	ts, _ := target.(string)
+	ss, _ := source.(string)
+	var items []string
+	for _, part := range strings.Split(ts+";"+ss, ";") {
+		if part = strings.TrimSpace(part); part != "" && !slices.Contains(items, part) {
+			items = append(items, part)
+		}
+	}
+	if len(items) == 0 {
+		return target
+	}
+	return ";" + strings.Join(items, ";") + ";"
+}
+
+func normalizeValue(v any) any {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func valuesEqual(a, b any) bool {
+	a, b = normalizeValue(a), normalizeValue(b)
+	if an, ok := toFloat(a); ok {
+		bn, ok := toFloat(b)
+		return ok && an == bn
+	}
+	return a == b
+}
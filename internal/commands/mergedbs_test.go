@@ -54,3 +54,55 @@ func TestMergeDBsCmd_Run(t *testing.T) {
 		t.Errorf("Expected 2 rows in merged database, got %d", count)
 	}
 }
+
+func TestMergeDBsCmd_Policies(t *testing.T) {
+	targetPath, target := createSyncLibrary(t, "/mnt/media/")
+	srcPath, src := createSyncLibrary(t, "/home/me/nas/")
+
+	mustExec(t, target, `UPDATE media SET play_count = 3, playhead = 10, time_last_played = 100,
+		categories = ';music;' WHERE path = '/mnt/media/a.mp4'`)
+	mustExec(t, src, `UPDATE media SET play_count = 1, playhead = 50, time_last_played = 200,
+		categories = ';live;music;' WHERE path = '/home/me/nas/a.mp4'`)
+	mustExec(t, target, "INSERT INTO history (media_path, time_played, playhead) VALUES ('/mnt/media/a.mp4', 100, 10)")
+	mustExec(t, src, `INSERT INTO history (media_path, time_played, playhead) VALUES
+		('/home/me/nas/a.mp4', 100, 10), ('/home/me/nas/a.mp4', 200, 50)`)
+
+	cmd := &commands.MergeDBsCmd{TargetDB: targetPath, SourceDBs: []string{srcPath}}
+	cmd.Upsert = true
+	cmd.OnlyTables = []string{"media", "history"}
+	cmd.PathMap = []string{"/home/me/nas/=/mnt/media/"}
+
+	// A dry run leaves the target untouched
+	cmd.MergeDryRun = true
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var playhead int64
+	target.QueryRow("SELECT playhead FROM media WHERE path = '/mnt/media/a.mp4'").Scan(&playhead)
+	if playhead != 10 {
+		t.Errorf("Dry run changed playhead to %d", playhead)
+	}
+
+	cmd.MergeDryRun = false
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var playCount, mediaCount, historyCount int64
+	var categories string
+	target.QueryRow("SELECT playhead, play_count, categories FROM media WHERE path = '/mnt/media/a.mp4'").
+		Scan(&playhead, &playCount, &categories)
+	if playhead != 50 || playCount != 3 || categories != ";music;live;" {
+		t.Errorf("playhead=%d play_count=%d categories=%q", playhead, playCount, categories)
+	}
+	target.QueryRow("SELECT COUNT(*) FROM media").Scan(&mediaCount)
+	target.QueryRow("SELECT COUNT(*) FROM history").Scan(&historyCount)
+	if mediaCount != 2 || historyCount != 2 {
+		t.Errorf("Expected 2 media and 2 history rows, got %d and %d", mediaCount, historyCount)
+	}
+
+	cmd.MergePolicy = []string{"play_count=bogus"}
+	if err := cmd.Run(context.Background()); err == nil {
+		t.Error("Expected an invalid merge policy to fail")
+	}
+}
@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/history"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/player"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// mpvCheckpointInterval is how often a running session writes its playhead
+var mpvCheckpointInterval = 15 * time.Second
+
+// mpvOwnerRetry is how long to wait before asking the socket owner for its pid again
+var mpvOwnerRetry = 200 * time.Millisecond
+
+var mpvSessionProperties = []string{"pid", "time-pos", "pause", "playlist-pos", "eof-reached"}
+
+// playbackSession follows a running mpv over IPC and periodically saves progress,
+// instead of only reading watch_later files after the player exits
+type playbackSession struct {
+	m       models.MediaWithDB
+	pid     int
+	history *history.Session
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	saveNow chan struct{}
+
+	mu          sync.Mutex
+	owned       bool
+	timePos     float64
+	havePos     bool
+	playlistPos int
+	eof         bool
+	lastSaved   int
+}
+
+// startPlaybackSession begins tracking m as played by process pid. socketPath may be empty
+// when the player is not mpv, in which case the session only records the final playhead
+func startPlaybackSession(ctx context.Context, socketPath string, pid int, m models.MediaWithDB) *playbackSession {
+	s := &playbackSession{
+		m:         m,
+		pid:       pid,
+		history:   history.NewSession(m.DB, m.Path),
+		saveNow:   make(chan struct{}, 1),
+		lastSaved: -1,
+	}
+	if socketPath == "" {
+		return s
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		// Another mpv, such as one started by hand, may hold the shared socket until ours replaces it
+		if !waitForMpvOwner(ctx, socketPath, pid) {
+			return
+		}
+		if err := utils.ObserveMpv(ctx, socketPath, mpvSessionProperties, s.handleEvent); err != nil {
+			models.Log.Debug("mpv IPC session ended", "path", m.Path, "error", err)
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(mpvCheckpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-s.saveNow:
+			}
+			s.checkpoint(ctx)
+		}
+	}()
+	return s
+}
+
+// waitForMpvOwner returns once the mpv answering on socketPath is process pid
+func waitForMpvOwner(ctx context.Context, socketPath string, pid int) bool {
+	for {
+		if v, err := utils.MpvGetProperty(ctx, socketPath, "pid"); err == nil {
+			if got, ok := v.(float64); ok && int(got) == pid {
+				return true
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(mpvOwnerRetry):
+		}
+	}
+}
+
+func (s *playbackSession) handleEvent(ev utils.MpvEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The socket may have changed hands between the pid check and observing
+	if ev.Name == "pid" {
+		got, ok := ev.Data.(float64)
+		s.owned = ok && int(got) == s.pid
+		return
+	}
+	if !s.owned {
+		return
+	}
+
+	switch ev.Name {
+	case "time-pos":
+		// time-pos is null between files and while idle
+		if pos, ok := ev.Data.(float64); ok && s.playlistPos == 0 {
+			s.timePos = pos
+			s.havePos = true
+		}
+	case "pause":
+		// Save right away so a pause followed by a kill or crash keeps the position
+		if paused, _ := ev.Data.(bool); paused {
+			select {
+			case s.saveNow <- struct{}{}:
+			default:
+			}
+		}
+	case "playlist-pos":
+		// Anything but the first entry means the user loaded another file into this mpv
+		if pos, ok := ev.Data.(float64); ok {
+			s.playlistPos = int(pos)
+		}
+	case "eof-reached":
+		s.eof, _ = ev.Data.(bool)
+	}
+}
+
+// position returns the last observed playhead, if any
+func (s *playbackSession) position() (playhead int, ok, eof bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int(s.timePos), s.havePos, s.eof
+}
+
+func (s *playbackSession) checkpoint(ctx context.Context) {
+	s.mu.Lock()
+	playhead, ok := int(s.timePos), s.havePos
+	unchanged := playhead == s.lastSaved
+	s.mu.Unlock()
+
+	if !ok || unchanged {
+		return
+	}
+
+	if err := s.history.Checkpoint(ctx, playhead, false); err != nil {
+		models.Log.Warn("Failed to save playhead", "path", s.m.Path, "error", err)
+		return
+	}
+	s.mu.Lock()
+	s.lastSaved = playhead
+	s.mu.Unlock()
+}
+
+// finish stops observing and records the final playhead. The live IPC position is preferred;
+// without one it falls back to the watch_later/session-length estimate
+func (s *playbackSession) finish(ctx context.Context, flags models.GlobalFlags, startTime time.Time) error {
+	if s.cancel != nil {
+		s.cancel()
+		s.wg.Wait()
+	}
+	defer s.history.Close()
+
+	playhead, ok, eof := s.position()
+	if !ok {
+		playhead = utils.GetPlayhead(flags, s.m.Path, startTime, mustInt(s.m.Playhead), mustInt(s.m.Duration))
+	}
+	return s.history.Checkpoint(ctx, playhead, eof)
+}
+
+// mpvSessionSocket returns the IPC socket to observe, or "" when the player is not mpv
+func mpvSessionSocket(flags models.PlaybackFlags) string {
+	if mpv, ok := playerFor(flags).(*player.Mpv); ok {
+		return mpv.Socket
+	}
+	return ""
+}
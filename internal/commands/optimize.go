@@ -17,45 +17,59 @@ type OptimizeCmd struct {
 	models.CoreFlags `embed:""`
 
 	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+
+	// progress, when set, receives the number of databases optimized
+	progress func(done, total int)
 }
 
 func (c *OptimizeCmd) Run(ctx context.Context) error {
 	models.SetupLogging(c.Verbose)
-	for _, dbPath := range c.Databases {
-		models.Log.Info("Optimizing database", "path", dbPath)
+	return c.run(ctx)
+}
+
+// run is Run without replacing the global logger, for jobs that log through ctx
+func (c *OptimizeCmd) run(ctx context.Context) error {
+	for i, dbPath := range c.Databases {
+		if c.progress != nil {
+			c.progress(i, len(c.Databases))
+		}
+		models.LogFrom(ctx).Info("Optimizing database", "path", dbPath)
 		sqlDB, queries, err := db.ConnectWithInit(ctx, dbPath)
 		if err != nil {
 			return err
 		}
 		defer sqlDB.Close()
 
-		models.Log.Info("Running VACUUM...")
+		models.LogFrom(ctx).Info("Running VACUUM...")
 		if _, err := sqlDB.ExecContext(ctx, "VACUUM"); err != nil {
 			return fmt.Errorf("VACUUM failed on %s: %w", dbPath, err)
 		}
 
-		models.Log.Info("Running ANALYZE...")
+		models.LogFrom(ctx).Info("Running ANALYZE...")
 		if _, err := sqlDB.ExecContext(ctx, "ANALYZE"); err != nil {
 			return fmt.Errorf("ANALYZE failed on %s: %w", dbPath, err)
 		}
 
-		models.Log.Info("Optimizing FTS index...")
+		models.LogFrom(ctx).Info("Optimizing FTS index...")
 		// FTS5 optimize command
 		if _, err := sqlDB.ExecContext(ctx, "INSERT INTO media_fts(media_fts) VALUES('optimize')"); err != nil {
-			models.Log.Warn("FTS optimize failed (maybe table doesn't exist?)", "path", dbPath, "error", err)
+			models.LogFrom(ctx).Warn("FTS optimize failed (maybe table doesn't exist?)", "path", dbPath, "error", err)
 		}
 
 		if err := c.BulkMarkOptimizedExtensions(ctx, sqlDB, queries); err != nil {
-			models.Log.Warn("BulkMarkOptimizedExtensions failed", "path", dbPath, "error", err)
+			models.LogFrom(ctx).Warn("BulkMarkOptimizedExtensions failed", "path", dbPath, "error", err)
 		}
 
-		models.Log.Info("Optimization complete", "path", dbPath)
+		models.LogFrom(ctx).Info("Optimization complete", "path", dbPath)
+	}
+	if c.progress != nil {
+		c.progress(len(c.Databases), len(c.Databases))
 	}
 	return nil
 }
 
 func (c *OptimizeCmd) BulkMarkOptimizedExtensions(ctx context.Context, sqlDB *sql.DB, _ *db.Queries) error {
-	models.Log.Info("Running BulkMarkOptimizedExtensions...")
+	models.LogFrom(ctx).Info("Running BulkMarkOptimizedExtensions...")
 	tx, err := sqlDB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
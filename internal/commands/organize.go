@@ -0,0 +1,359 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type OrganizeCmd struct {
+	models.CoreFlags        `embed:""`
+	models.PathFilterFlags  `embed:""`
+	models.FilterFlags      `embed:""`
+	models.MediaFilterFlags `embed:""`
+	models.TimeFilterFlags  `embed:""`
+
+	OnCollision string `help:"What to do when the target path is taken" default:"rename" enum:"rename,skip"`
+
+	Destination string   `help:"Library root the template is relative to"                          required:"true" arg:"" type:"path"`
+	Template    string   `help:"Path template, e.g. '{artist}/{album}/{track:02} - {title}{ext}'"  required:"true" arg:""`
+	Databases   []string `help:"SQLite database files"                                             required:"true" arg:"" type:"existingfile"`
+}
+
+// organizeMove is one planned rename; sidecars are external subtitles moving along
+type organizeMove struct {
+	media    models.MediaWithDB
+	dst      string
+	sidecars [][2]string
+}
+
+var (
+	organizeFieldRegex   = regexp.MustCompile(`\{(\w+)(?::(\d+))?(?:\|([^}]*))?\}`)
+	organizeEpisodeRegex = regexp.MustCompile(`(?i)^(.*?)[ ._-]*\bS(\d{1,3})[ ._-]?E(\d{1,4})\b`)
+	organizeTrackRegex   = regexp.MustCompile(`^(\d{1,3})(?:[ ._-]|$)`)
+)
+
+func (c *OrganizeCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	flags := models.GlobalFlags{
+		CoreFlags:        c.CoreFlags,
+		PathFilterFlags:  c.PathFilterFlags,
+		FilterFlags:      c.FilterFlags,
+		MediaFilterFlags: c.MediaFilterFlags,
+		TimeFilterFlags:  c.TimeFilterFlags,
+	}
+	if !organizeFieldRegex.MatchString(c.Template) {
+		return fmt.Errorf("template has no {fields}: %s", c.Template)
+	}
+
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return err
+	}
+	media = query.FilterMedia(media, flags)
+
+	plan := c.planOrganize(media)
+	if len(plan) == 0 {
+		fmt.Println("Nothing to organize")
+		return nil
+	}
+	for _, mv := range plan {
+		fmt.Printf("%s\n  -> %s\n", mv.media.Path, mv.dst)
+		for _, s := range mv.sidecars {
+			fmt.Printf("  %s -> %s\n", s[0], s[1])
+		}
+	}
+	if c.Simulate {
+		fmt.Printf("\n%d files would be moved\n", len(plan))
+		return nil
+	}
+	if !c.NoConfirm && !utils.Confirm(fmt.Sprintf("Move %d files?", len(plan))) {
+		return nil
+	}
+
+	byDB := map[string][]organizeMove{}
+	for _, mv := range plan {
+		byDB[mv.media.DB] = append(byDB[mv.media.DB], mv)
+	}
+	if err := applyOrganize(ctx, byDB); err != nil {
+		return err
+	}
+	fmt.Printf("%d files moved\n", len(plan))
+	return nil
+}
+
+// planOrganize renders the template for each file and resolves collisions, both with
+// files on disk and with other files of the plan
+func (c *OrganizeCmd) planOrganize(media []models.MediaWithDB) []organizeMove {
+	taken := map[string]bool{}
+	var plan []organizeMove
+	for _, m := range media {
+		if !utils.FileExists(m.Path) {
+			continue
+		}
+		rel, err := renderOrganizeTemplate(c.Template, m)
+		if err != nil {
+			models.Log.Warn("Skipping", "path", m.Path, "error", err)
+			continue
+		}
+		dst, err := filepath.Abs(filepath.Join(c.Destination, filepath.FromSlash(rel)))
+		if err != nil {
+			models.Log.Warn("Skipping", "path", m.Path, "error", err)
+			continue
+		}
+		if dst == m.Path {
+			taken[dst] = true
+			continue
+		}
+
+		subs := utils.GetExternalSubtitles(m.Path)
+		mv := organizeMove{media: m, dst: dst, sidecars: organizeSidecars(m.Path, dst, subs)}
+		if !organizeFree(mv, taken) {
+			if c.OnCollision == "skip" {
+				models.Log.Warn("Skipping, target exists", "path", m.Path, "target", dst)
+				continue
+			}
+			mv = organizeAltMove(mv, subs, taken)
+		}
+		taken[mv.dst] = true
+		for _, s := range mv.sidecars {
+			taken[s[1]] = true
+		}
+		plan = append(plan, mv)
+	}
+	return plan
+}
+
+// organizeSidecars pairs each subtitle of src with its path next to dst
+func organizeSidecars(src, dst string, subs []string) [][2]string {
+	oldBase := strings.TrimSuffix(src, filepath.Ext(src))
+	newBase := strings.TrimSuffix(dst, filepath.Ext(dst))
+	sidecars := make([][2]string, 0, len(subs))
+	for _, sub := range subs {
+		sidecars = append(sidecars, [2]string{sub, newBase + strings.TrimPrefix(sub, oldBase)})
+	}
+	return sidecars
+}
+
+// organizeFree reports whether neither the file nor its sidecars would land on a
+// file on disk or on another target of the plan
+func organizeFree(mv organizeMove, taken map[string]bool) bool {
+	for _, dst := range append([]string{mv.dst}, organizeTargets(mv.sidecars)...) {
+		if taken[dst] || utils.FileExists(dst) {
+			return false
+		}
+	}
+	return true
+}
+
+func organizeTargets(pairs [][2]string) []string {
+	targets := make([]string, len(pairs))
+	for i, p := range pairs {
+		targets[i] = p[1]
+	}
+	return targets
+}
+
+// organizeAltMove numbers the target the way utils.AltName does until the file and
+// all of its sidecars fit
+func organizeAltMove(mv organizeMove, subs []string, taken map[string]bool) organizeMove {
+	ext := filepath.Ext(mv.dst)
+	base := strings.TrimSuffix(mv.dst, ext)
+	for i := 1; ; i++ {
+		alt := organizeMove{media: mv.media, dst: fmt.Sprintf("%s_%d%s", base, i, ext)}
+		alt.sidecars = organizeSidecars(mv.media.Path, alt.dst, subs)
+		if organizeFree(alt, taken) {
+			return alt
+		}
+	}
+}
+
+// renderOrganizeTemplate fills {field}, {field:02} (zero padded) and {field|fallback}
+// placeholders from the media columns, the path and details parsed from the filename
+func renderOrganizeTemplate(tmpl string, m models.MediaWithDB) (string, error) {
+	fields, err := organizeFields(m)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	out := organizeFieldRegex.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+		parts := organizeFieldRegex.FindStringSubmatch(placeholder)
+		name, width, fallback := parts[1], parts[2], parts[3]
+		v := fields[name]
+		if v == "" {
+			if fallback == "" {
+				missing = append(missing, name)
+			}
+			return fallback
+		}
+		if width != "" {
+			if n, err := strconv.ParseFloat(v, 64); err == nil {
+				w, _ := strconv.Atoi(width)
+				return fmt.Sprintf("%0*d", w, int64(n))
+			}
+		}
+		if name == "ext" {
+			return v
+		}
+		if v = organizeSanitize(v); v == "" && fallback == "" {
+			missing = append(missing, name)
+		}
+		return cmp.Or(v, fallback)
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	return out, nil
+}
+
+// organizeSanitize makes a field value safe as one path segment. Only characters
+// that are invalid in filenames on common filesystems are dropped
+func organizeSanitize(v string) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`/\<>:"|?*`, r) {
+			return -1
+		}
+		return r
+	}, v)
+	// Windows drops trailing dots and spaces, and "." or ".." would change the directory
+	return strings.TrimRight(strings.TrimSpace(v), ". ")
+}
+
+// organizeFields returns the values a template can use, as text
+func organizeFields(m models.MediaWithDB) (map[string]string, error) {
+	raw, err := exportFields(m)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw)+8)
+	for k, v := range raw {
+		switch v := v.(type) {
+		case nil:
+		case string:
+			fields[k] = strings.TrimSpace(v)
+		case json.Number:
+			fields[k] = v.String()
+		default:
+			fields[k] = fmt.Sprint(v)
+		}
+	}
+
+	stem := m.Stem()
+	fields["name"] = filepath.Base(m.Path)
+	fields["stem"] = stem
+	fields["ext"] = filepath.Ext(m.Path)
+	fields["parent"] = filepath.Base(m.Parent())
+	fields["grandparent"] = filepath.Base(filepath.Dir(m.Parent()))
+
+	if fields["track"] == "" {
+		fields["track"] = fields["track_number"]
+	}
+	if fields["track"] == "" {
+		if t := organizeTrackRegex.FindStringSubmatch(stem); t != nil {
+			fields["track"] = t[1]
+		}
+	}
+	if e := organizeEpisodeRegex.FindStringSubmatch(stem); e != nil {
+		fields["season"] = e[2]
+		fields["episode"] = e[3]
+		fields["show"] = strings.TrimSpace(strings.NewReplacer(".", " ", "_", " ").Replace(e[1]))
+	}
+	if fields["show"] == "" && fields["season"] != "" {
+		fields["show"] = fields["parent"]
+	}
+	return fields, nil
+}
+
+// organizeBatch is the open transaction of one database and the files moved for it
+type organizeBatch struct {
+	dbPath string
+	sqlDB  *sql.DB
+	tx     *sql.Tx
+	moved  [][2]string
+}
+
+// undo puts the files of the batch back, newest first
+func (b *organizeBatch) undo() {
+	for i := len(b.moved) - 1; i >= 0; i-- {
+		if err := utils.MoveFile(b.moved[i][1], b.moved[i][0]); err != nil {
+			models.Log.Error("Could not move file back", "path", b.moved[i][1], "error", err)
+		}
+	}
+}
+
+// applyOrganize moves the files and updates their paths with one transaction per
+// database. Nothing is committed until every file has moved; if anything fails
+// before that, all transactions roll back and the files are put back
+func applyOrganize(ctx context.Context, byDB map[string][]organizeMove) error {
+	var batches []*organizeBatch
+	defer func() {
+		for _, b := range batches {
+			_ = b.tx.Rollback()
+			_ = b.sqlDB.Close()
+		}
+	}()
+	for _, dbPath := range sortedKeys(byDB) {
+		sqlDB, err := db.Connect(ctx, dbPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", dbPath, err)
+		}
+		tx, err := sqlDB.BeginTx(ctx, nil)
+		if err != nil {
+			_ = sqlDB.Close()
+			return fmt.Errorf("%s: %w", dbPath, err)
+		}
+		batches = append(batches, &organizeBatch{dbPath: dbPath, sqlDB: sqlDB, tx: tx})
+	}
+
+	for i, b := range batches {
+		if err := b.apply(ctx, byDB[b.dbPath]); err != nil {
+			for j := i; j >= 0; j-- {
+				batches[j].undo()
+			}
+			return fmt.Errorf("%s: %w", b.dbPath, err)
+		}
+	}
+
+	for i, b := range batches {
+		if err := b.tx.Commit(); err != nil {
+			// Earlier databases already point at the new paths, so only the files
+			// of this and later databases go back
+			for _, rest := range batches[i:] {
+				rest.undo()
+			}
+			return fmt.Errorf("%s: %w", b.dbPath, err)
+		}
+	}
+	return nil
+}
+
+func (b *organizeBatch) apply(ctx context.Context, plan []organizeMove) error {
+	for _, mv := range plan {
+		for _, pair := range append([][2]string{{mv.media.Path, mv.dst}}, mv.sidecars...) {
+			// Another process may have created the target since the plan was made
+			if utils.FileExists(pair[1]) {
+				return fmt.Errorf("target exists: %s", pair[1])
+			}
+			if err := utils.MoveFile(pair[0], pair[1]); err != nil {
+				return err
+			}
+			b.moved = append(b.moved, pair)
+		}
+		if err := renameMediaPath(ctx, b.tx, mv.media.Path, mv.dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
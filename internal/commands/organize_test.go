@@ -0,0 +1,107 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+func TestOrganizeCmd(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+
+	fixture.CreateFileTree(map[string]any{
+		"in": map[string]any{
+			"1 intro.mp3":             "a",
+			"2 outro.mp3":             "b",
+			"untagged.mp3":            "c",
+			"Some.Show.S01E02.mkv":    "d",
+			"Some.Show.S01E02.en.srt": "e",
+		},
+	})
+	in := filepath.Join(fixture.TempDir, "in")
+	lib := filepath.Join(fixture.TempDir, "lib")
+
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	insert := "INSERT INTO media (path, artist, album, title) VALUES (?, ?, ?, ?)"
+	sqlDB.Exec(insert, filepath.Join(in, "1 intro.mp3"), "Band", "Album", "Intro")
+	sqlDB.Exec(insert, filepath.Join(in, "2 outro.mp3"), "Band", "Album", "Ágætis byrjun: Don't Stop")
+	sqlDB.Exec(insert, filepath.Join(in, "untagged.mp3"), nil, nil, nil)
+	sqlDB.Exec("INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, 100, 10, 1)",
+		filepath.Join(in, "1 intro.mp3"))
+	sqlDB.Close()
+
+	run := func(tmpl string, simulate bool) {
+		cmd := &commands.OrganizeCmd{
+			CoreFlags:   models.CoreFlags{Simulate: simulate, NoConfirm: true},
+			OnCollision: "rename",
+			Destination: lib,
+			Template:    tmpl,
+			Databases:   []string{fixture.DBPath},
+		}
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatalf("organize failed: %v", err)
+		}
+	}
+
+	musicTemplate := "{artist}/{album}/{track:02} - {title}{ext}"
+	run(musicTemplate, true)
+	if !utils.FileExists(filepath.Join(in, "1 intro.mp3")) {
+		t.Fatal("simulate should not move files")
+	}
+
+	run(musicTemplate, false)
+	first := filepath.Join(lib, "Band", "Album", "01 - Intro.mp3")
+	// Only characters invalid in filenames are dropped from field values
+	second := filepath.Join(lib, "Band", "Album", "02 - Ágætis byrjun Don't Stop.mp3")
+	for _, p := range []string{first, second} {
+		if !utils.FileExists(p) {
+			t.Errorf("expected %s", p)
+		}
+	}
+	if !utils.FileExists(filepath.Join(in, "untagged.mp3")) {
+		t.Error("files missing template fields should be left alone")
+	}
+
+	sqlDB = fixture.GetDB()
+	var historyPath string
+	sqlDB.QueryRow("SELECT media_path FROM history").Scan(&historyPath)
+	if historyPath != first {
+		t.Errorf("history not updated, got %q", historyPath)
+	}
+	var n int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM media WHERE path LIKE ?", in+"%").Scan(&n)
+	if n != 1 {
+		t.Errorf("expected only the untagged file left under %s, got %d", in, n)
+	}
+
+	sqlDB.Exec("INSERT INTO media (path) VALUES (?)", filepath.Join(in, "Some.Show.S01E02.mkv"))
+	sqlDB.Close()
+
+	// A subtitle already at the sidecar target makes the episode take another name
+	season := filepath.Join(lib, "Some Show", "Season 01")
+	fixture.CreateFileTree(map[string]any{
+		"lib": map[string]any{"Some Show": map[string]any{"Season 01": map[string]any{"Some Show S01E02.en.srt": "old"}}},
+	})
+
+	run("{show}/Season {season}/{show} S{season:02}E{episode:02}{ext}", false)
+	episode := filepath.Join(season, "Some Show S01E02_1.mkv")
+	if !utils.FileExists(episode) {
+		t.Errorf("expected %s", episode)
+	}
+	if !utils.FileExists(filepath.Join(season, "Some Show S01E02_1.en.srt")) {
+		t.Error("subtitle sidecar was not moved")
+	}
+	if b, _ := os.ReadFile(filepath.Join(season, "Some Show S01E02.en.srt")); string(b) != "old" {
+		t.Errorf("existing subtitle was overwritten, got %q", b)
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
-	"strconv"
 	"strings"
 
 	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/player"
 	"github.com/chapmanjacobd/discoteca/internal/utils"
 )
 
@@ -21,45 +20,45 @@ type NowCmd struct {
 }
 
 func (c *NowCmd) Run(ctx context.Context) error {
-	cattFile := utils.GetCattNowPlayingFile()
-	if utils.FileExists(cattFile) {
-		data, err := os.ReadFile(cattFile)
+	found := false
+
+	caster, err := runningCast(ctx, c.CastDevice)
+	if err != nil {
+		models.Log.Warn("Failed to query Chromecast", "error", err)
+	} else if caster != nil {
+		st, err := caster.Status(ctx)
+		caster.Close()
 		if err == nil {
-			fmt.Printf("Now Playing (Chromecast): %s\n", string(data))
+			printPlayerStatus("Now Playing (Chromecast)", st)
+			found = true
 		}
 	}
 
-	socketPath := utils.GetMpvSocketPath(c.MpvSocket)
-	pathResp, err := utils.MpvCall(ctx, socketPath, "get_property", "path")
+	var st player.Status
+	p, err := player.Running(ctx, controlPlayerConfig(c.ControlFlags))
+	if err == nil {
+		st, err = p.Status(ctx)
+	}
 	if err != nil {
-		if !utils.FileExists(cattFile) {
-			return errors.New("no playback detected (mpv or chromecast)")
+		if !found {
+			return errors.New("no playback detected (mpv, vlc, or chromecast)")
 		}
 		return nil
 	}
 
-	path := utils.GetString(pathResp.Data)
-	fmt.Printf("Now Playing: %s\n", path)
+	printPlayerStatus("Now Playing", st)
+	return nil
+}
 
-	posResp, err := utils.MpvCall(ctx, socketPath, "get_property", "time-pos")
-	if err == nil && posResp.Data != nil {
-		pos := 0.0
-		if v, ok := posResp.Data.(float64); ok {
-			pos = v
-		}
-		fmt.Printf("    Playhead: %s\n", utils.SecondsToHHMMSS(int64(pos)))
+func printPlayerStatus(heading string, st player.Status) {
+	fmt.Printf("%s: %s\n", heading, st.Path)
+	fmt.Printf("    Playhead: %s\n", utils.SecondsToHHMMSS(int64(st.Position)))
+	if st.Duration > 0 {
+		fmt.Printf("    Duration: %s\n", utils.SecondsToHHMMSS(int64(st.Duration)))
 	}
-
-	durResp, err := utils.MpvCall(ctx, socketPath, "get_property", "duration")
-	if err == nil && durResp.Data != nil {
-		dur := 0.0
-		if v, ok := durResp.Data.(float64); ok {
-			dur = v
-		}
-		fmt.Printf("    Duration: %s\n", utils.SecondsToHHMMSS(int64(dur)))
+	if st.Paused {
+		fmt.Println("    Paused")
 	}
-
-	return nil
 }
 
 type StopCmd struct {
@@ -68,9 +67,7 @@ type StopCmd struct {
 
 func (c *StopCmd) Run(ctx context.Context) error {
 	return DispatchPlaybackCommand(ctx, c.ControlFlags, PlaybackCommandParams{
-		MpvCmd:  "loadfile",
-		MpvArgs: []any{"/dev/null"},
-		CastCmd: "stop",
+		Action: player.Controller.Stop,
 	})
 }
 
@@ -80,9 +77,7 @@ type PauseCmd struct {
 
 func (c *PauseCmd) Run(ctx context.Context) error {
 	return DispatchPlaybackCommand(ctx, c.ControlFlags, PlaybackCommandParams{
-		MpvCmd:  "cycle",
-		MpvArgs: []any{"pause"},
-		CastCmd: "play_toggle",
+		Action: player.Controller.Pause,
 	})
 }
 
@@ -91,11 +86,9 @@ type NextCmd struct {
 }
 
 func (c *NextCmd) Run(ctx context.Context) error {
-	// Note: We don't remove cattFile for next because CastPlay loop handles it
+	// On a Chromecast this stops the current item and the casting loop moves on
 	return DispatchPlaybackCommand(ctx, c.ControlFlags, PlaybackCommandParams{
-		MpvCmd:  "playlist_next",
-		MpvArgs: []any{"force"},
-		CastCmd: "stop",
+		Action: player.Controller.Next,
 	})
 }
 
@@ -134,14 +127,6 @@ func (c *SeekCmd) Run(ctx context.Context) error {
 		seconds = -seconds
 	}
 
-	castCmd := "seek"
-	if isRelative && isNegative {
-		castCmd = "rewind"
-		seconds = -seconds
-	} else if isRelative {
-		castCmd = "ffwd"
-	}
-
 	mode := "absolute"
 	if isRelative {
 		mode = "relative"
@@ -154,10 +139,9 @@ func (c *SeekCmd) Run(ctx context.Context) error {
 		ctx,
 		c.ControlFlags,
 		PlaybackCommandParams{
-			MpvCmd:   "seek",
-			MpvArgs:  []any{seconds, mode},
-			CastCmd:  castCmd,
-			CastArgs: []string{strconv.FormatInt(int64(seconds), 10)},
+			Action: func(p player.Controller, ctx context.Context) error {
+				return p.Seek(ctx, seconds, mode == "relative")
+			},
 		},
 	)
 }
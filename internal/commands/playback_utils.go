@@ -2,42 +2,60 @@ package commands
 
 import (
 	"context"
-	"os"
+	"errors"
 
 	"github.com/chapmanjacobd/discoteca/internal/models"
-	"github.com/chapmanjacobd/discoteca/internal/utils"
+	"github.com/chapmanjacobd/discoteca/internal/player"
 )
 
-// PlaybackCommandParams contains the command and arguments for mpv and chromecast playback
+// playerFor returns the player backend selected by --override-player / --player-template
+func playerFor(flags models.PlaybackFlags) player.Player {
+	return player.New(player.Config{
+		Binary:    flags.OverridePlayer,
+		MpvSocket: flags.MpvSocket,
+		VlcRC:     flags.VlcRC,
+		VlcHTTP:   flags.VlcHTTP,
+		Template:  flags.PlayerTemplate,
+	})
+}
+
+// controlPlayerConfig describes which running player the control commands talk to
+func controlPlayerConfig(c models.ControlFlags) player.Config {
+	return player.Config{
+		Backend:   c.Player,
+		MpvSocket: c.MpvSocket,
+		VlcRC:     c.VlcRC,
+		VlcHTTP:   c.VlcHTTP,
+	}
+}
+
+// PlaybackCommandParams contains the action to run against each active player.
+// Action has the shape of a Controller method expression, e.g. player.Controller.Pause
 type PlaybackCommandParams struct {
-	MpvCmd   string
-	MpvArgs  []any
-	CastCmd  string
-	CastArgs []string
+	Action func(p player.Controller, ctx context.Context) error
 }
 
-// DispatchPlaybackCommand handles common logic for sending commands to mpv or Chromecast
+// DispatchPlaybackCommand handles common logic for sending commands to the local player or Chromecast
 func DispatchPlaybackCommand(
 	ctx context.Context,
 	c models.ControlFlags,
 	params PlaybackCommandParams,
 ) error {
-	cattFile := utils.GetCattNowPlayingFile()
-	if utils.FileExists(cattFile) {
-		args := append([]string{params.CastCmd}, params.CastArgs...)
-		if err := utils.CastCommand(ctx, c.CastDevice, args...); err != nil {
+	caster, err := runningCast(ctx, c.CastDevice)
+	if err != nil {
+		models.Log.Warn("Cast command failed", "error", err)
+	} else if caster != nil {
+		defer caster.Close()
+		if err := params.Action(caster, ctx); err != nil {
 			models.Log.Warn("Cast command failed", "error", err)
 		}
-		if params.CastCmd == "stop" {
-			os.Remove(cattFile)
-		}
 	}
 
-	socketPath := utils.GetMpvSocketPath(c.MpvSocket)
-	if utils.FileExists(socketPath) {
-		args := append([]any{params.MpvCmd}, params.MpvArgs...)
-		_, err := utils.MpvCall(ctx, socketPath, args...)
+	p, err := player.Running(ctx, controlPlayerConfig(c))
+	if errors.Is(err, player.ErrNotRunning) {
+		return nil
+	} else if err != nil {
 		return err
 	}
-	return nil
+	return params.Action(p, ctx)
 }
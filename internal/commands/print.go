@@ -56,11 +56,7 @@ func (c *PrintCmd) Run(ctx context.Context) error {
 
 		HideRedundantFirstPlayed(media)
 
-		isAggregated := flags.BigDirs || flags.GroupByExtensions || flags.GroupBySize || flags.Depth > 0 ||
-			flags.Parents ||
-			flags.FoldersOnly ||
-			len(flags.FolderSizes) > 0 ||
-			flags.FolderCounts != ""
+		isAggregated := isAggregated(flags)
 
 		if flags.JSON {
 			if isAggregated {
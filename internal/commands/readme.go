@@ -168,6 +168,11 @@ var subcommandExamples = map[string][]string{
 		"disco history my_videos.db",
 		"disco history my_videos.db --inprogress",
 	},
+	"feeds": {
+		"disco feeds add podcasts.db https://example.com/feed.xml",
+		"disco feeds add podcasts.db https://example.com/feed.xml --download-dir ~/Podcasts",
+		"disco feeds update podcasts.db",
+	},
 	"optimize": {
 		"disco optimize my_videos.db",
 	},
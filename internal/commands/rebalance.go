@@ -0,0 +1,501 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+	"github.com/chapmanjacobd/discoteca/internal/utils/pathutil"
+)
+
+type RebalanceCmd struct {
+	models.CoreFlags `embed:""`
+
+	Disks   []string `help:"Mount points to balance, e.g. --disks /mnt/d1,/mnt/d2"                       required:"true"`
+	MinFree string   `help:"Free space each disk should reach (e.g. 200GB) instead of evening it out"`
+	Depth   int      `help:"Move folders this many levels below the disk instead of series and album folders"`
+	Replan  bool     `help:"Discard an interrupted rebalance instead of resuming it"`
+
+	Databases []string `help:"SQLite database files"                                                        required:"true" arg:"" type:"existingfile"`
+}
+
+// rebalanceJournalKey is the _maintenance_meta key of the moves that are left, kept in
+// the first database so that an interrupted rebalance can be resumed
+const rebalanceJournalKey = "rebalance_plan"
+
+// rebalanceSubfolderRegex matches the season and disc folders of a series or album,
+// which move together with their parent folder
+var rebalanceSubfolderRegex = regexp.MustCompile(
+	`(?i)^((season|series|s|disc|disk|cd|dvd|part|vol(ume)?)[ ._-]*\d+|specials?|extras?)$`)
+
+type rebalanceDisk struct {
+	mount  string
+	fs     string // mount point of the filesystem
+	space  utils.DiskSpace
+	target int64 // free space to reach
+	units  []models.FolderStats
+}
+
+// rebalanceMove moves the folder or file Src to Dst on another disk
+type rebalanceMove struct {
+	Src  string `json:"src"`
+	Dst  string `json:"dst"`
+	Size int64  `json:"size"`
+}
+
+func (c *RebalanceCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	if len(c.Disks) < 2 {
+		return errors.New("at least two --disks are needed")
+	}
+	disks, err := c.loadDisks()
+	if err != nil {
+		return err
+	}
+
+	journalDB, err := db.Connect(ctx, c.Databases[0])
+	if err != nil {
+		return err
+	}
+	defer journalDB.Close()
+	plan, err := loadRebalanceJournal(ctx, journalDB)
+	if err != nil {
+		return err
+	}
+	if len(plan) > 0 && !c.Replan {
+		fmt.Printf("Resuming an interrupted rebalance, %d moves left\n", len(plan))
+	} else {
+		if plan, err = c.planRebalance(ctx, disks); err != nil {
+			return err
+		}
+	}
+	if len(plan) == 0 {
+		fmt.Println("Nothing to move")
+		if c.Replan && !c.Simulate {
+			return saveRebalanceJournal(ctx, journalDB, nil)
+		}
+		return nil
+	}
+
+	c.printPlan(disks, plan)
+	if c.Simulate {
+		return nil
+	}
+	if !c.NoConfirm && !utils.Confirm(fmt.Sprintf("Move %d folders?", len(plan))) {
+		return nil
+	}
+	return c.applyRebalance(ctx, journalDB, plan)
+}
+
+// loadDisks reads the usage of each disk and the free space it should reach
+func (c *RebalanceCmd) loadDisks() ([]*rebalanceDisk, error) {
+	var disks []*rebalanceDisk
+	var total, free int64
+	for _, path := range c.Disks {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		mount, err := utils.GetMountPoint(abs)
+		if err != nil {
+			return nil, err
+		}
+		space, err := utils.GetDiskSpace(abs)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		disks = append(disks, &rebalanceDisk{mount: abs, fs: mount, space: space})
+		total += space.Total
+		free += space.Free
+	}
+
+	var minFree int64
+	if c.MinFree != "" {
+		var err error
+		if minFree, err = utils.HumanToBytes(c.MinFree); err != nil {
+			return nil, fmt.Errorf("invalid --min-free: %s", c.MinFree)
+		}
+	}
+	for _, d := range disks {
+		if c.MinFree != "" {
+			d.target = minFree
+		} else if total > 0 {
+			d.target = int64(float64(d.space.Total) * float64(free) / float64(total))
+		}
+	}
+	return disks, nil
+}
+
+// diskOf returns the index of the disk path is on, or -1
+func diskOf(disks []*rebalanceDisk, path string) int {
+	found := -1
+	for i, d := range disks {
+		if pathWithin(path, d.mount) && (found < 0 || len(d.mount) > len(disks[found].mount)) {
+			found = i
+		}
+	}
+	return found
+}
+
+func pathWithin(path, root string) bool {
+	rest, ok := strings.CutPrefix(path, root)
+	return ok && (rest == "" || rest[0] == filepath.Separator || strings.HasSuffix(root, string(filepath.Separator)))
+}
+
+// planRebalance repeatedly moves a folder from the disk furthest below its target free
+// space to the disk furthest above it, choosing the folder that fits best. A move
+// never takes the destination below its target
+func (c *RebalanceCmd) planRebalance(ctx context.Context, disks []*rebalanceDisk) ([]rebalanceMove, error) {
+	for i, d := range disks {
+		for _, other := range disks[:i] {
+			if d.fs == other.fs {
+				return nil, fmt.Errorf("%s and %s are on the same filesystem", other.mount, d.mount)
+			}
+		}
+	}
+	flags := models.GlobalFlags{CoreFlags: c.CoreFlags}
+	flags.All = true
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return nil, err
+	}
+	byDisk := make([][]models.MediaWithDB, len(disks))
+	for _, m := range media {
+		if i := diskOf(disks, m.Path); i >= 0 && utils.FileExists(m.Path) {
+			byDisk[i] = append(byDisk[i], m)
+		}
+	}
+	for i, d := range disks {
+		d.units = c.rebalanceUnits(d.mount, byDisk[i])
+	}
+
+	free := make([]int64, len(disks))
+	for i, d := range disks {
+		free[i] = d.space.Free
+	}
+	moved := map[string]bool{}
+	exhausted := map[int]bool{}
+	var plan []rebalanceMove
+	for {
+		src, dst := -1, -1
+		for i, d := range disks {
+			if deficit := d.target - free[i]; deficit > 0 && !exhausted[i] &&
+				(src < 0 || deficit > disks[src].target-free[src]) {
+				src = i
+			}
+			if surplus := free[i] - d.target; surplus > 0 && (dst < 0 || surplus > free[dst]-disks[dst].target) {
+				dst = i
+			}
+		}
+		if src < 0 || dst < 0 {
+			break
+		}
+		deficit, surplus := disks[src].target-free[src], free[dst]-disks[dst].target
+
+		best := -1
+		for j, u := range disks[src].units {
+			// When evening out, a folder larger than twice the deficit would leave the
+			// disk further from its target than before
+			if moved[u.Path] || u.TotalSize <= 0 || u.TotalSize > surplus ||
+				(c.MinFree == "" && u.TotalSize >= 2*deficit) {
+				continue
+			}
+			if best < 0 || absInt64(deficit-u.TotalSize) < absInt64(deficit-disks[src].units[best].TotalSize) {
+				best = j
+			}
+		}
+		if best < 0 {
+			exhausted[src] = true
+			continue
+		}
+
+		u := disks[src].units[best]
+		rel, err := filepath.Rel(disks[src].mount, u.Path)
+		if err != nil {
+			return nil, err
+		}
+		moved[u.Path] = true
+		free[src] += u.TotalSize
+		free[dst] -= u.TotalSize
+		plan = append(plan, rebalanceMove{Src: u.Path, Dst: filepath.Join(disks[dst].mount, rel), Size: u.TotalSize})
+	}
+	return plan, nil
+}
+
+func absInt64(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// rebalanceUnits groups the media of a disk into the folders that move as a whole.
+// Season and disc folders are part of their series or album folder, a folder holds all
+// of its subfolders, and files directly on the disk move on their own
+func (c *RebalanceCmd) rebalanceUnits(mount string, media []models.MediaWithDB) []models.FolderStats {
+	var aggFlags models.GlobalFlags
+	if c.Depth > 0 {
+		aggFlags.Depth = pathutil.Depth(mount) + c.Depth
+	}
+	units := map[string]*models.FolderStats{}
+	add := func(path string, size int64, files ...models.MediaWithDB) {
+		u, ok := units[path]
+		if !ok {
+			u = &models.FolderStats{Path: path}
+			units[path] = u
+		}
+		u.TotalSize += size
+		u.Files = append(u.Files, files...)
+	}
+	for _, folder := range query.AggregateByDepth(media, aggFlags) {
+		path := folder.Path
+		if len(folder.Files) == 1 && folder.Files[0].Path == path {
+			continue // a file at the aggregation depth, which is in its folder's group too
+		}
+		if path == mount {
+			for _, f := range folder.Files {
+				var size int64
+				if f.Size != nil {
+					size = *f.Size
+				}
+				add(f.Path, size, f)
+			}
+			continue
+		}
+		if c.Depth == 0 {
+			for rebalanceSubfolderRegex.MatchString(filepath.Base(path)) && filepath.Dir(path) != mount {
+				path = filepath.Dir(path)
+			}
+		}
+		add(path, folder.TotalSize, folder.Files...)
+	}
+
+	// Shortest first, so that a folder is merged into an ancestor that was kept already
+	paths := sortedKeys(units)
+	slices.SortStableFunc(paths, func(a, b string) int { return cmp.Compare(len(a), len(b)) })
+	kept := map[string]*models.FolderStats{}
+	var result []models.FolderStats
+	for _, path := range paths {
+		ancestor := ""
+		for dir := filepath.Dir(path); dir != mount && pathWithin(dir, mount); dir = filepath.Dir(dir) {
+			if _, ok := kept[dir]; ok {
+				ancestor = dir
+			}
+		}
+		if ancestor != "" {
+			kept[ancestor].TotalSize += units[path].TotalSize
+			kept[ancestor].Files = append(kept[ancestor].Files, units[path].Files...)
+			continue
+		}
+		kept[path] = units[path]
+	}
+	for _, path := range sortedKeys(kept) {
+		result = append(result, *kept[path])
+	}
+	return result
+}
+
+func (c *RebalanceCmd) printPlan(disks []*rebalanceDisk, plan []rebalanceMove) {
+	after := make([]int64, len(disks))
+	for i, d := range disks {
+		after[i] = d.space.Free
+	}
+	for _, mv := range plan {
+		fmt.Printf("%s -> %s (%s)\n", mv.Src, mv.Dst, utils.FormatSize(mv.Size))
+		if i := diskOf(disks, mv.Src); i >= 0 {
+			after[i] += mv.Size
+		}
+		if i := diskOf(disks, mv.Dst); i >= 0 {
+			after[i] -= mv.Size
+		}
+	}
+
+	fmt.Println()
+	fmt.Println(strings.Join([]string{"disk", "size", "free", "free after", "target"}, "\t"))
+	for i, d := range disks {
+		fmt.Println(strings.Join([]string{
+			d.mount, utils.FormatSize(d.space.Total), utils.FormatSize(d.space.Free),
+			utils.FormatSize(after[i]), utils.FormatSize(d.target),
+		}, "\t"))
+	}
+}
+
+// applyRebalance carries out the moves, saving the ones left before each so that an
+// interrupted rebalance resumes where it stopped
+func (c *RebalanceCmd) applyRebalance(ctx context.Context, journalDB *sql.DB, plan []rebalanceMove) error {
+	var dbs []*sql.DB
+	for _, dbPath := range c.Databases {
+		sqlDB, err := db.Connect(ctx, dbPath)
+		if err != nil {
+			return err
+		}
+		defer sqlDB.Close()
+		dbs = append(dbs, sqlDB)
+	}
+
+	for i, mv := range plan {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := saveRebalanceJournal(ctx, journalDB, plan[i:]); err != nil {
+			return err
+		}
+		if err := moveRebalanceUnit(ctx, dbs, mv); err != nil {
+			return fmt.Errorf("%s: %w (run rebalance again to resume)", mv.Src, err)
+		}
+		fmt.Printf("Moved %s -> %s\n", mv.Src, mv.Dst)
+	}
+	if err := saveRebalanceJournal(ctx, journalDB, nil); err != nil {
+		return err
+	}
+
+	for i, sqlDB := range dbs {
+		if err := db.RebuildFTS(ctx, sqlDB, c.Databases[i]); err != nil {
+			return err
+		}
+		if err := db.RefreshFolderStats(ctx, sqlDB); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d folders moved\n", len(plan))
+	return nil
+}
+
+// moveRebalanceUnit moves each file of mv, including files that are not in the
+// database, by copying it, verifying the copy, renaming its rows and only then
+// removing the original. Files whose copy already arrived intact are not copied again
+func moveRebalanceUnit(ctx context.Context, dbs []*sql.DB, mv rebalanceMove) error {
+	info, err := os.Stat(mv.Src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	files := []string{mv.Src}
+	if info.IsDir() {
+		files = nil
+		err := filepath.WalkDir(mv.Src, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				files = append(files, path)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, src := range files {
+		dst := mv.Dst
+		if info.IsDir() {
+			rel, err := filepath.Rel(mv.Src, src)
+			if err != nil {
+				return err
+			}
+			dst = filepath.Join(mv.Dst, rel)
+		}
+		if utils.FileExists(dst) {
+			srcHash, err := utils.FullHashFile(src)
+			if err != nil {
+				return err
+			}
+			dstHash, err := utils.FullHashFile(dst)
+			if err != nil {
+				return err
+			}
+			if srcHash != dstHash {
+				return fmt.Errorf("%s already exists with different content", dst)
+			}
+		} else if _, err := utils.CopyFileVerified(src, dst); err != nil {
+			return err
+		}
+
+		for _, sqlDB := range dbs {
+			if err := renameMovedFile(ctx, sqlDB, src, dst); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(src); err != nil {
+			return err
+		}
+	}
+
+	if info.IsDir() {
+		removeEmptyDirs(mv.Src)
+	}
+	return nil
+}
+
+func renameMovedFile(ctx context.Context, sqlDB *sql.DB, src, dst string) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := mergeMediaRow(ctx, tx, src, dst); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeEmptyDirs removes root and the folders below it that are left empty
+func removeEmptyDirs(root string) {
+	var dirs []string
+	_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	for _, dir := range slices.Backward(dirs) {
+		_ = os.Remove(dir) // fails unless empty
+	}
+}
+
+func loadRebalanceJournal(ctx context.Context, sqlDB *sql.DB) ([]rebalanceMove, error) {
+	var value string
+	err := sqlDB.QueryRowContext(ctx, "SELECT value FROM _maintenance_meta WHERE key = ?", rebalanceJournalKey).
+		Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) || value == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var plan []rebalanceMove
+	if err := json.Unmarshal([]byte(value), &plan); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", rebalanceJournalKey, err)
+	}
+	return plan, nil
+}
+
+// saveRebalanceJournal stores the moves that are left; none clears the journal
+func saveRebalanceJournal(ctx context.Context, sqlDB *sql.DB, plan []rebalanceMove) error {
+	if len(plan) == 0 {
+		_, err := sqlDB.ExecContext(ctx, "DELETE FROM _maintenance_meta WHERE key = ?", rebalanceJournalKey)
+		return err
+	}
+	value, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+	_, err = sqlDB.ExecContext(ctx,
+		"INSERT OR REPLACE INTO _maintenance_meta (key, value, last_updated) VALUES (?, ?, ?)",
+		rebalanceJournalKey, string(value), time.Now().Unix())
+	return err
+}
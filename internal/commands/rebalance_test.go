@@ -0,0 +1,87 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+func TestRebalanceCmd_Resume(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	fixture.CreateFileTree(map[string]any{
+		"d1": map[string]any{"Show": map[string]any{
+			"Season 1":   map[string]any{"e1.mkv": "episode one"},
+			"Season 2":   map[string]any{"e2.mkv": "episode two"},
+			"poster.jpg": "poster",
+		}},
+		"d2": map[string]any{},
+	})
+	d1, d2 := filepath.Join(fixture.TempDir, "d1"), filepath.Join(fixture.TempDir, "d2")
+	e1 := filepath.Join(d1, "Show", "Season 1", "e1.mkv")
+	e2 := filepath.Join(d1, "Show", "Season 2", "e2.mkv")
+
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	sqlDB.Exec("INSERT INTO media (path, size) VALUES (?, 11), (?, 11)", e1, e2)
+	sqlDB.Exec("INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, 100, 10, 1)", e1)
+	sqlDB.Close()
+
+	run := func() error {
+		cmd := &commands.RebalanceCmd{
+			CoreFlags: models.CoreFlags{NoConfirm: true},
+			Disks:     []string{d1, d2},
+			Databases: []string{fixture.DBPath},
+		}
+		return cmd.Run(context.Background())
+	}
+	if err := run(); err == nil {
+		t.Fatal("expected an error for two disks on the same filesystem")
+	}
+
+	// An interrupted move of Show that had already copied the first episode
+	dst := filepath.Join(d2, "Show")
+	os.MkdirAll(filepath.Join(dst, "Season 1"), 0o755)
+	os.WriteFile(filepath.Join(dst, "Season 1", "e1.mkv"), []byte("episode one"), 0o644)
+	journal, _ := json.Marshal([]map[string]any{{"src": filepath.Join(d1, "Show"), "dst": dst, "size": 22}})
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	sqlDB.Exec("INSERT INTO _maintenance_meta (key, value, last_updated) VALUES ('rebalance_plan', ?, 0)",
+		string(journal))
+
+	if err := run(); err != nil {
+		t.Fatalf("resuming the rebalance failed: %v", err)
+	}
+
+	for _, rel := range []string{"Season 1/e1.mkv", "Season 2/e2.mkv", "poster.jpg"} {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(rel))); err != nil {
+			t.Errorf("expected %s on the second disk: %v", rel, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(d1, "Show")); !os.IsNotExist(err) {
+		t.Error("expected the emptied folder to be removed from the first disk")
+	}
+
+	var n int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM media WHERE path LIKE ?", dst+"%").Scan(&n)
+	if n != 2 {
+		t.Errorf("expected both media paths on the second disk, got %d", n)
+	}
+	var historyPath string
+	sqlDB.QueryRow("SELECT media_path FROM history").Scan(&historyPath)
+	if historyPath != filepath.Join(dst, "Season 1", "e1.mkv") {
+		t.Errorf("expected history to follow the file, got %q", historyPath)
+	}
+	sqlDB.QueryRow("SELECT COUNT(*) FROM _maintenance_meta WHERE key = 'rebalance_plan'").Scan(&n)
+	if n != 0 {
+		t.Error("expected the journal to be cleared")
+	}
+}
@@ -0,0 +1,282 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+	"github.com/chapmanjacobd/discoteca/internal/utils/pathutil"
+)
+
+type RemapPathsCmd struct {
+	models.CoreFlags `embed:""`
+
+	Verify bool `help:"Abort unless every remapped media file exists"`
+
+	Database  string `help:"SQLite database file"               required:"true" arg:"" type:"existingfile"`
+	OldPrefix string `help:"Path prefix to replace"             required:"true" arg:""`
+	NewPrefix string `help:"Replacement prefix (e.g. /media/b)" required:"true" arg:""`
+}
+
+type remapColumn struct{ table, column, where string }
+
+// remapColumns are the path columns rewritten besides media.path. Rows of pathTables
+// that have a media row move along with it. podcast_feeds only holds paths for
+// folder feeds
+func remapColumns() []remapColumn {
+	var columns []remapColumn
+	for _, table := range pathTables {
+		columns = append(columns, remapColumn{table, "media_path", "media_path NOT IN (SELECT path FROM media)"})
+	}
+	return append(columns,
+		remapColumn{"playlists", "path", ""},
+		remapColumn{"podcast_feeds", "value", "kind = '" + db.PodcastKindFolder + "'"},
+	)
+}
+
+// pathRemapper rewrites a path prefix. When the two prefixes use different separator
+// styles the rest of the path is converted too, so that /mnt/a/x/y can become D:\x\y
+type pathRemapper struct {
+	oldPrefix, newPrefix string
+	sep                  byte
+	convert              bool
+}
+
+func newPathRemapper(oldPrefix, newPrefix string) (pathRemapper, error) {
+	oldTrimmed := pathutil.StripTrailingSep(oldPrefix)
+	if oldTrimmed == "" {
+		return pathRemapper{}, fmt.Errorf("old prefix must not be a filesystem root: %q", oldPrefix)
+	}
+	newTrimmed := pathutil.StripTrailingSep(newPrefix)
+	if newTrimmed == "" {
+		newTrimmed = newPrefix
+	}
+	sep := pathutil.SeparatorOf(newTrimmed)
+	return pathRemapper{
+		oldPrefix: oldTrimmed,
+		newPrefix: newTrimmed,
+		sep:       sep,
+		convert:   pathutil.SeparatorOf(oldTrimmed) != sep,
+	}, nil
+}
+
+// remap returns the new path, or false when path is not under the old prefix
+func (m pathRemapper) remap(path string) (string, bool) {
+	if len(path) < len(m.oldPrefix) || path[:len(m.oldPrefix)] != m.oldPrefix {
+		return "", false
+	}
+	rest := path[len(m.oldPrefix):]
+	if rest != "" && rest[0] != '/' && rest[0] != '\\' {
+		return "", false // /mnt/a must not match /mnt/ab
+	}
+	if m.convert {
+		rest = pathutil.ToSeparator(rest, m.sep)
+	}
+	if rest != "" && pathutil.HasTrailingSep(m.newPrefix) {
+		rest = rest[1:]
+	}
+	return m.newPrefix + rest, true
+}
+
+type pathRename struct{ old, new string }
+
+// remapPlan holds the renames for each table, gathered before any is applied so that
+// a new prefix inside the old one is not remapped twice
+type remapPlan struct {
+	media   []pathRename
+	columns map[string][]pathRename // "table.column"
+}
+
+func (c *RemapPathsCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	remapper, err := newPathRemapper(c.OldPrefix, c.NewPrefix)
+	if err != nil {
+		return err
+	}
+
+	sqlDB, err := db.Connect(ctx, c.Database)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	plan, err := planRemap(ctx, sqlDB, remapper)
+	if err != nil {
+		return err
+	}
+	total := len(plan.media)
+	for _, renames := range plan.columns {
+		total += len(renames)
+	}
+	if total == 0 {
+		fmt.Printf("No paths start with %s\n", c.OldPrefix)
+		return nil
+	}
+
+	if c.Verify {
+		var missing []string
+		for _, r := range plan.media {
+			if !utils.FileExists(r.new) {
+				missing = append(missing, r.new)
+			}
+		}
+		if len(missing) > 0 {
+			for _, p := range missing[:min(len(missing), 10)] {
+				fmt.Println("Missing:", p)
+			}
+			return fmt.Errorf("%d of %d remapped media files do not exist", len(missing), len(plan.media))
+		}
+	}
+
+	if c.Simulate {
+		for _, r := range plan.media {
+			fmt.Printf("%s -> %s\n", r.old, r.new)
+		}
+		c.printSummary(plan, "would be remapped")
+		return nil
+	}
+	if !c.NoConfirm && !utils.Confirm(fmt.Sprintf("Remap %d paths in %s?", total, c.Database)) {
+		return nil
+	}
+
+	if err := applyRemap(ctx, sqlDB, c.Database, plan); err != nil {
+		return fmt.Errorf("%s: %w", c.Database, err)
+	}
+
+	c.printSummary(plan, "remapped")
+	return nil
+}
+
+func (c *RemapPathsCmd) printSummary(plan remapPlan, verb string) {
+	fmt.Printf("media: %d paths %s\n", len(plan.media), verb)
+	for _, key := range sortedKeys(plan.columns) {
+		if n := len(plan.columns[key]); n > 0 {
+			fmt.Printf("%s: %d paths %s\n", key, n, verb)
+		}
+	}
+}
+
+func planRemap(ctx context.Context, sqlDB *sql.DB, remapper pathRemapper) (remapPlan, error) {
+	plan := remapPlan{columns: map[string][]pathRename{}}
+	collect := func(query string) ([]pathRename, error) {
+		rows, err := sqlDB.QueryContext(ctx, query, remapper.oldPrefix, remapper.oldPrefix)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var renames []pathRename
+		for rows.Next() {
+			var path string
+			if err := rows.Scan(&path); err != nil {
+				return nil, err
+			}
+			if newPath, ok := remapper.remap(path); ok && newPath != path {
+				renames = append(renames, pathRename{path, newPath})
+			}
+		}
+		return renames, rows.Err()
+	}
+	prefixMatch := func(column string) string {
+		return "substr(" + column + ", 1, length(?)) = ?"
+	}
+
+	var err error
+	plan.media, err = collect("SELECT path FROM media WHERE " + prefixMatch("path"))
+	if err != nil {
+		return plan, err
+	}
+	// Deepest first, so that with a new prefix inside the old one a path is moved
+	// out of the way before another is remapped onto it
+	slices.SortFunc(plan.media, func(a, b pathRename) int { return cmp.Compare(len(b.old), len(a.old)) })
+
+	for _, rc := range remapColumns() {
+		query := fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s", rc.column, rc.table, prefixMatch(rc.column))
+		if rc.where != "" {
+			query += " AND " + rc.where
+		}
+		renames, err := collect(query)
+		if isMissingTable(err) {
+			continue
+		}
+		if err != nil {
+			return plan, err
+		}
+		plan.columns[rc.table+"."+rc.column] = renames
+	}
+	return plan, nil
+}
+
+// applyRemap renames all paths of plan and rebuilds the indexes derived from them in one
+// transaction. Media remapped onto a path that is already in the database, e.g. after
+// the new mount point was scanned, is merged into that row
+func applyRemap(ctx context.Context, sqlDB *sql.DB, dbPath string, plan remapPlan) error {
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, r := range plan.media {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM media WHERE path = ?)", r.new).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			err = mergeMediaRow(ctx, tx, r.old, r.new)
+		} else {
+			err = renameMediaPath(ctx, tx, r.old, r.new)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", r.old, err)
+		}
+	}
+
+	for key, renames := range plan.columns {
+		table, column, _ := strings.Cut(key, ".")
+		// A playlist must not replace another one; let the conflict abort the remap
+		update := "UPDATE"
+		if slices.Contains(pathTables, table) {
+			update = "UPDATE OR REPLACE"
+		}
+		for _, r := range renames {
+			_, err := tx.ExecContext(ctx,
+				fmt.Sprintf("%[1]s %[2]s SET %[3]s = ? WHERE %[3]s = ?", update, table, column), r.new, r.old)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+
+	// Captions FTS has no update trigger and folder_stats is keyed by folder path
+	if err := db.RebuildFTS(ctx, tx, dbPath); err != nil {
+		return err
+	}
+	if err := rebuildCaptionsFTS(ctx, tx); err != nil {
+		return err
+	}
+	if err := db.RefreshFolderStats(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func rebuildCaptionsFTS(ctx context.Context, tx *sql.Tx) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'captions_fts')").Scan(&exists)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO captions_fts(captions_fts) VALUES('rebuild')")
+	if err != nil {
+		return fmt.Errorf("failed to rebuild captions FTS: %w", err)
+	}
+	return nil
+}
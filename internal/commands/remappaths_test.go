@@ -0,0 +1,98 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+func TestRemapPathsCmd(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	fixture.CreateFileTree(map[string]any{"show": map[string]any{"ep1.mkv": "a"}})
+	newRoot := fixture.TempDir
+
+	const oldRoot = "/mnt/old"
+	oldPath := oldRoot + "/show/ep1.mkv"
+	newPath := filepath.Join(newRoot, "show", "ep1.mkv")
+
+	db.SetFtsEnabled(true)
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	var hasCaptions bool
+	sqlDB.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE name = 'captions_fts')").Scan(&hasCaptions)
+	sqlDB.Exec("INSERT INTO media (path, path_tokenized, title) VALUES (?, 'mnt old show ep1', 'Ep')", oldPath)
+	sqlDB.Exec("INSERT INTO media (path) VALUES ('/mnt/older/other.mkv')")
+	sqlDB.Exec("INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, 100, 10, 1)", oldPath)
+	if hasCaptions {
+		sqlDB.Exec("INSERT INTO captions (media_path, time, text) VALUES (?, 1, 'hello there')", oldPath)
+	}
+	sqlDB.Exec("INSERT INTO playlists (id, path) VALUES (1, ?)", oldRoot+"/show")
+	sqlDB.Exec("INSERT INTO playlist_items (playlist_id, media_path) VALUES (1, ?)", oldPath)
+	sqlDB.Exec("INSERT INTO podcast_feeds (token, title, kind, value) VALUES ('t', 'Show', 'folder', ?)", oldRoot+"/show")
+	sqlDB.Close()
+
+	run := func(oldPrefix, newPrefix string, verify bool) error {
+		cmd := &commands.RemapPathsCmd{
+			CoreFlags: models.CoreFlags{NoConfirm: true},
+			Verify:    verify,
+			Database:  fixture.DBPath,
+			OldPrefix: oldPrefix,
+			NewPrefix: newPrefix,
+		}
+		return cmd.Run(context.Background())
+	}
+
+	if err := run(oldRoot, "/mnt/missing", true); err == nil {
+		t.Fatal("expected --verify to fail when the remapped files do not exist")
+	}
+	if err := run(oldRoot, newRoot, true); err != nil {
+		t.Fatalf("remap failed: %v", err)
+	}
+
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	checks := []struct{ query, want string }{
+		{"SELECT path FROM media WHERE title = 'Ep'", newPath},
+		{"SELECT media_path FROM history", newPath},
+		{"SELECT media_path FROM playlist_items", newPath},
+		{"SELECT path FROM playlists", filepath.Join(newRoot, "show")},
+		{"SELECT value FROM podcast_feeds", filepath.Join(newRoot, "show")},
+		{"SELECT path FROM media WHERE title IS NULL", "/mnt/older/other.mkv"},
+		{"SELECT parent FROM folder_stats WHERE parent LIKE '%show'", filepath.Join(newRoot, "show")},
+	}
+	for _, c := range checks {
+		var got string
+		if err := sqlDB.QueryRow(c.query).Scan(&got); err != nil {
+			t.Fatalf("%s: %v", c.query, err)
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.query, got, c.want)
+		}
+	}
+
+	if hasCaptions {
+		var captionPath, ftsPath string
+		sqlDB.QueryRow("SELECT media_path FROM captions").Scan(&captionPath)
+		sqlDB.QueryRow("SELECT media_path FROM captions_fts WHERE captions_fts MATCH 'hello'").Scan(&ftsPath)
+		if captionPath != newPath || ftsPath != newPath {
+			t.Errorf("captions not remapped, got %q and FTS %q", captionPath, ftsPath)
+		}
+	}
+
+	// Separators after the prefix follow the new prefix's style
+	if err := run(newRoot, `D:\media`, false); err != nil {
+		t.Fatalf("remap to a Windows prefix failed: %v", err)
+	}
+	var winPath string
+	sqlDB.QueryRow("SELECT path FROM media WHERE title = 'Ep'").Scan(&winPath)
+	if winPath != `D:\media\show\ep1.mkv` {
+		t.Errorf("expected Windows separators, got %q", winPath)
+	}
+}
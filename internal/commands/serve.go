@@ -2,7 +2,10 @@ package commands
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"mime"
@@ -75,11 +78,24 @@ type ServeCmd struct {
 	Dev                  bool     `help:"Enable development mode (auto-reload)"`
 	ReadOnly             bool     `help:"Disable write operations (progress tracking, playlist modifications, deletions)"`
 	NoBrowser            bool     `help:"Don't open browser on startup"`
+	Schedule             bool     `help:"Rescan scan roots, refresh feeds and run database maintenance in the background"`
+	RescanHours          int      `help:"Hours between scheduled rescans of roots without their own hours_update_delay"                                              default:"24"`
+	QuietHours           string   `help:"Local hours when scheduled rescans and maintenance may run, e.g. 1-6 (default: any time)"`
+	Peer                 []string `help:"Other disco serve URLs whose libraries are merged into searches"`
+	PeerToken            []string `help:"API token for each --peer, in the same order, or one token for all peers"`
 	ApplicationStartTime int64    `                                                                                                                                                           kong:"-"`
 	APIToken             string   `                                                                                                                                                           kong:"-"`
 	thumbnailCache       sync.Map
 	dbCache              sync.Map
 	hasFfmpeg            bool
+	events               eventHub
+	remote               serverPlayer
+	jobs                 jobManager
+	schedule             scheduler
+	metrics              serverMetrics
+	peers                peerSet
+	offline              offlineCache
+	podcastItems         podcastItemCache
 }
 
 // authMiddleware validates API token for authenticated endpoints
@@ -92,6 +108,10 @@ func (c *ServeCmd) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 				token = cookie.Value
 			}
 		}
+		if token == "" {
+			// Prometheus and other scrapers send the token as a bearer credential
+			token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
 
 		if token != c.APIToken {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
@@ -101,6 +121,44 @@ func (c *ServeCmd) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// mediaAuthMiddleware is authMiddleware for the stream routes. Cast receivers can't
+// send headers or cookies, so a URL signed for one path by signMediaURL also works
+func (c *ServeCmd) mediaAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	authed := c.authMiddleware(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("sig") {
+			if !c.validMediaSignature(r.URL.Query()) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+		authed(w, r)
+	}
+}
+
+// signMediaURL returns query parameters that grant read access to one path until ttl passes
+func (c *ServeCmd) signMediaURL(path string, ttl time.Duration) url.Values {
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	return url.Values{"path": {path}, "expires": {expires}, "sig": {c.mediaSignature(path, expires)}}
+}
+
+func (c *ServeCmd) validMediaSignature(q url.Values) bool {
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	want := c.mediaSignature(q.Get("path"), q.Get("expires"))
+	return hmac.Equal([]byte(q.Get("sig")), []byte(want))
+}
+
+func (c *ServeCmd) mediaSignature(path, expires string) string {
+	mac := hmac.New(sha256.New, []byte(c.APIToken))
+	mac.Write([]byte(path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
 // isPathBlocklisted checks if a path should be denied access
 func (c *ServeCmd) isPathBlocklisted(path string) bool {
 	// Normalize path separators to forward slashes for consistent matching
@@ -149,8 +207,19 @@ func (c *ServeCmd) registerAPIRoutes(mux *http.ServeMux) {
 		{"/api/languages", c.HandleLanguages},
 		{"/api/ratings", c.HandleRatings},
 		{"/api/query", c.HandleQuery},
+		{"/api/exists", c.HandleExists},
 		{"/api/metadata", c.HandleMetadata},
+		{"/api/media", c.HandleMediaUpdate},
 		{"/api/play", c.HandlePlay},
+		{"/api/player/{action}", c.HandlePlayer},
+		{"/api/jobs", c.HandleJobs},
+		{"/api/jobs/{id}", c.HandleJob},
+		{"/api/jobs/{id}/cancel", c.HandleJobCancel},
+		{"/api/jobs/{id}/log", c.HandleJobLog},
+		{"/api/schedule", c.HandleSchedule},
+		{"/metrics", c.HandleMetrics},
+		{"/api/sync", c.HandleSync},
+		{"/api/sync/changes", c.HandleSyncChanges},
 		{"/api/delete", c.HandleDelete},
 		{"/api/progress", c.HandleProgress},
 		{"/api/mark-played", c.HandleMarkPlayed},
@@ -170,17 +239,13 @@ func (c *ServeCmd) registerAPIRoutes(mux *http.ServeMux) {
 		{"/api/categorize/keywords", c.HandleCategorizeKeywords},
 		{"/api/categorize/category", c.HandleCategorizeDeleteCategory},
 		{"/api/categorize/keyword", c.HandleCategorizeKeyword},
-		{"/api/raw", c.HandleRaw},
 		{"/api/queries", c.HandleQueries},
 		{"/api/zim/view", c.HandleZimView},
 		{"/api/zim/proxy/{port}/{rest...}", c.HandleZimProxy},
-		{"/api/rsvp", c.HandleRSVP},
+		{"/api/rsvp", c.peerStream(c.HandleRSVP)},
 		{"/api/epub/{path...}", c.HandleEpubConvert},
-		{"/api/hls/playlist", c.HandleHLSPlaylist},
-		{"/api/hls/segment", c.HandleHLSSegment},
-		{"/api/subtitles", c.HandleSubtitles},
-		{"/api/thumbnail", c.HandleThumbnail},
 		{"/opds", c.HandleOPDS},
+		{"/api/podcasts", c.HandlePodcasts},
 		{"/api/trash", c.HandleTrash},
 		{"/api/empty-bin", c.HandleEmptyBin},
 	}
@@ -188,6 +253,23 @@ func (c *ServeCmd) registerAPIRoutes(mux *http.ServeMux) {
 	for _, route := range apiRoutes {
 		mux.HandleFunc(route.pattern, c.authMiddleware(route.handler))
 	}
+
+	// Routes a cast receiver fetches, which also accept a signed URL
+	mediaRoutes := []struct {
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{"/api/raw", c.peerStream(c.HandleRaw)},
+		{"/api/hls/playlist", c.peerStream(c.HandleHLSPlaylist)},
+		{"/api/hls/segment", c.peerStream(c.HandleHLSSegment)},
+		{"/api/subtitles", c.peerStream(c.HandleSubtitles)},
+		{"/api/thumbnail", c.peerStream(c.HandleThumbnail)},
+	}
+	for _, route := range mediaRoutes {
+		mux.HandleFunc(route.pattern, c.mediaAuthMiddleware(route.handler))
+	}
+
+	c.registerPodcastRoutes(mux)
 }
 
 // newLibHandler creates a handler for library static assets
@@ -268,7 +350,7 @@ func (c *ServeCmd) Mux() http.Handler {
 	mux.HandleFunc("/lib/", c.newLibHandler())
 	mux.Handle("/", c.newStaticHandler())
 
-	return mux
+	return c.instrument(mux)
 }
 
 // getOrCreateDBConn retrieves a cached database connection or creates a new one
@@ -341,7 +423,9 @@ func (c *ServeCmd) execDB(ctx context.Context, dbPath string, fn func(ctx contex
 			return err
 		}
 
+		start := time.Now()
 		err = fn(ctx, sqlDB)
+		observeQuery(dbPath, time.Since(start))
 		if err != nil {
 			if shouldRetry, _ := c.handleCorruptionError(ctx, dbPath, sqlDB, err, false); shouldRetry {
 				continue
@@ -358,6 +442,10 @@ func (c *ServeCmd) execDB(ctx context.Context, dbPath string, fn func(ctx contex
 
 // Close closes all cached database connections
 func (c *ServeCmd) Close() error {
+	c.remote.close()
+	c.events.close()
+	c.schedule.close()
+	c.jobs.close()
 	var errs []error
 	c.dbCache.Range(func(key, value any) bool {
 		if sqlDB, ok := value.(*sql.DB); ok {
@@ -378,8 +466,9 @@ func (c *ServeCmd) Close() error {
 // initDatabases connects to all databases and caches the connections
 func (c *ServeCmd) initDatabases(ctx context.Context) {
 	for _, dbPath := range c.Databases {
-		sqlDB, _, err := db.ConnectWithInit(ctx, dbPath)
+		sqlDB, queries, err := db.ConnectWithInit(ctx, dbPath)
 		if err == nil {
+			backfillVolumes(ctx, queries)
 			_ = sqlDB.Close()
 		}
 	}
@@ -465,6 +554,14 @@ func (c *ServeCmd) openBrowser(ctx context.Context, baseURL string) {
 func (c *ServeCmd) Run(ctx context.Context) error {
 	defer c.Close()
 	models.SetupLogging(c.Verbose)
+	if c.QuietHours != "" {
+		if _, _, err := parseQuietHours(c.QuietHours); err != nil {
+			return err
+		}
+	}
+	if _, err := c.peerServers(); err != nil {
+		return err
+	}
 	db.InitFtsConfig()
 	db.SetFtsEnabled(true)
 
@@ -492,6 +589,13 @@ func (c *ServeCmd) Run(ctx context.Context) error {
 	// Check for ffmpeg
 	c.checkFfmpeg()
 
+	// Resume background jobs interrupted by the last shutdown
+	c.startJobs()
+
+	if c.Schedule && !c.ReadOnly {
+		c.startScheduler()
+	}
+
 	handler := c.Mux()
 
 	addr := fmt.Sprintf(":%d", c.Port)
@@ -787,6 +891,15 @@ func (c *ServeCmd) parseMediaFlags(flags *models.GlobalFlags, q url.Values) {
 	if q.Get("captions") == "true" || q.Get("view") == "captions" {
 		flags.WithCaptions = true
 	}
+	if q.Get("integrity") == "failed" {
+		flags.IntegrityFailed = true
+	}
+	if corrupt := q["corrupt"]; len(corrupt) > 0 {
+		flags.Corrupt = corrupt
+	}
+	if q.Get("unchecked") == "true" {
+		flags.Unchecked = true
+	}
 	if watched := q.Get("watched"); watched == "true" {
 		w := true
 		flags.Watched = &w
@@ -799,7 +912,7 @@ func (c *ServeCmd) parseMediaFlags(flags *models.GlobalFlags, q url.Values) {
 	}
 }
 
-// parseStatusFlags extracts status-related flags (trash, all, episodes, group_by)
+// parseStatusFlags extracts status-related flags (trash, offline, all, episodes, group_by)
 func (c *ServeCmd) parseStatusFlags(flags *models.GlobalFlags, q url.Values) {
 	if all := q.Get("all"); all == "true" {
 		flags.All = true
@@ -808,6 +921,14 @@ func (c *ServeCmd) parseStatusFlags(flags *models.GlobalFlags, q url.Values) {
 		flags.OnlyDeleted = true
 		flags.HideDeleted = false
 	}
+	switch q.Get("offline") {
+	case "only":
+		flags.OnlyOffline = true
+		flags.HideOffline = false
+	case "hide":
+		flags.HideOffline = true
+		flags.OnlyOffline = false
+	}
 	if episodes := q["episodes"]; len(episodes) > 0 {
 		flags.FileCounts = strings.Join(episodes, ",")
 	} else if episodes := q.Get("episodes"); episodes != "" {
@@ -827,8 +948,12 @@ func (c *ServeCmd) parseDatabaseFlags(flags *models.GlobalFlags, q url.Values) {
 
 // ParseFlags extracts query parameters into GlobalFlags
 func (c *ServeCmd) ParseFlags(r *http.Request) models.GlobalFlags {
+	return c.parseFlagValues(r.URL.Query())
+}
+
+// parseFlagValues extracts already-decoded query parameters into GlobalFlags
+func (c *ServeCmd) parseFlagValues(q url.Values) models.GlobalFlags {
 	flags := c.GetGlobalFlags()
-	q := r.URL.Query()
 
 	c.parseSearchFlags(&flags, q)
 	c.parseCategoryFlags(&flags, q)
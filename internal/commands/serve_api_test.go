@@ -70,6 +70,23 @@ func TestServeAPI_Query(t *testing.T) {
 			t.Errorf("Expected 401, got %d", w.Code)
 		}
 	})
+
+	t.Run("QueryToken", func(t *testing.T) {
+		// Only signed media URLs authenticate through the query string
+		req := httptest.NewRequest(http.MethodGet, "/api/query?token="+cmd.APIToken, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for ?token=, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/raw?path=/tmp/test.mp4&expires=9999999999&sig=forged", nil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for a forged signature, got %d", w.Code)
+		}
+	})
 }
 
 func TestServeAPI_Metadata(t *testing.T) {
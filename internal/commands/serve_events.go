@@ -0,0 +1,284 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// Event types sent over /api/events
+const (
+	eventMedia      = "media"
+	eventPlaylist   = "playlist"
+	eventCategories = "categories"
+	eventDatabase   = "database"
+	eventPlayer     = "player"
+	eventJob        = "job"
+	// eventResync tells a reconnecting client that events were lost and it should reload
+	eventResync = "resync"
+)
+
+// eventHistorySize is how many events are kept for Last-Event-ID replay
+const eventHistorySize = 256
+
+// dataVersionInterval is how often databases are polled for writes by other processes
+var dataVersionInterval = time.Second
+
+// serverEvent is pushed to browsers over /api/events. Its SSE id is Epoch-ID, so an
+// ID from an earlier run of the server is never mistaken for one of this run
+type serverEvent struct {
+	Epoch string
+	ID    uint64
+	Type  string
+	Data  any
+}
+
+// mediaChange is the payload of media events
+type mediaChange struct {
+	Action    string   `json:"action"` // deleted, restored, rated, progress, played, unplayed, tagged
+	Path      string   `json:"path"`
+	Score     *float64 `json:"score,omitempty"`
+	Playhead  *int64   `json:"playhead,omitempty"`
+	Completed bool     `json:"completed,omitempty"`
+}
+
+// playlistChange is the payload of playlist events
+type playlistChange struct {
+	Action   string `json:"action"` // created, deleted, added, removed, reordered
+	Playlist string `json:"playlist"`
+	Path     string `json:"path,omitempty"`
+}
+
+// eventHub fans events out to /api/events subscribers and keeps recent events
+// for clients that reconnect. Slow clients miss events rather than blocking publishers
+type eventHub struct {
+	mu      sync.Mutex
+	subs    map[chan serverEvent]struct{}
+	epoch   string
+	lastID  uint64
+	history []serverEvent
+
+	// lastWrite is when this server last changed a database, in Unix nanoseconds
+	lastWrite atomic.Int64
+
+	watchOnce sync.Once
+	stopWatch context.CancelFunc
+}
+
+// subscribe registers a listener and returns the events published after lastID.
+// complete is false when some of those events are no longer kept, or when lastID
+// was issued by an earlier run of the server
+func (h *eventHub) subscribe(lastID string) (events <-chan serverEvent, missed []serverEvent, complete bool, unsubscribe func()) {
+	ch := make(chan serverEvent, 32)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan serverEvent]struct{})
+	}
+	h.subs[ch] = struct{}{}
+
+	complete = true
+	if lastID != "" {
+		epoch, seq, _ := strings.Cut(lastID, "-")
+		id, err := strconv.ParseUint(seq, 10, 64)
+		complete = err == nil && epoch == h.bootEpoch() && id <= h.lastID &&
+			(len(h.history) == 0 || id+1 >= h.history[0].ID)
+		if complete {
+			for _, ev := range h.history {
+				if ev.ID > id {
+					missed = append(missed, ev)
+				}
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	return ch, missed, complete, func() {
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+	}
+}
+
+func (h *eventHub) publish(eventType string, data any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	ev := serverEvent{Epoch: h.bootEpoch(), ID: h.lastID, Type: eventType, Data: data}
+	h.history = append(h.history, ev)
+	if len(h.history) > eventHistorySize {
+		h.history = slices.Delete(h.history, 0, len(h.history)-eventHistorySize)
+	}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// bootEpoch names this run of the server in event IDs. h.mu must be held
+func (h *eventHub) bootEpoch() string {
+	if h.epoch == "" {
+		h.epoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return h.epoch
+}
+
+// encode renders the event in text/event-stream format
+func (ev serverEvent) encode() ([]byte, error) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(ev.Type)+len(data)+32)
+	if ev.ID > 0 {
+		out = append(out, "id: "...)
+		out = append(out, ev.Epoch...)
+		out = append(out, '-')
+		out = strconv.AppendUint(out, ev.ID, 10)
+		out = append(out, '\n')
+	}
+	out = append(out, "event: "...)
+	out = append(out, ev.Type...)
+	out = append(out, "\ndata: "...)
+	out = append(out, data...)
+	return append(out, "\n\n"...), nil
+}
+
+// notifyChange publishes an event for a write this server made, so the database
+// watcher does not report it a second time
+func (c *ServeCmd) notifyChange(eventType string, data any) {
+	c.events.lastWrite.Store(time.Now().UnixNano())
+	c.events.publish(eventType, data)
+}
+
+// startDatabaseWatcher begins polling for outside writes and players once the first client listens
+func (c *ServeCmd) startDatabaseWatcher() {
+	c.events.watchOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.events.mu.Lock()
+		c.events.stopWatch = cancel
+		c.events.mu.Unlock()
+		go c.watchDatabases(ctx)
+		go c.watchExternalPlayer(ctx)
+	})
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.stopWatch != nil {
+		h.stopWatch()
+	}
+}
+
+// eventFilter parses ?types=media,playlist; nil accepts every event
+func eventFilter(r *http.Request) map[string]bool {
+	types := r.URL.Query().Get("types")
+	if types == "" {
+		return nil
+	}
+	filter := map[string]bool{eventResync: true}
+	for t := range strings.SplitSeq(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			filter[t] = true
+		}
+	}
+	return filter
+}
+
+// lastEventID reads the Last-Event-ID header browsers send on reconnect, or
+// ?last_event_id= for the first connection of a reloaded page
+func lastEventID(r *http.Request) string {
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		return v
+	}
+	return r.URL.Query().Get("last_event_id")
+}
+
+// watchDatabases publishes a database event when another process, such as a
+// concurrent disco add, writes to one of the served databases
+func (c *ServeCmd) watchDatabases(ctx context.Context) {
+	type watched struct {
+		db      *sql.DB
+		conn    *sql.Conn
+		version int64
+	}
+	dbs := map[string]*watched{}
+	defer func() {
+		for _, w := range dbs {
+			_ = w.conn.Close()
+			_ = w.db.Close()
+		}
+	}()
+
+	// data_version only changes for commits made on other connections,
+	// so each database keeps one connection for the life of the watcher
+	for _, dbPath := range c.Databases {
+		sqlDB, err := db.Connect(ctx, dbPath)
+		if err != nil {
+			models.Log.Warn("Not watching database for changes", "db", dbPath, "error", err)
+			continue
+		}
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			_ = sqlDB.Close()
+			models.Log.Warn("Not watching database for changes", "db", dbPath, "error", err)
+			continue
+		}
+		w := &watched{db: sqlDB, conn: conn}
+		if err := conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&w.version); err != nil {
+			_ = conn.Close()
+			_ = sqlDB.Close()
+			models.Log.Warn("Not watching database for changes", "db", dbPath, "error", err)
+			continue
+		}
+		dbs[dbPath] = w
+	}
+	if len(dbs) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(dataVersionInterval)
+	defer ticker.Stop()
+	lastPoll := time.Now().UnixNano()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Changes since the last poll may be this server's own writes, which were already
+		// announced. An outside write in the same interval is not reported separately
+		ownWrites := c.events.lastWrite.Load() >= lastPoll
+		lastPoll = time.Now().UnixNano()
+
+		for dbPath, w := range dbs {
+			var version int64
+			if err := w.conn.QueryRowContext(ctx, "PRAGMA data_version").Scan(&version); err != nil {
+				if ctx.Err() == nil {
+					models.Log.Debug("Failed to poll database for changes", "db", dbPath, "error", err)
+				}
+				continue
+			}
+			if version == w.version {
+				continue
+			}
+			w.version = version
+			if !ownWrites {
+				c.events.publish(eventDatabase, map[string]string{"database": dbPath})
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package commands_test
+
+import (
+	"bufio"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type sseEvent struct {
+	ID, Type, Data string
+}
+
+// openEventStream connects to /api/events and returns received events on a channel
+func openEventStream(t *testing.T, server *httptest.Server, token, query, lastID string) <-chan sseEvent {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/events?"+query, nil)
+	req.Header.Set("X-Disco-Token", token)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+
+	reader := bufio.NewReader(resp.Body)
+	// The first line is sent once the subscription exists
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ":") {
+		t.Fatalf("Expected keep-alive comment, got %q %v", line, err)
+	}
+
+	events := make(chan sseEvent, 16)
+	go func() {
+		var ev sseEvent
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if ev.Type != "" {
+					events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.ID = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.Type = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.Data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent, timeout time.Duration) sseEvent {
+	t.Helper()
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(timeout):
+		t.Fatal("Timed out waiting for an event")
+		return sseEvent{}
+	}
+}
+
+// postJSON sends a write the events tests expect to succeed
+func postJSON(t *testing.T, server *httptest.Server, token, path, body string) {
+	t.Helper()
+	if code, resp := serveRequest(t, server, token, http.MethodPost, path, body); code >= 300 {
+		t.Fatalf("POST %s: %d %s", path, code, resp)
+	}
+}
+
+// eventSeq is the per-run sequence number of an event ID
+func eventSeq(id string) string {
+	_, seq, _ := strings.Cut(id, "-")
+	return seq
+}
+
+func seedEventsDB(sqlDB *sql.DB) {
+	sqlDB.Exec(`INSERT INTO media (path, title, media_type, time_deleted) VALUES ('/m/a.mp4', 'A', 'video', 0)`)
+}
+
+func TestServeEvents_WritePaths(t *testing.T) {
+	cmd, server, _ := setupServeServer(t, seedEventsDB)
+	all := openEventStream(t, server, cmd.APIToken, "", "")
+	playlists := openEventStream(t, server, cmd.APIToken, "types=playlist", "")
+
+	postJSON(t, server, cmd.APIToken, "/api/rate", `{"path": "/m/a.mp4", "score": 4}`)
+	postJSON(t, server, cmd.APIToken, "/api/playlists", `{"title": "Mix"}`)
+	postJSON(t, server, cmd.APIToken, "/api/delete", `{"path": "/m/a.mp4"}`)
+
+	ev := nextEvent(t, all, 5*time.Second)
+	if ev.Type != "media" || eventSeq(ev.ID) != "1" || ev.Data != `{"action":"rated","path":"/m/a.mp4","score":4}` {
+		t.Errorf("Unexpected first event: %+v", ev)
+	}
+	ev = nextEvent(t, all, 5*time.Second)
+	if ev.Type != "playlist" || !strings.Contains(ev.Data, `"action":"created"`) {
+		t.Errorf("Unexpected second event: %+v", ev)
+	}
+	ev = nextEvent(t, all, 5*time.Second)
+	if ev.Type != "media" || eventSeq(ev.ID) != "3" || !strings.Contains(ev.Data, `"action":"deleted"`) {
+		t.Errorf("Unexpected third event: %+v", ev)
+	}
+
+	// The filtered client only sees the playlist change
+	ev = nextEvent(t, playlists, 5*time.Second)
+	if ev.Type != "playlist" || eventSeq(ev.ID) != "2" {
+		t.Errorf("Unexpected filtered event: %+v", ev)
+	}
+	select {
+	case ev := <-playlists:
+		t.Errorf("Filtered client got %+v", ev)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestServeEvents_LastEventIDReplay(t *testing.T) {
+	cmd, server, _ := setupServeServer(t, seedEventsDB)
+	postJSON(t, server, cmd.APIToken, "/api/rate", `{"path": "/m/a.mp4", "score": 1}`)
+	postJSON(t, server, cmd.APIToken, "/api/rate", `{"path": "/m/a.mp4", "score": 2}`)
+	postJSON(t, server, cmd.APIToken, "/api/mark-played", `{"path": "/m/a.mp4"}`)
+
+	// A live event shows the epoch of this run of the server
+	all := openEventStream(t, server, cmd.APIToken, "", "")
+	postJSON(t, server, cmd.APIToken, "/api/mark-unplayed", `{"path": "/m/a.mp4"}`)
+	epoch, _, _ := strings.Cut(nextEvent(t, all, 5*time.Second).ID, "-")
+
+	replayed := openEventStream(t, server, cmd.APIToken, "", epoch+"-1")
+	for _, want := range []string{"2", "3", "4"} {
+		if ev := nextEvent(t, replayed, 5*time.Second); ev.ID != epoch+"-"+want {
+			t.Errorf("Expected replay of event %s, got %+v", want, ev)
+		}
+	}
+
+	// The same sequence number from an earlier run of the server is not replayed
+	stale := openEventStream(t, server, cmd.APIToken, "", "0-1")
+	if ev := nextEvent(t, stale, 5*time.Second); ev.Type != "resync" {
+		t.Errorf("Expected resync, got %+v", ev)
+	}
+}
+
+func TestServeEvents_OutsideWriter(t *testing.T) {
+	cmd, server, dbPath := setupServeServer(t, seedEventsDB)
+	events := openEventStream(t, server, cmd.APIToken, "types=database", "")
+
+	// Writes made through the server were already announced
+	time.Sleep(200 * time.Millisecond)
+	postJSON(t, server, cmd.APIToken, "/api/rate", `{"path": "/m/a.mp4", "score": 5}`)
+	select {
+	case ev := <-events:
+		t.Errorf("Own write reported as outside change: %+v", ev)
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	other, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err := other.Exec(`INSERT INTO media (path, title, media_type, time_deleted) VALUES ('/m/b.mp4', 'B', 'video', 0)`); err != nil {
+		t.Fatal(err)
+	}
+
+	ev := nextEvent(t, events, 5*time.Second)
+	if ev.Type != "database" || !strings.Contains(ev.Data, "serve.db") {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+}
@@ -514,6 +514,9 @@ func (c *ServeCmd) HandleCategorizeApply(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
+	if count > 0 {
+		c.notifyChange(eventCategories, map[string]any{"action": "applied", "count": count})
+	}
 	w.Header().Set("Content-Type", "application/json")
 	fmt.Fprintf(w, `{"count": %d}`, count)
 }
@@ -574,9 +577,8 @@ func (c *ServeCmd) HandleRaw(w http.ResponseWriter, r *http.Request) {
 
 	isLocal := utils.FileExists(localPath)
 	if !isLocal {
-		models.Log.Warn("File not found on disk, marking as deleted in databases", "path", path)
-		c.markDeletedInAllDBs(r.Context(), path, true)
-		http.Error(w, "File not found", http.StatusNotFound)
+		code, msg := c.fileMissing(r.Context(), path)
+		http.Error(w, msg, code)
 		return
 	}
 
@@ -823,6 +825,7 @@ func (c *ServeCmd) handlePostPlaylist(w http.ResponseWriter, r *http.Request) {
 			models.Log.Error("Failed to insert playlist", "db", dbPath, "title", req.Title, "error", err)
 		}
 	}
+	c.notifyChange(eventPlaylist, playlistChange{Action: "created", Playlist: req.Title})
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -857,6 +860,7 @@ func (c *ServeCmd) handleDeletePlaylist(w http.ResponseWriter, r *http.Request)
 			models.Log.Error("Failed to delete playlist", "db", dbPath, "title", title, "error", err)
 		}
 	}
+	c.notifyChange(eventPlaylist, playlistChange{Action: "deleted", Playlist: title})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1051,6 +1055,7 @@ func (c *ServeCmd) handlePostPlaylistItem(w http.ResponseWriter, r *http.Request
 			models.Log.Error("Failed to insert playlist item", "db", dbPath, "title", req.PlaylistTitle, "error", err)
 		}
 	}
+	c.notifyChange(eventPlaylist, playlistChange{Action: "added", Playlist: req.PlaylistTitle, Path: req.MediaPath})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1108,6 +1113,7 @@ func (c *ServeCmd) handleDeletePlaylistItem(w http.ResponseWriter, r *http.Reque
 			)
 		}
 	}
+	c.notifyChange(eventPlaylist, playlistChange{Action: "removed", Playlist: req.PlaylistTitle, Path: req.MediaPath})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -1275,6 +1281,7 @@ func (c *ServeCmd) streamRSVPVideo(
 
 	models.Log.Info("Starting RSVP stream", "wpm", 250, "duration", duration)
 
+	defer c.trackFfmpeg("rsvp")()
 	cmd := exec.CommandContext(r.Context(), "ffmpeg", args...)
 	cmd.Stdout = w
 
@@ -54,6 +54,9 @@ func (c *ServeCmd) prepareFilterFlags(flags models.GlobalFlags, filterToIgnore s
 	case "downloaded":
 		tempFlags.DownloadedAfter = ""
 		tempFlags.DownloadedBefore = ""
+	case "damaged":
+		tempFlags.Corrupt = nil
+		tempFlags.Unchecked = false
 	}
 	return tempFlags
 }
@@ -365,6 +368,9 @@ func (c *ServeCmd) HandleFilterBins(w http.ResponseWriter, r *http.Request) {
 	typeData := c.computeFilterBinsData(r.Context(), flags, "media_type", dbs)
 	resp.MediaType = buildTypeBins(typeData.typeCounts)
 
+	// Get media-check data - keep as bins like media types
+	resp.Damaged = buildTypeBins(c.computeDamagedCounts(r.Context(), flags, dbs))
+
 	// Log query info for debugging
 	models.Log.Info("FilterBins computed",
 		"episodesOnly", episodesOnly,
@@ -378,6 +384,12 @@ func (c *ServeCmd) HandleFilterBins(w http.ResponseWriter, r *http.Request) {
 		"durationCount", len(durData.durations),
 		"parentCount", len(epData.parentCounts))
 
+	if peers := c.federatedPeers(r); len(peers) > 0 {
+		if parts := c.fetchPeerFilterBins(r, peers); len(parts) > 0 {
+			resp = *mergeFilterBins(append(parts, &resp))
+		}
+	}
+
 	sendJSON(w, http.StatusOK, resp)
 }
 
@@ -424,6 +436,7 @@ func (c *ServeCmd) calculateFilterCountsOptimized(
 
 	typeData := c.computeFilterBinsDataOptimized(ctx, flags, "media_type", dbs)
 	resp.MediaType = buildTypeBins(typeData.typeCounts)
+	resp.Damaged = buildTypeBins(c.computeDamagedCounts(ctx, flags, dbs))
 
 	return resp
 }
@@ -520,6 +533,65 @@ func (c *ServeCmd) fetchTypeCounts(ctx context.Context, sqlDB *sql.DB, allTypeCo
 	}
 }
 
+// damagedLabel buckets media by their saved media-check result
+const damagedLabel = `CASE WHEN mc.media_path IS NULL THEN 'unchecked'
+	WHEN ROUND(mc.corruption * 10000) > 0 THEN 'damaged' ELSE 'ok' END`
+
+// computeDamagedCounts counts the media matching flags, other than the media-check
+// filters, by their media-check result
+func (c *ServeCmd) computeDamagedCounts(ctx context.Context, flags models.GlobalFlags, dbs []string) map[string]int64 {
+	tempFlags := c.prepareFilterFlags(flags, "damaged")
+	// Sorting doesn't change the counts
+	tempFlags.SortBy = ""
+	tempFlags.Random = false
+	fb := query.NewFilterBuilder(tempFlags)
+	sqlQuery, args := fb.BuildSelect(ctx, "path")
+	countQuery := fmt.Sprintf(`SELECT %s AS label, COUNT(*)
+		FROM (%s) q LEFT JOIN media_checks mc ON mc.media_path = q.path
+		GROUP BY label`, damagedLabel, sqlQuery)
+
+	var mu sync.Mutex
+	counts := make(map[string]int64)
+	var wg sync.WaitGroup
+	for _, dbPath := range dbs {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			_ = c.execDB(ctx, path, func(ctx context.Context, sqlDB *sql.DB) error {
+				return scanLabelCounts(ctx, sqlDB, countQuery, args, counts, &mu)
+			})
+		}(dbPath)
+	}
+	wg.Wait()
+	return counts
+}
+
+func scanLabelCounts(
+	ctx context.Context,
+	sqlDB *sql.DB,
+	sqlQuery string,
+	args []any,
+	counts map[string]int64,
+	mu *sync.Mutex,
+) error {
+	rows, err := sqlDB.QueryContext(ctx, sqlQuery, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var label string
+		var cnt int64
+		if err := rows.Scan(&label, &cnt); err != nil {
+			return err
+		}
+		mu.Lock()
+		counts[label] += cnt
+		mu.Unlock()
+	}
+	return rows.Err()
+}
+
 type histogramData struct {
 	sizes      []int64
 	durations  []int64
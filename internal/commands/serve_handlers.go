@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
@@ -272,7 +271,13 @@ func (c *ServeCmd) handleQueryMediaGrid(
 	dbs []string,
 ) {
 	q := r.URL.Query()
-	media, err := query.MediaQuery(ctx, dbs, flags)
+	peers := c.federatedPeers(r)
+	localFlags := flags
+	if len(peers) > 0 && !flags.All && flags.Limit > 0 {
+		localFlags.Limit += localFlags.Offset
+		localFlags.Offset = 0
+	}
+	media, err := query.MediaQuery(ctx, dbs, localFlags)
 	if err != nil {
 		models.Log.Error("Query failed", "dbs", dbs, "error", err)
 		sendError(w, http.StatusInternalServerError, "Query failed: "+err.Error())
@@ -304,6 +309,13 @@ func (c *ServeCmd) handleQueryMediaGrid(
 	}
 
 	c.sortMediaIfNeeded(ctx, media, flags, dbs)
+	c.markOffline(ctx, media)
+
+	if len(peers) > 0 {
+		var peerTotal int64
+		media, peerTotal, filterCounts = c.mergePeerQuery(ctx, r, peers, flags, media, filterCounts)
+		totalCount += peerTotal
+	}
 
 	w.Header().Set("X-Total-Count", strconv.FormatInt(totalCount, 10))
 	w.Header().Set("Content-Type", "application/json")
@@ -422,18 +434,14 @@ func (c *ServeCmd) HandlePlay(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !strings.HasPrefix(req.Path, "http") && !utils.FileExists(req.Path) {
-		models.Log.Warn("File not found, marking as deleted in databases", "path", req.Path)
-		c.markDeletedInAllDBs(r.Context(), req.Path, true)
-		sendError(w, http.StatusNotFound, "File not found")
+		code, msg := c.fileMissing(r.Context(), req.Path)
+		sendError(w, code, msg)
 		return
 	}
 
 	// Trigger local playback
-	models.Log.Info("Playing", "path", req.Path)
-	cmd := exec.CommandContext(r.Context(), "mpv", req.Path)
-	// We run it in background and don't wait for it
-	if err := cmd.Start(); err != nil {
-		models.Log.Error("Failed to start mpv", "error", err)
+	if _, err := c.startPlayer(r.Context(), req.Path); err != nil {
+		models.Log.Error("Failed to start player", "error", err)
 		sendError(w, http.StatusInternalServerError, "Failed to start playback: "+err.Error())
 		return
 	}
@@ -462,6 +470,12 @@ func (c *ServeCmd) markDeletedInAllDBs(ctx context.Context, path string, deleted
 			models.Log.Error("Failed to mark file as deleted", "db", dbPath, "path", path, "error", err)
 		}
 	}
+
+	action := "restored"
+	if deleted {
+		action = "deleted"
+	}
+	c.notifyChange(eventMedia, mediaChange{Action: action, Path: path})
 }
 
 // HandleDelete marks a file as deleted or restores it in all databases.
@@ -533,6 +547,12 @@ func (c *ServeCmd) HandleProgress(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	c.notifyChange(eventMedia, mediaChange{
+		Action:    "progress",
+		Path:      req.Path,
+		Playhead:  &req.Playhead,
+		Completed: req.Completed,
+	})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -575,6 +595,7 @@ func (c *ServeCmd) HandleMarkUnplayed(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	c.notifyChange(eventMedia, mediaChange{Action: "unplayed", Path: req.Path})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -619,6 +640,7 @@ func (c *ServeCmd) HandleMarkPlayed(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	c.notifyChange(eventMedia, mediaChange{Action: "played", Path: req.Path})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -661,6 +683,7 @@ func (c *ServeCmd) HandleRate(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	c.notifyChange(eventMedia, mediaChange{Action: "rated", Path: req.Path, Score: &req.Score})
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -684,11 +707,45 @@ func (c *ServeCmd) HandleEvents(w http.ResponseWriter, r *http.Request) {
 		flusher.Flush()
 	}
 
+	c.startDatabaseWatcher()
+	filter := eventFilter(r)
+	events, missed, complete, unsubscribe := c.events.subscribe(lastEventID(r))
+	defer unsubscribe()
+
+	send := func(ev serverEvent) bool {
+		if filter != nil && !filter[ev.Type] {
+			return true
+		}
+		msg, err := ev.encode()
+		if err != nil {
+			models.Log.Error("Failed to encode event", "type", ev.Type, "error", err)
+			return true
+		}
+		if _, err := w.Write(msg); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+
+	if !complete {
+		send(serverEvent{Type: eventResync, Data: map[string]any{}})
+	}
+	for _, ev := range missed {
+		if !send(ev) {
+			return
+		}
+	}
+
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case ev := <-events:
+			if !send(ev) {
+				return
+			}
 		case <-ticker.C:
 			fmt.Fprintf(w, ": heartbeat\n\n")
 			flusher.Flush()
@@ -1054,12 +1111,16 @@ func (c *ServeCmd) HandleDU(w http.ResponseWriter, r *http.Request) {
 	}
 
 	folders := c.convertDUFolderResults(folderResults)
+	limit, offset := c.parseDUPagination(r)
+	var peerCounts []*models.FilterBinsResponse
+	if peers := c.federatedPeers(r); len(peers) > 0 {
+		folders, directFiles, peerCounts = c.mergePeerDU(r, peers, offset+limit, folders, directFiles)
+	}
 
 	sortBy, reverse := c.parseDUSortParams(r)
 	query.SortFolders(folders, sortBy, reverse)
 	c.sortDUFiles(directFiles, sortBy, reverse)
 
-	limit, offset := c.parseDUPagination(r)
 	totalCount := len(folders) + len(directFiles)
 	folders, directFiles = c.applyDUPagination(folders, directFiles, offset, limit)
 
@@ -1073,6 +1134,9 @@ func (c *ServeCmd) HandleDU(w http.ResponseWriter, r *http.Request) {
 
 	if includeCounts {
 		response.Counts = c.calculateFilterCounts(r.Context(), flags, dbs)
+		if len(peerCounts) > 0 {
+			response.Counts = mergeFilterBins(append(peerCounts, response.Counts))
+		}
 	}
 
 	w.Header().Set("X-Total-Count", strconv.Itoa(totalCount))
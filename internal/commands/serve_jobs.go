@@ -0,0 +1,671 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/metadata"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// Job kinds accepted by POST /api/jobs
+const (
+	jobAdd        = "add"
+	jobCheck      = "check"
+	jobMediaCheck = "mediacheck"
+	jobOptimize   = "optimize"
+	// jobCaptions extracts text, OCR or speech captions for files already in the library
+	jobCaptions = "captions"
+	// jobFeeds fetches new episodes of the feed subscriptions that are due
+	jobFeeds = "feeds"
+	// jobTags writes tags into a file, which can mean remuxing a whole video
+	jobTags = "tags"
+)
+
+// jobLogLines is how much of a job's log is kept
+const jobLogLines = 1000
+
+// jobProgressInterval limits how often progress is saved and pushed to browsers
+var jobProgressInterval = time.Second
+
+// jobArgs are the kind-specific parameters of a job, stored as JSON in the jobs table
+type jobArgs struct {
+	Database          string   `json:"database,omitempty"`
+	Paths             []string `json:"paths,omitempty"`
+	Include           []string `json:"include,omitempty"`
+	DryRun            bool     `json:"dry_run,omitempty"`
+	ExtractText       bool     `json:"extract_text,omitempty"`
+	OCR               bool     `json:"ocr,omitempty"`
+	SpeechRecognition bool     `json:"speech_recognition,omitempty"`
+	FullScan          bool     `json:"full_scan,omitempty"`
+	DeleteCorrupt     string   `json:"delete_corrupt,omitempty"`
+	SkipChecked       string   `json:"skip_checked,omitempty"`
+	// Tags are the edits a tags job writes into its one path
+	Tags *metadata.Tags `json:"tags,omitempty"`
+}
+
+type jobRequest struct {
+	Kind string `json:"kind"`
+	jobArgs
+}
+
+// jobView is a job as returned by /api/jobs and pushed as "job" events
+type jobView struct {
+	ID           int64   `json:"id"`
+	Kind         string  `json:"kind"`
+	Args         jobArgs `json:"args"`
+	Status       string  `json:"status"`
+	Done         int64   `json:"done"`
+	Total        int64   `json:"total"`
+	Error        string  `json:"error,omitempty"`
+	TimeCreated  int64   `json:"time_created"`
+	TimeStarted  int64   `json:"time_started,omitempty"`
+	TimeFinished int64   `json:"time_finished,omitempty"`
+}
+
+// jobManager runs background jobs one at a time in submission order. The jobs
+// table is the queue, so jobs interrupted by a restart are picked up again.
+// Jobs write to the same SQLite databases, so running them in parallel would
+// mostly have them wait on each other
+type jobManager struct {
+	startOnce sync.Once
+	wake      chan struct{}
+	stop      context.CancelFunc
+	stopped   chan struct{}
+
+	mu      sync.Mutex
+	current *activeJob
+}
+
+// activeJob is the job currently running
+type activeJob struct {
+	id          int64
+	cancel      context.CancelFunc
+	cancelled   atomic.Bool
+	log         *jobLog
+	done, total atomic.Int64
+	lastReport  atomic.Int64
+}
+
+// startJobs begins working through the job queue, resuming anything left over
+// from a previous run
+func (c *ServeCmd) startJobs() {
+	c.jobs.startOnce.Do(func() {
+		if len(c.Databases) == 0 {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		c.jobs.mu.Lock()
+		c.jobs.wake = make(chan struct{}, 1)
+		c.jobs.stop = cancel
+		c.jobs.stopped = make(chan struct{})
+		c.jobs.mu.Unlock()
+		go c.runJobs(ctx)
+	})
+}
+
+// close stops the job runner. A job interrupted this way stays running in the
+// jobs table so it is resumed on the next start
+func (m *jobManager) close() {
+	m.mu.Lock()
+	stop, stopped := m.stop, m.stopped
+	m.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-stopped
+}
+
+func (m *jobManager) notify() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.wake == nil {
+		return
+	}
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// jobsDB is where jobs are stored, alongside published podcast feeds
+func (c *ServeCmd) jobsDB() string {
+	return c.Databases[0]
+}
+
+func (c *ServeCmd) runJobs(ctx context.Context) {
+	defer close(c.jobs.stopped)
+	for {
+		var next *db.Job
+		err := c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+			jobs, err := db.New(sqlDB).GetUnfinishedJobs(ctx)
+			if len(jobs) > 0 {
+				next = &jobs[0]
+			}
+			return err
+		})
+		if err != nil && ctx.Err() == nil {
+			models.Log.Error("Failed to read job queue", "error", err)
+		}
+
+		if next != nil {
+			c.runJob(ctx, *next)
+			if ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.jobs.wake:
+		}
+	}
+}
+
+func (c *ServeCmd) runJob(ctx context.Context, job db.Job) {
+	// Bookkeeping must outlive a cancelled job
+	bg := context.WithoutCancel(ctx)
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	active := &activeJob{id: job.ID, cancel: cancel, log: newJobLog(jobLogLines)}
+	active.done.Store(job.Done)
+	active.total.Store(job.Total)
+
+	c.jobs.mu.Lock()
+	c.jobs.current = active
+	c.jobs.mu.Unlock()
+	defer func() {
+		c.jobs.mu.Lock()
+		c.jobs.current = nil
+		c.jobs.mu.Unlock()
+	}()
+
+	if err := c.execDB(bg, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		return db.New(sqlDB).StartJob(ctx, job.ID)
+	}); err != nil {
+		models.Log.Error("Failed to start job", "id", job.ID, "error", err)
+		return
+	}
+	c.publishJob(bg, job.ID)
+
+	// Each job logs to its own log, at Info whatever the server's verbosity
+	log := models.NewLogger(active.log, slog.LevelInfo)
+	jobCtx = models.WithLogger(jobCtx, log)
+	if job.Status == db.JobRunning {
+		log.Info("Resuming interrupted job", "kind", job.Kind)
+	} else {
+		log.Info("Starting job", "kind", job.Kind)
+	}
+	err := c.execJob(jobCtx, job, func(done, total int) { c.reportJobProgress(bg, active, done, total) })
+
+	status := db.JobDone
+	switch {
+	case active.cancelled.Load():
+		status = db.JobCancelled
+	case ctx.Err() != nil:
+		// The server is stopping; leave the job running so it resumes
+		c.saveJobProgress(bg, active)
+		return
+	case err != nil:
+		status = db.JobFailed
+		fmt.Fprintf(active.log, "Job failed: %v\n", err)
+	}
+
+	c.saveJobProgress(bg, active)
+	if finishErr := c.execDB(bg, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		var errText sql.NullString
+		if err != nil && status == db.JobFailed {
+			errText = utils.ToNullString(err.Error())
+		}
+		return db.New(sqlDB).FinishJob(ctx, db.FinishJobParams{
+			ID:     job.ID,
+			Status: status,
+			Error:  errText,
+			Log:    utils.ToNullString(active.log.String()),
+		})
+	}); finishErr != nil {
+		models.Log.Error("Failed to record job result", "id", job.ID, "error", finishErr)
+	}
+	if err := c.recordJobRun(bg, job, time.Now()); err != nil {
+		models.Log.Warn("Failed to record scheduled run", "id", job.ID, "error", err)
+	}
+	c.publishJob(bg, job.ID)
+}
+
+// execJob runs the command behind a job
+func (c *ServeCmd) execJob(ctx context.Context, job db.Job, progress func(done, total int)) error {
+	var args jobArgs
+	if err := json.Unmarshal([]byte(job.Args), &args); err != nil {
+		return fmt.Errorf("invalid job arguments: %w", err)
+	}
+	dbPath := args.Database
+	if dbPath == "" {
+		dbPath = c.jobsDB()
+	}
+	core := models.CoreFlags{Verbose: c.Verbose}
+
+	switch job.Kind {
+	case jobAdd, jobCaptions:
+		cmd := &AddCmd{
+			CoreFlags:               core,
+			Database:                dbPath,
+			ScanPaths:               args.Paths,
+			Parallel:                runtime.NumCPU() * 4,
+			ExtractText:             args.ExtractText,
+			OCR:                     args.OCR,
+			OCREngine:               "tesseract",
+			SpeechRecognition:       args.SpeechRecognition,
+			SpeechRecognitionEngine: "vosk",
+			progress:                progress,
+			rescan:                  job.Kind == jobCaptions,
+		}
+		return cmd.run(ctx)
+	case jobCheck:
+		cmd := &CheckCmd{
+			CoreFlags:  core,
+			DryRun:     args.DryRun,
+			CheckPaths: args.Paths,
+			Databases:  []string{dbPath},
+			progress:   progress,
+		}
+		return cmd.run(ctx)
+	case jobMediaCheck:
+		core.Simulate = args.DryRun
+		cmd := &MediaCheckCmd{
+			CoreFlags:       core,
+			PathFilterFlags: models.PathFilterFlags{Include: args.Include},
+			DeletedFlags:    models.DeletedFlags{HideDeleted: true},
+			Databases:       []string{dbPath},
+			ChunkSize:       0.5,
+			Gap:             "5%",
+			FullScan:        args.FullScan,
+			DeleteCorrupt:   args.DeleteCorrupt,
+			SkipChecked:     args.SkipChecked,
+			progress:        progress,
+		}
+		return cmd.Run(ctx)
+	case jobOptimize:
+		cmd := &OptimizeCmd{CoreFlags: core, Databases: []string{dbPath}, progress: progress}
+		return cmd.run(ctx)
+	case jobFeeds:
+		cmd := &FeedsUpdateCmd{CoreFlags: core, Database: dbPath}
+		return cmd.run(ctx)
+	case jobTags:
+		path := args.Paths[0]
+		progress(0, 1)
+		found, err := applyTagEdits(ctx, c.execDB, c.Databases, path, *args.Tags, true)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("media not found: %s", path)
+		}
+		progress(1, 1)
+		c.notifyChange(eventMedia, mediaChange{Action: "tagged", Path: path})
+		return nil
+	default:
+		return fmt.Errorf("unknown job kind: %s", job.Kind)
+	}
+}
+
+func (c *ServeCmd) reportJobProgress(ctx context.Context, active *activeJob, done, total int) {
+	active.done.Store(int64(done))
+	active.total.Store(int64(total))
+
+	now := time.Now().UnixNano()
+	last := active.lastReport.Load()
+	if done < total && time.Duration(now-last) < jobProgressInterval {
+		return
+	}
+	if !active.lastReport.CompareAndSwap(last, now) {
+		return
+	}
+	c.saveJobProgress(ctx, active)
+	c.publishJob(ctx, active.id)
+}
+
+func (c *ServeCmd) saveJobProgress(ctx context.Context, active *activeJob) {
+	err := c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		return db.New(sqlDB).UpdateJobProgress(ctx, active.id, active.done.Load(), active.total.Load())
+	})
+	if err != nil {
+		models.Log.Debug("Failed to save job progress", "id", active.id, "error", err)
+	}
+}
+
+func (c *ServeCmd) publishJob(ctx context.Context, id int64) {
+	if job, err := c.getJob(ctx, id); err == nil {
+		c.events.publish(eventJob, job)
+	}
+}
+
+// getJob reads a job, with live progress if it is running
+func (c *ServeCmd) getJob(ctx context.Context, id int64) (jobView, error) {
+	var job db.Job
+	err := c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		var err error
+		job, err = db.New(sqlDB).GetJob(ctx, id)
+		return err
+	})
+	if err != nil {
+		return jobView{}, err
+	}
+	return c.viewJob(job), nil
+}
+
+func (c *ServeCmd) viewJob(job db.Job) jobView {
+	v := jobView{
+		ID:           job.ID,
+		Kind:         job.Kind,
+		Status:       job.Status,
+		Done:         job.Done,
+		Total:        job.Total,
+		Error:        job.Error.String,
+		TimeCreated:  job.TimeCreated.Int64,
+		TimeStarted:  job.TimeStarted.Int64,
+		TimeFinished: job.TimeFinished.Int64,
+	}
+	_ = json.Unmarshal([]byte(job.Args), &v.Args)
+	if active := c.activeJob(job.ID); active != nil {
+		v.Done, v.Total = active.done.Load(), active.total.Load()
+	}
+	return v
+}
+
+func (c *ServeCmd) activeJob(id int64) *activeJob {
+	c.jobs.mu.Lock()
+	defer c.jobs.mu.Unlock()
+	if c.jobs.current != nil && c.jobs.current.id == id {
+		return c.jobs.current
+	}
+	return nil
+}
+
+// queueJob adds a job to the end of the queue
+func (c *ServeCmd) queueJob(ctx context.Context, kind string, args jobArgs) (jobView, error) {
+	encoded, _ := json.Marshal(args)
+	var id int64
+	err := c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		var err error
+		id, err = db.New(sqlDB).InsertJob(ctx, kind, string(encoded))
+		return err
+	})
+	if err != nil {
+		return jobView{}, err
+	}
+	c.jobs.notify()
+
+	job, err := c.getJob(ctx, id)
+	if err != nil {
+		return jobView{}, err
+	}
+	c.events.publish(eventJob, job)
+	return job, nil
+}
+
+// validateJob checks a submitted job before it is queued
+func (c *ServeCmd) validateJob(req *jobRequest) error {
+	if req.Database != "" && !slices.Contains(c.Databases, req.Database) {
+		return fmt.Errorf("database is not served: %s", req.Database)
+	}
+	for _, p := range req.Paths {
+		if p == "" || p == "-" {
+			return errors.New("paths must be files or folders")
+		}
+	}
+
+	switch req.Kind {
+	case jobAdd:
+		if len(req.Paths) == 0 {
+			return errors.New("add needs at least one path")
+		}
+	case jobCaptions:
+		if len(req.Paths) == 0 {
+			return errors.New("captions needs at least one path")
+		}
+		if !req.ExtractText && !req.OCR && !req.SpeechRecognition {
+			return errors.New("captions needs extract_text, ocr or speech_recognition")
+		}
+	case jobTags:
+		if len(req.Paths) != 1 || req.Tags == nil {
+			return errors.New("tags needs one path and tags")
+		}
+	case jobCheck, jobMediaCheck, jobOptimize, jobFeeds:
+	default:
+		return fmt.Errorf("unknown job kind: %q", req.Kind)
+	}
+	return nil
+}
+
+// HandleJobs lists recent jobs (GET) or queues a new one (POST)
+// Body: {"kind": "add|check|mediacheck|optimize|captions|feeds|tags", "database": "...", "paths": [...], ...}
+func (c *ServeCmd) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	if len(c.Databases) == 0 {
+		sendError(w, http.StatusServiceUnavailable, "No database to store jobs in")
+		return
+	}
+	c.startJobs()
+
+	switch r.Method {
+	case http.MethodGet:
+		limit := int64(100)
+		if v, err := strconv.ParseInt(r.URL.Query().Get("limit"), 10, 64); err == nil && v > 0 {
+			limit = v
+		}
+		var jobs []db.Job
+		err := c.execDB(r.Context(), c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+			var err error
+			jobs, err = db.New(sqlDB).GetJobs(ctx, limit)
+			return err
+		})
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		views := make([]jobView, 0, len(jobs))
+		for _, j := range jobs {
+			views = append(views, c.viewJob(j))
+		}
+		sendJSON(w, http.StatusOK, views)
+	case http.MethodPost:
+		if c.ReadOnly {
+			sendError(w, http.StatusForbidden, "Read-only mode")
+			return
+		}
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := c.validateJob(&req); err != nil {
+			sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		job, err := c.queueJob(r.Context(), req.Kind, req.jobArgs)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendJSON(w, http.StatusCreated, job)
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// jobFromPath loads the job named by the {id} path segment, writing an error response if it cannot
+func (c *ServeCmd) jobFromPath(w http.ResponseWriter, r *http.Request) (jobView, bool) {
+	if len(c.Databases) == 0 {
+		sendError(w, http.StatusNotFound, "Job not found")
+		return jobView{}, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid job id")
+		return jobView{}, false
+	}
+	job, err := c.getJob(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendError(w, http.StatusNotFound, "Job not found")
+		return jobView{}, false
+	} else if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return jobView{}, false
+	}
+	return job, true
+}
+
+// HandleJob returns one job
+// GET /api/jobs/{id}
+func (c *ServeCmd) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if job, ok := c.jobFromPath(w, r); ok {
+		sendJSON(w, http.StatusOK, job)
+	}
+}
+
+// HandleJobCancel stops a running job or removes a queued one from the queue
+// POST /api/jobs/{id}/cancel
+func (c *ServeCmd) HandleJobCancel(w http.ResponseWriter, r *http.Request) {
+	if c.ReadOnly {
+		sendError(w, http.StatusForbidden, "Read-only mode")
+		return
+	}
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	job, ok := c.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if active := c.activeJob(job.ID); active != nil {
+		active.cancelled.Store(true)
+		active.cancel()
+		sendJSON(w, http.StatusAccepted, job)
+		return
+	}
+	if job.Status != db.JobQueued {
+		sendError(w, http.StatusConflict, "Job is not queued or running")
+		return
+	}
+
+	err := c.execDB(r.Context(), c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		return db.New(sqlDB).FinishJob(ctx, db.FinishJobParams{ID: job.ID, Status: db.JobCancelled})
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	job, _ = c.getJob(r.Context(), job.ID)
+	c.events.publish(eventJob, job)
+	sendJSON(w, http.StatusOK, job)
+}
+
+// HandleJobLog returns the last lines a job logged as plain text
+// GET /api/jobs/{id}/log?lines=100
+func (c *ServeCmd) HandleJobLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	job, ok := c.jobFromPath(w, r)
+	if !ok {
+		return
+	}
+	n := 100
+	if v, err := strconv.Atoi(r.URL.Query().Get("lines")); err == nil && v > 0 {
+		n = v
+	}
+
+	var lines []string
+	if active := c.activeJob(job.ID); active != nil {
+		lines = active.log.tail(n)
+	} else {
+		var stored sql.NullString
+		err := c.execDB(r.Context(), c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+			j, err := db.New(sqlDB).GetJob(ctx, job.ID)
+			stored = j.Log
+			return err
+		})
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		lines = strings.Split(strings.TrimSuffix(stored.String, "\n"), "\n")
+		if stored.String == "" {
+			lines = nil
+		}
+		lines = lines[max(0, len(lines)-n):]
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// jobLog keeps the most recent lines a job logged
+type jobLog struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	max     int
+}
+
+func newJobLog(maxLines int) *jobLog {
+	return &jobLog{max: maxLines}
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.partial = append(l.partial, p...)
+	for {
+		i := slices.Index(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.lines = append(l.lines, string(l.partial[:i]))
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.lines) > l.max {
+		l.lines = slices.Delete(l.lines, 0, len(l.lines)-l.max)
+	}
+	return len(p), nil
+}
+
+func (l *jobLog) tail(n int) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.lines[max(0, len(l.lines)-n):])
+}
+
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.lines) == 0 {
+		return ""
+	}
+	return strings.Join(l.lines, "\n") + "\n"
+}
@@ -0,0 +1,183 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+)
+
+type testJob struct {
+	ID     int64  `json:"id"`
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Done   int64  `json:"done"`
+	Total  int64  `json:"total"`
+	Error  string `json:"error"`
+}
+
+// waitForJob polls a job until it leaves the queue
+func waitForJob(t *testing.T, server *httptest.Server, token string, id int64) testJob {
+	t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		code, body := serveRequest(t, server, token, http.MethodGet, "/api/jobs/"+strconv.FormatInt(id, 10), "")
+		if code != http.StatusOK {
+			t.Fatalf("GET job %d: %d %s", id, code, body)
+		}
+		var job testJob
+		if err := json.Unmarshal([]byte(body), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status != db.JobQueued && job.Status != db.JobRunning {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Job %d still %s", id, job.Status)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestServeJobs_SubmitAdd(t *testing.T) {
+	cmd, server, dbPath := setupServeServer(t, nil)
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp4", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("not really media"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]any{"kind": "add", "database": dbPath, "paths": []string{dir}})
+	code, resp := serveRequest(t, server, cmd.APIToken, http.MethodPost, "/api/jobs", string(body))
+	if code != http.StatusCreated {
+		t.Fatalf("Submit: %d %s", code, resp)
+	}
+	var submitted testJob
+	json.Unmarshal([]byte(resp), &submitted)
+	if submitted.Kind != "add" || submitted.ID == 0 {
+		t.Fatalf("Unexpected submitted job: %s", resp)
+	}
+
+	job := waitForJob(t, server, cmd.APIToken, submitted.ID)
+	if job.Status != db.JobDone || job.Error != "" {
+		t.Fatalf("Expected done, got %+v", job)
+	}
+	if job.Total != 2 || job.Done != 2 {
+		t.Errorf("Expected progress 2/2, got %d/%d", job.Done, job.Total)
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	var count int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM media").Scan(&count)
+	if count != 2 {
+		t.Errorf("Expected 2 media rows, got %d", count)
+	}
+
+	code, logText := serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs/"+strconv.FormatInt(job.ID, 10)+"/log", "")
+	if code != http.StatusOK || !strings.Contains(logText, "Starting job") {
+		t.Errorf("Log: %d %q", code, logText)
+	}
+	// The command's own Info lines reach the job log at the server's default verbosity
+	if !strings.Contains(logText, "Extracting metadata") {
+		t.Errorf("Expected the add command's log in the job log, got %q", logText)
+	}
+
+	code, list := serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs", "")
+	if code != http.StatusOK || !strings.Contains(list, `"status":"done"`) {
+		t.Errorf("List: %d %s", code, list)
+	}
+}
+
+func TestServeJobs_ResumeInterrupted(t *testing.T) {
+	var id int64
+	cmd, server, _ := setupServeServer(t, func(sqlDB *sql.DB) {
+		ctx, q := context.Background(), db.New(sqlDB)
+		var err error
+		if id, err = q.InsertJob(ctx, "optimize", `{}`); err != nil {
+			t.Fatal(err)
+		}
+		// The previous server stopped while this job was running
+		if err := q.StartJob(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	// Listing jobs starts the runner, which picks the job up again
+	serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs", "")
+	if job := waitForJob(t, server, cmd.APIToken, id); job.Status != db.JobDone {
+		t.Errorf("Expected resumed job to finish, got %+v", job)
+	}
+	_, logText := serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs/"+strconv.FormatInt(id, 10)+"/log", "")
+	if !strings.Contains(logText, "Resuming interrupted job") {
+		t.Errorf("Expected resume in log, got %q", logText)
+	}
+}
+
+func TestServeJobs_Cancel(t *testing.T) {
+	var queued, finished int64
+	cmd, server, _ := setupServeServer(t, func(sqlDB *sql.DB) {
+		ctx, q := context.Background(), db.New(sqlDB)
+		queued, _ = q.InsertJob(ctx, "optimize", `{}`)
+		finished, _ = q.InsertJob(ctx, "optimize", `{}`)
+		q.FinishJob(ctx, db.FinishJobParams{ID: finished, Status: db.JobDone})
+	})
+
+	// Cancelling does not start the runner, so the queued job is still waiting
+	path := "/api/jobs/" + strconv.FormatInt(queued, 10) + "/cancel"
+	if code, body := serveRequest(t, server, cmd.APIToken, http.MethodPost, path, ""); code != http.StatusOK ||
+		!strings.Contains(body, `"status":"cancelled"`) {
+		t.Errorf("Cancel queued: %d %s", code, body)
+	}
+	if code, _ := serveRequest(t, server, cmd.APIToken, http.MethodPost, path, ""); code != http.StatusConflict {
+		t.Errorf("Cancel twice: expected 409, got %d", code)
+	}
+	path = "/api/jobs/" + strconv.FormatInt(finished, 10) + "/cancel"
+	if code, _ := serveRequest(t, server, cmd.APIToken, http.MethodPost, path, ""); code != http.StatusConflict {
+		t.Errorf("Cancel finished: expected 409, got %d", code)
+	}
+	if code, _ := serveRequest(t, server, cmd.APIToken, http.MethodPost, "/api/jobs/999/cancel", ""); code != http.StatusNotFound {
+		t.Errorf("Cancel unknown: expected 404, got %d", code)
+	}
+
+	// Once the runner starts, the cancelled job stays cancelled
+	serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs", "")
+	time.Sleep(200 * time.Millisecond)
+	if job := waitForJob(t, server, cmd.APIToken, queued); job.Status != db.JobCancelled {
+		t.Errorf("Expected cancelled, got %+v", job)
+	}
+}
+
+func TestServeJobs_Validation(t *testing.T) {
+	cmd, server, _ := setupServeServer(t, nil)
+
+	for _, body := range []string{
+		`{"kind": "rm -rf"}`,
+		`{"kind": "add"}`,
+		`{"kind": "add", "paths": ["/tmp"], "database": "/other.db"}`,
+		`{"kind": "captions", "paths": ["/tmp"]}`,
+		`{"kind": "check", "paths": ["-"]}`,
+	} {
+		if code, resp := serveRequest(t, server, cmd.APIToken, http.MethodPost, "/api/jobs", body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", body, code, resp)
+		}
+	}
+
+	cmd.ReadOnly = true
+	if code, _ := serveRequest(t, server, cmd.APIToken, http.MethodPost, "/api/jobs", `{"kind": "optimize"}`); code != http.StatusForbidden {
+		t.Errorf("Read-only submit: expected 403, got %d", code)
+	}
+}
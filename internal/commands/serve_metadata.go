@@ -3,11 +3,13 @@ package commands
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"sort"
 	"strings"
 
 	database "github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/metadata"
 	"github.com/chapmanjacobd/discoteca/internal/models"
 )
 
@@ -54,6 +56,79 @@ func (c *ServeCmd) HandleMetadata(w http.ResponseWriter, r *http.Request) {
 	sendJSON(w, http.StatusOK, metadata)
 }
 
+// HandleMediaUpdate edits the tags of a media file in every database that has it and
+// returns the updated media. With write_file the tags are written into the file as well,
+// by a background job since that can remux a whole video; the job is returned instead.
+// PATCH /api/media
+// Body: {"path": "...", "title": "...", "artist": "...", "album": "...", "genre": "...", "categories": "...", "write_file": bool}
+func (c *ServeCmd) HandleMediaUpdate(w http.ResponseWriter, r *http.Request) {
+	if c.ReadOnly {
+		sendError(w, http.StatusForbidden, "Read-only mode")
+		return
+	}
+	if r.Method != http.MethodPatch {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		metadata.Tags
+		Path      string `json:"path"`
+		WriteFile bool   `json:"write_file"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.Path == "" || req.Tags.IsEmpty() {
+		sendError(w, http.StatusBadRequest, "Path and at least one tag required")
+		return
+	}
+
+	if req.WriteFile {
+		if found, _ := c.getMediaTypeFromDB(r.Context(), req.Path); !found {
+			sendError(w, http.StatusNotFound, "Media not found")
+			return
+		}
+		c.startJobs()
+		job, err := c.queueJob(r.Context(), jobTags, jobArgs{Paths: []string{req.Path}, Tags: &req.Tags})
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	found, err := applyTagEdits(r.Context(), c.execDB, c.Databases, req.Path, req.Tags, false)
+	if err != nil {
+		models.Log.Error("Failed to update tags", "path", req.Path, "error", err)
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		sendError(w, http.StatusNotFound, "Media not found")
+		return
+	}
+
+	var media models.Media
+	for _, dbPath := range c.Databases {
+		err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			dbMedia, err := database.New(sqlDB).GetMediaByPathExact(ctx, req.Path)
+			if err == nil {
+				media = models.FromDB(dbMedia)
+			}
+			return err
+		})
+		if err == nil {
+			break
+		}
+	}
+
+	c.notifyChange(eventMedia, mediaChange{Action: "tagged", Path: req.Path})
+	sendJSON(w, http.StatusOK, media)
+}
+
 // HandleDatabases returns server configuration.
 // GET /api/databases
 func (c *ServeCmd) HandleDatabases(w http.ResponseWriter, _ *http.Request) {
@@ -150,6 +225,9 @@ func (c *ServeCmd) HandleCategories(w http.ResponseWriter, r *http.Request) {
 
 	c.fetchCategoryCounts(r.Context(), counts, isCustom)
 	counts["Uncategorized"] = c.fetchUncategorizedCount(r.Context())
+	if peers := c.federatedPeers(r); len(peers) > 0 {
+		c.fetchPeerCategories(r, peers, counts)
+	}
 
 	res := make([]models.CatStat, 0, len(counts))
 	for k, v := range counts {
@@ -0,0 +1,310 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// Histogram buckets in seconds
+var (
+	httpDurationBuckets   = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+	sqliteDurationBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5}
+)
+
+// sqliteQueryDurations is shared with TimedQuery, which has no server to report to
+var sqliteQueryDurations = newHistogram(sqliteDurationBuckets)
+
+// serverMetrics holds the counters behind /metrics. Gauges that can be read from
+// the databases, such as library size and job queue depth, are queried at scrape time
+type serverMetrics struct {
+	httpDurations   *histogram
+	httpRequests    counterVec
+	ffmpegActive    counterVec
+	ffmpegStarted   counterVec
+	thumbnailHits   atomic.Uint64
+	thumbnailMisses atomic.Uint64
+	initOnce        sync.Once
+}
+
+func (m *serverMetrics) init() {
+	m.initOnce.Do(func() {
+		m.httpDurations = newHistogram(httpDurationBuckets)
+	})
+}
+
+// histogram is a Prometheus histogram keyed by a rendered label set
+type histogram struct {
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogram) observe(labels string, seconds float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[labels]
+	if s == nil {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[labels] = s
+	}
+	for i, upper := range h.buckets {
+		if seconds <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += seconds
+}
+
+func (h *histogram) write(w io.Writer, name, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, labels := range sortedKeys(h.series) {
+		s := h.series[labels]
+		sep := ""
+		if labels != "" {
+			sep = ","
+		}
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s%sle=%q} %d\n", name, labels, sep, formatFloat(upper), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, braced(labels), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, braced(labels), s.count)
+	}
+}
+
+// counterVec is a set of counters or gauges keyed by a rendered label set
+type counterVec struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func (v *counterVec) add(labels string, delta float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.values == nil {
+		v.values = map[string]float64{}
+	}
+	v.values[labels] += delta
+}
+
+func (v *counterVec) write(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, labels := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", name, braced(labels), formatFloat(v.values[labels]))
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// labelSet renders name/value pairs in exposition format, e.g. route="/api/query"
+func labelSet(pairs ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(pairs[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func braced(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// observeQuery records how long a unit of database work took
+func observeQuery(dbPath string, d time.Duration) {
+	sqliteQueryDurations.observe(labelSet("database", dbPath), d.Seconds())
+}
+
+// trackFfmpeg counts a running ffmpeg process; call the returned func when it exits
+func (c *ServeCmd) trackFfmpeg(kind string) func() {
+	labels := labelSet("kind", kind)
+	c.metrics.ffmpegStarted.add(labels, 1)
+	c.metrics.ffmpegActive.add(labels, 1)
+	return func() { c.metrics.ffmpegActive.add(labels, -1) }
+}
+
+// instrument records latency and status of every request by its ServeMux pattern
+func (c *ServeCmd) instrument(next http.Handler) http.Handler {
+	c.metrics.init()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		mw := &metricsWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(mw, r)
+
+		// Patterns keep the label set small, unlike raw paths
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		c.metrics.httpDurations.observe(labelSet("route", route), time.Since(start).Seconds())
+		c.metrics.httpRequests.add(labelSet("route", route, "code", strconv.Itoa(mw.status)), 1)
+	})
+}
+
+// metricsWriter captures the response status while keeping streaming working
+type metricsWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (m *metricsWriter) WriteHeader(code int) {
+	if !m.wroteHeader {
+		m.status, m.wroteHeader = code, true
+	}
+	m.ResponseWriter.WriteHeader(code)
+}
+
+func (m *metricsWriter) Write(b []byte) (int, error) {
+	m.wroteHeader = true
+	return m.ResponseWriter.Write(b)
+}
+
+func (m *metricsWriter) Flush() {
+	m.wroteHeader = true
+	if f, ok := m.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// ReadFrom keeps sendfile for http.ServeContent
+func (m *metricsWriter) ReadFrom(r io.Reader) (int64, error) {
+	m.wroteHeader = true
+	if rf, ok := m.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{m.ResponseWriter}, r)
+}
+
+func (m *metricsWriter) Unwrap() http.ResponseWriter {
+	return m.ResponseWriter
+}
+
+// HandleMetrics serves Prometheus text exposition format. Prometheus can pass the
+// API token with `authorization: {credentials: ...}` in its scrape config
+func (c *ServeCmd) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	c.metrics.init()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	c.metrics.httpDurations.write(out, "disco_http_request_duration_seconds", "HTTP request latency by route")
+	c.metrics.httpRequests.write(out, "disco_http_requests_total", "counter", "HTTP requests by route and status code")
+	sqliteQueryDurations.write(out, "disco_sqlite_query_duration_seconds", "Time spent in database operations by database")
+	c.metrics.ffmpegActive.write(out, "disco_ffmpeg_processes", "gauge", "Running ffmpeg processes by purpose")
+	c.metrics.ffmpegStarted.write(out, "disco_ffmpeg_processes_started_total", "counter", "ffmpeg processes started by purpose")
+
+	fmt.Fprintf(out, "# HELP disco_thumbnail_cache_requests_total Thumbnail requests by cache result\n")
+	fmt.Fprintf(out, "# TYPE disco_thumbnail_cache_requests_total counter\n")
+	fmt.Fprintf(out, "disco_thumbnail_cache_requests_total{result=\"hit\"} %d\n", c.metrics.thumbnailHits.Load())
+	fmt.Fprintf(out, "disco_thumbnail_cache_requests_total{result=\"miss\"} %d\n", c.metrics.thumbnailMisses.Load())
+
+	c.writeLibraryMetrics(r.Context(), out)
+	c.writeJobMetrics(r.Context(), out)
+}
+
+// writeLibraryMetrics reports item counts and sizes per database and media type
+func (c *ServeCmd) writeLibraryMetrics(ctx context.Context, w io.Writer) {
+	var items, sizes counterVec
+	for _, dbPath := range c.Databases {
+		err := c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			rows, err := sqlDB.QueryContext(ctx, `
+				SELECT COALESCE(media_type, ''), COUNT(*), COALESCE(SUM(size), 0)
+				FROM media
+				WHERE COALESCE(time_deleted, 0) = 0
+				GROUP BY 1`)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var mediaType string
+				var count, size int64
+				if err := rows.Scan(&mediaType, &count, &size); err != nil {
+					return err
+				}
+				labels := labelSet("database", dbPath, "media_type", mediaType)
+				items.add(labels, float64(count))
+				sizes.add(labels, float64(size))
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			models.Log.Debug("Failed to collect library metrics", "db", dbPath, "error", err)
+		}
+	}
+	items.write(w, "disco_library_items", "gauge", "Media items that are not deleted")
+	sizes.write(w, "disco_library_size_bytes", "gauge", "Total size of media items that are not deleted")
+}
+
+// writeJobMetrics reports the background job queue depth
+func (c *ServeCmd) writeJobMetrics(ctx context.Context, w io.Writer) {
+	var jobs counterVec
+	jobs.add(labelSet("status", db.JobQueued), 0)
+	jobs.add(labelSet("status", db.JobRunning), 0)
+	if len(c.Databases) > 0 {
+		_ = c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+			unfinished, err := db.New(sqlDB).GetUnfinishedJobs(ctx)
+			for _, j := range unfinished {
+				jobs.add(labelSet("status", j.Status), 1)
+			}
+			return err
+		})
+	}
+	jobs.write(w, "disco_jobs", "gauge", "Background jobs waiting or running")
+}
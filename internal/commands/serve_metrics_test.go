@@ -0,0 +1,60 @@
+package commands_test
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+)
+
+func TestServeMetrics(t *testing.T) {
+	cmd, server, dbPath := setupServeServer(t, func(sqlDB *sql.DB) {
+		_, err := sqlDB.Exec(`INSERT INTO media (path, title, media_type, size, time_deleted) VALUES
+			('/m/a.mp4', 'A', 'video', 100, 0), ('/m/b.mp4', 'B', 'video', 50, 0),
+			('/m/c.mp3', 'C', 'audio', 10, 0), ('/m/d.mp3', 'D', 'audio', 10, 1)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.New(sqlDB).InsertJob(t.Context(), "optimize", `{}`); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/playlists", "")
+	if code, _ := serveRequest(t, server, "", http.MethodGet, "/metrics", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected /metrics to need the API token, got %d", code)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+cmd.APIToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	code, body := resp.StatusCode, string(b)
+	if code != http.StatusOK {
+		t.Fatalf("GET /metrics: %d %s", code, body)
+	}
+
+	for _, want := range []string{
+		`# TYPE disco_http_request_duration_seconds histogram`,
+		`disco_http_request_duration_seconds_count{route="/api/playlists"} 1`,
+		`disco_http_requests_total{route="/api/playlists",code="200"} 1`,
+		`disco_http_requests_total{route="/metrics",code="401"} 1`,
+		`disco_sqlite_query_duration_seconds_count{database="` + dbPath + `"}`,
+		`disco_library_items{database="` + dbPath + `",media_type="video"} 2`,
+		`disco_library_items{database="` + dbPath + `",media_type="audio"} 1`,
+		`disco_library_size_bytes{database="` + dbPath + `",media_type="video"} 150`,
+		`disco_jobs{status="queued"} 1`,
+		`disco_jobs{status="running"} 0`,
+		`disco_thumbnail_cache_requests_total{result="hit"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Missing %q in:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,571 @@
+package commands
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+)
+
+// peerTimeout bounds a single request to a peer so one slow machine can't stall searches
+const peerTimeout = 15 * time.Second
+
+// peerMaxRows caps how many rows a peer is asked for in one request
+const peerMaxRows = 10_000
+
+// peerOriginsMax is how many paths the owning peer is remembered for
+const peerOriginsMax = 50_000
+
+// peerServer is another disco serve whose library is merged into searches
+type peerServer struct {
+	// name is the --peer URL; results from this peer are tagged with it
+	name  string
+	base  *url.URL
+	token string
+	proxy *httputil.ReverseProxy
+}
+
+// peerSet holds the parsed --peer flags and remembers which peer owns each path
+// that was returned to a client, so later stream requests can be proxied
+type peerSet struct {
+	once    sync.Once
+	servers []*peerServer
+	err     error
+	client  *http.Client
+	origins peerOrigins
+}
+
+// peerOrigins maps media paths to the peer that owns them, forgetting the least
+// recently used paths past peerOriginsMax
+type peerOrigins struct {
+	mu    sync.Mutex
+	order list.List // of *peerOrigin, most recently used first
+	paths map[string]*list.Element
+}
+
+type peerOrigin struct {
+	path string
+	peer *peerServer
+}
+
+func (o *peerOrigins) store(path string, p *peerServer) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.paths == nil {
+		o.paths = make(map[string]*list.Element)
+	}
+	if e, ok := o.paths[path]; ok {
+		e.Value.(*peerOrigin).peer = p
+		o.order.MoveToFront(e)
+		return
+	}
+	o.paths[path] = o.order.PushFront(&peerOrigin{path: path, peer: p})
+	if o.order.Len() > peerOriginsMax {
+		oldest := o.order.Back()
+		o.order.Remove(oldest)
+		delete(o.paths, oldest.Value.(*peerOrigin).path)
+	}
+}
+
+func (o *peerOrigins) load(path string) (*peerServer, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e, ok := o.paths[path]
+	if !ok {
+		return nil, false
+	}
+	o.order.MoveToFront(e)
+	return e.Value.(*peerOrigin).peer, true
+}
+
+// parsePeers pairs --peer URLs with --peer-token values. A single token is shared by all peers
+func parsePeers(urls, tokens []string) ([]*peerServer, error) {
+	if len(tokens) > 1 && len(tokens) != len(urls) {
+		return nil, fmt.Errorf("got %d --peer-token values for %d --peer servers", len(tokens), len(urls))
+	}
+	servers := make([]*peerServer, 0, len(urls))
+	for i, raw := range urls {
+		base, err := url.Parse(strings.TrimRight(raw, "/"))
+		if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+			return nil, fmt.Errorf("peer must be an http(s) URL: %q", raw)
+		}
+		p := &peerServer{name: base.String(), base: base}
+		switch {
+		case len(tokens) == 1:
+			p.token = tokens[0]
+		case len(tokens) > 1:
+			p.token = tokens[i]
+		}
+		p.proxy = newPeerProxy(p)
+		servers = append(servers, p)
+	}
+	return servers, nil
+}
+
+func (c *ServeCmd) peerServers() ([]*peerServer, error) {
+	c.peers.once.Do(func() {
+		c.peers.servers, c.peers.err = parsePeers(c.Peer, c.PeerToken)
+		c.peers.client = &http.Client{Timeout: peerTimeout}
+	})
+	return c.peers.servers, c.peers.err
+}
+
+// federatedPeers returns the peers a request should fan out to. Requests from peers
+// carry federate=false so two servers that list each other don't loop, and a db filter
+// names local files so it keeps the search local
+func (c *ServeCmd) federatedPeers(r *http.Request) []*peerServer {
+	q := r.URL.Query()
+	if len(c.Peer) == 0 || q.Get("federate") == "false" || len(q["db"]) > 0 {
+		return nil
+	}
+	servers, err := c.peerServers()
+	if err != nil {
+		return nil
+	}
+	return servers
+}
+
+// peerQuery copies the client's query for a peer, dropping the local token and URL
+// signature, which the peer can't verify
+func peerQuery(r *http.Request) url.Values {
+	q := r.URL.Query()
+	q.Del("token")
+	q.Del("sig")
+	q.Del("expires")
+	q.Del("peer")
+	q.Set("federate", "false")
+	return q
+}
+
+type peerResponse struct {
+	peer   *peerServer
+	body   []byte
+	header http.Header
+}
+
+// fetchPeers GETs path from every peer in parallel. Peers that fail are logged and
+// left out so one machine being down doesn't break searches
+func (c *ServeCmd) fetchPeers(ctx context.Context, peers []*peerServer, path string, q url.Values) []peerResponse {
+	results := make([]*peerResponse, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Go(func() {
+			res, err := c.fetchPeer(ctx, p, path, q)
+			if err != nil {
+				models.Log.Warn("Peer request failed", "peer", p.name, "path", path, "error", err)
+				return
+			}
+			results[i] = res
+		})
+	}
+	wg.Wait()
+
+	var out []peerResponse
+	for _, res := range results {
+		if res != nil {
+			out = append(out, *res)
+		}
+	}
+	return out
+}
+
+func (c *ServeCmd) fetchPeer(ctx context.Context, p *peerServer, path string, q url.Values) (*peerResponse, error) {
+	u := p.base.JoinPath(path)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Disco-Token", p.token)
+
+	resp, err := c.peers.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(body.String()))
+	}
+	return &peerResponse{peer: p, body: body.Bytes(), header: resp.Header}, nil
+}
+
+// tagPeerMedia marks results with the peer they came from and remembers the owner of each path
+func (c *ServeCmd) tagPeerMedia(p *peerServer, media []models.MediaWithDB) {
+	for i := range media {
+		media[i].Peer = p.name
+		c.peers.origins.store(media[i].Path, p)
+	}
+}
+
+// decodePeerQuery reads an /api/query response, which is a bare array unless
+// include_counts was set
+func decodePeerQuery(body []byte) ([]models.MediaWithDB, *models.FilterBinsResponse, error) {
+	var media []models.MediaWithDB
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(body, &media)
+		return media, nil, err
+	}
+	var resp struct {
+		Items  []models.MediaWithDB       `json:"items"`
+		Counts *models.FilterBinsResponse `json:"counts"`
+	}
+	err := json.Unmarshal(body, &resp)
+	return resp.Items, resp.Counts, err
+}
+
+// mergePeerQuery adds peer results to a local /api/query result. Local results must
+// have been fetched with limit+offset rows and no offset, like executeMultiDB does
+func (c *ServeCmd) mergePeerQuery(
+	ctx context.Context,
+	r *http.Request,
+	peers []*peerServer,
+	flags models.GlobalFlags,
+	media []models.MediaWithDB,
+	counts *models.FilterBinsResponse,
+) ([]models.MediaWithDB, int64, *models.FilterBinsResponse) {
+	paginate := !flags.All && flags.Limit > 0
+	q := peerQuery(r)
+	q.Set("limit", strconv.Itoa(peerMaxRows))
+	if paginate {
+		q.Set("limit", strconv.Itoa(min(flags.Limit+flags.Offset, peerMaxRows)))
+		q.Set("offset", "0")
+	}
+
+	var total int64
+	countParts := []*models.FilterBinsResponse{counts}
+	for _, res := range c.fetchPeers(ctx, peers, "/api/query", q) {
+		peerMedia, peerCounts, err := decodePeerQuery(res.body)
+		if err != nil {
+			models.Log.Warn("Invalid peer response", "peer", res.peer.name, "error", err)
+			continue
+		}
+		c.tagPeerMedia(res.peer, peerMedia)
+		media = append(media, peerMedia...)
+		if n, err := strconv.ParseInt(res.header.Get("X-Total-Count"), 10, 64); err == nil {
+			total += n
+		}
+		countParts = append(countParts, peerCounts)
+	}
+
+	if paginate {
+		media = query.SortAndPaginate(media, flags, flags.Limit, flags.Offset)
+	} else {
+		query.SortMedia(media, flags)
+	}
+	if counts != nil {
+		counts = mergeFilterBins(countParts)
+	}
+	return media, total, counts
+}
+
+// mergePeerDU adds peer folders and files to a local /api/du result before it is
+// sorted and paginated. Folders with the same path are summed. Each peer sends at most
+// the rows up to the end of the requested page
+func (c *ServeCmd) mergePeerDU(
+	r *http.Request,
+	peers []*peerServer,
+	rows int,
+	folders []models.FolderStats,
+	files []models.MediaWithDB,
+) ([]models.FolderStats, []models.MediaWithDB, []*models.FilterBinsResponse) {
+	q := peerQuery(r)
+	q.Set("offset", "0")
+	q.Set("limit", strconv.Itoa(min(rows, peerMaxRows)))
+
+	index := make(map[string]int, len(folders))
+	for i, f := range folders {
+		index[f.Path] = i
+	}
+	var counts []*models.FilterBinsResponse
+	for _, res := range c.fetchPeers(r.Context(), peers, "/api/du", q) {
+		var du models.DUResponse
+		if err := json.Unmarshal(res.body, &du); err != nil {
+			models.Log.Warn("Invalid peer response", "peer", res.peer.name, "error", err)
+			continue
+		}
+		for _, f := range du.Folders {
+			i, ok := index[f.Path]
+			if !ok {
+				index[f.Path] = len(folders)
+				folders = append(folders, models.FolderStats{Path: f.Path})
+				i = len(folders) - 1
+			}
+			folders[i].Count += f.Count
+			folders[i].TotalSize += f.TotalSize
+			folders[i].TotalDuration += f.TotalDuration
+		}
+		c.tagPeerMedia(res.peer, du.Files)
+		files = append(files, du.Files...)
+		counts = append(counts, du.Counts)
+	}
+	return folders, files, counts
+}
+
+// fetchPeerCategories adds peer category counts to counts
+func (c *ServeCmd) fetchPeerCategories(r *http.Request, peers []*peerServer, counts map[string]int64) {
+	for _, res := range c.fetchPeers(r.Context(), peers, "/api/categories", peerQuery(r)) {
+		var stats []models.CatStat
+		if err := json.Unmarshal(res.body, &stats); err != nil {
+			models.Log.Warn("Invalid peer response", "peer", res.peer.name, "error", err)
+			continue
+		}
+		for _, s := range stats {
+			counts[s.Category] += s.Count
+		}
+	}
+}
+
+// fetchPeerFilterBins returns the filter bins of every reachable peer
+func (c *ServeCmd) fetchPeerFilterBins(r *http.Request, peers []*peerServer) []*models.FilterBinsResponse {
+	var parts []*models.FilterBinsResponse
+	for _, res := range c.fetchPeers(r.Context(), peers, "/api/filter-bins", peerQuery(r)) {
+		var bins models.FilterBinsResponse
+		if err := json.Unmarshal(res.body, &bins); err != nil {
+			models.Log.Warn("Invalid peer response", "peer", res.peer.name, "error", err)
+			continue
+		}
+		parts = append(parts, &bins)
+	}
+	return parts
+}
+
+// percentileDim is one slider of a FilterBinsResponse
+type percentileDim struct {
+	minVal, maxVal *int64
+	percentiles    *[]int64
+}
+
+func percentileDims(r *models.FilterBinsResponse) []percentileDim {
+	return []percentileDim{
+		{&r.EpisodesMinVal, &r.EpisodesMaxVal, &r.EpisodesPercentiles},
+		{&r.SizeMinVal, &r.SizeMaxVal, &r.SizePercentiles},
+		{&r.DurationMinVal, &r.DurationMaxVal, &r.DurationPercentiles},
+		{&r.ModifiedMinVal, &r.ModifiedMaxVal, &r.ModifiedPercentiles},
+		{&r.CreatedMinVal, &r.CreatedMaxVal, &r.CreatedPercentiles},
+		{&r.DownloadedMinVal, &r.DownloadedMaxVal, &r.DownloadedPercentiles},
+	}
+}
+
+// mergeFilterBins combines filter bins from several servers. Percentiles are merged
+// by weighting each server's distribution by its item count
+func mergeFilterBins(parts []*models.FilterBinsResponse) *models.FilterBinsResponse {
+	parts = slices.DeleteFunc(slices.Clone(parts), func(p *models.FilterBinsResponse) bool { return p == nil })
+	merged := &models.FilterBinsResponse{}
+	if len(parts) == 0 {
+		return merged
+	}
+
+	typeCounts := map[string]int64{}
+	damagedCounts := map[string]int64{}
+	weights := make([]float64, len(parts))
+	for i, p := range parts {
+		for _, bin := range p.MediaType {
+			typeCounts[bin.Label] += bin.Value
+			weights[i] += float64(bin.Value)
+		}
+		weights[i] = max(weights[i], 1)
+		for _, bin := range p.Damaged {
+			damagedCounts[bin.Label] += bin.Value
+		}
+	}
+	merged.MediaType = buildTypeBins(typeCounts)
+	merged.Damaged = buildTypeBins(damagedCounts)
+
+	out := percentileDims(merged)
+	for d := range out {
+		var lists [][]int64
+		var listWeights []float64
+		for i, p := range parts {
+			dim := percentileDims(p)[d]
+			if len(*dim.percentiles) == 0 {
+				continue
+			}
+			if len(lists) == 0 {
+				*out[d].minVal, *out[d].maxVal = *dim.minVal, *dim.maxVal
+			} else {
+				*out[d].minVal = min(*out[d].minVal, *dim.minVal)
+				*out[d].maxVal = max(*out[d].maxVal, *dim.maxVal)
+			}
+			lists = append(lists, *dim.percentiles)
+			listWeights = append(listWeights, weights[i])
+		}
+		*out[d].percentiles = mergePercentiles(lists, listWeights)
+	}
+	return merged
+}
+
+// mergePercentiles approximates the percentiles of the union of several
+// distributions from their percentile arrays
+func mergePercentiles(lists [][]int64, weights []float64) []int64 {
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		return lists[0]
+	}
+
+	var candidates []int64
+	var total float64
+	for i, l := range lists {
+		candidates = append(candidates, l...)
+		total += weights[i]
+	}
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+
+	// cdf[j] is the weighted share of values <= candidates[j]
+	cdf := make([]float64, len(candidates))
+	for j, v := range candidates {
+		for i, l := range lists {
+			n, _ := slices.BinarySearch(l, v+1)
+			cdf[j] += weights[i] * float64(n) / float64(len(l))
+		}
+		cdf[j] /= total
+	}
+
+	size := len(lists[0])
+	merged := make([]int64, size)
+	j := 0
+	for p := range size {
+		target := float64(p) / float64(size-1)
+		for j < len(candidates)-1 && cdf[j] < target {
+			j++
+		}
+		merged[p] = candidates[j]
+	}
+	return merged
+}
+
+func newPeerProxy(p *peerServer) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(p.base)
+			pr.Out.URL.RawQuery = peerQuery(pr.In).Encode()
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Set("X-Disco-Token", p.token)
+		},
+		// Stream video and HLS segments as they arrive
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			models.Log.Warn("Peer stream failed", "peer", p.name, "path", r.URL.Path, "error", err)
+			sendError(w, http.StatusBadGateway, "Peer unavailable")
+		},
+	}
+}
+
+// streamPeer finds the peer that owns the file a stream request asks for: the one
+// named by ?peer=, the peer a search returned the path from, or else the first peer
+// that has the path, for paths the server forgot or never searched, e.g. after a restart
+func (c *ServeCmd) streamPeer(r *http.Request) *peerServer {
+	if len(c.Peer) == 0 || r.URL.Query().Get("federate") == "false" {
+		return nil
+	}
+	servers, err := c.peerServers()
+	if err != nil {
+		return nil
+	}
+	q := r.URL.Query()
+	if name := strings.TrimRight(q.Get("peer"), "/"); name != "" {
+		for _, p := range servers {
+			if p.name == name {
+				return p
+			}
+		}
+		return nil
+	}
+
+	path := q.Get("path")
+	if path == "" {
+		return nil
+	}
+	// A file in a local database is served locally even if a peer has the same path
+	if found, _ := c.getMediaTypeFromDB(r.Context(), path); found {
+		return nil
+	}
+	if owner, ok := c.peers.origins.load(path); ok {
+		return owner
+	}
+	owner := c.findPeerOwner(r.Context(), servers, path)
+	if owner != nil {
+		c.peers.origins.store(path, owner)
+	}
+	return owner
+}
+
+// findPeerOwner asks every peer whether it has path and returns the first that does.
+// It uses /api/exists rather than the stream routes, which may transcode or mark
+// missing files deleted
+func (c *ServeCmd) findPeerOwner(ctx context.Context, peers []*peerServer, path string) *peerServer {
+	q := url.Values{"path": {path}, "federate": {"false"}}
+	found := make([]bool, len(peers))
+	var wg sync.WaitGroup
+	for i, p := range peers {
+		wg.Go(func() {
+			u := p.base.JoinPath("/api/exists")
+			u.RawQuery = q.Encode()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+			if err != nil {
+				return
+			}
+			req.Header.Set("X-Disco-Token", p.token)
+			resp, err := c.peers.client.Do(req)
+			if err != nil {
+				models.Log.Debug("Peer request failed", "peer", p.name, "path", path, "error", err)
+				return
+			}
+			resp.Body.Close()
+			found[i] = resp.StatusCode == http.StatusOK
+		})
+	}
+	wg.Wait()
+	if i := slices.Index(found, true); i >= 0 {
+		return peers[i]
+	}
+	return nil
+}
+
+// HandleExists reports whether path is in a local database, without touching the file.
+// Peers use it to find which server owns a path
+func (c *ServeCmd) HandleExists(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		sendError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	if found, _ := c.getMediaTypeFromDB(r.Context(), path); !found {
+		sendError(w, http.StatusNotFound, "Not found")
+		return
+	}
+	sendJSON(w, http.StatusOK, map[string]bool{"exists": true})
+}
+
+// peerStream proxies stream requests for files owned by a peer
+func (c *ServeCmd) peerStream(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p := c.streamPeer(r); p != nil {
+			p.proxy.ServeHTTP(w, r)
+			return
+		}
+		next(w, r)
+	}
+}
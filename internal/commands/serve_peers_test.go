@@ -0,0 +1,184 @@
+package commands_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// setupPeerServers starts a peer with two files and a local server that federates to it
+func setupPeerServers(t *testing.T) (local *commands.ServeCmd, localServer *httptest.Server, peerFile string) {
+	t.Helper()
+	dir := t.TempDir()
+	peerFile = filepath.Join(dir, "peer-b.mp4")
+	if err := os.WriteFile(peerFile, []byte("peer bytes"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	peer, peerServer, _ := setupServeServer(t, func(sqlDB *sql.DB) {
+		_, err := sqlDB.Exec(`INSERT INTO media (path, media_type, size, duration) VALUES
+			(?, 'video', 200, 60), ('/peer/d.mp4', 'video', 400, 60), ('/peer/e.mp3', 'audio', 600, 60)`, peerFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	peer.APIToken = "peer-token"
+
+	local, localServer, _ = setupServeServer(t, func(sqlDB *sql.DB) {
+		_, err := sqlDB.Exec(`INSERT INTO media (path, media_type, size, duration) VALUES
+			('/local/a.mp4', 'video', 100, 60), ('/local/c.mp4', 'video', 300, 60)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	local.Peer = []string{peerServer.URL}
+	local.PeerToken = []string{"peer-token"}
+	return local, localServer, peerFile
+}
+
+func peerQueryMedia(t *testing.T, cmd *commands.ServeCmd, server *httptest.Server, params string) ([]models.MediaWithDB, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/query?"+params, nil)
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var media []models.MediaWithDB
+	if err := json.NewDecoder(resp.Body).Decode(&media); err != nil {
+		t.Fatalf("Decode %s: %v", params, err)
+	}
+	return media, resp.Header.Get("X-Total-Count")
+}
+
+func TestServePeers_Query(t *testing.T) {
+	local, server, peerFile := setupPeerServers(t)
+
+	all, total := peerQueryMedia(t, local, server, "sort=size&limit=10")
+	var sizes []int64
+	for _, m := range all {
+		sizes = append(sizes, *m.Size)
+		if isPeer := m.Path == peerFile || filepath.Dir(m.Path) == "/peer"; isPeer != (m.Peer == local.Peer[0]) {
+			t.Errorf("%s tagged with peer %q", m.Path, m.Peer)
+		}
+	}
+	if want := []int64{100, 200, 300, 400, 600}; !slices.Equal(sizes, want) || total != "5" {
+		t.Fatalf("Merged sizes %v (total %s), want %v", sizes, total, want)
+	}
+
+	// Pages are cut from the merged order, not from each server
+	page, _ := peerQueryMedia(t, local, server, "sort=size&limit=2&offset=1")
+	if len(page) != 2 || page[0].Path != all[1].Path || page[1].Path != all[2].Path {
+		t.Errorf("Page mismatch: %+v", page)
+	}
+
+	// Peers are asked with federate=false so they don't fan out again
+	if media, _ := peerQueryMedia(t, local, server, "sort=size&federate=false"); len(media) != 2 {
+		t.Errorf("federate=false returned %d items", len(media))
+	}
+}
+
+func TestServePeers_CategoriesAndFilterBins(t *testing.T) {
+	local, server, _ := setupPeerServers(t)
+
+	code, body := serveRequest(t, server, local.APIToken, http.MethodGet, "/api/categories", "")
+	var cats []models.CatStat
+	json.Unmarshal([]byte(body), &cats)
+	if code != http.StatusOK || len(cats) == 0 || cats[len(cats)-1].Category != "Uncategorized" || cats[len(cats)-1].Count != 5 {
+		t.Errorf("Categories: %d %s", code, body)
+	}
+
+	code, body = serveRequest(t, server, local.APIToken, http.MethodGet, "/api/filter-bins", "")
+	var bins models.FilterBinsResponse
+	json.Unmarshal([]byte(body), &bins)
+	if code != http.StatusOK || bins.SizeMinVal != 100 || bins.SizeMaxVal != 600 {
+		t.Errorf("Filter bins: %d %s", code, body)
+	}
+	types := map[string]int64{}
+	for _, b := range bins.MediaType {
+		types[b.Label] = b.Value
+	}
+	if types["video"] != 4 || types["audio"] != 1 {
+		t.Errorf("Media types: %v", types)
+	}
+}
+
+func TestServePeers_StreamProxy(t *testing.T) {
+	local, server, peerFile := setupPeerServers(t)
+	raw := "/api/raw?path=" + url.QueryEscape(peerFile)
+
+	// A path no search returned yet, as after a restart, is looked up on the peers
+	code, body := serveRequest(t, server, local.APIToken, http.MethodGet, raw, "")
+	if code != http.StatusOK || body != "peer bytes" {
+		t.Errorf("Proxied raw before search: %d %q", code, body)
+	}
+	peerQueryMedia(t, local, server, "limit=10")
+	code, body = serveRequest(t, server, local.APIToken, http.MethodGet, raw, "")
+	if code != http.StatusOK || body != "peer bytes" {
+		t.Errorf("Proxied raw: %d %q", code, body)
+	}
+	missing := "/api/raw?path=" + url.QueryEscape(filepath.Join(filepath.Dir(peerFile), "nowhere.mp4"))
+	if code, _ := serveRequest(t, server, local.APIToken, http.MethodGet, missing, ""); code == http.StatusOK {
+		t.Errorf("Path unknown to every server was served")
+	}
+
+	// An explicit peer works without a prior search
+	local2, server2, peerFile2 := setupPeerServers(t)
+	code, body = serveRequest(t, server2, local2.APIToken, http.MethodGet,
+		"/api/raw?path="+url.QueryEscape(peerFile2)+"&peer="+url.QueryEscape(local2.Peer[0]), "")
+	if code != http.StatusOK || body != "peer bytes" {
+		t.Errorf("Explicit peer raw: %d %q", code, body)
+	}
+}
+
+func TestServePeers_SignedStream(t *testing.T) {
+	local, server, peerFile := setupPeerServers(t)
+
+	// A cast receiver streams with a URL signed by the local server, which the peer can't check
+	expires := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(local.APIToken))
+	mac.Write([]byte(peerFile + "\n" + expires))
+	q := url.Values{"path": {peerFile}, "expires": {expires}, "sig": {base64.RawURLEncoding.EncodeToString(mac.Sum(nil))}}
+	resp, err := http.Get(server.URL + "/api/raw?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "peer bytes" {
+		t.Errorf("Signed peer raw: %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestServePeers_Exists(t *testing.T) {
+	local, server, _ := setupPeerServers(t)
+
+	// /local/a.mp4 isn't on disk; looking it up must not mark it deleted
+	for range 2 {
+		if code, body := serveRequest(t, server, local.APIToken, http.MethodGet, "/api/exists?path=/local/a.mp4", ""); code != http.StatusOK {
+			t.Errorf("Exists: %d %s", code, body)
+		}
+	}
+	if code, _ := serveRequest(t, server, local.APIToken, http.MethodGet, "/api/exists?path=/local/b.mp4", ""); code != http.StatusNotFound {
+		t.Errorf("Exists for an unknown path: %d", code)
+	}
+	if code, _ := serveRequest(t, server, local.APIToken, http.MethodGet, "/api/exists?path=/peer/d.mp4", ""); code != http.StatusNotFound {
+		t.Errorf("Exists looked at a peer: %d", code)
+	}
+}
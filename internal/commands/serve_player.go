@@ -0,0 +1,353 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/player"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// playerEventDelay coalesces bursts of mpv property changes into one event
+var playerEventDelay = 200 * time.Millisecond
+
+var playerObservedProperties = []string{"path", "pause", "volume", "duration", "seeking"}
+
+// externalPlayerInterval is how often the server looks for an mpv it did not start
+var externalPlayerInterval = 2 * time.Second
+
+// serverPlayer is the player process HandlePlay launched on the server machine
+type serverPlayer struct {
+	mu      sync.Mutex
+	backend player.Player
+	cmd     *exec.Cmd
+	path    string
+	exited  chan struct{}
+	cancel  context.CancelFunc
+}
+
+// playerState is returned by /api/player/* and pushed as "player" events
+type playerState struct {
+	Running bool `json:"running"`
+	player.Status
+}
+
+type playerRequest struct {
+	Seconds  float64 `json:"seconds"`
+	Relative bool    `json:"relative"`
+	Volume   *int    `json:"volume"`
+	Path     string  `json:"path"`
+}
+
+// startPlayer plays path on the server, replacing whatever was started before, and
+// returns the player it launched
+func (c *ServeCmd) startPlayer(ctx context.Context, path string) (player.Player, error) {
+	c.remote.mu.Lock()
+	defer c.remote.mu.Unlock()
+	c.remote.killLocked()
+
+	p := playerFor(c.PlaybackFlags)
+	models.Log.Info("Playing", "path", path, "player", p.Name())
+	// The player outlives the request that started it
+	cmd := p.Command(context.WithoutCancel(ctx), []string{path}, player.LaunchOptions{
+		Volume:     c.Volume,
+		Mute:       c.Mute,
+		Fullscreen: c.Fullscreen,
+	})
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	observeCtx, cancel := context.WithCancel(context.Background())
+	exited := make(chan struct{})
+	c.remote.backend, c.remote.cmd, c.remote.path = p, cmd, path
+	c.remote.exited, c.remote.cancel = exited, cancel
+
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+		cancel()
+
+		c.remote.mu.Lock()
+		current := c.remote.cmd == cmd
+		if current {
+			c.remote.backend, c.remote.cmd, c.remote.path = nil, nil, ""
+		}
+		c.remote.mu.Unlock()
+		// A replaced player exiting is not news
+		if current {
+			c.events.publish(eventPlayer, playerState{Running: false})
+		}
+	}()
+	if mpv, ok := p.(*player.Mpv); ok {
+		go c.observePlayer(observeCtx, mpv)
+	}
+
+	c.events.publish(eventPlayer, playerState{Running: true, Status: player.Status{Backend: p.Name(), Path: path}})
+	return p, nil
+}
+
+// killLocked ends the tracked process and waits for it to exit
+func (s *serverPlayer) killLocked() {
+	if s.cmd == nil {
+		return
+	}
+	s.cancel()
+	if s.cmd.Process != nil {
+		_ = s.cmd.Process.Kill()
+	}
+	<-s.exited
+}
+
+// tracked returns the running player HandlePlay started, if any
+func (s *serverPlayer) tracked() (player.Player, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend, s.path
+}
+
+func (s *serverPlayer) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cancel()
+	}
+}
+
+// observePlayer follows mpv property changes and pushes them to browsers
+func (c *ServeCmd) observePlayer(ctx context.Context, mpv *player.Mpv) {
+	changed := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(playerEventDelay):
+			}
+			c.publishPlayerState(ctx, mpv)
+		}
+	}()
+
+	err := utils.ObserveMpv(ctx, mpv.Socket, playerObservedProperties, func(ev utils.MpvEvent) {
+		if ev.Name == "seeking" && ev.Data == true {
+			return
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		models.Log.Debug("Stopped observing player", "error", err)
+	}
+}
+
+// watchExternalPlayer follows an mpv started outside the server, such as by disco watch
+// or listen, so browsers see what is currently playing there too
+func (c *ServeCmd) watchExternalPlayer(ctx context.Context) {
+	mpv := player.New(player.Config{Backend: player.BackendMpv, MpvSocket: c.MpvSocket}).(*player.Mpv)
+	ticker := time.NewTicker(externalPlayerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// A player HandlePlay started is already observed. A stale socket file does not answer
+		if tracked, _ := c.remote.tracked(); tracked != nil || !utils.FileExists(mpv.Socket) {
+			continue
+		}
+		if _, err := utils.MpvGetProperty(ctx, mpv.Socket, "path"); err != nil {
+			continue
+		}
+
+		c.publishPlayerState(ctx, mpv)
+		observeCtx, cancel := context.WithCancel(ctx)
+		c.observePlayer(observeCtx, mpv)
+		cancel()
+		if tracked, _ := c.remote.tracked(); tracked == nil && ctx.Err() == nil {
+			c.events.publish(eventPlayer, playerState{Running: false})
+		}
+	}
+}
+
+// playerController returns the tracked player, or any mpv/VLC already running on this machine
+func (c *ServeCmd) playerController(ctx context.Context) (player.Controller, error) {
+	if p, _ := c.remote.tracked(); p != nil {
+		return p, nil
+	}
+	return player.Running(ctx, player.Config{MpvSocket: c.MpvSocket, VlcRC: c.VlcRC, VlcHTTP: c.VlcHTTP})
+}
+
+func (c *ServeCmd) currentPlayerState(ctx context.Context, p player.Controller) playerState {
+	st, err := p.Status(ctx)
+	if err != nil {
+		// mpv may not have opened its socket yet, or the backend cannot report status
+		tracked, path := c.remote.tracked()
+		if tracked == nil {
+			return playerState{Running: false}
+		}
+		return playerState{Running: true, Status: player.Status{Backend: tracked.Name(), Path: path}}
+	}
+	return playerState{Running: true, Status: st}
+}
+
+func (c *ServeCmd) publishPlayerState(ctx context.Context, p player.Controller) playerState {
+	state := c.currentPlayerState(ctx, p)
+	c.events.publish(eventPlayer, state)
+	return state
+}
+
+// HandlePlayer remote-controls server-side playback: GET status; POST pause, seek,
+// next, stop, volume and queue
+func (c *ServeCmd) HandlePlayer(w http.ResponseWriter, r *http.Request) {
+	action := r.PathValue("action")
+	ctx := r.Context()
+
+	if action == "status" {
+		if r.Method != http.MethodGet {
+			sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+		p, err := c.playerController(ctx)
+		if err != nil {
+			sendJSON(w, http.StatusOK, playerState{Running: false})
+			return
+		}
+		sendJSON(w, http.StatusOK, c.currentPlayerState(ctx, p))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req playerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if action == "queue" {
+		c.handlePlayerQueue(w, r, req.Path)
+		return
+	}
+
+	p, err := c.playerController(ctx)
+	if err != nil {
+		sendError(w, http.StatusConflict, "No active player")
+		return
+	}
+
+	switch action {
+	case "pause":
+		err = p.Pause(ctx)
+	case "next":
+		err = p.Next(ctx)
+	case "seek":
+		err = p.Seek(ctx, req.Seconds, req.Relative)
+	case "stop":
+		err = c.stopPlayer(ctx, p)
+	case "volume":
+		mixer, ok := p.(player.Mixer)
+		switch {
+		case req.Volume == nil || *req.Volume < 0:
+			sendError(w, http.StatusBadRequest, "volume must be a non-negative percentage")
+			return
+		case !ok:
+			err = player.ErrUnsupported
+		default:
+			err = mixer.SetVolume(ctx, *req.Volume)
+		}
+	default:
+		sendError(w, http.StatusNotFound, "Unknown player action")
+		return
+	}
+	if err != nil {
+		c.sendPlayerError(w, action, err)
+		return
+	}
+
+	if action == "stop" {
+		sendJSON(w, http.StatusOK, playerState{Running: false})
+		return
+	}
+	sendJSON(w, http.StatusOK, c.publishPlayerState(ctx, p))
+}
+
+// stopPlayer asks the player to stop, and kills the tracked process when it cannot be asked
+func (c *ServeCmd) stopPlayer(ctx context.Context, p player.Controller) error {
+	err := p.Stop(ctx)
+	if tracked, _ := c.remote.tracked(); tracked != nil && tracked == p {
+		if err != nil {
+			c.remote.mu.Lock()
+			c.remote.killLocked()
+			c.remote.mu.Unlock()
+		}
+		return nil
+	}
+	if err == nil {
+		c.events.publish(eventPlayer, playerState{Running: false})
+	}
+	return err
+}
+
+// handlePlayerQueue appends to the running player's playlist, or starts playback when nothing is playing
+func (c *ServeCmd) handlePlayerQueue(w http.ResponseWriter, r *http.Request, path string) {
+	ctx := r.Context()
+	if path == "" {
+		sendError(w, http.StatusBadRequest, "path is required")
+		return
+	}
+	if !strings.HasPrefix(path, "http") && !utils.FileExists(path) {
+		sendError(w, http.StatusNotFound, "File not found")
+		return
+	}
+
+	p, err := c.playerController(ctx)
+	if err != nil {
+		// The player may already have exited, so don't read it back from c.remote
+		started, err := c.startPlayer(ctx, path)
+		if err != nil {
+			c.sendPlayerError(w, "queue", err)
+			return
+		}
+		sendJSON(w, http.StatusOK, playerState{Running: true, Status: player.Status{Backend: started.Name(), Path: path}})
+		return
+	}
+
+	queuer, ok := p.(player.Queuer)
+	if !ok {
+		c.sendPlayerError(w, "queue", player.ErrUnsupported)
+		return
+	}
+	if err := queuer.Append(ctx, path); err != nil {
+		c.sendPlayerError(w, "queue", err)
+		return
+	}
+	sendJSON(w, http.StatusOK, c.publishPlayerState(ctx, p))
+}
+
+func (c *ServeCmd) sendPlayerError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, player.ErrUnsupported) {
+		sendError(w, http.StatusNotImplemented, err.Error())
+		return
+	}
+	models.Log.Error("Player command failed", "action", action, "error", err)
+	sendError(w, http.StatusBadGateway, "Player command failed: "+err.Error())
+}
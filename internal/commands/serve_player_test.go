@@ -0,0 +1,289 @@
+package commands_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// fakeMpvServer answers get_property from props and records every other command
+type fakeMpvServer struct {
+	mu       sync.Mutex
+	props    map[string]any
+	commands []string
+}
+
+func startFakeMpvServer(t *testing.T) (string, *fakeMpvServer) {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "mpv.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeMpvServer{props: map[string]any{
+		"path": "/media/a.mkv", "time-pos": 42.0, "duration": 100.0, "pause": false, "volume": 80.0,
+	}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				scanner := bufio.NewScanner(c)
+				for scanner.Scan() {
+					var cmd utils.MpvCommand
+					json.Unmarshal(scanner.Bytes(), &cmd)
+					resp := utils.MpvResponse{Error: "success"}
+
+					s.mu.Lock()
+					var parts []string
+					for _, a := range cmd.Command {
+						b, _ := json.Marshal(a)
+						parts = append(parts, strings.Trim(string(b), `"`))
+					}
+					switch {
+					case len(cmd.Command) == 2 && cmd.Command[0] == "get_property":
+						resp.Data = s.props[cmd.Command[1].(string)]
+					case len(cmd.Command) == 3 && cmd.Command[0] == "set_property":
+						s.props[cmd.Command[1].(string)] = cmd.Command[2]
+						s.commands = append(s.commands, strings.Join(parts, " "))
+					case len(parts) > 0 && parts[0] == "cycle":
+						s.props["pause"] = !s.props["pause"].(bool)
+						s.commands = append(s.commands, strings.Join(parts, " "))
+					default:
+						s.commands = append(s.commands, strings.Join(parts, " "))
+					}
+					s.mu.Unlock()
+
+					b, _ := json.Marshal(resp)
+					c.Write(append(b, '\n'))
+				}
+			}(conn)
+		}
+	}()
+	return socketPath, s
+}
+
+func (s *fakeMpvServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.commands)
+}
+
+func playerRequest(t *testing.T, cmd *commands.ServeCmd, method, action, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := cmd.Mux()
+	req := httptest.NewRequest(method, "/api/player/"+action, strings.NewReader(body))
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func postPlayer(t *testing.T, cmd *commands.ServeCmd, action, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return playerRequest(t, cmd, http.MethodPost, action, body)
+}
+
+func TestServePlayerControl(t *testing.T) {
+	models.SetupLogging(0)
+	socketPath, mpv := startFakeMpvServer(t)
+
+	cmd := &commands.ServeCmd{}
+	cmd.MpvSocket = socketPath
+	cmd.VlcRC = "127.0.0.1:1"
+	defer cmd.Close()
+
+	w := playerRequest(t, cmd, http.MethodGet, "status", "")
+	var state map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &state); err != nil {
+		t.Fatalf("Bad status response %q: %v", w.Body.String(), err)
+	}
+	if state["running"] != true || state["backend"] != "mpv" || state["path"] != "/media/a.mkv" ||
+		state["position"] != 42.0 || state["volume"] != 80.0 {
+		t.Errorf("Unexpected status: %v", state)
+	}
+
+	if w := postPlayer(t, cmd, "pause", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"paused":true`) {
+		t.Errorf("pause: %d %s", w.Code, w.Body.String())
+	}
+	if w := postPlayer(t, cmd, "seek", `{"seconds": -10, "relative": true}`); w.Code != http.StatusOK {
+		t.Errorf("seek: %d %s", w.Code, w.Body.String())
+	}
+	if w := postPlayer(t, cmd, "volume", `{"volume": 55}`); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"volume":55`) {
+		t.Errorf("volume: %d %s", w.Code, w.Body.String())
+	}
+	if w := postPlayer(t, cmd, "volume", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("volume without a level: expected 400, got %d", w.Code)
+	}
+
+	queued := filepath.Join(t.TempDir(), "b.mkv")
+	os.WriteFile(queued, []byte("x"), 0o644)
+	if w := postPlayer(t, cmd, "queue", `{"path": "`+queued+`"}`); w.Code != http.StatusOK {
+		t.Errorf("queue: %d %s", w.Code, w.Body.String())
+	}
+	if w := postPlayer(t, cmd, "queue", `{"path": "/nope/missing.mkv"}`); w.Code != http.StatusNotFound {
+		t.Errorf("queue of a missing file: expected 404, got %d", w.Code)
+	}
+	if w := postPlayer(t, cmd, "rewind", ""); w.Code != http.StatusNotFound {
+		t.Errorf("unknown action: expected 404, got %d", w.Code)
+	}
+
+	want := []string{"cycle pause", "seek -10 relative", "set_property volume 55", "loadfile " + queued + " append-play"}
+	if got := mpv.received(); !slices.Equal(got, want) {
+		t.Errorf("mpv received %q, want %q", got, want)
+	}
+}
+
+func TestServePlayerNotRunning(t *testing.T) {
+	models.SetupLogging(0)
+	cmd := &commands.ServeCmd{}
+	cmd.MpvSocket = filepath.Join(t.TempDir(), "none.sock")
+	cmd.VlcRC = "127.0.0.1:1"
+	defer cmd.Close()
+
+	w := playerRequest(t, cmd, http.MethodGet, "status", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"running":false`) {
+		t.Errorf("status: %d %s", w.Code, w.Body.String())
+	}
+
+	for _, action := range []string{"pause", "next", "stop"} {
+		if w := postPlayer(t, cmd, action, ""); w.Code != http.StatusConflict {
+			t.Errorf("%s with no player: expected 409, got %d", action, w.Code)
+		}
+	}
+
+	if w := playerRequest(t, cmd, http.MethodGet, "pause", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET pause: expected 405, got %d", w.Code)
+	}
+
+	// Queueing starts a player; one that exits at once must not break the response
+	cmd.OverridePlayer = "true"
+	queued := filepath.Join(t.TempDir(), "a.mkv")
+	os.WriteFile(queued, []byte("x"), 0o644)
+	if w := postPlayer(t, cmd, "queue", `{"path": "`+queued+`"}`); w.Code != http.StatusOK ||
+		!strings.Contains(w.Body.String(), `"backend":"command"`) {
+		t.Errorf("queue with a short-lived player: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestServePlayerEvents(t *testing.T) {
+	models.SetupLogging(0)
+	socketPath, _ := startFakeMpvServer(t)
+
+	cmd := &commands.ServeCmd{}
+	cmd.MpvSocket = socketPath
+	defer cmd.Close()
+	server := httptest.NewServer(cmd.Mux())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/events", nil)
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	reader := bufio.NewReader(resp.Body)
+	// Wait for the stream to be established before acting
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ":") {
+		t.Fatalf("Expected keep-alive comment, got %q %v", line, err)
+	}
+
+	req, _ = http.NewRequest(http.MethodPost, server.URL+"/api/player/pause", nil)
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	post, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post.Body.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		var event string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event = strings.TrimSpace(name)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok && event == "player" {
+				got <- strings.TrimSpace(data)
+				return
+			}
+		}
+	}()
+
+	select {
+	case data := <-got:
+		if !strings.Contains(data, `"running":true`) || !strings.Contains(data, `"paused":true`) {
+			t.Errorf("Unexpected player event: %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No player event after pause")
+	}
+}
+
+func TestServePlayerExternalEvents(t *testing.T) {
+	models.SetupLogging(0)
+	// An mpv the server did not start, as from disco watch
+	socketPath, _ := startFakeMpvServer(t)
+
+	cmd := &commands.ServeCmd{}
+	cmd.MpvSocket = socketPath
+	defer cmd.Close()
+	server := httptest.NewServer(cmd.Mux())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/events?types=player", nil)
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	got := make(chan string, 1)
+	go func() {
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				got <- strings.TrimSpace(data)
+				return
+			}
+		}
+	}()
+
+	select {
+	case data := <-got:
+		if !strings.Contains(data, `"running":true`) || !strings.Contains(data, `"path":"/media/a.mkv"`) {
+			t.Errorf("Unexpected player event: %s", data)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("No player event for the running mpv")
+	}
+}
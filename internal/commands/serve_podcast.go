@@ -0,0 +1,486 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	database "github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// podcastItemsTTL bounds how long the items of a feed are reused to authorize its raw
+// and thumbnail requests. Players send many Range requests for each episode
+const podcastItemsTTL = time.Minute
+
+// podcastItemCache holds the item paths of recently requested feeds by feed token
+type podcastItemCache struct {
+	mu    sync.Mutex
+	feeds map[string]podcastItems
+}
+
+type podcastItems struct {
+	paths   map[string]bool
+	expires time.Time
+}
+
+// podcastFeedResponse is a published feed as returned by /api/podcasts
+type podcastFeedResponse struct {
+	database.PodcastFeed
+
+	URL string `json:"url"`
+	DB  string `json:"db"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string     `xml:"title"`
+	Link        string     `xml:"link"`
+	Description string     `xml:"description"`
+	Author      string     `xml:"itunes:author"`
+	Image       *rssImage  `xml:"itunes:image,omitempty"`
+	Items       []rssEntry `xml:"item"`
+}
+
+type rssImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssEntry struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate,omitempty"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Duration    int64        `xml:"itunes:duration,omitempty"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Image       *rssImage    `xml:"itunes:image,omitempty"`
+}
+
+// requestBaseURL returns scheme://host for building absolute links
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// HandlePodcasts manages published podcast feeds.
+// GET lists feeds, POST {title, kind, value} publishes one, DELETE ?token=... revokes one
+func (c *ServeCmd) HandlePodcasts(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		c.handleGetPodcasts(w, r)
+		return
+	}
+
+	if c.ReadOnly {
+		http.Error(w, "Read-only mode", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		c.handlePostPodcast(w, r)
+	case http.MethodDelete:
+		c.handleDeletePodcast(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (c *ServeCmd) handleGetPodcasts(w http.ResponseWriter, r *http.Request) {
+	base := requestBaseURL(r)
+	feeds := []podcastFeedResponse{}
+	for _, dbPath := range c.Databases {
+		err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			pfs, err := database.New(sqlDB).GetPodcastFeeds(ctx)
+			if err != nil {
+				return err
+			}
+			for _, pf := range pfs {
+				feeds = append(feeds, podcastFeedResponse{
+					PodcastFeed: pf,
+					URL:         base + "/podcast/" + pf.Token,
+					DB:          dbPath,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			models.Log.Error("Failed to fetch podcast feeds", "db", dbPath, "error", err)
+		}
+	}
+	sendJSON(w, http.StatusOK, feeds)
+}
+
+func (c *ServeCmd) handlePostPodcast(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Title string `json:"title"`
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req.Value = strings.TrimSpace(req.Value)
+	switch req.Kind {
+	case database.PodcastKindPlaylist, database.PodcastKindFolder:
+	case database.PodcastKindQuery:
+		req.Value = strings.TrimPrefix(req.Value, "?")
+		if _, err := url.ParseQuery(req.Value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid query: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "kind must be one of playlist, folder, query", http.StatusBadRequest)
+		return
+	}
+	if req.Value == "" && req.Kind != database.PodcastKindQuery {
+		http.Error(w, "Value required", http.StatusBadRequest)
+		return
+	}
+	if req.Title == "" {
+		req.Title = req.Value
+	}
+	if len(c.Databases) == 0 {
+		http.Error(w, "No databases", http.StatusInternalServerError)
+		return
+	}
+
+	// Feeds span every database but are stored in the first one
+	dbPath := c.Databases[0]
+	pf := database.PodcastFeed{
+		Token: utils.RandomString(32),
+		Title: req.Title,
+		Kind:  req.Kind,
+		Value: req.Value,
+	}
+	err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		return database.New(sqlDB).InsertPodcastFeed(ctx, database.InsertPodcastFeedParams{
+			Token: pf.Token,
+			Title: pf.Title,
+			Kind:  pf.Kind,
+			Value: pf.Value,
+		})
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	models.Log.Info("Published podcast feed", "title", pf.Title, "kind", pf.Kind, "value", pf.Value)
+	sendJSON(w, http.StatusCreated, podcastFeedResponse{
+		PodcastFeed: pf,
+		URL:         requestBaseURL(r) + "/podcast/" + pf.Token,
+		DB:          dbPath,
+	})
+}
+
+func (c *ServeCmd) handleDeletePodcast(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "Token required", http.StatusBadRequest)
+		return
+	}
+
+	found := false
+	for _, dbPath := range c.Databases {
+		err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			deleted, err := database.New(sqlDB).DeletePodcastFeed(ctx, token)
+			found = found || deleted
+			return err
+		})
+		if err != nil {
+			models.Log.Error("Failed to delete podcast feed", "db", dbPath, "error", err)
+		}
+	}
+
+	if !found {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+	c.podcastItems.mu.Lock()
+	delete(c.podcastItems.feeds, token)
+	c.podcastItems.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+// findPodcastFeed looks up a feed token in every database
+func (c *ServeCmd) findPodcastFeed(ctx context.Context, token string) (database.PodcastFeed, bool) {
+	if token == "" {
+		return database.PodcastFeed{}, false
+	}
+	for _, dbPath := range c.Databases {
+		var pf database.PodcastFeed
+		err := c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			var err error
+			pf, err = database.New(sqlDB).GetPodcastFeed(ctx, token)
+			return err
+		})
+		if err == nil {
+			return pf, true
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			models.Log.Error("Failed to look up podcast feed", "db", dbPath, "error", err)
+		}
+	}
+	return database.PodcastFeed{}, false
+}
+
+// podcastFeedMedia resolves a feed definition to its playable items
+func (c *ServeCmd) podcastFeedMedia(ctx context.Context, pf database.PodcastFeed) ([]models.MediaWithDB, error) {
+	var media []models.MediaWithDB
+
+	switch pf.Kind {
+	case database.PodcastKindPlaylist:
+		for _, dbPath := range c.Databases {
+			err := c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+				queries := database.New(sqlDB)
+				playlistID, err := c.findPlaylistID(ctx, queries, pf.Value)
+				if err != nil || playlistID == -1 {
+					return err
+				}
+				items, err := c.fetchPlaylistMedia(ctx, queries, playlistID, dbPath)
+				for _, m := range items {
+					if m.TimeDeleted == nil || *m.TimeDeleted == 0 {
+						media = append(media, m)
+					}
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case database.PodcastKindFolder:
+		folder := pf.Value
+		if !strings.HasSuffix(folder, "/") && !strings.HasSuffix(folder, `\`) {
+			folder += string(filepath.Separator)
+		}
+		flags := c.GetGlobalFlags()
+		flags.Paths = []string{folder + "%"}
+		flags.SortBy = "time_created"
+		flags.Reverse = true
+		flags.HideDeleted = true
+
+		var err error
+		media, err = query.MediaQuery(ctx, c.Databases, flags)
+		if err != nil {
+			return nil, err
+		}
+
+	case database.PodcastKindQuery:
+		q, err := url.ParseQuery(pf.Value)
+		if err != nil {
+			return nil, err
+		}
+		flags := c.parseFlagValues(q)
+		flags.HideDeleted = true
+		dbs, err := c.getDBs(flags)
+		if err != nil {
+			return nil, err
+		}
+		media, err = query.MediaQuery(ctx, dbs, flags)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown podcast feed kind: %s", pf.Kind)
+	}
+
+	return c.filterPlayable(media, ""), nil
+}
+
+// rawMimeType is the MIME type clients will receive from /api/raw
+func (c *ServeCmd) rawMimeType(m models.Media) (mimeType string, transcoded bool) {
+	if c.hasFfmpeg {
+		if strategy := utils.GetTranscodeStrategy(m); strategy.NeedsTranscode {
+			return strategy.TargetMime, true
+		}
+	}
+	if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(m.Path))); t != "" {
+		return t, false
+	}
+	if m.MediaType != nil && strings.HasPrefix(*m.MediaType, "video") {
+		return "video/mp4", false
+	}
+	return "audio/mpeg", false
+}
+
+func (c *ServeCmd) podcastEntry(m models.MediaWithDB, feedBase string) rssEntry {
+	title := m.Stem()
+	if m.Title != nil && *m.Title != "" {
+		title = *m.Title
+	}
+	pathParam := "?path=" + strings.ReplaceAll(url.QueryEscape(m.Path), "+", "%20")
+
+	entry := rssEntry{
+		Title: title,
+		GUID:  rssGUID{Value: m.Path},
+		Image: &rssImage{Href: feedBase + "/thumbnail" + pathParam},
+	}
+	if m.Description != nil {
+		entry.Description = *m.Description
+	}
+	if m.Artist != nil {
+		entry.Author = *m.Artist
+	}
+	if m.Duration != nil {
+		entry.Duration = *m.Duration
+	}
+
+	for _, ts := range []*int64{m.TimeCreated, m.TimeModified, m.TimeDownloaded} {
+		if ts != nil && *ts > 0 {
+			entry.PubDate = time.Unix(*ts, 0).UTC().Format(time.RFC1123Z)
+			break
+		}
+	}
+
+	mimeType, transcoded := c.rawMimeType(m.Media)
+	entry.Enclosure = rssEnclosure{URL: feedBase + "/raw" + pathParam, Type: mimeType}
+	// The size of a transcoded stream is unknown ahead of time
+	if m.Size != nil && !transcoded {
+		entry.Enclosure.Length = *m.Size
+	}
+	return entry
+}
+
+// HandlePodcastFeed renders a published feed as RSS 2.0 with iTunes tags.
+// GET /podcast/{token}
+func (c *ServeCmd) HandlePodcastFeed(w http.ResponseWriter, r *http.Request) {
+	pf, ok := c.findPodcastFeed(r.Context(), r.PathValue("token"))
+	if !ok {
+		http.Error(w, "Feed not found", http.StatusNotFound)
+		return
+	}
+
+	media, err := c.podcastFeedMedia(r.Context(), pf)
+	if err != nil {
+		models.Log.Error("Podcast feed query failed", "title", pf.Title, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	base := requestBaseURL(r)
+	feedBase := base + "/podcast/" + pf.Token
+	feed := rssFeed{
+		Version: "2.0",
+		Itunes:  "http://www.itunes.com/dtds/podcast-1.0.dtd",
+		Channel: rssChannel{
+			Title:       pf.Title,
+			Link:        base,
+			Description: fmt.Sprintf("Discoteca %s: %s", pf.Kind, pf.Value),
+			Author:      "Discoteca",
+		},
+	}
+	for _, m := range media {
+		feed.Channel.Items = append(feed.Channel.Items, c.podcastEntry(m, feedBase))
+	}
+	if len(feed.Channel.Items) > 0 {
+		feed.Channel.Image = feed.Channel.Items[0].Image
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		models.Log.Error("Failed to encode podcast feed", "error", err)
+	}
+}
+
+// podcastAuthorize checks that the requested path is an item of the feed named by the token
+func (c *ServeCmd) podcastAuthorize(w http.ResponseWriter, r *http.Request) bool {
+	token := r.PathValue("token")
+	c.podcastItems.mu.Lock()
+	items, cached := c.podcastItems.feeds[token]
+	c.podcastItems.mu.Unlock()
+
+	if !cached || time.Now().After(items.expires) {
+		pf, ok := c.findPodcastFeed(r.Context(), token)
+		if !ok {
+			http.Error(w, "Feed not found", http.StatusNotFound)
+			return false
+		}
+		media, err := c.podcastFeedMedia(r.Context(), pf)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return false
+		}
+		items = podcastItems{paths: make(map[string]bool, len(media)), expires: time.Now().Add(podcastItemsTTL)}
+		for _, m := range media {
+			items.paths[m.Path] = true
+		}
+
+		c.podcastItems.mu.Lock()
+		if c.podcastItems.feeds == nil {
+			c.podcastItems.feeds = make(map[string]podcastItems)
+		}
+		c.podcastItems.feeds[token] = items
+		c.podcastItems.mu.Unlock()
+	}
+
+	if items.paths[r.URL.Query().Get("path")] {
+		return true
+	}
+	http.Error(w, "Access denied: not in feed", http.StatusForbidden)
+	return false
+}
+
+// HandlePodcastRaw streams a feed item without the session token.
+// GET /podcast/{token}/raw?path=...
+func (c *ServeCmd) HandlePodcastRaw(w http.ResponseWriter, r *http.Request) {
+	if c.podcastAuthorize(w, r) {
+		c.HandleRaw(w, r)
+	}
+}
+
+// HandlePodcastThumbnail serves cover art for a feed item without the session token.
+// GET /podcast/{token}/thumbnail?path=...
+func (c *ServeCmd) HandlePodcastThumbnail(w http.ResponseWriter, r *http.Request) {
+	if c.podcastAuthorize(w, r) {
+		c.HandleThumbnail(w, r)
+	}
+}
+
+// registerPodcastRoutes registers the feed routes which authenticate by feed token
+// instead of the session token, since podcast apps cannot send the disco_token cookie
+func (c *ServeCmd) registerPodcastRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /podcast/{token}", c.HandlePodcastFeed)
+	mux.HandleFunc("GET /podcast/{token}/raw", c.HandlePodcastRaw)
+	mux.HandleFunc("GET /podcast/{token}/thumbnail", c.HandlePodcastThumbnail)
+}
@@ -0,0 +1,242 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+type podcastTestFeed struct {
+	Channel struct {
+		Title string `xml:"title"`
+		Items []struct {
+			Title     string `xml:"title"`
+			PubDate   string `xml:"pubDate"`
+			Duration  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+			Enclosure struct {
+				URL    string `xml:"url,attr"`
+				Length string `xml:"length,attr"`
+				Type   string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+func setupPodcastServer(t *testing.T) (cmd *commands.ServeCmd, mux http.Handler, show, other string) {
+	t.Helper()
+	models.SetupLogging(0)
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "podcast.db")
+
+	show = filepath.Join(tempDir, "show", "ep1.mp3")
+	other = filepath.Join(tempDir, "other", "song.mp3")
+	for _, p := range []string{show, other} {
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("ID3 audio data"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	if err := db.InitDB(context.Background(), sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec(`INSERT INTO media (path, title, media_type, duration, size, time_created, time_deleted) VALUES
+		(?, 'Episode One', 'audio', 1800, 14, 1700000000, 0),
+		(?, 'Song', 'audio', 200, 14, 1600000000, 0)`, show, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec(`INSERT INTO playlists (id, title, path, time_deleted) VALUES (1, 'Commute', 'Commute', 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sqlDB.Exec(`INSERT INTO playlist_items (playlist_id, media_path) VALUES (1, ?)`, other); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd = &commands.ServeCmd{Databases: []string{dbPath}}
+	t.Cleanup(func() { cmd.Close() })
+	return cmd, cmd.Mux(), show, other
+}
+
+func publishPodcast(t *testing.T, cmd *commands.ServeCmd, mux http.Handler, kind, value string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"title": "My Feed", "kind": kind, "value": value})
+	req := httptest.NewRequest(http.MethodPost, "/api/podcasts", bytes.NewBuffer(body))
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Token == "" || !strings.HasSuffix(resp.URL, "/podcast/"+resp.Token) {
+		t.Fatalf("Unexpected publish response: %+v", resp)
+	}
+	return resp.Token
+}
+
+func fetchPodcast(t *testing.T, mux http.Handler, token string) podcastTestFeed {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/podcast/"+token, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	var feed podcastTestFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("Invalid RSS: %v\n%s", err, w.Body.String())
+	}
+	return feed
+}
+
+func TestPodcastFolderFeed(t *testing.T) {
+	cmd, mux, show, other := setupPodcastServer(t)
+	token := publishPodcast(t, cmd, mux, "folder", filepath.Dir(show))
+
+	feed := fetchPodcast(t, mux, token)
+	if feed.Channel.Title != "My Feed" {
+		t.Errorf("Expected channel title 'My Feed', got %q", feed.Channel.Title)
+	}
+	if len(feed.Channel.Items) != 1 {
+		t.Fatalf("Expected 1 item, got %d", len(feed.Channel.Items))
+	}
+	item := feed.Channel.Items[0]
+	if item.Title != "Episode One" || item.Duration != "1800" || item.Enclosure.Length != "14" {
+		t.Errorf("Unexpected item: %+v", item)
+	}
+	if !strings.Contains(item.PubDate, "2023") {
+		t.Errorf("Expected pubDate from time_created, got %q", item.PubDate)
+	}
+	if item.Enclosure.Type != "audio/mpeg" {
+		t.Errorf("Expected audio/mpeg enclosure, got %q", item.Enclosure.Type)
+	}
+
+	t.Run("EnclosureStreamsWithoutSessionToken", func(t *testing.T) {
+		u, err := url.Parse(item.Enclosure.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodGet, u.RequestURI(), nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "ID3 audio data" {
+			t.Errorf("Expected file contents, got %d: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("TokenIsScopedToFeedItems", func(t *testing.T) {
+		req := httptest.NewRequest(
+			http.MethodGet,
+			"/podcast/"+token+"/raw?path="+url.QueryEscape(other),
+			nil,
+		)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/podcast/nope", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/podcasts?token="+token, nil)
+		req.Header.Set("X-Disco-Token", cmd.APIToken)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/podcast/"+token, nil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after revoke, got %d", w.Code)
+		}
+
+		// The items cached by the earlier enclosure request go with the feed
+		req = httptest.NewRequest(http.MethodGet, "/podcast/"+token+"/raw?path="+url.QueryEscape(show), nil)
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an item of a revoked feed, got %d", w.Code)
+		}
+	})
+}
+
+func TestPodcastPlaylistAndQueryFeeds(t *testing.T) {
+	cmd, mux, show, other := setupPodcastServer(t)
+
+	feed := fetchPodcast(t, mux, publishPodcast(t, cmd, mux, "playlist", "Commute"))
+	if len(feed.Channel.Items) != 1 || !strings.Contains(feed.Channel.Items[0].Enclosure.URL, url.QueryEscape(other)) {
+		t.Errorf("Expected playlist item %s, got %+v", other, feed.Channel.Items)
+	}
+
+	feed = fetchPodcast(t, mux, publishPodcast(t, cmd, mux, "query", "?search=Episode&search_type=substring"))
+	if len(feed.Channel.Items) != 1 || feed.Channel.Items[0].Title != "Episode One" {
+		t.Errorf("Expected query to match %s, got %+v", show, feed.Channel.Items)
+	}
+}
+
+func TestPodcastManagementRequiresAuth(t *testing.T) {
+	cmd, mux, _, _ := setupPodcastServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/podcasts", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401, got %d", w.Code)
+	}
+
+	body, _ := json.Marshal(map[string]string{"kind": "bogus", "value": "x"})
+	req = httptest.NewRequest(http.MethodPost, "/api/podcasts", bytes.NewBuffer(body))
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown kind, got %d", w.Code)
+	}
+}
@@ -160,6 +160,7 @@ func TimedQuery[T any](_ context.Context, dbPath, query string, args []any, fn f
 	start := time.Now()
 	result, err := fn()
 	duration := time.Since(start)
+	observeQuery(dbPath, duration)
 
 	// Record slow queries
 	if duration > db.SlowQueryThreshold && IsQueryStatsEnabled() {
@@ -195,5 +195,6 @@ func (c *ServeCmd) HandlePlaylistReorder(w http.ResponseWriter, r *http.Request)
 	}
 
 	c.updateTrackNumbers(r.Context(), finalItems)
+	c.notifyChange(eventPlaylist, playlistChange{Action: "reordered", Playlist: req.PlaylistTitle, Path: req.MediaPath})
 	w.WriteHeader(http.StatusOK)
 }
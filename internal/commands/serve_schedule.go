@@ -0,0 +1,420 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// scheduleInterval is how often the scheduler looks for due work
+var scheduleInterval = time.Minute
+
+const (
+	// defaultRescanHours applies to scan roots without their own hours_update_delay
+	defaultRescanHours = 24
+	// optimizeInterval is the minimum time between scheduled VACUUM/ANALYZE runs
+	optimizeInterval = 24 * time.Hour
+	// feedRetryInterval keeps a feed that keeps failing from being fetched every pass
+	feedRetryInterval = time.Hour
+)
+
+// scheduler rescans scan roots, refreshes feeds and runs database maintenance from
+// inside disco serve. Rescans, feed refreshes and optimize runs are queued as background
+// jobs so they show up in /api/jobs, and are recorded when their job ends
+type scheduler struct {
+	startOnce sync.Once
+	stop      context.CancelFunc
+	stopped   chan struct{}
+
+	// pass serializes scheduler passes from the timer and POST /api/schedule
+	pass    sync.Mutex
+	mu      sync.Mutex
+	lastRun time.Time
+	lastErr error
+	// feedsQueued is when a feed refresh was last queued for each database
+	feedsQueued map[string]time.Time
+}
+
+// scheduleStatus is returned by /api/schedule
+type scheduleStatus struct {
+	Enabled            bool                `json:"enabled"`
+	QuietHours         string              `json:"quiet_hours,omitempty"`
+	MaintenanceAllowed bool                `json:"maintenance_allowed"`
+	LastRun            int64               `json:"last_run,omitempty"`
+	LastError          string              `json:"last_error,omitempty"`
+	Roots              []rootSchedule      `json:"roots"`
+	Maintenance        []maintenanceStatus `json:"maintenance"`
+}
+
+type rootSchedule struct {
+	Database  string `json:"database"`
+	Path      string `json:"path"`
+	Available bool   `json:"available"`
+	LastScan  int64  `json:"last_scan,omitempty"`
+	NextScan  int64  `json:"next_scan"`
+}
+
+type maintenanceStatus struct {
+	Database               string `json:"database"`
+	FolderStatsLastRefresh int64  `json:"folder_stats_last_refresh,omitempty"`
+	FTSLastRebuild         int64  `json:"fts_last_rebuild,omitempty"`
+	OptimizeLastRun        int64  `json:"optimize_last_run,omitempty"`
+}
+
+// parseQuietHours parses a local hour range such as 1-6 or 22-4. The end hour is exclusive
+func parseQuietHours(s string) (start, end int, err error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("quiet hours must look like 1-6: %q", s)
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(from))
+	end, err2 := strconv.Atoi(strings.TrimSpace(to))
+	if err1 != nil || err2 != nil || start < 0 || start > 23 || end < 0 || end > 24 || start == end {
+		return 0, 0, fmt.Errorf("quiet hours must be two different hours between 0 and 24: %q", s)
+	}
+	return start, end, nil
+}
+
+// maintenanceAllowed reports whether scheduled work may run at now: always without
+// --quiet-hours, otherwise only inside them
+func (c *ServeCmd) maintenanceAllowed(now time.Time) bool {
+	if c.QuietHours == "" {
+		return true
+	}
+	start, end, err := parseQuietHours(c.QuietHours)
+	if err != nil {
+		return false
+	}
+	h := now.Hour()
+	if start < end {
+		return h >= start && h < end
+	}
+	return h >= start || h < end
+}
+
+// nextScan is when a scan root is due to be rescanned
+func (c *ServeCmd) nextScan(root db.ScanRoot) time.Time {
+	if !root.TimeModified.Valid || root.TimeModified.Int64 == 0 {
+		return time.Time{}
+	}
+	hours := int64(c.RescanHours)
+	if root.HoursUpdateDelay.Valid {
+		hours = root.HoursUpdateDelay.Int64
+	}
+	if hours <= 0 {
+		hours = defaultRescanHours
+	}
+	return time.Unix(root.TimeModified.Int64, 0).Add(time.Duration(hours) * time.Hour)
+}
+
+// rootAvailable reports whether a scan root can be scanned. Checking a root whose drive
+// is not attached would mark everything under it deleted, so a root with a recorded
+// volume needs that volume mounted. Other roots need files: an empty folder is most
+// likely the mount point of a drive that is not attached
+func rootAvailable(ctx context.Context, queries *db.Queries, path string) bool {
+	v, err := queries.GetVolume(ctx, path)
+	if err != nil {
+		return utils.DirHasEntries(path)
+	}
+	return utils.VolumeMounted(query.RecordedVolume(v)) && utils.DirExists(path)
+}
+
+// startScheduler runs a scheduler pass now and then every scheduleInterval
+func (c *ServeCmd) startScheduler() {
+	c.schedule.startOnce.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		c.schedule.mu.Lock()
+		c.schedule.stop = cancel
+		c.schedule.stopped = make(chan struct{})
+		c.schedule.mu.Unlock()
+
+		go func() {
+			defer close(c.schedule.stopped)
+			ticker := time.NewTicker(scheduleInterval)
+			defer ticker.Stop()
+			for {
+				c.runSchedule(ctx, time.Now())
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+func (s *scheduler) close() {
+	s.mu.Lock()
+	stop, stopped := s.stop, s.stopped
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-stopped
+}
+
+// runSchedule queues rescans of due scan roots and refreshes of due feeds and runs
+// maintenance. Outside quiet hours it does nothing
+func (c *ServeCmd) runSchedule(ctx context.Context, now time.Time) {
+	c.schedule.pass.Lock()
+	defer c.schedule.pass.Unlock()
+
+	var errs []error
+	if c.maintenanceAllowed(now) {
+		for _, dbPath := range c.Databases {
+			for _, schedule := range []func(context.Context, string, time.Time) error{
+				c.scheduleRescans, c.scheduleFeeds, c.scheduleMaintenance,
+			} {
+				if err := schedule(ctx, dbPath, now); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", dbPath, err))
+				}
+			}
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil && ctx.Err() == nil {
+		models.Log.Error("Scheduled work failed", "error", err)
+	}
+
+	c.schedule.mu.Lock()
+	c.schedule.lastRun, c.schedule.lastErr = now, err
+	c.schedule.mu.Unlock()
+}
+
+// scheduleRescans queues add and check jobs for scan roots whose delay has elapsed.
+// The root is marked scanned when its add job ends
+func (c *ServeCmd) scheduleRescans(ctx context.Context, dbPath string, now time.Time) error {
+	var due []string
+	err := c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		queries := db.New(sqlDB)
+		roots, err := queries.GetScanRoots(ctx)
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			if now.Before(c.nextScan(root)) {
+				continue
+			}
+			if !rootAvailable(ctx, queries, root.Path) {
+				models.Log.Debug("Scan root not available, skipping rescan", "path", root.Path)
+				continue
+			}
+			due = append(due, root.Path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range due {
+		args := jobArgs{Database: dbPath, Paths: []string{path}}
+		for _, kind := range []string{jobAdd, jobCheck} {
+			id, err := c.queueJobOnce(ctx, kind, args)
+			if err != nil {
+				return err
+			}
+			if id != 0 && kind == jobAdd {
+				models.Log.Info("Scheduled rescan", "path", path)
+			}
+		}
+	}
+	return nil
+}
+
+// scheduleFeeds queues a feeds job when any feed subscription is due for a refresh
+func (c *ServeCmd) scheduleFeeds(ctx context.Context, dbPath string, now time.Time) error {
+	c.schedule.mu.Lock()
+	last := c.schedule.feedsQueued[dbPath]
+	c.schedule.mu.Unlock()
+	if now.Sub(last) < feedRetryInterval {
+		return nil
+	}
+
+	var due bool
+	err := c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		feeds, err := db.New(sqlDB).GetFeedPlaylists(ctx)
+		for _, fp := range feeds {
+			due = due || feedIsDue(fp, now)
+		}
+		return err
+	})
+	if err != nil || !due {
+		return err
+	}
+	if _, err := c.queueJobOnce(ctx, jobFeeds, jobArgs{Database: dbPath}); err != nil {
+		return err
+	}
+
+	c.schedule.mu.Lock()
+	if c.schedule.feedsQueued == nil {
+		c.schedule.feedsQueued = make(map[string]time.Time)
+	}
+	c.schedule.feedsQueued[dbPath] = now
+	c.schedule.mu.Unlock()
+	return nil
+}
+
+// scheduleMaintenance refreshes folder_stats and FTS when they are stale and queues
+// a daily VACUUM/ANALYZE
+func (c *ServeCmd) scheduleMaintenance(ctx context.Context, dbPath string, now time.Time) error {
+	return c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		if err := db.RunMaintenance(ctx, sqlDB, db.DefaultMaintenanceConfig(), dbPath); err != nil {
+			return err
+		}
+		status, err := db.GetMaintenanceStatus(ctx, sqlDB)
+		if err != nil {
+			return err
+		}
+		if now.Sub(status.OptimizeLastRun) < optimizeInterval {
+			return nil
+		}
+		_, err = c.queueJobOnce(ctx, jobOptimize, jobArgs{Database: dbPath})
+		return err
+	})
+}
+
+// recordJobRun records a finished rescan or optimize run for the scheduler. Failed and
+// cancelled runs count too, so they wait for the usual delay instead of the next pass
+func (c *ServeCmd) recordJobRun(ctx context.Context, job db.Job, now time.Time) error {
+	if job.Kind != jobAdd && job.Kind != jobOptimize {
+		return nil
+	}
+	var args jobArgs
+	if err := json.Unmarshal([]byte(job.Args), &args); err != nil {
+		return err
+	}
+	dbPath := cmp.Or(args.Database, c.jobsDB())
+	return c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		if job.Kind == jobOptimize {
+			return db.MarkOptimized(ctx, sqlDB, now)
+		}
+		queries := db.New(sqlDB)
+		roots, err := queries.GetScanRoots(ctx)
+		if err != nil {
+			return err
+		}
+		for _, root := range roots {
+			if slices.Contains(args.Paths, root.Path) {
+				if err := queries.MarkScanRootScanned(ctx, root.ID, now.Unix()); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// queueJobOnce queues a job unless the same job is already waiting or running
+func (c *ServeCmd) queueJobOnce(ctx context.Context, kind string, args jobArgs) (int64, error) {
+	encoded, _ := json.Marshal(args)
+	var pending bool
+	err := c.execDB(ctx, c.jobsDB(), func(ctx context.Context, sqlDB *sql.DB) error {
+		jobs, err := db.New(sqlDB).GetUnfinishedJobs(ctx)
+		for _, j := range jobs {
+			pending = pending || (j.Kind == kind && j.Args == string(encoded))
+		}
+		return err
+	})
+	if err != nil || pending {
+		return 0, err
+	}
+	job, err := c.queueJob(ctx, kind, args)
+	return job.ID, err
+}
+
+func (c *ServeCmd) getScheduleStatus(ctx context.Context) scheduleStatus {
+	now := time.Now()
+	c.schedule.mu.Lock()
+	status := scheduleStatus{
+		Enabled:            c.Schedule && !c.ReadOnly,
+		QuietHours:         c.QuietHours,
+		MaintenanceAllowed: c.maintenanceAllowed(now),
+		Roots:              []rootSchedule{},
+		Maintenance:        []maintenanceStatus{},
+	}
+	if !c.schedule.lastRun.IsZero() {
+		status.LastRun = c.schedule.lastRun.Unix()
+	}
+	if c.schedule.lastErr != nil {
+		status.LastError = c.schedule.lastErr.Error()
+	}
+	c.schedule.mu.Unlock()
+
+	for _, dbPath := range c.Databases {
+		_ = c.execDB(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			roots, err := db.New(sqlDB).GetScanRoots(ctx)
+			if err != nil {
+				return err
+			}
+			for _, root := range roots {
+				rs := rootSchedule{
+					Database:  dbPath,
+					Path:      root.Path,
+					Available: rootAvailable(ctx, db.New(sqlDB), root.Path),
+					LastScan:  root.TimeModified.Int64,
+				}
+				if next := c.nextScan(root); !next.IsZero() {
+					rs.NextScan = next.Unix()
+				}
+				status.Roots = append(status.Roots, rs)
+			}
+
+			m, err := db.GetMaintenanceStatus(ctx, sqlDB)
+			if err != nil {
+				return err
+			}
+			ms := maintenanceStatus{Database: dbPath}
+			if !m.FolderStatsLastRefresh.IsZero() {
+				ms.FolderStatsLastRefresh = m.FolderStatsLastRefresh.Unix()
+			}
+			if !m.FTSLastRebuild.IsZero() {
+				ms.FTSLastRebuild = m.FTSLastRebuild.Unix()
+			}
+			if !m.OptimizeLastRun.IsZero() {
+				ms.OptimizeLastRun = m.OptimizeLastRun.Unix()
+			}
+			status.Maintenance = append(status.Maintenance, ms)
+			return nil
+		})
+	}
+	return status
+}
+
+// HandleSchedule reports scheduler status (GET) or runs a scheduler pass now (POST)
+func (c *ServeCmd) HandleSchedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		sendJSON(w, http.StatusOK, c.getScheduleStatus(r.Context()))
+	case http.MethodPost:
+		if c.ReadOnly {
+			sendError(w, http.StatusForbidden, "Read-only mode")
+			return
+		}
+		if len(c.Databases) == 0 {
+			sendError(w, http.StatusServiceUnavailable, "No database to schedule work for")
+			return
+		}
+		c.startJobs()
+		c.runSchedule(r.Context(), time.Now())
+		sendJSON(w, http.StatusOK, c.getScheduleStatus(r.Context()))
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,151 @@
+package commands_test
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+)
+
+type testSchedule struct {
+	Enabled            bool  `json:"enabled"`
+	MaintenanceAllowed bool  `json:"maintenance_allowed"`
+	LastRun            int64 `json:"last_run"`
+	Roots              []struct {
+		Path      string `json:"path"`
+		Available bool   `json:"available"`
+		NextScan  int64  `json:"next_scan"`
+	} `json:"roots"`
+}
+
+// setupScheduleServer serves a library with a due root, a root that is not mounted,
+// a root whose recorded volume is not mounted, a root that was scanned recently, and
+// a feed that is due for a refresh
+func setupScheduleServer(t *testing.T) (*commands.ServeCmd, *httptest.Server, string, *feedServer) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.mp4"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	recent := t.TempDir()
+	os.WriteFile(filepath.Join(recent, "b.mp4"), []byte("x"), 0o644)
+	unmounted := t.TempDir()
+	// Files left on the parent filesystem don't make an unplugged drive available
+	unplugged := t.TempDir()
+	os.WriteFile(filepath.Join(unplugged, "c.mp4"), []byte("x"), 0o644)
+	feed := newFeedServer(t)
+
+	cmd, server, _ := setupServeServer(t, func(sqlDB *sql.DB) {
+		_, err := sqlDB.Exec(`INSERT INTO playlists (path, extractor_key, time_modified, hours_update_delay, time_deleted) VALUES
+			(?, 'Local', 0, NULL, 0), (?, 'Local', 0, NULL, 0), (?, 'Local', 0, NULL, 0),
+			(?, 'Local', unixepoch(), 24, 0), (?, 'Feed', 0, 24, 0)`,
+			root, unmounted, unplugged, recent, feed.URL+"/feed.xml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = sqlDB.Exec(`INSERT INTO volumes (root, mount_point, uuid) VALUES (?, ?, 'unplugged')`,
+			unplugged, unplugged)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	return cmd, server, root, feed
+}
+
+func getSchedule(t *testing.T, cmd *commands.ServeCmd, server *httptest.Server, method string) testSchedule {
+	t.Helper()
+	code, body := serveRequest(t, server, cmd.APIToken, method, "/api/schedule", "")
+	if code != http.StatusOK {
+		t.Fatalf("%s schedule: %d %s", method, code, body)
+	}
+	var status testSchedule
+	json.Unmarshal([]byte(body), &status)
+	return status
+}
+
+func listJobs(t *testing.T, cmd *commands.ServeCmd, server *httptest.Server) []testJob {
+	t.Helper()
+	code, body := serveRequest(t, server, cmd.APIToken, http.MethodGet, "/api/jobs", "")
+	if code != http.StatusOK {
+		t.Fatalf("List jobs: %d %s", code, body)
+	}
+	var jobs []testJob
+	json.Unmarshal([]byte(body), &jobs)
+	return jobs
+}
+
+func TestServeSchedule_RescansDueRoots(t *testing.T) {
+	cmd, server, root, feed := setupScheduleServer(t)
+
+	status := getSchedule(t, cmd, server, http.MethodPost)
+	if status.Enabled || !status.MaintenanceAllowed || status.LastRun == 0 || len(status.Roots) != 4 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+	for _, r := range status.Roots {
+		// A queued rescan has not happened yet
+		if r.Path == root && (!r.Available || r.NextScan != 0) {
+			t.Errorf("Queued root should be available and still due: %+v", r)
+		}
+		if r.Path != root && r.Available != (r.NextScan > 0) {
+			t.Errorf("Unexpected root status: %+v", r)
+		}
+	}
+
+	jobs := listJobs(t, cmd, server)
+	var kinds []string
+	for _, j := range jobs {
+		kinds = append(kinds, j.Kind)
+	}
+	slices.Sort(kinds)
+	// The unmounted roots and the recently scanned root are left alone
+	if want := []string{"add", "check", "feeds", "optimize"}; !slices.Equal(kinds, want) {
+		t.Fatalf("Queued %v, want %v", kinds, want)
+	}
+	for _, j := range jobs {
+		if done := waitForJob(t, server, cmd.APIToken, j.ID); done.Status != db.JobDone {
+			t.Errorf("%s job: %+v", j.Kind, done)
+		}
+	}
+	if feed.fetches.Load() != 1 {
+		t.Errorf("Expected the due feed to be fetched once, got %d", feed.fetches.Load())
+	}
+	for _, r := range getSchedule(t, cmd, server, http.MethodGet).Roots {
+		if r.Path == root && r.NextScan <= time.Now().Unix() {
+			t.Errorf("Rescanned root should be scheduled later: %+v", r)
+		}
+	}
+
+	// Nothing is due on the next pass
+	getSchedule(t, cmd, server, http.MethodPost)
+	if jobs := listJobs(t, cmd, server); len(jobs) != 4 {
+		t.Errorf("Expected no new jobs, got %d", len(jobs))
+	}
+}
+
+func TestServeSchedule_QuietHours(t *testing.T) {
+	cmd, server, _, _ := setupScheduleServer(t)
+	h := time.Now().Hour()
+	cmd.QuietHours = fmt.Sprintf("%d-%d", (h+2)%24, (h+3)%24)
+
+	if status := getSchedule(t, cmd, server, http.MethodPost); status.MaintenanceAllowed {
+		t.Fatalf("Expected to be outside quiet hours: %+v", status)
+	}
+	// Neither rescans nor maintenance are queued outside quiet hours
+	if jobs := listJobs(t, cmd, server); len(jobs) != 0 {
+		t.Errorf("Expected no jobs outside quiet hours, got %+v", jobs)
+	}
+
+	cmd.ReadOnly = true
+	if code, _ := serveRequest(t, server, cmd.APIToken, http.MethodPost, "/api/schedule", ""); code != http.StatusForbidden {
+		t.Errorf("Read-only run: expected 403, got %d", code)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -146,6 +147,7 @@ func (c *ServeCmd) buildFormatArgs(strategy utils.TranscodeStrategy) []string {
 
 // runTranscodeCommand executes the ffmpeg command and handles errors
 func (c *ServeCmd) runTranscodeCommand(ctx context.Context, w http.ResponseWriter, path string, ffmpegArgs []string) {
+	defer c.trackFfmpeg("transcode")()
 	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
 	cmd.Stdout = w
 
@@ -290,6 +292,7 @@ func (c *ServeCmd) convertSubtitleToVTT(ctx context.Context, path, streamIndex s
 	ffmpegArgs := append([]string{"-hide_banner", "-loglevel", "error"}, args...)
 	models.Log.Debug("subtitle ffmpeg command", "args", strings.Join(ffmpegArgs, " "))
 
+	defer c.trackFfmpeg("subtitles")()
 	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
@@ -322,9 +325,8 @@ func (c *ServeCmd) HandleSubtitles(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !utils.FileExists(path) {
-		models.Log.Warn("File not found on disk, marking as deleted in databases", "path", path)
-		c.markDeletedInAllDBs(r.Context(), path, true)
-		http.Error(w, "File not found", http.StatusNotFound)
+		code, msg := c.fileMissing(r.Context(), path)
+		http.Error(w, msg, code)
 		return
 	}
 
@@ -587,6 +589,7 @@ func (c *ServeCmd) generateFallbackThumbnail(ctx context.Context, path, mediaTyp
 	}
 
 	ffmpegArgs := append([]string{"-hide_banner", "-loglevel", "error"}, args...)
+	defer c.trackFfmpeg("thumbnail")()
 	cmd := exec.CommandContext(ctx, "ffmpeg", ffmpegArgs...)
 	thumb, err := cmd.Output()
 
@@ -625,10 +628,12 @@ func (c *ServeCmd) HandleThumbnail(w http.ResponseWriter, r *http.Request) {
 	if !c.Dev {
 		if val, ok := c.thumbnailCache.Load(path); ok {
 			if data, ok := val.([]byte); ok {
+				c.metrics.thumbnailHits.Add(1)
 				c.writeThumbnailResponse(w, data, "image/jpeg")
 				return
 			}
 		}
+		c.metrics.thumbnailMisses.Add(1)
 	}
 
 	// Handle image files
@@ -718,7 +723,12 @@ func (c *ServeCmd) HandleHLSPlaylist(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
 	w.Header().Set("Cache-Control", "no-cache")
 
-	playlist := utils.GenerateHLSPlaylist(path, duration, HlsSegmentDuration)
+	// Segment requests must authenticate the same way the playlist request did
+	var extraQuery string
+	if q := r.URL.Query(); q.Has("sig") {
+		extraQuery = "&expires=" + url.QueryEscape(q.Get("expires")) + "&sig=" + url.QueryEscape(q.Get("sig"))
+	}
+	playlist := utils.GenerateHLSPlaylist(path, duration, HlsSegmentDuration, extraQuery)
 	fmt.Fprint(w, playlist)
 }
 
@@ -737,9 +747,8 @@ func (c *ServeCmd) HandleHLSSegment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !utils.FileExists(path) {
-		models.Log.Warn("File not found on disk, marking as deleted in databases", "path", path)
-		c.markDeletedInAllDBs(r.Context(), path, true)
-		http.Error(w, "File not found", http.StatusNotFound)
+		code, msg := c.fileMissing(r.Context(), path)
+		http.Error(w, msg, code)
 		return
 	}
 
@@ -779,6 +788,7 @@ func (c *ServeCmd) HandleHLSSegment(w http.ResponseWriter, r *http.Request) {
 	// Skip logging for segments to avoid spam
 	// models.Log.Debug("HLS Segment", "index", index, "start", startTime)
 
+	defer c.trackFfmpeg("hls")()
 	cmd := exec.CommandContext(
 		r.Context(),
 		"ffmpeg",
@@ -0,0 +1,124 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+)
+
+// maxSyncBatch bounds the changes returned by one GET /api/sync/changes
+const maxSyncBatch = 10000
+
+// syncDB is the database a sync request is for: ?db= or the first database
+func (c *ServeCmd) syncDB(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if len(c.Databases) == 0 {
+		sendError(w, http.StatusServiceUnavailable, "No database to sync")
+		return "", false
+	}
+	dbPath := r.URL.Query().Get("db")
+	if dbPath == "" {
+		return c.Databases[0], true
+	}
+	if _, err := c.filterDatabases([]string{dbPath}); err != nil {
+		sendError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return dbPath, true
+}
+
+// HandleSync returns the library's sync id and, with ?peer=, how far it has applied
+// that peer's changes. Used by disco sync
+func (c *ServeCmd) HandleSync(w http.ResponseWriter, r *http.Request) {
+	dbPath, ok := c.syncDB(w, r)
+	if !ok {
+		return
+	}
+	var state syncState
+	err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		var err error
+		if state.ID, err = db.GetSyncID(ctx, sqlDB); err != nil {
+			return err
+		}
+		if peer := r.URL.Query().Get("peer"); peer != "" {
+			state.Cursor, err = db.GetSyncCursor(ctx, sqlDB, peer)
+		}
+		return err
+	})
+	if err != nil {
+		sendError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sendJSON(w, http.StatusOK, state)
+}
+
+// HandleSyncChanges returns playstate changes after a cursor for another library (GET ?peer=)
+// or applies a batch of changes from it (POST ?peer=)
+func (c *ServeCmd) HandleSyncChanges(w http.ResponseWriter, r *http.Request) {
+	dbPath, ok := c.syncDB(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+
+	switch r.Method {
+	case http.MethodGet:
+		var cursor db.SyncCursor
+		cursor.ChangeSeq, _ = strconv.ParseInt(q.Get("change_seq"), 10, 64)
+		cursor.HistoryID, _ = strconv.ParseInt(q.Get("history_id"), 10, 64)
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil || limit <= 0 || limit > maxSyncBatch {
+			limit = maxSyncBatch
+		}
+
+		var batch db.SyncBatch
+		err = c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			var err error
+			batch, err = db.GetSyncBatch(ctx, sqlDB, q.Get("peer"), cursor, limit)
+			return err
+		})
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendJSON(w, http.StatusOK, batch)
+
+	case http.MethodPost:
+		if c.ReadOnly {
+			sendError(w, http.StatusForbidden, "Read-only mode")
+			return
+		}
+		peer := q.Get("peer")
+		if peer == "" {
+			sendError(w, http.StatusBadRequest, "peer is required")
+			return
+		}
+		var batch db.SyncBatch
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			sendError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		if err := batch.Validate(); err != nil {
+			sendError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var res db.SyncResult
+		err := c.execDB(r.Context(), dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			var err error
+			res, err = db.ApplySyncBatch(ctx, sqlDB, peer, batch)
+			return err
+		})
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sendJSON(w, http.StatusOK, res)
+
+	default:
+		sendError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
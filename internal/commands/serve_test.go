@@ -5,12 +5,17 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
 	"github.com/chapmanjacobd/discoteca/internal/testutils"
 )
 
@@ -22,6 +27,45 @@ func SetupTestServeCmd(dbPath string) *commands.ServeCmd {
 	return cmd
 }
 
+// setupServeServer creates a library database, runs seed against it, then serves it
+func setupServeServer(t *testing.T, seed func(sqlDB *sql.DB)) (*commands.ServeCmd, *httptest.Server, string) {
+	t.Helper()
+	models.SetupLogging(0)
+	dbPath := filepath.Join(t.TempDir(), "serve.db")
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InitDB(context.Background(), sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	if seed != nil {
+		seed(sqlDB)
+	}
+	sqlDB.Close()
+
+	cmd := &commands.ServeCmd{Databases: []string{dbPath}}
+	server := httptest.NewServer(cmd.Mux())
+	t.Cleanup(func() {
+		server.Close()
+		cmd.Close()
+	})
+	return cmd, server, dbPath
+}
+
+func serveRequest(t *testing.T, server *httptest.Server, token, method, path, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	req.Header.Set("X-Disco-Token", token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
 func TestServeCmd_HandlePlay_FileNotFound(t *testing.T) {
 	fixture := testutils.Setup(t)
 	defer fixture.Cleanup()
@@ -0,0 +1,57 @@
+package commands
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+)
+
+// offlineRootsTTL bounds how long a mount or unmount may go unnoticed by the server
+const offlineRootsTTL = 30 * time.Second
+
+type offlineCache struct {
+	mu      sync.Mutex
+	roots   []string
+	expires time.Time
+}
+
+// offlineRoots returns the scan roots whose volume is not mounted, re-checking the
+// mounts at most every offlineRootsTTL
+func (c *ServeCmd) offlineRoots(ctx context.Context) []string {
+	c.offline.mu.Lock()
+	defer c.offline.mu.Unlock()
+	if time.Now().Before(c.offline.expires) {
+		return c.offline.roots
+	}
+	c.offline.roots = query.OfflineRoots(ctx, c.Databases)
+	c.offline.expires = time.Now().Add(offlineRootsTTL)
+	return c.offline.roots
+}
+
+// fileMissing handles a media file that is not on disk. A file on an unmounted
+// volume is offline and keeps its database row; anything else is marked deleted.
+// It returns the status code and message to send
+func (c *ServeCmd) fileMissing(ctx context.Context, path string) (int, string) {
+	if query.PathOffline(path, c.offlineRoots(ctx)) {
+		models.Log.Info("File is on an offline volume", "path", path)
+		return http.StatusServiceUnavailable, "Volume offline"
+	}
+	models.Log.Warn("File not found on disk, marking as deleted in databases", "path", path)
+	c.markDeletedInAllDBs(ctx, path, true)
+	return http.StatusNotFound, "File not found"
+}
+
+// markOffline flags the media that is on an unmounted volume
+func (c *ServeCmd) markOffline(ctx context.Context, media []models.MediaWithDB) {
+	roots := c.offlineRoots(ctx)
+	if len(roots) == 0 {
+		return
+	}
+	for i := range media {
+		media[i].Offline = query.PathOffline(media[i].Path, roots)
+	}
+}
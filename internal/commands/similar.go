@@ -33,6 +33,11 @@ type SimilarFoldersCmd struct {
 	models.DisplayFlags     `embed:""`
 	models.SimilarityFlags  `embed:""`
 
+	Merge       bool   `help:"Merge each group of similar folders into one folder"`
+	MergeInto   string `help:"Folder of each group that receives the others' files" default:"largest" enum:"largest,most-files,newest,shortest-path"`
+	Trash       bool   `help:"Trash identical duplicate files instead of deleting them"`
+	DeleteWorse bool   `help:"Delete the worse of two different files with the same name instead of trashing it"`
+
 	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
 }
 
@@ -61,6 +66,9 @@ func (c *SimilarFoldersCmd) Run(ctx context.Context) error {
 		DisplayFlags:     c.DisplayFlags,
 		SimilarityFlags:  c.SimilarityFlags,
 	}
+	if c.Merge {
+		return c.runMerge(ctx, flags)
+	}
 	return runSimilar(ctx, flags, c.Databases, true)
 }
 
@@ -82,6 +90,11 @@ func runSimilar(ctx context.Context, flags models.GlobalFlags, dbs []string, fol
 }
 
 func runSimilarFolders(flags models.GlobalFlags, media []models.MediaWithDB) error {
+	groups := clusterSimilarFolders(flags, media)
+	return PrintFolders(flags.DisplayFlags, flags.Columns, groups)
+}
+
+func clusterSimilarFolders(flags models.GlobalFlags, media []models.MediaWithDB) []models.FolderStats {
 	// Defaults for similar folders
 	if !flags.FilterSizes && !flags.FilterDurations && !flags.FilterNames && !flags.FilterCounts {
 		flags.FilterCounts = true
@@ -103,7 +116,7 @@ func runSimilarFolders(flags models.GlobalFlags, media []models.MediaWithDB) err
 		groups = aggregate.ClusterFoldersByNumbers(flags, folders)
 	}
 
-	return PrintFolders(flags.DisplayFlags, flags.Columns, groups)
+	return groups
 }
 
 func refineFolderGroups(flags models.GlobalFlags, groups []models.FolderStats) []models.FolderStats {
@@ -0,0 +1,319 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// mergeFolder is one folder of a group of similar folders
+type mergeFolder struct {
+	path   string
+	files  []models.MediaWithDB
+	size   int64
+	newest int64
+}
+
+// folderMergeStep is one planned change of a folder merge
+type folderMergeStep struct {
+	kind string // move, duplicate, replace or keep
+	src  models.MediaWithDB
+	dst  string
+}
+
+func (c *SimilarFoldersCmd) runMerge(ctx context.Context, flags models.GlobalFlags) error {
+	models.SetupLogging(flags.Verbose)
+
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return err
+	}
+	media = query.FilterMedia(media, flags)
+
+	groups := clusterSimilarFolders(flags, media)
+	merged, total := 0, 0
+	for _, g := range groups {
+		folders := c.groupFolders(g)
+		if len(folders) < 2 {
+			continue
+		}
+		total++
+		dest := folders[0]
+		plan := planFolderMerge(dest, folders[1:])
+
+		fmt.Printf("Merge into %s:\n", dest.path)
+		printFolderMergePlan(plan, c.DeleteWorse)
+		if c.Simulate || (!c.NoConfirm && !utils.Confirm("Merge this group?")) {
+			fmt.Println()
+			continue
+		}
+		// utils.Trash unlinks when there is no trash command, which would lose the worse copies
+		if !c.DeleteWorse && dropsDifferentFiles(plan) && !trashAvailable() {
+			return errors.New("trash-put or trash is needed to set aside the worse copies; pass --delete-worse to delete them")
+		}
+
+		if err := c.applyFolderMerge(ctx, flags, plan, folders[1:]); err != nil {
+			return err
+		}
+		fmt.Println()
+		merged++
+	}
+
+	fmt.Printf("%d of %d groups merged\n", merged, total)
+	return nil
+}
+
+// groupFolders splits a group into its folders, ordered so that the folder chosen by
+// --merge-into comes first
+func (c *SimilarFoldersCmd) groupFolders(g models.FolderStats) []mergeFolder {
+	byPath := map[string]*mergeFolder{}
+	var folders []*mergeFolder
+	for _, m := range g.Files {
+		dir := m.Parent()
+		f, ok := byPath[dir]
+		if !ok {
+			f = &mergeFolder{path: dir}
+			byPath[dir] = f
+			folders = append(folders, f)
+		}
+		f.files = append(f.files, m)
+		f.size += utils.Int64Value(m.Size)
+		f.newest = max(f.newest, utils.Int64Value(m.TimeModified), utils.Int64Value(m.TimeCreated))
+	}
+
+	slices.SortStableFunc(folders, func(a, b *mergeFolder) int {
+		var cmp int64
+		switch c.MergeInto {
+		case "most-files":
+			cmp = int64(len(b.files) - len(a.files))
+		case "newest":
+			cmp = b.newest - a.newest
+		case "shortest-path":
+			cmp = int64(len(a.path) - len(b.path))
+		default:
+			cmp = b.size - a.size
+		}
+		if cmp == 0 {
+			return strings.Compare(a.path, b.path)
+		}
+		if cmp < 0 {
+			return -1
+		}
+		return 1
+	})
+
+	result := make([]mergeFolder, len(folders))
+	for i, f := range folders {
+		result[i] = *f
+	}
+	return result
+}
+
+// planFolderMerge decides what happens to each file of the other folders. Files whose
+// name is free in dest are moved; on a name collision identical files are dropped and
+// otherwise the better copy ends up in dest
+func planFolderMerge(dest mergeFolder, others []mergeFolder) []folderMergeStep {
+	inDest := map[string]models.MediaWithDB{}
+	for _, m := range dest.files {
+		inDest[filepath.Base(m.Path)] = m
+	}
+	// Files from other folders that an earlier step puts in dest, which are still at their old path
+	planned := map[string]models.MediaWithDB{}
+
+	var plan []folderMergeStep
+	for _, f := range others {
+		for _, m := range f.files {
+			if !utils.FileExists(m.Path) {
+				continue
+			}
+			name := filepath.Base(m.Path)
+			dst := filepath.Join(dest.path, name)
+			existing, occupied := planned[name]
+			if !occupied && utils.FileExists(dst) {
+				occupied = true
+				var known bool
+				if existing, known = inDest[name]; !known {
+					existing = models.MediaWithDB{Media: models.Media{Path: dst}}
+				}
+			}
+
+			switch {
+			case !occupied:
+				plan = append(plan, folderMergeStep{kind: "move", src: m, dst: dst})
+				planned[name] = m
+			case sameFileContents(m.Path, existing.Path):
+				plan = append(plan, folderMergeStep{kind: "duplicate", src: m, dst: dst})
+			case betterCopy(m, existing):
+				plan = append(plan, folderMergeStep{kind: "replace", src: m, dst: dst})
+				planned[name] = m
+			default:
+				plan = append(plan, folderMergeStep{kind: "keep", src: m, dst: dst})
+			}
+		}
+	}
+	return plan
+}
+
+// dropsDifferentFiles reports whether plan removes a file that is not a copy of the one kept
+func dropsDifferentFiles(plan []folderMergeStep) bool {
+	return slices.ContainsFunc(plan, func(step folderMergeStep) bool {
+		return step.kind == "keep" || step.kind == "replace"
+	})
+}
+
+func trashAvailable() bool {
+	for _, name := range []string{"trash-put", "trash"} {
+		if _, err := exec.LookPath(name); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// sameFileContents compares sizes, then sample hashes, then full hashes
+func sameFileContents(a, b string) bool {
+	ia, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	ib, err := os.Stat(b)
+	if err != nil || ia.Size() != ib.Size() {
+		return false
+	}
+	ha, errA := utils.SampleHashFile(a, 1, 0.1, 0)
+	hb, errB := utils.SampleHashFile(b, 1, 0.1, 0)
+	if errA != nil || errB != nil || ha != hb {
+		return false
+	}
+	ha, errA = utils.FullHashFile(a)
+	hb, errB = utils.FullHashFile(b)
+	return errA == nil && errB == nil && ha == hb
+}
+
+// betterCopy reports whether a is a better copy than b: higher resolution, then larger
+func betterCopy(a, b models.MediaWithDB) bool {
+	pa := utils.Int64Value(a.Width) * utils.Int64Value(a.Height)
+	pb := utils.Int64Value(b.Width) * utils.Int64Value(b.Height)
+	if pa != pb {
+		return pa > pb
+	}
+	return fileSize(a.Path) > fileSize(b.Path)
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func printFolderMergePlan(plan []folderMergeStep, deleteWorse bool) {
+	worseAction := "trash"
+	if deleteWorse {
+		worseAction = "delete"
+	}
+	for _, step := range plan {
+		switch step.kind {
+		case "move":
+			fmt.Printf("  move %s\n", step.src.Path)
+		case "duplicate":
+			fmt.Printf("  delete %s (same as %s)\n", step.src.Path, step.dst)
+		case "replace":
+			fmt.Printf("  replace %s with %s (%s the old copy)\n", step.dst, step.src.Path, worseAction)
+		case "keep":
+			fmt.Printf("  %s %s (%s is better)\n", worseAction, step.src.Path, step.dst)
+		}
+	}
+}
+
+func (c *SimilarFoldersCmd) applyFolderMerge(
+	ctx context.Context,
+	flags models.GlobalFlags,
+	plan []folderMergeStep,
+	others []mergeFolder,
+) error {
+	for _, step := range plan {
+		src := step.src.Path
+		var err error
+		switch step.kind {
+		case "move":
+			err = utils.MoveFile(src, step.dst)
+		case "duplicate":
+			err = c.removeFile(ctx, flags, src, true)
+		case "keep":
+			err = c.removeFile(ctx, flags, src, false)
+		case "replace":
+			if err = c.removeFile(ctx, flags, step.dst, false); err == nil {
+				err = utils.MoveFile(src, step.dst)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if err := updateMergedPath(ctx, step); err != nil {
+			return fmt.Errorf("%s: %w", step.src.DB, err)
+		}
+	}
+
+	for _, f := range others {
+		if !utils.IsEmptyFolder(f.path) {
+			models.Log.Info("Folder not empty after merge", "path", f.path)
+			continue
+		}
+		if err := utils.Rmtree(flags, f.path); err != nil {
+			models.Log.Warn("Could not remove folder", "path", f.path, "error", err)
+		}
+	}
+	return nil
+}
+
+// removeFile drops a file that lost a name collision. An identical copy is deleted
+// unless --trash. A different file can't be recovered from the one kept, so it is
+// trashed unless --delete-worse
+func (c *SimilarFoldersCmd) removeFile(ctx context.Context, flags models.GlobalFlags, path string, identical bool) error {
+	if (identical && !c.Trash) || (!identical && c.DeleteWorse) {
+		return os.Remove(path)
+	}
+	return utils.Trash(ctx, flags, path)
+}
+
+// updateMergedPath points the database at where the file of step ended up
+func updateMergedPath(ctx context.Context, step folderMergeStep) error {
+	sqlDB, err := db.Connect(ctx, step.src.DB)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	switch step.kind {
+	case "move":
+		err = renameMediaPath(ctx, tx, step.src.Path, step.dst)
+	case "duplicate", "keep":
+		err = mergeMediaRow(ctx, tx, step.src.Path, step.dst)
+	case "replace":
+		// The copy from the other folder now lives at dst; keep the plays of both
+		if err = mergeMediaRow(ctx, tx, step.dst, step.src.Path); err == nil {
+			err = renameMediaPath(ctx, tx, step.src.Path, step.dst)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
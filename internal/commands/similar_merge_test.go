@@ -0,0 +1,179 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+func setupSimilarMerge(t *testing.T) (*testutils.TestFixture, string, string) {
+	fixture := testutils.Setup(t)
+	fixture.CreateFileTree(map[string]any{
+		"a": map[string]any{"one.mkv": "same", "two.mkv": "aaaa", "four.mkv": "aaaa"},
+		"b": map[string]any{"one.mkv": "same", "two.mkv": "bigger", "three.mkv": "bbbb"},
+	})
+	a := filepath.Join(fixture.TempDir, "a")
+	b := filepath.Join(fixture.TempDir, "b")
+
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	for _, p := range []string{"a/one.mkv", "a/two.mkv", "a/four.mkv", "b/one.mkv", "b/two.mkv", "b/three.mkv"} {
+		sqlDB.Exec("INSERT INTO media (path, size, duration) VALUES (?, 4, 60)", filepath.Join(fixture.TempDir, p))
+	}
+	sqlDB.Exec("UPDATE media SET play_count = 2, time_last_played = 200 WHERE path = ?", filepath.Join(b, "one.mkv"))
+	sqlDB.Exec("INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, 200, 60, 1)", filepath.Join(b, "three.mkv"))
+	// The smaller a/two.mkv is replaced by b/two.mkv but its history stays
+	sqlDB.Exec("INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, 100, 60, 1)", filepath.Join(a, "two.mkv"))
+	sqlDB.Exec("INSERT INTO media_checks (media_path, corruption, scan_type, time_checked) VALUES (?, 0, 'quick', 100)",
+		filepath.Join(b, "one.mkv"))
+	sqlDB.Close()
+	return fixture, a, b
+}
+
+func TestSimilarFoldersCmd_Merge(t *testing.T) {
+	t.Run("Simulate", func(t *testing.T) {
+		fixture, _, b := setupSimilarMerge(t)
+		defer fixture.Cleanup()
+
+		cmd := &commands.SimilarFoldersCmd{
+			Databases: []string{fixture.DBPath},
+			CoreFlags: models.CoreFlags{Simulate: true},
+			SimilarityFlags: models.SimilarityFlags{
+				SizesDelta: 10, CountsDelta: 3, DurationsDelta: 5,
+			},
+			Merge:     true,
+			MergeInto: "shortest-path",
+		}
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatalf("merge failed: %v", err)
+		}
+		if !utils.FileExists(filepath.Join(b, "three.mkv")) {
+			t.Error("simulate should not move files")
+		}
+	})
+
+	t.Run("NeedsTrash", func(t *testing.T) {
+		fixture, a, _ := setupSimilarMerge(t)
+		defer fixture.Cleanup()
+		t.Setenv("PATH", t.TempDir())
+
+		cmd := &commands.SimilarFoldersCmd{
+			Databases: []string{fixture.DBPath},
+			CoreFlags: models.CoreFlags{NoConfirm: true},
+			SimilarityFlags: models.SimilarityFlags{
+				SizesDelta: 10, CountsDelta: 3, DurationsDelta: 5,
+			},
+			Merge:     true,
+			MergeInto: "shortest-path",
+		}
+		if err := cmd.Run(context.Background()); err == nil {
+			t.Error("expected the merge to refuse to unlink a different file without a trash command")
+		}
+		if !utils.FileExists(filepath.Join(a, "two.mkv")) {
+			t.Error("nothing should change when the merge is refused")
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		fixture, a, b := setupSimilarMerge(t)
+		defer fixture.Cleanup()
+
+		cmd := &commands.SimilarFoldersCmd{
+			Databases: []string{fixture.DBPath},
+			CoreFlags: models.CoreFlags{NoConfirm: true},
+			SimilarityFlags: models.SimilarityFlags{
+				SizesDelta: 10, CountsDelta: 3, DurationsDelta: 5,
+			},
+			Merge:       true,
+			MergeInto:   "shortest-path",
+			DeleteWorse: true,
+		}
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatalf("merge failed: %v", err)
+		}
+
+		if !utils.FileExists(filepath.Join(a, "three.mkv")) {
+			t.Error("unique file was not moved into the destination")
+		}
+		if utils.FileExists(b) {
+			t.Error("emptied folder was not removed")
+		}
+
+		sqlDB := fixture.GetDB()
+		defer sqlDB.Close()
+
+		var n int
+		sqlDB.QueryRow("SELECT COUNT(*) FROM media WHERE path LIKE ?", b+"%").Scan(&n)
+		if n != 0 {
+			t.Errorf("expected no media rows left under %s, got %d", b, n)
+		}
+		var historyPath string
+		sqlDB.QueryRow("SELECT media_path FROM history WHERE time_played = 200").Scan(&historyPath)
+		if historyPath != filepath.Join(a, "three.mkv") {
+			t.Errorf("history not moved, got %q", historyPath)
+		}
+		sqlDB.QueryRow("SELECT COUNT(*) FROM history WHERE media_path = ?", filepath.Join(a, "two.mkv")).Scan(&n)
+		if n != 1 {
+			t.Errorf("expected the replaced file's history to be kept, got %d rows", n)
+		}
+		sqlDB.QueryRow("SELECT COUNT(*) FROM media_checks WHERE media_path = ?", filepath.Join(a, "one.mkv")).Scan(&n)
+		if n != 1 {
+			t.Error("expected the duplicate's media-check result to move to the kept file")
+		}
+		var playCount int64
+		sqlDB.QueryRow("SELECT play_count FROM media WHERE path = ?", filepath.Join(a, "one.mkv")).Scan(&playCount)
+		if playCount != 2 {
+			t.Errorf("expected play_count of the duplicate to carry over, got %d", playCount)
+		}
+	})
+	t.Run("SameNameInSeveralFolders", func(t *testing.T) {
+		fixture := testutils.Setup(t)
+		defer fixture.Cleanup()
+		fixture.CreateFileTree(map[string]any{
+			"a": map[string]any{"one.mkv": "same", "y.mkv": "aaaa"},
+			"b": map[string]any{"one.mkv": "same", "x.mkv": "bbbbbb"},
+			"c": map[string]any{"one.mkv": "same", "x.mkv": "cc"},
+		})
+		sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+		db.InitDB(context.Background(), sqlDB)
+		for _, p := range []string{"a/one.mkv", "a/y.mkv", "b/one.mkv", "b/x.mkv", "c/one.mkv", "c/x.mkv"} {
+			sqlDB.Exec("INSERT INTO media (path, size, duration) VALUES (?, 4, 60)", filepath.Join(fixture.TempDir, p))
+		}
+		sqlDB.Close()
+
+		cmd := &commands.SimilarFoldersCmd{
+			Databases: []string{fixture.DBPath},
+			CoreFlags: models.CoreFlags{NoConfirm: true},
+			SimilarityFlags: models.SimilarityFlags{
+				SizesDelta: 10, CountsDelta: 3, DurationsDelta: 5,
+			},
+			Merge:       true,
+			MergeInto:   "shortest-path",
+			DeleteWorse: true,
+		}
+		if err := cmd.Run(context.Background()); err != nil {
+			t.Fatalf("merge failed: %v", err)
+		}
+
+		// b/x.mkv is moved first; the smaller c/x.mkv must be compared with it, not moved over it
+		data, err := os.ReadFile(filepath.Join(fixture.TempDir, "a", "x.mkv"))
+		if err != nil || string(data) != "bbbbbb" {
+			t.Errorf("expected the better x.mkv in the destination, got %q %v", data, err)
+		}
+		sqlDB = fixture.GetDB()
+		defer sqlDB.Close()
+		var n int
+		sqlDB.QueryRow("SELECT COUNT(*) FROM media WHERE path LIKE '%x.mkv'").Scan(&n)
+		if n != 1 {
+			t.Errorf("expected one media row for x.mkv, got %d", n)
+		}
+	})
+}
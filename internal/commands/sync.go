@@ -0,0 +1,300 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+type SyncCmd struct {
+	models.CoreFlags `embed:""`
+
+	Left      string   `help:"First library: a database file or a disco serve URL"   required:"true" arg:""`
+	Right     string   `help:"Second library: a database file or a disco serve URL"  required:"true" arg:""`
+	Map       []string `help:"Path prefix of the first library and its equivalent in the second, e.g. /mnt/nas/=/media/nas/"`
+	Token     []string `help:"API token for each disco serve URL, in argument order"`
+	BatchSize int      `help:"Changes sent per request"                                                                     default:"1000"`
+}
+
+// syncLibrary is one side of a sync: a database file or a disco serve instance
+type syncLibrary interface {
+	ID(ctx context.Context) (string, error)
+	Cursor(ctx context.Context, peer string) (db.SyncCursor, error)
+	Batch(ctx context.Context, peer string, cursor db.SyncCursor, limit int) (db.SyncBatch, error)
+	Apply(ctx context.Context, peer string, batch db.SyncBatch) (db.SyncResult, error)
+	Close() error
+}
+
+func (c *SyncCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	if c.BatchSize <= 0 {
+		c.BatchSize = 1000
+	}
+	forward, reverse, err := parsePathMaps(c.Map)
+	if err != nil {
+		return err
+	}
+
+	tokens := slices.Clone(c.Token)
+	left, err := openSyncLibrary(ctx, c.Left, &tokens)
+	if err != nil {
+		return err
+	}
+	defer left.Close()
+	right, err := openSyncLibrary(ctx, c.Right, &tokens)
+	if err != nil {
+		return err
+	}
+	defer right.Close()
+
+	leftID, err := left.ID(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Left, err)
+	}
+	rightID, err := right.ID(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Right, err)
+	}
+	if leftID == rightID {
+		return fmt.Errorf("%s and %s are the same library", c.Left, c.Right)
+	}
+
+	if err := c.syncOneWay(ctx, left, right, leftID, rightID, forward, c.Left+" -> "+c.Right); err != nil {
+		return err
+	}
+	return c.syncOneWay(ctx, right, left, rightID, leftID, reverse, c.Right+" -> "+c.Left)
+}
+
+// syncOneWay sends from's changes since to's cursor, one batch per transaction, so an
+// interrupted sync picks up at the last applied batch
+func (c *SyncCmd) syncOneWay(
+	ctx context.Context,
+	from, to syncLibrary,
+	fromID, toID string,
+	mapPath func(string) string,
+	label string,
+) error {
+	cursor, err := to.Cursor(ctx, fromID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+
+	var total db.SyncResult
+	var changes, history int
+	for {
+		batch, err := from.Batch(ctx, toID, cursor, c.BatchSize)
+		if err != nil {
+			return fmt.Errorf("%s: %w", label, err)
+		}
+		if len(batch.Changes) == 0 && len(batch.History) == 0 {
+			break
+		}
+		changes += len(batch.Changes)
+		history += len(batch.History)
+		next := batch.Cursor(cursor)
+
+		for i := range batch.Changes {
+			batch.Changes[i].Path = mapPath(batch.Changes[i].Path)
+		}
+		for i := range batch.History {
+			batch.History[i].Path = mapPath(batch.History[i].Path)
+		}
+
+		if !c.Simulate {
+			res, err := to.Apply(ctx, fromID, batch)
+			if err != nil {
+				return fmt.Errorf("%s: %w", label, err)
+			}
+			total.Applied += res.Applied
+			total.Older += res.Older
+			total.Missing += res.Missing
+			total.History += res.History
+		}
+		cursor = next
+		if !batch.More {
+			break
+		}
+	}
+
+	if c.Simulate {
+		fmt.Printf("%s: would send %d changes and %d history rows\n", label, changes, history)
+		return nil
+	}
+	fmt.Printf("%s: %d changes applied, %d older than local, %d not in library, %d history rows added\n",
+		label, total.Applied, total.Older, total.Missing, total.History)
+	return nil
+}
+
+// parsePathMaps parses FROM=TO prefix pairs into functions that map paths of the
+// first library to the second and back. Longer prefixes win
+func parsePathMaps(specs []string) (forward, reverse func(string) string, err error) {
+	type pair struct{ from, to string }
+	var pairs []pair
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, "=")
+		if !ok || from == "" || to == "" {
+			return nil, nil, fmt.Errorf("path map must look like /old/prefix/=/new/prefix/: %q", spec)
+		}
+		pairs = append(pairs, pair{from, to})
+	}
+	mapper := func(swap bool) func(string) string {
+		ordered := slices.Clone(pairs)
+		if swap {
+			for i := range ordered {
+				ordered[i].from, ordered[i].to = ordered[i].to, ordered[i].from
+			}
+		}
+		slices.SortStableFunc(ordered, func(a, b pair) int { return len(b.from) - len(a.from) })
+		return func(path string) string {
+			for _, p := range ordered {
+				if rest, ok := strings.CutPrefix(path, p.from); ok {
+					return p.to + rest
+				}
+			}
+			return path
+		}
+	}
+	return mapper(false), mapper(true), nil
+}
+
+// openSyncLibrary opens a database file, or connects to a disco serve URL using the
+// next token from tokens
+func openSyncLibrary(ctx context.Context, target string, tokens *[]string) (syncLibrary, error) {
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		base, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		lib := &remoteSyncLibrary{base: base, client: &http.Client{Timeout: 5 * time.Minute}}
+		if len(*tokens) > 0 {
+			lib.token, *tokens = (*tokens)[0], (*tokens)[1:]
+		}
+		return lib, nil
+	}
+
+	sqlDB, _, err := db.ConnectWithInit(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", target, err)
+	}
+	return localSyncLibrary{sqlDB}, nil
+}
+
+type localSyncLibrary struct {
+	sqlDB *sql.DB
+}
+
+func (l localSyncLibrary) ID(ctx context.Context) (string, error) {
+	return db.GetSyncID(ctx, l.sqlDB)
+}
+
+func (l localSyncLibrary) Cursor(ctx context.Context, peer string) (db.SyncCursor, error) {
+	return db.GetSyncCursor(ctx, l.sqlDB, peer)
+}
+
+func (l localSyncLibrary) Batch(ctx context.Context, peer string, cursor db.SyncCursor, limit int) (db.SyncBatch, error) {
+	return db.GetSyncBatch(ctx, l.sqlDB, peer, cursor, limit)
+}
+
+func (l localSyncLibrary) Apply(ctx context.Context, peer string, batch db.SyncBatch) (db.SyncResult, error) {
+	return db.ApplySyncBatch(ctx, l.sqlDB, peer, batch)
+}
+
+func (l localSyncLibrary) Close() error {
+	return l.sqlDB.Close()
+}
+
+// remoteSyncLibrary talks to the /api/sync endpoints of disco serve. A db query
+// parameter in the URL selects one of the server's databases
+type remoteSyncLibrary struct {
+	base   *url.URL
+	token  string
+	client *http.Client
+}
+
+// syncState is returned by GET /api/sync
+type syncState struct {
+	ID     string        `json:"id"`
+	Cursor db.SyncCursor `json:"cursor"`
+}
+
+func (l *remoteSyncLibrary) do(ctx context.Context, method, path string, params url.Values, in, out any) error {
+	u := l.base.JoinPath(path)
+	q := l.base.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Disco-Token", l.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e models.ErrorResponse
+		_ = json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (l *remoteSyncLibrary) ID(ctx context.Context) (string, error) {
+	var state syncState
+	err := l.do(ctx, http.MethodGet, "/api/sync", nil, nil, &state)
+	if err == nil && state.ID == "" {
+		err = errors.New("server did not return a sync id")
+	}
+	return state.ID, err
+}
+
+func (l *remoteSyncLibrary) Cursor(ctx context.Context, peer string) (db.SyncCursor, error) {
+	var state syncState
+	err := l.do(ctx, http.MethodGet, "/api/sync", url.Values{"peer": {peer}}, nil, &state)
+	return state.Cursor, err
+}
+
+func (l *remoteSyncLibrary) Batch(ctx context.Context, peer string, cursor db.SyncCursor, limit int) (db.SyncBatch, error) {
+	var batch db.SyncBatch
+	err := l.do(ctx, http.MethodGet, "/api/sync/changes", url.Values{
+		"peer":       {peer},
+		"change_seq": {strconv.FormatInt(cursor.ChangeSeq, 10)},
+		"history_id": {strconv.FormatInt(cursor.HistoryID, 10)},
+		"limit":      {strconv.Itoa(limit)},
+	}, nil, &batch)
+	return batch, err
+}
+
+func (l *remoteSyncLibrary) Apply(ctx context.Context, peer string, batch db.SyncBatch) (db.SyncResult, error) {
+	var res db.SyncResult
+	err := l.do(ctx, http.MethodPost, "/api/sync/changes", url.Values{"peer": {peer}}, batch, &res)
+	return res, err
+}
+
+func (l *remoteSyncLibrary) Close() error {
+	return nil
+}
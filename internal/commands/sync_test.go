@@ -0,0 +1,181 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// createSyncLibrary creates a library with the same two files under prefix
+func createSyncLibrary(t *testing.T, prefix string) (string, *sql.DB) {
+	t.Helper()
+	dbPath := filepath.Join(t.TempDir(), "library.db")
+	sqlDB, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+	if err := db.InitDB(context.Background(), sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	_, err = sqlDB.Exec("INSERT INTO media (path, media_type) VALUES (?, 'video'), (?, 'video')",
+		prefix+"a.mp4", prefix+"b.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbPath, sqlDB
+}
+
+func mustExec(t *testing.T, sqlDB *sql.DB, query string, args ...any) {
+	t.Helper()
+	if _, err := sqlDB.Exec(query, args...); err != nil {
+		t.Fatal(err)
+	}
+	// Change times are in milliseconds; keep successive changes ordered
+	time.Sleep(5 * time.Millisecond)
+}
+
+func TestSyncCmd_Databases(t *testing.T) {
+	models.SetupLogging(0)
+	laptopPath, laptop := createSyncLibrary(t, "/home/me/nas/")
+	nasPath, nas := createSyncLibrary(t, "/mnt/media/")
+
+	// Categories change on both sides; the later change wins everywhere
+	mustExec(t, laptop, "UPDATE media SET categories = ';old;' WHERE path = '/home/me/nas/a.mp4'")
+	mustExec(t, nas, "UPDATE media SET categories = ';new;' WHERE path = '/mnt/media/a.mp4'")
+	mustExec(t, laptop, "UPDATE media SET playhead = 42, play_count = 1 WHERE path = '/home/me/nas/a.mp4'")
+	mustExec(t, laptop, "INSERT INTO history (media_path, time_played, playhead, done) VALUES ('/home/me/nas/a.mp4', 1000, 42, 0)")
+	mustExec(t, nas, "UPDATE media SET score = 4.5, time_deleted = 99 WHERE path = '/mnt/media/b.mp4'")
+
+	cmd := &commands.SyncCmd{Left: laptopPath, Right: nasPath, Map: []string{"/home/me/nas/=/mnt/media/"}}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lib := range []struct {
+		name   string
+		sqlDB  *sql.DB
+		prefix string
+	}{{"laptop", laptop, "/home/me/nas/"}, {"nas", nas, "/mnt/media/"}} {
+		var categories string
+		var playhead, playCount, deleted int64
+		var score float64
+		lib.sqlDB.QueryRow("SELECT categories, playhead, play_count FROM media WHERE path = ?", lib.prefix+"a.mp4").
+			Scan(&categories, &playhead, &playCount)
+		lib.sqlDB.QueryRow("SELECT score, time_deleted FROM media WHERE path = ?", lib.prefix+"b.mp4").
+			Scan(&score, &deleted)
+		if categories != ";new;" || playhead != 42 || playCount != 1 || score != 4.5 || deleted != 99 {
+			t.Errorf("%s: categories=%q playhead=%d play_count=%d score=%v time_deleted=%d",
+				lib.name, categories, playhead, playCount, score, deleted)
+		}
+		var history int
+		lib.sqlDB.QueryRow("SELECT COUNT(*) FROM history WHERE media_path = ?", lib.prefix+"a.mp4").Scan(&history)
+		if history != 1 {
+			t.Errorf("%s: %d history rows", lib.name, history)
+		}
+	}
+
+	// A second run resumes from the saved cursors and changes nothing
+	var before int64
+	nas.QueryRow("SELECT MAX(seq) FROM playstate_changes").Scan(&before)
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var after int64
+	nas.QueryRow("SELECT MAX(seq) FROM playstate_changes").Scan(&after)
+	if before != after {
+		t.Errorf("Second sync recorded new changes: seq %d -> %d", before, after)
+	}
+}
+
+func TestSyncCmd_AppliedChangesAreNotSentBack(t *testing.T) {
+	models.SetupLogging(0)
+	laptopPath, laptop := createSyncLibrary(t, "/media/")
+	nasPath, nas := createSyncLibrary(t, "/media/")
+	phonePath, phone := createSyncLibrary(t, "/media/")
+	mustExec(t, laptop, "UPDATE media SET playhead = 42 WHERE path = '/media/a.mp4'")
+
+	if err := (&commands.SyncCmd{Left: laptopPath, Right: nasPath}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var laptopTime, nasTime int64
+	laptop.QueryRow("SELECT time_changed FROM playstate_changes WHERE media_path = '/media/a.mp4'").Scan(&laptopTime)
+	nas.QueryRow("SELECT time_changed FROM playstate_changes WHERE media_path = '/media/a.mp4'").Scan(&nasTime)
+	if laptopTime == 0 || nasTime != laptopTime {
+		t.Errorf("Applied change was restamped: laptop %d, nas %d", laptopTime, nasTime)
+	}
+
+	laptopID, _ := db.GetSyncID(context.Background(), laptop)
+	phoneID, _ := db.GetSyncID(context.Background(), phone)
+	back, err := db.GetSyncBatch(context.Background(), nas, laptopID, db.SyncCursor{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back.Changes) != 0 {
+		t.Errorf("Changes applied from the laptop are sent back to it: %+v", back.Changes)
+	}
+	onward, _ := db.GetSyncBatch(context.Background(), nas, phoneID, db.SyncCursor{}, 100)
+	if len(onward.Changes) != 1 || onward.Changes[0].TimeChanged != laptopTime {
+		t.Errorf("Expected the laptop's change to travel on to the phone, got %+v", onward.Changes)
+	}
+
+	if err := (&commands.SyncCmd{Left: nasPath, Right: phonePath}).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var playhead int64
+	phone.QueryRow("SELECT playhead FROM media WHERE path = '/media/a.mp4'").Scan(&playhead)
+	if playhead != 42 {
+		t.Errorf("phone playhead=%d", playhead)
+	}
+}
+
+func TestSyncCmd_Server(t *testing.T) {
+	models.SetupLogging(0)
+	laptopPath, laptop := createSyncLibrary(t, "/home/me/nas/")
+	nasPath, nas := createSyncLibrary(t, "/mnt/media/")
+	mustExec(t, laptop, "UPDATE media SET playhead = 7 WHERE path = '/home/me/nas/b.mp4'")
+	mustExec(t, nas, "UPDATE media SET play_count = 3 WHERE path = '/mnt/media/a.mp4'")
+
+	serve := &commands.ServeCmd{Databases: []string{nasPath}}
+	server := httptest.NewServer(serve.Mux())
+	defer server.Close()
+	defer serve.Close()
+
+	cmd := &commands.SyncCmd{
+		Left:  laptopPath,
+		Right: server.URL,
+		Map:   []string{"/home/me/nas/=/mnt/media/"},
+		Token: []string{serve.APIToken},
+	}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var playhead, playCount int64
+	nas.QueryRow("SELECT playhead FROM media WHERE path = '/mnt/media/b.mp4'").Scan(&playhead)
+	laptop.QueryRow("SELECT play_count FROM media WHERE path = '/home/me/nas/a.mp4'").Scan(&playCount)
+	if playhead != 7 || playCount != 3 {
+		t.Errorf("playhead=%d play_count=%d", playhead, playCount)
+	}
+
+	// A change for a column that is not synced is the client's fault
+	bad := `{"changes":[{"path":"/mnt/media/a.mp4","column":"path","value":"x","time_changed":1,"seq":1}]}`
+	if code, body := serveRequest(t, server, serve.APIToken, http.MethodPost, "/api/sync/changes?peer=x", bad); code != http.StatusBadRequest {
+		t.Errorf("Invalid change: %d %s", code, body)
+	}
+
+	serve.ReadOnly = true
+	mustExec(t, laptop, "UPDATE media SET playhead = 8 WHERE path = '/home/me/nas/b.mp4'")
+	if err := cmd.Run(context.Background()); err == nil {
+		t.Error("Expected sync to a read-only server to fail")
+	}
+}
@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/metadata"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type TagWriteCmd struct {
+	models.CoreFlags        `embed:""`
+	models.PathFilterFlags  `embed:""`
+	models.FilterFlags      `embed:""`
+	models.MediaFilterFlags `embed:""`
+
+	SetTitle      *string `help:"Set the title"`
+	SetArtist     *string `help:"Set the artist"`
+	SetAlbum      *string `help:"Set the album"`
+	SetGenre      *string `help:"Set the genre"`
+	SetCategories *string `help:"Set the categories (;a;b;)"`
+	DBOnly        bool    `help:"Only update the database; leave the files untouched"`
+
+	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+}
+
+func (c *TagWriteCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	edits := metadata.Tags{
+		Title:      c.SetTitle,
+		Artist:     c.SetArtist,
+		Album:      c.SetAlbum,
+		Genre:      c.SetGenre,
+		Categories: c.SetCategories,
+	}
+	if edits.IsEmpty() && c.DBOnly {
+		return errors.New("nothing to do: set a tag or drop --db-only")
+	}
+
+	flags := models.GlobalFlags{
+		CoreFlags:        c.CoreFlags,
+		PathFilterFlags:  c.PathFilterFlags,
+		FilterFlags:      c.FilterFlags,
+		MediaFilterFlags: c.MediaFilterFlags,
+	}
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return err
+	}
+	media = query.FilterMedia(media, flags)
+
+	// The same file can be in several databases; write it once
+	var paths []string
+	dbsByPath := map[string][]string{}
+	for _, m := range media {
+		if _, ok := dbsByPath[m.Path]; !ok {
+			paths = append(paths, m.Path)
+		}
+		dbsByPath[m.Path] = append(dbsByPath[m.Path], m.DB)
+	}
+	if len(paths) == 0 {
+		fmt.Println("No media found")
+		return nil
+	}
+
+	if c.Simulate {
+		for _, p := range paths {
+			fmt.Println(p)
+		}
+		fmt.Printf("%d files would be tagged\n", len(paths))
+		return nil
+	}
+	// Without edits the database tags are written into the files, which can remux every
+	// matched video, so that is confirmed as well
+	prompt := fmt.Sprintf("Set tags on %d files?", len(paths))
+	if edits.IsEmpty() {
+		prompt = fmt.Sprintf("Write the database tags into %d files?", len(paths))
+	}
+	if len(paths) > 1 && !c.NoConfirm && !utils.Confirm(prompt) {
+		return nil
+	}
+
+	updated := 0
+	for _, p := range paths {
+		if _, err := applyTagEdits(ctx, connectDB, dbsByPath[p], p, edits, !c.DBOnly); err != nil {
+			models.Log.Error("Failed to tag file", "path", p, "error", err)
+			continue
+		}
+		updated++
+	}
+	fmt.Printf("%d of %d files tagged\n", updated, len(paths))
+	if updated < len(paths) {
+		return fmt.Errorf("%d files could not be tagged", len(paths)-updated)
+	}
+	return nil
+}
+
+// tagColumns are the media columns that can be edited and written into files
+var tagColumns = []string{"title", "artist", "album", "genre", "categories"}
+
+func tagEditValues(edits metadata.Tags) []*string {
+	return []*string{edits.Title, edits.Artist, edits.Album, edits.Genre, edits.Categories}
+}
+
+// dbExec runs fn with a connection to dbPath
+type dbExec func(ctx context.Context, dbPath string, fn func(ctx context.Context, sqlDB *sql.DB) error) error
+
+// connectDB is a dbExec that opens its own connection, for commands run outside serve
+func connectDB(ctx context.Context, dbPath string, fn func(ctx context.Context, sqlDB *sql.DB) error) error {
+	sqlDB, err := db.Connect(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	return fn(ctx, sqlDB)
+}
+
+// applyTagEdits sets the edited tag columns of path in each database. With writeFile
+// the resulting tags are written into the file first, outside of any transaction since
+// a remux can take minutes, and its new size and mtime are recorded along with the tags
+// so that the next add does not see it as changed. No database is updated if the file
+// cannot be written. found is false when no database has path
+func applyTagEdits(
+	ctx context.Context,
+	exec dbExec,
+	dbs []string,
+	path string,
+	edits metadata.Tags,
+	writeFile bool,
+) (bool, error) {
+	var fileTags metadata.Tags
+	var found []string
+	for _, dbPath := range dbs {
+		values, ok, err := readTagColumns(ctx, exec, dbPath, path)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", dbPath, err)
+		}
+		if !ok {
+			continue
+		}
+		if len(found) == 0 {
+			fileTags = fileTagsFromRow(edits, values)
+		}
+		found = append(found, dbPath)
+	}
+	if len(found) == 0 {
+		return false, nil
+	}
+
+	var sets []string
+	var args []any
+	for i, v := range tagEditValues(edits) {
+		if v != nil {
+			sets = append(sets, tagColumns[i]+" = ?")
+			args = append(args, utils.ToNullString(*v))
+		}
+	}
+	if writeFile {
+		if err := metadata.WriteTags(ctx, path, fileTags); err != nil {
+			return true, err
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return true, err
+		}
+		sets = append(sets, "size = ?", "time_modified = ?")
+		args = append(args, info.Size(), info.ModTime().Unix())
+	}
+	if len(sets) == 0 {
+		return true, nil
+	}
+
+	args = append(args, path)
+	for _, dbPath := range found {
+		err := exec(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+			_, err := sqlDB.ExecContext(ctx, "UPDATE media SET "+strings.Join(sets, ", ")+" WHERE path = ?", args...)
+			return err
+		})
+		if err != nil {
+			return true, fmt.Errorf("%s: %w", dbPath, err)
+		}
+	}
+	return true, nil
+}
+
+// readTagColumns returns the tag columns of path in dbPath. ok is false when the
+// database does not have path
+func readTagColumns(ctx context.Context, exec dbExec, dbPath, path string) (values [5]sql.NullString, ok bool, err error) {
+	err = exec(ctx, dbPath, func(ctx context.Context, sqlDB *sql.DB) error {
+		return sqlDB.QueryRowContext(ctx,
+			"SELECT "+strings.Join(tagColumns, ", ")+" FROM media WHERE path = ?", path,
+		).Scan(&values[0], &values[1], &values[2], &values[3], &values[4])
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return values, false, nil
+	}
+	return values, err == nil, err
+}
+
+// fileTagsFromRow returns the tags to write: edited fields as given, so that cleared
+// fields are removed from the file, and the database values for the others
+func fileTagsFromRow(edits metadata.Tags, row [5]sql.NullString) metadata.Tags {
+	values := tagEditValues(edits)
+	for i := range values {
+		if values[i] == nil && row[i].Valid {
+			values[i] = &row[i].String
+		}
+	}
+	return metadata.Tags{
+		Title:      values[0],
+		Artist:     values[1],
+		Album:      values[2],
+		Genre:      values[3],
+		Categories: values[4],
+	}
+}
@@ -0,0 +1,150 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+func setupTagWriteDB(t *testing.T, fixture *testutils.TestFixture) string {
+	t.Helper()
+	path := filepath.Join(fixture.TempDir, "song.mp3")
+	if err := os.WriteFile(path, append([]byte{0xFF, 0xFB, 0x90, 0x00}, make([]byte, 400)...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	sqlDB.Exec("INSERT INTO media (path, title, artist, size, time_modified) VALUES (?, 'Old', 'Band', 404, 1)", path)
+	sqlDB.Close()
+	return path
+}
+
+func TestTagWriteCmd(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	path := setupTagWriteDB(t, fixture)
+
+	title := "New Title"
+	cmd := &commands.TagWriteCmd{
+		CoreFlags: models.CoreFlags{NoConfirm: true},
+		SetTitle:  &title,
+		Databases: []string{fixture.DBPath},
+	}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("tag-write failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(data, []byte("ID3")) || !bytes.Contains(data, []byte("New Title")) ||
+		!bytes.Contains(data, []byte("Band")) {
+		t.Error("expected the title and existing artist to be written into the file")
+	}
+
+	info, _ := os.Stat(path)
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	var dbTitle string
+	var size, mtime int64
+	sqlDB.QueryRow("SELECT title, size, time_modified FROM media WHERE path = ?", path).
+		Scan(&dbTitle, &size, &mtime)
+	if dbTitle != title {
+		t.Errorf("expected title %q, got %q", title, dbTitle)
+	}
+	if size != info.Size() || mtime != info.ModTime().Unix() {
+		t.Errorf("expected size and mtime of the rewritten file, got %d %d", size, mtime)
+	}
+
+	empty := ""
+	cmd = &commands.TagWriteCmd{
+		CoreFlags: models.CoreFlags{NoConfirm: true},
+		SetArtist: &empty,
+		DBOnly:    true,
+		Databases: []string{fixture.DBPath},
+	}
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("tag-write --db-only failed: %v", err)
+	}
+	var artist sql.NullString
+	sqlDB.QueryRow("SELECT artist FROM media WHERE path = ?", path).Scan(&artist)
+	if artist.Valid {
+		t.Errorf("expected artist to be cleared, got %q", artist.String)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, data) {
+		t.Error("--db-only should not touch the file")
+	}
+}
+
+func TestServeCmd_HandleMediaUpdate(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	path := setupTagWriteDB(t, fixture)
+
+	cmd := SetupTestServeCmd(fixture.DBPath)
+	defer cmd.Close()
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, "/api/media", strings.NewReader(body))
+		req.Header.Set("X-Disco-Token", cmd.APIToken)
+		w := httptest.NewRecorder()
+		cmd.HandleMediaUpdate(w, req)
+		return w
+	}
+
+	w := patch(`{"path": "` + path + `", "artist": "Trio"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "Trio") {
+		t.Errorf("expected the updated media in the response, got %s", w.Body.String())
+	}
+
+	// Writing the file is left to a job, as a remux can take minutes
+	server := httptest.NewServer(cmd.Mux())
+	defer server.Close()
+	w = patch(`{"path": "` + path + `", "genre": "Jazz", "write_file": true}`)
+	var queued testJob
+	if err := json.Unmarshal(w.Body.Bytes(), &queued); w.Code != http.StatusAccepted || err != nil || queued.Kind != "tags" {
+		t.Fatalf("expected a queued tags job, got %d: %s", w.Code, w.Body.String())
+	}
+	if job := waitForJob(t, server, cmd.APIToken, queued.ID); job.Status != db.JobDone {
+		t.Fatalf("tags job %s: %s", job.Status, job.Error)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Contains(data, []byte("Jazz")) || !bytes.Contains(data, []byte("Trio")) {
+		t.Error("expected the genre and artist to be written into the file")
+	}
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	var genre string
+	var size int64
+	sqlDB.QueryRow("SELECT genre, size FROM media WHERE path = ?", path).Scan(&genre, &size)
+	if info, _ := os.Stat(path); genre != "Jazz" || size != info.Size() {
+		t.Errorf("expected the job to record the genre and new size, got %q %d", genre, size)
+	}
+	if w := patch(`{"path": "/missing.mp3", "genre": "Jazz", "write_file": true}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown path, got %d", w.Code)
+	}
+
+	if w := patch(`{"path": "/missing.mp3", "genre": "Jazz"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown path, got %d", w.Code)
+	}
+	if w := patch(`{"path": "` + path + `"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 without tags, got %d", w.Code)
+	}
+
+	cmd.ReadOnly = true
+	if w := patch(`{"path": "` + path + `", "genre": "Rock"}`); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 in read-only mode, got %d", w.Code)
+	}
+}
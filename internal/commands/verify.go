@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type VerifyCmd struct {
+	models.CoreFlags        `embed:""`
+	models.PathFilterFlags  `embed:""`
+	models.FilterFlags      `embed:""`
+	models.MediaFilterFlags `embed:""`
+
+	Budget  string `help:"Stop after reading this much data (e.g. 500GB)"`
+	MaxTime string `help:"Stop after this long (e.g. 2h)"`
+
+	Databases []string `help:"SQLite database files"                          required:"true" arg:"" type:"existingfile"`
+}
+
+// integrityRecord is the last verified state of a file
+type integrityRecord struct {
+	sha256       string
+	size         int64
+	timeModified int64
+	timeVerified int64
+}
+
+type verifyTask struct {
+	media  models.MediaWithDB
+	record *integrityRecord
+}
+
+type verifyCounts struct {
+	ok, added, changed, failed, missing, deferred int
+	bytesRead                                     int64
+}
+
+func (c *VerifyCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	var budget int64
+	if c.Budget != "" {
+		var err error
+		if budget, err = utils.HumanToBytes(c.Budget); err != nil || budget <= 0 {
+			return fmt.Errorf("invalid --budget: %s", c.Budget)
+		}
+	}
+	var maxTime time.Duration
+	if c.MaxTime != "" {
+		if maxTime = utils.ParseDurationString(c.MaxTime); maxTime <= 0 {
+			return fmt.Errorf("invalid --max-time: %s", c.MaxTime)
+		}
+	}
+
+	flags := models.GlobalFlags{
+		CoreFlags:        c.CoreFlags,
+		PathFilterFlags:  c.PathFilterFlags,
+		FilterFlags:      c.FilterFlags,
+		MediaFilterFlags: c.MediaFilterFlags,
+	}
+	// Every run picks the files verified longest ago from the whole library
+	flags.All = true
+	media, err := query.MediaQuery(ctx, c.Databases, flags)
+	if err != nil {
+		return err
+	}
+	media = query.FilterMedia(media, flags)
+
+	conns := map[string]*sql.DB{}
+	defer func() {
+		for _, sqlDB := range conns {
+			sqlDB.Close()
+		}
+	}()
+	records := map[string]map[string]*integrityRecord{}
+	for _, dbPath := range c.Databases {
+		sqlDB, _, err := db.ConnectWithInit(ctx, dbPath)
+		if err != nil {
+			return err
+		}
+		conns[dbPath] = sqlDB
+		if records[dbPath], err = loadIntegrity(ctx, sqlDB); err != nil {
+			return fmt.Errorf("%s: %w", dbPath, err)
+		}
+	}
+
+	tasks := make([]verifyTask, len(media))
+	for i, m := range media {
+		tasks[i] = verifyTask{media: m, record: records[m.DB][m.Path]}
+	}
+	// Files never verified come first, then the ones verified longest ago
+	slices.SortStableFunc(tasks, func(a, b verifyTask) int {
+		return cmp.Or(
+			cmp.Compare(a.lastVerified(), b.lastVerified()),
+			cmp.Compare(a.media.Path, b.media.Path),
+		)
+	})
+
+	var counts verifyCounts
+	start := time.Now()
+	for i, task := range tasks {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if (budget > 0 && counts.bytesRead >= budget) || (maxTime > 0 && time.Since(start) >= maxTime) {
+			counts.deferred = len(tasks) - i
+			break
+		}
+		if err := c.verifyFile(ctx, conns[task.media.DB], task, &counts); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Verified %d files (%s): %d ok, %d new, %d changed, %d failed, %d missing\n",
+		counts.ok+counts.added+counts.changed+counts.failed, utils.FormatSize(counts.bytesRead),
+		counts.ok, counts.added, counts.changed, counts.failed, counts.missing)
+	if counts.deferred > 0 {
+		fmt.Printf("%d files left for the next run\n", counts.deferred)
+	}
+	if counts.failed > 0 {
+		return fmt.Errorf("%d files failed verification", counts.failed)
+	}
+	return nil
+}
+
+func (t verifyTask) lastVerified() int64 {
+	if t.record == nil {
+		return 0
+	}
+	return t.record.timeVerified
+}
+
+// verifyFile hashes one file and compares it with its record. A file whose size or
+// mtime changed was edited on purpose, so it gets a new baseline instead of failing
+func (c *VerifyCmd) verifyFile(ctx context.Context, sqlDB *sql.DB, task verifyTask, counts *verifyCounts) error {
+	path := task.media.Path
+	stat, err := os.Stat(path)
+	if err != nil {
+		counts.missing++
+		models.Log.Debug("Skipping missing file", "path", path)
+		return nil
+	}
+	hash, err := utils.FullHashFile(path)
+	if err != nil {
+		models.Log.Error("Failed to hash file", "path", path, "error", err)
+		return nil
+	}
+	counts.bytesRead += stat.Size()
+
+	now := time.Now().Unix()
+	rec := task.record
+	switch {
+	case rec == nil || rec.size != stat.Size() || rec.timeModified != stat.ModTime().Unix():
+		if rec == nil {
+			counts.added++
+		} else {
+			counts.changed++
+		}
+		if c.Simulate {
+			return nil
+		}
+		if _, err := sqlDB.ExecContext(ctx, `INSERT INTO integrity
+			(media_path, sha256, size, time_modified, time_verified, status, time_failed)
+			VALUES (?, ?, ?, ?, ?, 'ok', NULL)
+			ON CONFLICT (media_path) DO UPDATE SET sha256 = excluded.sha256, size = excluded.size,
+				time_modified = excluded.time_modified, time_verified = excluded.time_verified,
+				status = 'ok', time_failed = NULL`,
+			path, hash, stat.Size(), stat.ModTime().Unix(), now); err != nil {
+			return err
+		}
+		_, err = sqlDB.ExecContext(ctx, "UPDATE media SET sha256 = ? WHERE path = ?", hash, path)
+		return err
+	case hash == rec.sha256:
+		counts.ok++
+		if c.Simulate {
+			return nil
+		}
+		_, err = sqlDB.ExecContext(ctx,
+			"UPDATE integrity SET time_verified = ?, status = 'ok', time_failed = NULL WHERE media_path = ?", now, path)
+		return err
+	default:
+		counts.failed++
+		fmt.Printf("FAILED\t%s\n", path)
+		if c.Simulate {
+			return nil
+		}
+		// The stored hash stays the known-good one so a restored copy verifies again
+		_, err = sqlDB.ExecContext(ctx, `UPDATE integrity SET time_verified = ?, status = 'failed',
+			time_failed = COALESCE(time_failed, ?) WHERE media_path = ?`, now, now, path)
+		return err
+	}
+}
+
+func loadIntegrity(ctx context.Context, sqlDB *sql.DB) (map[string]*integrityRecord, error) {
+	rows, err := sqlDB.QueryContext(ctx, `SELECT media_path, sha256, COALESCE(size, 0),
+		COALESCE(time_modified, 0), time_verified FROM integrity`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records := map[string]*integrityRecord{}
+	for rows.Next() {
+		var path string
+		var rec integrityRecord
+		if err := rows.Scan(&path, &rec.sha256, &rec.size, &rec.timeModified, &rec.timeVerified); err != nil {
+			return nil, err
+		}
+		records[path] = &rec
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,80 @@
+package commands_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+func TestVerifyCmd(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	fixture.CreateFileTree(map[string]any{
+		"good.mkv":   "healthy content",
+		"rotten.mkv": "original bytes",
+		"edited.mkv": "first version",
+	})
+	path := func(rel string) string { return filepath.Join(fixture.TempDir, rel) }
+
+	sqlDB, _ := sql.Open("sqlite3", fixture.DBPath)
+	db.InitDB(context.Background(), sqlDB)
+	sqlDB.Exec("INSERT INTO media (path) VALUES (?), (?), (?)", path("good.mkv"), path("rotten.mkv"), path("edited.mkv"))
+	sqlDB.Close()
+
+	run := func() error {
+		cmd := &commands.VerifyCmd{Databases: []string{fixture.DBPath}}
+		return cmd.Run(context.Background())
+	}
+	if err := run(); err != nil {
+		t.Fatalf("first verify failed: %v", err)
+	}
+
+	sqlDB = fixture.GetDB()
+	defer sqlDB.Close()
+	var baselines int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM integrity WHERE status = 'ok'").Scan(&baselines)
+	if baselines != 3 {
+		t.Fatalf("expected a baseline for every file, got %d", baselines)
+	}
+
+	// Flip bytes without changing the size or mtime, as a failing disk would
+	rotten := path("rotten.mkv")
+	stat, _ := os.Stat(rotten)
+	os.WriteFile(rotten, []byte("originax bytes"), 0o644)
+	os.Chtimes(rotten, stat.ModTime(), stat.ModTime())
+	// An edit changes the mtime and is a new baseline, not corruption
+	os.WriteFile(path("edited.mkv"), []byte("second version, longer"), 0o644)
+
+	if err := run(); err == nil {
+		t.Error("expected verify to report the corrupted file")
+	}
+
+	status := func(p string) string {
+		var s string
+		sqlDB.QueryRow("SELECT status FROM integrity WHERE media_path = ?", p).Scan(&s)
+		return s
+	}
+	if got := status(rotten); got != "failed" {
+		t.Errorf("expected the silently changed file to fail, got %q", got)
+	}
+	if got := status(path("edited.mkv")); got != "ok" {
+		t.Errorf("expected the edited file to be rebaselined, got %q", got)
+	}
+
+	media, err := query.MediaQuery(context.Background(), []string{fixture.DBPath},
+		models.GlobalFlags{FilterFlags: models.FilterFlags{IntegrityFailed: true}})
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if len(media) != 1 || media[0].Path != rotten {
+		t.Errorf("expected only the corrupted file with --integrity-failed, got %v", media)
+	}
+}
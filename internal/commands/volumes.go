@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+type VolumesCmd struct {
+	models.CoreFlags    `embed:""`
+	models.DisplayFlags `embed:""`
+
+	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+}
+
+type volumeStatus struct {
+	db.Volume
+
+	DB     string `json:"db"`
+	Online bool   `json:"online"`
+}
+
+func (c *VolumesCmd) Run(ctx context.Context) error {
+	models.SetupLogging(c.Verbose)
+	var statuses []volumeStatus
+	for _, dbPath := range c.Databases {
+		sqlDB, queries, err := db.ConnectWithInit(ctx, dbPath)
+		if err != nil {
+			return err
+		}
+		backfillVolumes(ctx, queries)
+		volumes, err := queries.GetVolumes(ctx)
+		sqlDB.Close()
+		if err != nil {
+			return err
+		}
+		offline := query.OfflineRoots(ctx, []string{dbPath})
+		for _, v := range volumes {
+			statuses = append(statuses, volumeStatus{Volume: v, DB: dbPath, Online: !query.PathOffline(v.Root, offline)})
+		}
+	}
+
+	if c.JSON {
+		return utils.PrintJSON(statuses)
+	}
+	if len(statuses) == 0 {
+		fmt.Println("No volumes recorded; run disco add to record scan roots")
+		return nil
+	}
+	fmt.Println(strings.Join([]string{"status", "root", "mount_point", "volume", "fs_type"}, "\t"))
+	offline := 0
+	for _, s := range statuses {
+		status := "online"
+		if !s.Online {
+			status = "offline"
+			offline++
+		}
+		volume := s.UUID
+		if s.Label != "" {
+			volume = s.Label
+		}
+		if volume == "" {
+			volume = s.Source
+		}
+		fmt.Println(strings.Join([]string{status, s.Root, s.MountPoint, volume, s.FSType}, "\t"))
+	}
+	fmt.Printf("\n%d scan roots, %d offline\n", len(statuses), offline)
+	return nil
+}
+
+// recordVolume stores the volume a scan root is on, so that its files can be told
+// apart from deleted ones when the volume is unplugged. An unplugged drive leaves its
+// empty mount point directory on the parent filesystem, so a recorded volume is only
+// replaced by a different one while it is mounted or when the root has files
+func recordVolume(ctx context.Context, queries *db.Queries, root string) {
+	v, err := utils.GetVolume(root)
+	if err != nil {
+		models.LogFrom(ctx).Debug("Failed to identify volume", "path", root, "error", err)
+		return
+	}
+	if recorded, err := queries.GetVolume(ctx, root); err == nil {
+		old := query.RecordedVolume(recorded)
+		if (old.ID() != v.ID() || old.MountPoint != v.MountPoint) &&
+			!utils.VolumeMounted(old) && !utils.DirHasEntries(root) {
+			models.LogFrom(ctx).Warn("Keeping the recorded volume of an empty scan root; its drive may be unplugged",
+				"path", root, "volume", old.ID(), "mount_point", old.MountPoint)
+			return
+		}
+	}
+	err = queries.UpsertVolume(ctx, db.Volume{
+		Root:       root,
+		MountPoint: v.MountPoint,
+		UUID:       v.UUID,
+		Label:      v.Label,
+		Source:     v.Source,
+		FSType:     v.FSType,
+	})
+	if err != nil {
+		models.LogFrom(ctx).Warn("Failed to record volume", "path", root, "error", err)
+	}
+}
+
+// backfillVolumes records the volumes of scan roots added before volumes were
+// recorded. Empty or missing roots are left for later: their drive may be unplugged
+func backfillVolumes(ctx context.Context, queries *db.Queries) {
+	roots, err := queries.GetScanRoots(ctx)
+	if err != nil {
+		models.LogFrom(ctx).Debug("Failed to list scan roots", "error", err)
+		return
+	}
+	volumes, err := queries.GetVolumes(ctx)
+	if err != nil {
+		models.LogFrom(ctx).Debug("Failed to list volumes", "error", err)
+		return
+	}
+	recorded := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		recorded[v.Root] = true
+	}
+	for _, root := range roots {
+		if !recorded[root.Path] && utils.DirHasEntries(root.Path) {
+			recordVolume(ctx, queries, root.Path)
+		}
+	}
+}
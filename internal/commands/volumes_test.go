@@ -0,0 +1,199 @@
+package commands_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/commands"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/query"
+	"github.com/chapmanjacobd/discoteca/internal/testutils"
+)
+
+// setupOfflineDB adds a scanned folder and a scan root on a volume that is no longer
+// mounted. It returns a path on the offline volume and one that was deleted
+func setupOfflineDB(t *testing.T, fixture *testutils.TestFixture) (string, string) {
+	t.Helper()
+	fixture.CreateDummyFile("online/a.mp4")
+	addCmd := &commands.AddCmd{Args: []string{fixture.DBPath, filepath.Join(fixture.TempDir, "online")}}
+	addCmd.AfterApply()
+	if err := addCmd.Run(context.Background()); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+
+	offlineRoot := filepath.Join(fixture.TempDir, "drive", "movies")
+	offlinePath := filepath.Join(offlineRoot, "b.mp4")
+	deletedPath := filepath.Join(fixture.TempDir, "online", "gone.mp4")
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	sqlDB.Exec("INSERT INTO volumes (root, mount_point, uuid) VALUES (?, ?, 'unplugged')",
+		offlineRoot, filepath.Join(fixture.TempDir, "drive"))
+	sqlDB.Exec("INSERT INTO media (path, media_type) VALUES (?, 'video'), (?, 'video')", offlinePath, deletedPath)
+	return offlinePath, deletedPath
+}
+
+func TestCheckCmd_OfflineVolume(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	offlinePath, deletedPath := setupOfflineDB(t, fixture)
+
+	var roots int
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	sqlDB.QueryRow("SELECT COUNT(*) FROM volumes").Scan(&roots)
+	if roots != 2 {
+		t.Errorf("expected add to record the volume of its scan root, got %d volumes", roots)
+	}
+
+	cmd := &commands.CheckCmd{Args: []string{fixture.DBPath}}
+	cmd.AfterApply()
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+
+	deleted := func(path string) bool {
+		var timeDeleted sql.NullInt64
+		sqlDB.QueryRow("SELECT time_deleted FROM media WHERE path = ?", path).Scan(&timeDeleted)
+		return timeDeleted.Valid && timeDeleted.Int64 > 0
+	}
+	if deleted(offlinePath) {
+		t.Error("expected a file on an unmounted volume not to be marked deleted")
+	}
+	if !deleted(deletedPath) {
+		t.Error("expected a missing file on a mounted volume to be marked deleted")
+	}
+}
+
+func TestMediaQuery_OfflineFilters(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	offlinePath, _ := setupOfflineDB(t, fixture)
+
+	search := func(flags models.GlobalFlags) []string {
+		media, err := query.MediaQuery(context.Background(), []string{fixture.DBPath}, flags)
+		if err != nil {
+			t.Fatalf("query failed: %v", err)
+		}
+		var paths []string
+		for _, m := range media {
+			paths = append(paths, m.Path)
+		}
+		return paths
+	}
+
+	only := search(models.GlobalFlags{DeletedFlags: models.DeletedFlags{OnlyOffline: true}})
+	if len(only) != 1 || only[0] != offlinePath {
+		t.Errorf("expected only the offline file, got %v", only)
+	}
+	for _, path := range search(models.GlobalFlags{DeletedFlags: models.DeletedFlags{HideOffline: true}}) {
+		if path == offlinePath {
+			t.Error("expected --hide-offline to exclude the offline file")
+		}
+	}
+}
+
+func TestServeCmd_HandlePlay_OfflineVolume(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	offlinePath, _ := setupOfflineDB(t, fixture)
+
+	cmd := SetupTestServeCmd(fixture.DBPath)
+	defer cmd.Close()
+
+	reqBody, _ := json.Marshal(map[string]string{"path": offlinePath})
+	req := httptest.NewRequest(http.MethodPost, "/api/play", bytes.NewBuffer(reqBody))
+	req.Header.Set("X-Disco-Token", cmd.APIToken)
+	w := httptest.NewRecorder()
+	cmd.HandlePlay(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for a file on an unmounted volume, got %d", w.Code)
+	}
+
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	var timeDeleted sql.NullInt64
+	sqlDB.QueryRow("SELECT time_deleted FROM media WHERE path = ?", offlinePath).Scan(&timeDeleted)
+	if timeDeleted.Valid && timeDeleted.Int64 > 0 {
+		t.Error("expected the offline file not to be marked deleted")
+	}
+}
+
+func TestAddCmd_KeepsUnpluggedVolume(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+	offlinePath, _ := setupOfflineDB(t, fixture)
+	offlineRoot := filepath.Dir(offlinePath)
+
+	// The drive is unplugged and only its empty mount point folder is left
+	os.MkdirAll(offlineRoot, 0o755)
+	addCmd := &commands.AddCmd{Args: []string{fixture.DBPath, offlineRoot}}
+	addCmd.AfterApply()
+	addCmd.Run(context.Background())
+
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	var uuid sql.NullString
+	sqlDB.QueryRow("SELECT uuid FROM volumes WHERE root = ?", offlineRoot).Scan(&uuid)
+	if uuid.String != "unplugged" {
+		t.Errorf("expected the recorded volume to be kept, got %q", uuid.String)
+	}
+
+	// A root added before volumes were recorded is backfilled by check
+	legacy := filepath.Join(fixture.TempDir, "legacy")
+	fixture.CreateDummyFile("legacy/c.mp4")
+	sqlDB.Exec("INSERT INTO playlists (path, extractor_key) VALUES (?, 'Local')", legacy)
+	checkCmd := &commands.CheckCmd{Args: []string{fixture.DBPath}}
+	checkCmd.AfterApply()
+	if err := checkCmd.Run(context.Background()); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	var n int
+	sqlDB.QueryRow("SELECT COUNT(*) FROM volumes WHERE root = ?", legacy).Scan(&n)
+	if n != 1 {
+		t.Error("expected check to record the volume of an existing scan root")
+	}
+	var timeDeleted sql.NullInt64
+	sqlDB.QueryRow("SELECT time_deleted FROM media WHERE path = ?", offlinePath).Scan(&timeDeleted)
+	if timeDeleted.Valid && timeDeleted.Int64 > 0 {
+		t.Error("expected the file on the unplugged volume not to be marked deleted")
+	}
+}
+
+func TestCheckCmd_MissingRootWithoutVolume(t *testing.T) {
+	fixture := testutils.Setup(t)
+	defer fixture.Cleanup()
+
+	// A root added before volumes were recorded, whose drive is now unplugged
+	legacy := filepath.Join(fixture.TempDir, "legacy")
+	legacyPath := filepath.Join(legacy, "c.mp4")
+	sqlDB := fixture.GetDB()
+	defer sqlDB.Close()
+	if err := testutils.InitTestDB(t, sqlDB); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Exec("INSERT INTO playlists (path, extractor_key) VALUES (?, 'Local')", legacy)
+	sqlDB.Exec("INSERT INTO media (path, media_type) VALUES (?, 'video')", legacyPath)
+
+	if offline := query.OfflineRoots(context.Background(), []string{fixture.DBPath}); len(offline) != 1 ||
+		offline[0] != legacy {
+		t.Errorf("expected the missing root to be offline, got %v", offline)
+	}
+
+	cmd := &commands.CheckCmd{Args: []string{fixture.DBPath}}
+	cmd.AfterApply()
+	if err := cmd.Run(context.Background()); err != nil {
+		t.Fatalf("check failed: %v", err)
+	}
+	var timeDeleted sql.NullInt64
+	sqlDB.QueryRow("SELECT time_deleted FROM media WHERE path = ?", legacyPath).Scan(&timeDeleted)
+	if timeDeleted.Valid && timeDeleted.Int64 > 0 {
+		t.Error("expected a file under a missing root without a recorded volume not to be marked deleted")
+	}
+}
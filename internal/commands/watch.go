@@ -9,8 +9,8 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/chapmanjacobd/discoteca/internal/history"
 	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/player"
 	"github.com/chapmanjacobd/discoteca/internal/query"
 	"github.com/chapmanjacobd/discoteca/internal/utils"
 )
@@ -31,6 +31,8 @@ type WatchCmd struct {
 	models.PostActionFlags  `embed:""`
 
 	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+
+	caster *castSender
 }
 
 func (c *WatchCmd) Run(ctx context.Context) error {
@@ -41,6 +43,13 @@ func (c *WatchCmd) Run(ctx context.Context) error {
 		return err
 	}
 
+	if c.Cast {
+		if c.caster, err = newCastSender(ctx, c.CastDevice, c.Databases); err != nil {
+			return err
+		}
+		defer c.caster.Close()
+	}
+
 	for i, m := range media {
 		if !utils.FileExists(m.Path) {
 			continue
@@ -104,20 +113,21 @@ func (c *WatchCmd) playMedia(
 	flags models.GlobalFlags,
 	m models.MediaWithDB,
 ) (stop bool, playErr error) {
-	args := c.buildMpvArgs(m)
-
-	if c.Cast {
-		if err := CastPlay(ctx, flags, []models.MediaWithDB{m}, false); err != nil {
-			models.Log.Error("Cast failed", "path", m.Path, "error", err)
-		}
-		return false, nil
+	if c.caster != nil {
+		start, _ := c.getStartEnd(mustInt(m.Duration))
+		return c.caster.play(ctx, flags, m, start, false)
 	}
 
 	startTime := time.Now()
-	exitCode, err := runPlayer(ctx, args, m.Path)
+	var session *playbackSession
+	exitCode, err := runPlayer(c.playerCommand(ctx, m), func(pid int) {
+		if c.TrackHistory {
+			session = startPlaybackSession(ctx, mpvSessionSocket(c.PlaybackFlags), pid, m)
+		}
+	})
 
-	if c.TrackHistory {
-		if err2 := c.updateHistory(ctx, flags, m, startTime); err2 != nil {
+	if session != nil {
+		if err2 := session.finish(ctx, flags, startTime); err2 != nil {
 			models.Log.Error("Warning: failed to update history", "path", m.Path, "error", err2)
 		}
 	}
@@ -133,78 +143,39 @@ func (c *WatchCmd) playMedia(
 	return false, err
 }
 
-func (c *WatchCmd) buildMpvArgs(m models.MediaWithDB) []string {
-	player := c.OverridePlayer
-	if player == "" {
-		player = "mpv"
-	}
-	args := []string{player}
-
-	if player == "mpv" {
-		args = c.appendWatchMpvFlags(args)
-		args = c.appendSubtitleArgs(args)
-		args = c.appendPlaybackArgs(args, mustInt(m.Duration))
+func (c *WatchCmd) playerCommand(ctx context.Context, m models.MediaWithDB) *exec.Cmd {
+	start, end := c.getStartEnd(mustInt(m.Duration))
+	opts := player.LaunchOptions{
+		Start:        start,
+		End:          end,
+		Volume:       c.Volume,
+		Speed:        c.Speed,
+		Fullscreen:   c.Fullscreen,
+		Mute:         c.Mute,
+		Loop:         c.Loop,
+		SavePosition: c.SavePlayhead,
 	}
 
-	args = append(args, m.Path)
-	return args
-}
-
-func (c *WatchCmd) appendWatchMpvFlags(args []string) []string {
-	if c.Volume > 0 {
-		args = append(args, fmt.Sprintf("--volume=%d", c.Volume))
-	}
-	if c.Fullscreen {
-		args = append(args, "--fullscreen")
-	}
-	if c.Mute {
-		args = append(args, "--mute=yes")
-	}
-	if c.Loop {
-		args = append(args, "--loop-file=inf")
-	}
-	if c.SavePlayhead {
-		args = append(args, "--save-position-on-quit")
-	}
-
-	ipcSocket := c.MpvSocket
-	if ipcSocket == "" {
-		ipcSocket = utils.GetMpvWatchSocket()
-	}
-	args = append(args, fmt.Sprintf("--input-ipc-server=%s", ipcSocket))
-	return args
-}
-
-func (c *WatchCmd) appendSubtitleArgs(args []string) []string {
 	useSubs := !c.NoSubtitles
 	if useSubs && c.SubtitleMix > 0 {
 		if utils.RandomFloat() < c.SubtitleMix {
 			useSubs = false
 		}
 	}
-
-	if !useSubs {
-		args = append(args, "--no-sub")
-		args = append(args, c.PlayerArgsNoSub...)
-	} else {
-		args = append(args, c.PlayerArgsSub...)
-	}
-	return args
-}
-
-func (c *WatchCmd) appendPlaybackArgs(args []string, duration int) []string {
-	if c.Speed != 1.0 {
-		args = append(args, fmt.Sprintf("--speed=%.2f", c.Speed))
+	p := playerFor(c.PlaybackFlags)
+	// --player-args-sub and --player-args-no-sub are mpv options
+	mpv := p.Name() == player.BackendMpv
+	switch {
+	case useSubs && mpv:
+		opts.ExtraArgs = c.PlayerArgsSub
+	case !useSubs:
+		opts.NoSubtitles = true
+		if mpv {
+			opts.ExtraArgs = c.PlayerArgsNoSub
+		}
 	}
 
-	start, end := c.getStartEnd(duration)
-	if start != "" {
-		args = append(args, fmt.Sprintf("--start=%s", start))
-	}
-	if end != "" {
-		args = append(args, fmt.Sprintf("--end=%s", end))
-	}
-	return args
+	return p.Command(ctx, []string{m.Path}, opts)
 }
 
 func (c *WatchCmd) getStartEnd(duration int) (start, end string) {
@@ -218,18 +189,6 @@ func (c *WatchCmd) getStartEnd(duration int) (start, end string) {
 	return start, end
 }
 
-func (c *WatchCmd) updateHistory(
-	ctx context.Context,
-	flags models.GlobalFlags,
-	m models.MediaWithDB,
-	startTime time.Time,
-) error {
-	mediaDuration := mustInt(m.Duration)
-	existingPlayhead := mustInt(m.Playhead)
-	playhead := utils.GetPlayhead(flags, m.Path, startTime, existingPlayhead, mediaDuration)
-	return history.UpdateHistorySimple(ctx, m.DB, []string{m.Path}, playhead, false)
-}
-
 type ListenCmd struct {
 	models.CoreFlags        `embed:""`
 	models.QueryFlags       `embed:""`
@@ -246,6 +205,8 @@ type ListenCmd struct {
 	models.PostActionFlags  `embed:""`
 
 	Databases []string `help:"SQLite database files" required:"true" arg:"" type:"existingfile"`
+
+	caster *castSender
 }
 
 func (c *ListenCmd) Run(ctx context.Context) error {
@@ -256,6 +217,13 @@ func (c *ListenCmd) Run(ctx context.Context) error {
 		return err
 	}
 
+	if c.Cast {
+		if c.caster, err = newCastSender(ctx, c.CastDevice, c.Databases); err != nil {
+			return err
+		}
+		defer c.caster.Close()
+	}
+
 	for _, m := range media {
 		if !utils.FileExists(m.Path) {
 			continue
@@ -315,20 +283,21 @@ func (c *ListenCmd) playMedia(
 	flags models.GlobalFlags,
 	m models.MediaWithDB,
 ) (stop bool, playErr error) {
-	args := c.buildMpvArgs(m)
-
-	if c.Cast {
-		if err := CastPlay(ctx, flags, []models.MediaWithDB{m}, true); err != nil {
-			models.Log.Error("Cast failed", "path", m.Path, "error", err)
-		}
-		return false, nil
+	if c.caster != nil {
+		start, _ := c.getStartEnd(mustInt(m.Duration))
+		return c.caster.play(ctx, flags, m, start, true)
 	}
 
 	startTime := time.Now()
-	exitCode, err := runPlayer(ctx, args, m.Path)
+	var session *playbackSession
+	exitCode, err := runPlayer(c.playerCommand(ctx, m), func(pid int) {
+		if c.TrackHistory {
+			session = startPlaybackSession(ctx, mpvSessionSocket(c.PlaybackFlags), pid, m)
+		}
+	})
 
-	if c.TrackHistory {
-		if err2 := c.updateHistory(ctx, flags, m, startTime); err2 != nil {
+	if session != nil {
+		if err2 := session.finish(ctx, flags, startTime); err2 != nil {
 			models.Log.Warn("Failed to update history", "error", err2)
 		}
 	}
@@ -344,54 +313,17 @@ func (c *ListenCmd) playMedia(
 	return false, err
 }
 
-func (c *ListenCmd) buildMpvArgs(m models.MediaWithDB) []string {
-	player := c.OverridePlayer
-	if player == "" {
-		player = "mpv"
-	}
-	args := []string{player}
-
-	if player == "mpv" {
-		args = append(args, "--video=no")
-		args = c.appendListenMpvFlags(args)
-		args = c.appendPlaybackArgs(args, mustInt(m.Duration))
-	}
-
-	args = append(args, m.Path)
-	return args
-}
-
-func (c *ListenCmd) appendListenMpvFlags(args []string) []string {
-	if c.Volume > 0 {
-		args = append(args, fmt.Sprintf("--volume=%d", c.Volume))
-	}
-	if c.Speed != 1.0 {
-		args = append(args, fmt.Sprintf("--speed=%.2f", c.Speed))
-	}
-	if c.Mute {
-		args = append(args, "--mute=yes")
-	}
-	if c.Loop {
-		args = append(args, "--loop-file=inf")
-	}
-
-	ipcSocket := c.MpvSocket
-	if ipcSocket == "" {
-		ipcSocket = utils.GetMpvWatchSocket()
-	}
-	args = append(args, fmt.Sprintf("--input-ipc-server=%s", ipcSocket))
-	return args
-}
-
-func (c *ListenCmd) appendPlaybackArgs(args []string, duration int) []string {
-	start, end := c.getStartEnd(duration)
-	if start != "" {
-		args = append(args, fmt.Sprintf("--start=%s", start))
-	}
-	if end != "" {
-		args = append(args, fmt.Sprintf("--end=%s", end))
-	}
-	return args
+func (c *ListenCmd) playerCommand(ctx context.Context, m models.MediaWithDB) *exec.Cmd {
+	start, end := c.getStartEnd(mustInt(m.Duration))
+	return playerFor(c.PlaybackFlags).Command(ctx, []string{m.Path}, player.LaunchOptions{
+		Start:   start,
+		End:     end,
+		Volume:  c.Volume,
+		Speed:   c.Speed,
+		Mute:    c.Mute,
+		Loop:    c.Loop,
+		NoVideo: true,
+	})
 }
 
 func (c *ListenCmd) getStartEnd(duration int) (start, end string) {
@@ -405,18 +337,6 @@ func (c *ListenCmd) getStartEnd(duration int) (start, end string) {
 	return start, end
 }
 
-func (c *ListenCmd) updateHistory(
-	ctx context.Context,
-	flags models.GlobalFlags,
-	m models.MediaWithDB,
-	startTime time.Time,
-) error {
-	mediaDuration := mustInt(m.Duration)
-	existingPlayhead := mustInt(m.Playhead)
-	playhead := utils.GetPlayhead(flags, m.Path, startTime, existingPlayhead, mediaDuration)
-	return history.UpdateHistorySimple(ctx, m.DB, []string{m.Path}, playhead, false)
-}
-
 // Shared helpers
 
 func mustInt(p *int64) int {
@@ -426,13 +346,19 @@ func mustInt(p *int64) int {
 	return int(*p)
 }
 
-func runPlayer(ctx context.Context, args []string, _ string) (exitCode int, err error) {
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+// runPlayer runs cmd in the foreground. started, when set, is called with the pid once the process is up
+func runPlayer(cmd *exec.Cmd, started func(pid int)) (exitCode int, err error) {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	cmd.Stdin = os.Stdin
 
-	err = cmd.Run()
+	err = cmd.Start()
+	if err == nil {
+		if started != nil {
+			started(cmd.Process.Pid)
+		}
+		err = cmd.Wait()
+	}
 	if err != nil {
 		exitError := &exec.ExitError{}
 		if errors.As(err, &exitError) {
@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// FeedExtractorKey marks playlists rows that are remote feed subscriptions
+const FeedExtractorKey = "Feed"
+
+// FeedPlaylist is a playlists row for a feed subscription
+type FeedPlaylist struct {
+	ID               int64          `json:"id"`
+	Path             string         `json:"path"`
+	Title            sql.NullString `json:"title"`
+	ExtractorConfig  sql.NullString `json:"extractor_config"`
+	TimeCreated      sql.NullInt64  `json:"time_created"`
+	TimeModified     sql.NullInt64  `json:"time_modified"`
+	HoursUpdateDelay sql.NullInt64  `json:"hours_update_delay"`
+}
+
+// UpsertFeedPlaylistParams are parameters for UpsertFeedPlaylist
+type UpsertFeedPlaylistParams struct {
+	Path             string
+	ExtractorConfig  sql.NullString
+	HoursUpdateDelay sql.NullInt64
+}
+
+// UpsertFeedPlaylist subscribes to a feed, reviving it if it was previously deleted.
+// The new extractor_config is merged into the stored one so state like pending
+// downloads survives re-adding a feed
+func (q *Queries) UpsertFeedPlaylist(ctx context.Context, arg UpsertFeedPlaylistParams) (int64, error) {
+	const query = `INSERT INTO playlists (path, extractor_key, extractor_config, hours_update_delay, time_created, time_modified, time_deleted) VALUES (?, ?, ?, ?, unixepoch(), 0, 0) ON CONFLICT(path) DO UPDATE SET extractor_key = excluded.extractor_key, extractor_config = CASE WHEN json_valid(playlists.extractor_config) AND json_valid(excluded.extractor_config) THEN json_patch(playlists.extractor_config, excluded.extractor_config) ELSE COALESCE(excluded.extractor_config, playlists.extractor_config) END, hours_update_delay = excluded.hours_update_delay, time_deleted = 0 RETURNING id`
+	var id int64
+	err := q.db.QueryRowContext(ctx, query, arg.Path, FeedExtractorKey, arg.ExtractorConfig, arg.HoursUpdateDelay).
+		Scan(&id)
+	return id, err
+}
+
+// GetFeedPlaylists retrieves all non-deleted feed subscriptions
+func (q *Queries) GetFeedPlaylists(ctx context.Context) ([]FeedPlaylist, error) {
+	const query = `SELECT id, path, title, extractor_config, time_created, time_modified, hours_update_delay FROM playlists WHERE extractor_key = ? AND time_deleted = 0 AND path IS NOT NULL ORDER BY path`
+	rows, err := q.db.QueryContext(ctx, query, FeedExtractorKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []FeedPlaylist
+	for rows.Next() {
+		var i FeedPlaylist
+		if err := rows.Scan(
+			&i.ID,
+			&i.Path,
+			&i.Title,
+			&i.ExtractorConfig,
+			&i.TimeCreated,
+			&i.TimeModified,
+			&i.HoursUpdateDelay,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// MarkFeedUpdatedParams are parameters for MarkFeedUpdated
+type MarkFeedUpdatedParams struct {
+	Title           sql.NullString
+	ExtractorConfig sql.NullString
+	TimeModified    sql.NullInt64
+	ID              int64
+}
+
+// MarkFeedUpdated records a successful feed refresh. A NULL title or extractor_config keeps the stored one
+func (q *Queries) MarkFeedUpdated(ctx context.Context, arg MarkFeedUpdatedParams) error {
+	const query = `UPDATE playlists SET title = COALESCE(?, title), extractor_config = COALESCE(?, extractor_config), time_modified = ? WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, arg.Title, arg.ExtractorConfig, arg.TimeModified, arg.ID)
+	return err
+}
+
+// SetFeedConfigParams are parameters for SetFeedConfig
+type SetFeedConfigParams struct {
+	ExtractorConfig sql.NullString
+	ID              int64
+}
+
+// SetFeedConfig replaces the extractor_config of a feed subscription
+func (q *Queries) SetFeedConfig(ctx context.Context, arg SetFeedConfigParams) error {
+	const query = `UPDATE playlists SET extractor_config = ? WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, arg.ExtractorConfig, arg.ID)
+	return err
+}
+
+// UpsertFeedEpisodeParams are parameters for UpsertFeedEpisode
+type UpsertFeedEpisodeParams struct {
+	Path           string
+	PathTokenized  sql.NullString
+	Title          sql.NullString
+	Description    sql.NullString
+	Duration       sql.NullInt64
+	Size           sql.NullInt64
+	TimeCreated    sql.NullInt64
+	MediaType      sql.NullString
+	TimeDownloaded sql.NullInt64
+}
+
+// UpsertFeedEpisode inserts an online media row for a feed enclosure and reports whether it was new.
+// Unlike UpsertMedia it never clears time_deleted, so episodes the user removed stay removed
+func (q *Queries) UpsertFeedEpisode(ctx context.Context, arg UpsertFeedEpisodeParams) (bool, error) {
+	var exists int
+	if err := q.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM media WHERE path = ?`, arg.Path).
+		Scan(&exists); err != nil {
+		return false, err
+	}
+
+	const query = `INSERT INTO media (path, path_tokenized, title, description, duration, size, time_created, time_modified, media_type, time_downloaded, time_deleted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0) ON CONFLICT(path) DO UPDATE SET title = COALESCE(excluded.title, media.title), description = COALESCE(excluded.description, media.description), duration = COALESCE(NULLIF(excluded.duration, 0), media.duration), size = COALESCE(NULLIF(excluded.size, 0), media.size), time_created = COALESCE(excluded.time_created, media.time_created), media_type = COALESCE(media.media_type, excluded.media_type)`
+	_, err := q.db.ExecContext(ctx, query,
+		arg.Path,
+		arg.PathTokenized,
+		arg.Title,
+		arg.Description,
+		arg.Duration,
+		arg.Size,
+		arg.TimeCreated,
+		arg.TimeCreated,
+		arg.MediaType,
+		arg.TimeDownloaded,
+	)
+	return exists == 0, err
+}
@@ -25,6 +25,11 @@ func InitDB(ctx context.Context, sqlDB *sql.DB) error {
 		return fmt.Errorf("failed to create core indexes: %w", err)
 	}
 
+	// 3b. Create the playstate changelog. Its triggers are on media, which Migrate may recreate
+	if _, err := sqlDB.ExecContext(ctx, schema.GetSyncTables()); err != nil {
+		return fmt.Errorf("failed to create sync tables: %w", err)
+	}
+
 	// 4. Create Captions table (ONLY if enabled)
 	if IsFtsEnabled() {
 		// Create captions table if not exists
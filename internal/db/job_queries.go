@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is a background operation run by disco serve. Args holds the
+// kind-specific parameters as JSON
+type Job struct {
+	ID           int64          `json:"id"`
+	Kind         string         `json:"kind"`
+	Args         string         `json:"args"`
+	Status       string         `json:"status"`
+	Done         int64          `json:"done"`
+	Total        int64          `json:"total"`
+	Error        sql.NullString `json:"error"`
+	Log          sql.NullString `json:"-"`
+	TimeCreated  sql.NullInt64  `json:"time_created"`
+	TimeStarted  sql.NullInt64  `json:"time_started"`
+	TimeFinished sql.NullInt64  `json:"time_finished"`
+}
+
+const jobColumns = `id, kind, args, status, done, total, error, log, time_created, time_started, time_finished`
+
+func scanJob(row interface{ Scan(dest ...any) error }) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Kind, &j.Args, &j.Status, &j.Done, &j.Total, &j.Error, &j.Log,
+		&j.TimeCreated, &j.TimeStarted, &j.TimeFinished)
+	return j, err
+}
+
+func (q *Queries) queryJobs(ctx context.Context, query string, args ...any) ([]Job, error) {
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, j)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// InsertJob queues a new job and returns its id
+func (q *Queries) InsertJob(ctx context.Context, kind, args string) (int64, error) {
+	const query = `INSERT INTO jobs (kind, args, status, time_created) VALUES (?, ?, ?, unixepoch())`
+	res, err := q.db.ExecContext(ctx, query, kind, args, JobQueued)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// GetJob retrieves a job by id
+func (q *Queries) GetJob(ctx context.Context, id int64) (Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+	return scanJob(q.db.QueryRowContext(ctx, query, id))
+}
+
+// GetJobs retrieves the most recent jobs, newest first
+func (q *Queries) GetJobs(ctx context.Context, limit int64) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs ORDER BY id DESC LIMIT ?`
+	return q.queryJobs(ctx, query, limit)
+}
+
+// GetUnfinishedJobs retrieves queued and running jobs in submission order
+func (q *Queries) GetUnfinishedJobs(ctx context.Context) ([]Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE status IN (?, ?) ORDER BY id`
+	return q.queryJobs(ctx, query, JobQueued, JobRunning)
+}
+
+// StartJob marks a job as running
+func (q *Queries) StartJob(ctx context.Context, id int64) error {
+	const query = `UPDATE jobs SET status = ?, time_started = COALESCE(time_started, unixepoch()) WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, JobRunning, id)
+	return err
+}
+
+// UpdateJobProgress records how far a running job has got
+func (q *Queries) UpdateJobProgress(ctx context.Context, id, done, total int64) error {
+	const query = `UPDATE jobs SET done = ?, total = ? WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, done, total, id)
+	return err
+}
+
+// FinishJobParams are parameters for FinishJob
+type FinishJobParams struct {
+	ID     int64
+	Status string
+	Error  sql.NullString
+	Log    sql.NullString
+}
+
+// FinishJob records the outcome and log of a job
+func (q *Queries) FinishJob(ctx context.Context, arg FinishJobParams) error {
+	const query = `UPDATE jobs SET status = ?, error = ?, log = ?, time_finished = unixepoch() WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, arg.Status, arg.Error, arg.Log, arg.ID)
+	return err
+}
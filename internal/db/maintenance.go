@@ -27,6 +27,7 @@ func DefaultMaintenanceConfig() MaintenanceConfig {
 type MaintenanceStatus struct {
 	FolderStatsLastRefresh time.Time
 	FTSLastRebuild         time.Time
+	OptimizeLastRun        time.Time
 }
 
 // GetMaintenanceStatus returns the current status of maintenance tasks
@@ -51,9 +52,27 @@ func GetMaintenanceStatus(ctx context.Context, db *sql.DB) (MaintenanceStatus, e
 		status.FTSLastRebuild = time.Unix(ftsTime, 0)
 	}
 
+	// Get last scheduled optimize
+	var optimizeStr string
+	var optimizeTime int64
+	err = db.QueryRowContext(ctx, "SELECT value, last_updated FROM _maintenance_meta WHERE key = 'optimize_last_run'").
+		Scan(&optimizeStr, &optimizeTime)
+	if err == nil && optimizeTime > 0 {
+		status.OptimizeLastRun = time.Unix(optimizeTime, 0)
+	}
+
 	return status, nil
 }
 
+// MarkOptimized records when VACUUM/ANALYZE last ran
+func MarkOptimized(ctx context.Context, db *sql.DB, when time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO _maintenance_meta (key, value, last_updated)
+		VALUES ('optimize_last_run', ?, ?)
+	`, "done", when.Unix())
+	return err
+}
+
 // NeedsRefresh checks if maintenance tasks need to be run based on the last refresh time
 func NeedsRefresh(ctx context.Context, db *sql.DB, interval time.Duration) (bool, error) {
 	status, err := GetMaintenanceStatus(ctx, db)
@@ -67,8 +86,8 @@ func NeedsRefresh(ctx context.Context, db *sql.DB, interval time.Duration) (bool
 	return needsRefresh, nil
 }
 
-// RefreshFolderStats rebuilds the folder_stats materialized view
-func RefreshFolderStats(ctx context.Context, db *sql.DB) error {
+// RefreshFolderStats rebuilds the folder_stats materialized view. db may be a transaction
+func RefreshFolderStats(ctx context.Context, db DBTX) error {
 	Log.Info("Refreshing folder_stats materialized view...")
 	start := time.Now()
 
@@ -96,8 +115,8 @@ func RefreshFolderStats(ctx context.Context, db *sql.DB) error {
 	return nil
 }
 
-// RebuildFTS rebuilds the FTS index
-func RebuildFTS(ctx context.Context, db *sql.DB, dbPath string) error {
+// RebuildFTS rebuilds the FTS index. db may be a transaction
+func RebuildFTS(ctx context.Context, db DBTX, dbPath string) error {
 	Log.Info("Rebuilding FTS index...", "db", dbPath)
 	start := time.Now()
 
@@ -106,7 +125,7 @@ func RebuildFTS(ctx context.Context, db *sql.DB, dbPath string) error {
 	err := db.QueryRowContext(ctx, `
 		SELECT EXISTS (
 			SELECT 1 FROM sqlite_master
-			WHERE type='virtual' AND name='media_fts'
+			WHERE type='table' AND name='media_fts'
 		)
 	`).Scan(&exists)
 	if err != nil {
@@ -168,7 +187,7 @@ func RunMaintenance(ctx context.Context, db *sql.DB, config MaintenanceConfig, d
 
 // PopulateFolderStatsInGo populates folder_stats using Go path manipulation
 // This is used by both maintenance and migration code
-func PopulateFolderStatsInGo(ctx context.Context, db *sql.DB) error {
+func PopulateFolderStatsInGo(ctx context.Context, db DBTX) error {
 	rows, err := db.QueryContext(ctx, `
 		SELECT path, COALESCE(size, 0), COALESCE(duration, 0)
 		FROM media
@@ -421,6 +421,9 @@ func removeConsecutives(s string, chars []string) string {
 func migrateColumns(ctx context.Context, db *sql.DB) error {
 	cols := []columnDef{
 		{"playlists", "title", "TEXT"},
+		{"playlists", "time_created", "INTEGER"},
+		{"playlists", "time_modified", "INTEGER"},
+		{"playlists", "hours_update_delay", "INTEGER"},
 		{"playlist_items", "time_added", "INTEGER DEFAULT 0"},
 		{"media", "path_tokenized", "TEXT"},
 		{"media", "description", "TEXT"},
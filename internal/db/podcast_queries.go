@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Podcast feed kinds
+const (
+	PodcastKindPlaylist = "playlist"
+	PodcastKindFolder   = "folder"
+	PodcastKindQuery    = "query"
+)
+
+// PodcastFeed is a published feed definition. Value is a playlist title,
+// a folder path, or an /api/query query string depending on Kind
+type PodcastFeed struct {
+	Token       string        `json:"token"`
+	Title       string        `json:"title"`
+	Kind        string        `json:"kind"`
+	Value       string        `json:"value"`
+	TimeCreated sql.NullInt64 `json:"time_created"`
+}
+
+// InsertPodcastFeedParams are parameters for InsertPodcastFeed
+type InsertPodcastFeedParams struct {
+	Token string
+	Title string
+	Kind  string
+	Value string
+}
+
+// InsertPodcastFeed publishes a new feed
+func (q *Queries) InsertPodcastFeed(ctx context.Context, arg InsertPodcastFeedParams) error {
+	const query = `INSERT INTO podcast_feeds (token, title, kind, value, time_created) VALUES (?, ?, ?, ?, unixepoch())`
+	_, err := q.db.ExecContext(ctx, query, arg.Token, arg.Title, arg.Kind, arg.Value)
+	return err
+}
+
+// GetPodcastFeeds retrieves all published feeds
+func (q *Queries) GetPodcastFeeds(ctx context.Context) ([]PodcastFeed, error) {
+	const query = `SELECT token, title, kind, value, time_created FROM podcast_feeds ORDER BY title, time_created`
+	rows, err := q.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []PodcastFeed
+	for rows.Next() {
+		var i PodcastFeed
+		if err := rows.Scan(&i.Token, &i.Title, &i.Kind, &i.Value, &i.TimeCreated); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// GetPodcastFeed retrieves a published feed by its token
+func (q *Queries) GetPodcastFeed(ctx context.Context, token string) (PodcastFeed, error) {
+	const query = `SELECT token, title, kind, value, time_created FROM podcast_feeds WHERE token = ?`
+	var i PodcastFeed
+	err := q.db.QueryRowContext(ctx, query, token).
+		Scan(&i.Token, &i.Title, &i.Kind, &i.Value, &i.TimeCreated)
+	return i, err
+}
+
+// DeletePodcastFeed revokes a published feed and reports whether it existed
+func (q *Queries) DeletePodcastFeed(ctx context.Context, token string) (bool, error) {
+	res, err := q.db.ExecContext(ctx, `DELETE FROM podcast_feeds WHERE token = ?`, token)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
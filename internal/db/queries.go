@@ -907,6 +907,13 @@ func (q *Queries) InsertCaption(ctx context.Context, arg InsertCaptionParams) er
 	return err
 }
 
+// DeleteCaptions removes the captions of a media item before they are extracted again
+func (q *Queries) DeleteCaptions(ctx context.Context, mediaPath string) error {
+	const query = `DELETE FROM captions WHERE media_path = ?`
+	_, err := q.db.ExecContext(ctx, query, mediaPath)
+	return err
+}
+
 // InsertHistoryParams are parameters for InsertHistory
 type InsertHistoryParams struct {
 	MediaPath  string
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// LocalExtractorKey marks playlists rows that are folders recorded by disco add
+const LocalExtractorKey = "Local"
+
+// ScanRoot is a playlists row for a folder recorded by disco add
+type ScanRoot struct {
+	ID               int64         `json:"id"`
+	Path             string        `json:"path"`
+	TimeModified     sql.NullInt64 `json:"time_modified"`
+	HoursUpdateDelay sql.NullInt64 `json:"hours_update_delay"`
+}
+
+// GetScanRoots retrieves the folders disco add has scanned
+func (q *Queries) GetScanRoots(ctx context.Context) ([]ScanRoot, error) {
+	const query = `SELECT id, path, time_modified, hours_update_delay FROM playlists WHERE extractor_key = ? AND time_deleted = 0 AND path IS NOT NULL ORDER BY path`
+	rows, err := q.db.QueryContext(ctx, query, LocalExtractorKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []ScanRoot
+	for rows.Next() {
+		var i ScanRoot
+		if err := rows.Scan(&i.ID, &i.Path, &i.TimeModified, &i.HoursUpdateDelay); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// MarkScanRootScanned records when a scan root was last rescanned
+func (q *Queries) MarkScanRootScanned(ctx context.Context, id, timeModified int64) error {
+	const query = `UPDATE playlists SET time_modified = ? WHERE id = ?`
+	_, err := q.db.ExecContext(ctx, query, timeModified, id)
+	return err
+}
@@ -0,0 +1,10 @@
+package schema
+
+// GetChecksTables returns the tables that record integrity and media-check results
+func GetChecksTables() string {
+	data, err := SchemaFS.ReadFile("checks.sql")
+	if err != nil {
+		panic("checks.sql not found: " + err.Error())
+	}
+	return string(data)
+}
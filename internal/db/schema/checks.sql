@@ -0,0 +1,26 @@
+-- Checksums recorded by `disco verify`. A file whose content no longer matches while
+-- its size and mtime did not change is marked failed; sha256 keeps the good hash
+CREATE TABLE IF NOT EXISTS integrity (
+    media_path TEXT PRIMARY KEY,
+    sha256 TEXT NOT NULL,
+    size INTEGER,
+    time_modified INTEGER,
+    time_verified INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'ok',
+    time_failed INTEGER,
+    FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS idx_integrity_time_verified ON integrity(time_verified);
+
+-- Latest result of `disco media-check` per file. corruption is the share of the
+-- file that failed to decode, from 0 to 1
+CREATE TABLE IF NOT EXISTS media_checks (
+    media_path TEXT PRIMARY KEY,
+    corruption REAL NOT NULL,
+    scan_type TEXT NOT NULL,
+    time_checked INTEGER NOT NULL,
+    FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS idx_media_checks_time_checked ON media_checks(time_checked);
@@ -24,3 +24,29 @@ CREATE TABLE IF NOT EXISTS _maintenance_meta (
 -- Initialize maintenance tracking keys
 INSERT OR IGNORE INTO _maintenance_meta (key, value, last_updated) VALUES ('folder_stats_last_refresh', '0', 0);
 INSERT OR IGNORE INTO _maintenance_meta (key, value, last_updated) VALUES ('fts_last_rebuild', '0', 0);
+
+-- Playlists, folders, and saved queries published as podcast feeds by `disco serve`
+-- The token is the only credential podcast apps send, so it is scoped to one feed
+CREATE TABLE IF NOT EXISTS podcast_feeds (
+    token TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    time_created INTEGER DEFAULT (unixepoch())
+) STRICT;
+
+-- Background jobs run by `disco serve`. Jobs still queued or running when the
+-- server stops are resumed when it starts again
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    args TEXT NOT NULL,
+    status TEXT NOT NULL,
+    done INTEGER DEFAULT 0,
+    total INTEGER DEFAULT 0,
+    error TEXT,
+    log TEXT,
+    time_created INTEGER DEFAULT (unixepoch()),
+    time_started INTEGER,
+    time_finished INTEGER
+) STRICT;
@@ -4,6 +4,9 @@ CREATE TABLE IF NOT EXISTS playlists (
     title TEXT,
     extractor_key TEXT,
     extractor_config TEXT,
+    time_created INTEGER,
+    time_modified INTEGER,
+    hours_update_delay INTEGER,
     time_deleted INTEGER DEFAULT 0
 ) STRICT;
 
@@ -16,3 +19,15 @@ CREATE TABLE IF NOT EXISTS playlist_items (
     FOREIGN KEY (playlist_id) REFERENCES playlists(id) ON DELETE CASCADE,
     FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
 ) STRICT;
+
+-- Volume of each local scan root, recorded by `disco add`. Missing files under a
+-- root whose volume is not mounted are offline rather than deleted
+CREATE TABLE IF NOT EXISTS volumes (
+    root TEXT PRIMARY KEY,
+    mount_point TEXT NOT NULL,
+    uuid TEXT,
+    label TEXT,
+    source TEXT,
+    fs_type TEXT,
+    time_seen INTEGER
+) STRICT;
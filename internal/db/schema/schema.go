@@ -8,7 +8,7 @@ import (
 //go:embed *.sql
 var SchemaFS embed.FS
 
-// GetCoreTables returns the SQL to create all core tables (media, playlists, history, meta)
+// GetCoreTables returns the SQL to create all core tables (media, playlists, history, meta, checks)
 func GetCoreTables() string {
 	var sb strings.Builder
 	sb.WriteString(GetMediaTable())
@@ -18,6 +18,8 @@ func GetCoreTables() string {
 	sb.WriteString(GetHistoryTable())
 	sb.WriteString("\n")
 	sb.WriteString(GetMetaTables())
+	sb.WriteString("\n")
+	sb.WriteString(GetChecksTables())
 	return sb.String()
 }
 
@@ -45,6 +47,8 @@ func GetSchema() string {
 	sb.WriteString("\n")
 	sb.WriteString(GetCoreIndexes())
 	sb.WriteString("\n")
+	sb.WriteString(GetSyncTables())
+	sb.WriteString("\n")
 	sb.WriteString(GetCaptionsTable())
 	sb.WriteString("\n")
 	sb.WriteString(GetFTSTables())
@@ -0,0 +1,10 @@
+package schema
+
+// GetSyncTables returns the playstate changelog tables and the media triggers that fill it
+func GetSyncTables() string {
+	data, err := SchemaFS.ReadFile("sync.sql")
+	if err != nil {
+		panic("sync.sql not found: " + err.Error())
+	}
+	return string(data)
+}
@@ -0,0 +1,63 @@
+-- Playstate changes for `disco sync`. There is one row per media path and column,
+-- updated in place. time_changed (unix ms) resolves conflicts per column and seq is
+-- the cursor other libraries resume from. source is the library a change was applied
+-- from, which it is not sent back to; NULL for changes made here
+CREATE TABLE IF NOT EXISTS playstate_changes (
+    media_path TEXT NOT NULL,
+    column_name TEXT NOT NULL,
+    time_changed INTEGER NOT NULL,
+    seq INTEGER NOT NULL,
+    source TEXT,
+    PRIMARY KEY (media_path, column_name)
+) STRICT;
+
+CREATE INDEX IF NOT EXISTS idx_playstate_changes_seq ON playstate_changes(seq);
+
+-- How far this library has applied each other library's changes and history
+CREATE TABLE IF NOT EXISTS sync_peers (
+    peer_id TEXT PRIMARY KEY,
+    change_seq INTEGER DEFAULT 0,
+    history_id INTEGER DEFAULT 0,
+    time_synced INTEGER
+) STRICT;
+
+-- Has a row only inside the transaction that applies another library's changes,
+-- so the triggers below don't stamp those changes as local ones
+CREATE TABLE IF NOT EXISTS sync_applying (
+    peer_id TEXT NOT NULL
+) STRICT;
+
+CREATE TRIGGER IF NOT EXISTS media_sync_playhead AFTER UPDATE OF playhead ON media
+WHEN NEW.playhead IS NOT OLD.playhead AND NOT EXISTS (SELECT 1 FROM sync_applying) BEGIN
+    INSERT INTO playstate_changes (media_path, column_name, time_changed, seq)
+    VALUES (NEW.path, 'playhead', CAST(unixepoch('subsec') * 1000 AS INTEGER), (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes))
+    ON CONFLICT (media_path, column_name) DO UPDATE SET time_changed = excluded.time_changed, seq = excluded.seq, source = NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_sync_play_count AFTER UPDATE OF play_count ON media
+WHEN NEW.play_count IS NOT OLD.play_count AND NOT EXISTS (SELECT 1 FROM sync_applying) BEGIN
+    INSERT INTO playstate_changes (media_path, column_name, time_changed, seq)
+    VALUES (NEW.path, 'play_count', CAST(unixepoch('subsec') * 1000 AS INTEGER), (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes))
+    ON CONFLICT (media_path, column_name) DO UPDATE SET time_changed = excluded.time_changed, seq = excluded.seq, source = NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_sync_score AFTER UPDATE OF score ON media
+WHEN NEW.score IS NOT OLD.score AND NOT EXISTS (SELECT 1 FROM sync_applying) BEGIN
+    INSERT INTO playstate_changes (media_path, column_name, time_changed, seq)
+    VALUES (NEW.path, 'score', CAST(unixepoch('subsec') * 1000 AS INTEGER), (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes))
+    ON CONFLICT (media_path, column_name) DO UPDATE SET time_changed = excluded.time_changed, seq = excluded.seq, source = NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_sync_categories AFTER UPDATE OF categories ON media
+WHEN NEW.categories IS NOT OLD.categories AND NOT EXISTS (SELECT 1 FROM sync_applying) BEGIN
+    INSERT INTO playstate_changes (media_path, column_name, time_changed, seq)
+    VALUES (NEW.path, 'categories', CAST(unixepoch('subsec') * 1000 AS INTEGER), (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes))
+    ON CONFLICT (media_path, column_name) DO UPDATE SET time_changed = excluded.time_changed, seq = excluded.seq, source = NULL;
+END;
+
+CREATE TRIGGER IF NOT EXISTS media_sync_time_deleted AFTER UPDATE OF time_deleted ON media
+WHEN NEW.time_deleted IS NOT OLD.time_deleted AND NOT EXISTS (SELECT 1 FROM sync_applying) BEGIN
+    INSERT INTO playstate_changes (media_path, column_name, time_changed, seq)
+    VALUES (NEW.path, 'time_deleted', CAST(unixepoch('subsec') * 1000 AS INTEGER), (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes))
+    ON CONFLICT (media_path, column_name) DO UPDATE SET time_changed = excluded.time_changed, seq = excluded.seq, source = NULL;
+END;
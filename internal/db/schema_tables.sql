@@ -22,6 +22,18 @@ CREATE TABLE IF NOT EXISTS playlist_items (
     FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
 ) STRICT;
 
+-- Volume of each local scan root, recorded by `disco add`. Missing files under a
+-- root whose volume is not mounted are offline rather than deleted
+CREATE TABLE IF NOT EXISTS volumes (
+    root TEXT PRIMARY KEY,
+    mount_point TEXT NOT NULL,
+    uuid TEXT,
+    label TEXT,
+    source TEXT,
+    fs_type TEXT,
+    time_seen INTEGER
+) STRICT;
+
 CREATE TABLE IF NOT EXISTS media (
     path TEXT PRIMARY KEY,
     path_tokenized TEXT, -- Processed path for FTS (dots replaced by spaces etc)
@@ -84,12 +96,40 @@ CREATE TABLE IF NOT EXISTS history (
     FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
 ) STRICT;
 
+CREATE TABLE IF NOT EXISTS integrity (
+    media_path TEXT PRIMARY KEY,
+    sha256 TEXT NOT NULL,
+    size INTEGER,
+    time_modified INTEGER,
+    time_verified INTEGER NOT NULL,
+    status TEXT NOT NULL DEFAULT 'ok',
+    time_failed INTEGER,
+    FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
+) STRICT;
+
+CREATE TABLE IF NOT EXISTS media_checks (
+    media_path TEXT PRIMARY KEY,
+    corruption REAL NOT NULL,
+    scan_type TEXT NOT NULL,
+    time_checked INTEGER NOT NULL,
+    FOREIGN KEY (media_path) REFERENCES media(path) ON DELETE CASCADE
+) STRICT;
+
 CREATE TABLE IF NOT EXISTS custom_keywords (
     category TEXT NOT NULL,
     keyword TEXT NOT NULL,
     PRIMARY KEY (category, keyword)
 ) STRICT;
 
+-- Playlists, folders, and saved queries published as podcast feeds by `disco serve`
+CREATE TABLE IF NOT EXISTS podcast_feeds (
+    token TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    value TEXT NOT NULL,
+    time_created INTEGER DEFAULT (unixepoch())
+) STRICT;
+
 -- Materialized view for folder statistics (optimizes /api/du endpoint)
 -- This pre-aggregates folder-level stats to avoid expensive GROUP BY queries
 CREATE TABLE IF NOT EXISTS folder_stats (
@@ -0,0 +1,332 @@
+package db
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SyncColumns are the media columns disco sync exchanges, with the Go type each is applied as
+var SyncColumns = map[string]string{
+	"playhead":     "int",
+	"play_count":   "int",
+	"score":        "float",
+	"categories":   "text",
+	"time_deleted": "int",
+}
+
+// PlaystateChange is the latest value of one media column
+type PlaystateChange struct {
+	Path        string `json:"path"`
+	Column      string `json:"column"`
+	Value       any    `json:"value"`
+	TimeChanged int64  `json:"time_changed"` // unix ms
+	Seq         int64  `json:"seq"`
+}
+
+// SyncHistory is a history row as exchanged by disco sync
+type SyncHistory struct {
+	ID         int64  `json:"id"`
+	Path       string `json:"path"`
+	TimePlayed int64  `json:"time_played"`
+	Playhead   int64  `json:"playhead"`
+	Done       int64  `json:"done"`
+}
+
+// SyncCursor is how far one library has applied another library's changes
+type SyncCursor struct {
+	ChangeSeq int64 `json:"change_seq"`
+	HistoryID int64 `json:"history_id"`
+}
+
+// SyncBatch is a page of changes and history rows from one library
+type SyncBatch struct {
+	Source  string            `json:"source"`
+	Changes []PlaystateChange `json:"changes"`
+	History []SyncHistory     `json:"history"`
+	More    bool              `json:"more"`
+}
+
+// Cursor is the position to resume from after applying b
+func (b SyncBatch) Cursor(from SyncCursor) SyncCursor {
+	for _, c := range b.Changes {
+		from.ChangeSeq = max(from.ChangeSeq, c.Seq)
+	}
+	for _, h := range b.History {
+		from.HistoryID = max(from.HistoryID, h.ID)
+	}
+	return from
+}
+
+// SyncResult counts what applying a batch did
+type SyncResult struct {
+	Applied int `json:"applied"`
+	Older   int `json:"older"`   // skipped because the local value changed later
+	Missing int `json:"missing"` // skipped because the path is not in this library
+	History int `json:"history"`
+}
+
+// GetSyncID returns the random id other libraries know this one by. The first call
+// also records the playstate that existed before the changelog, timestamped with
+// time_last_played, so the first sync has something to exchange
+func GetSyncID(ctx context.Context, db *sql.DB) (string, error) {
+	var id string
+	err := db.QueryRowContext(ctx, "SELECT value FROM _maintenance_meta WHERE key = 'sync_id'").Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	id = hex.EncodeToString(b)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	seeds := map[string]string{
+		"playhead":     "playhead > 0",
+		"play_count":   "play_count > 0",
+		"score":        "score IS NOT NULL",
+		"categories":   "categories IS NOT NULL AND categories != ''",
+		"time_deleted": "time_deleted > 0",
+	}
+	for column, where := range seeds {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			INSERT OR IGNORE INTO playstate_changes (media_path, column_name, time_changed, seq)
+			SELECT path, '%s', COALESCE(NULLIF(time_last_played, 0), time_deleted, 0) * 1000,
+				(SELECT COALESCE(MAX(seq), 0) FROM playstate_changes) + ROW_NUMBER() OVER (ORDER BY path)
+			FROM media WHERE %s`, column, where))
+		if err != nil {
+			return "", err
+		}
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO _maintenance_meta (key, value, last_updated) VALUES ('sync_id', ?, ?)",
+		id, time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// GetSyncCursor returns how far this library has applied peer's changes
+func GetSyncCursor(ctx context.Context, db *sql.DB, peer string) (SyncCursor, error) {
+	var c SyncCursor
+	err := db.QueryRowContext(ctx, "SELECT change_seq, history_id FROM sync_peers WHERE peer_id = ?", peer).
+		Scan(&c.ChangeSeq, &c.HistoryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, nil
+	}
+	return c, err
+}
+
+// Validate checks that every change is for a synced column and has a value of its type
+func (b SyncBatch) Validate() error {
+	for _, c := range b.Changes {
+		if _, err := syncValue(c.Column, c.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetSyncBatch returns up to limit changes and limit history rows after cursor for the
+// library peer. Changes that were applied from peer are left out
+func GetSyncBatch(ctx context.Context, db *sql.DB, peer string, cursor SyncCursor, limit int) (SyncBatch, error) {
+	id, err := GetSyncID(ctx, db)
+	if err != nil {
+		return SyncBatch{}, err
+	}
+	batch := SyncBatch{Source: id, Changes: []PlaystateChange{}, History: []SyncHistory{}}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.media_path, c.column_name, c.time_changed, c.seq,
+			CASE c.column_name
+				WHEN 'playhead' THEN m.playhead
+				WHEN 'play_count' THEN m.play_count
+				WHEN 'score' THEN m.score
+				WHEN 'categories' THEN m.categories
+				WHEN 'time_deleted' THEN m.time_deleted
+			END
+		FROM playstate_changes c
+		JOIN media m ON m.path = c.media_path
+		WHERE c.seq > ? AND c.source IS NOT ?
+		ORDER BY c.seq
+		LIMIT ?`, cursor.ChangeSeq, peer, limit)
+	if err != nil {
+		return batch, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c PlaystateChange
+		if err := rows.Scan(&c.Path, &c.Column, &c.TimeChanged, &c.Seq, &c.Value); err != nil {
+			return batch, err
+		}
+		if b, ok := c.Value.([]byte); ok {
+			c.Value = string(b)
+		}
+		batch.Changes = append(batch.Changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return batch, err
+	}
+
+	hrows, err := db.QueryContext(ctx, `
+		SELECT id, media_path, COALESCE(time_played, 0), COALESCE(playhead, 0), COALESCE(done, 0)
+		FROM history
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?`, cursor.HistoryID, limit)
+	if err != nil {
+		return batch, err
+	}
+	defer hrows.Close()
+	for hrows.Next() {
+		var h SyncHistory
+		if err := hrows.Scan(&h.ID, &h.Path, &h.TimePlayed, &h.Playhead, &h.Done); err != nil {
+			return batch, err
+		}
+		batch.History = append(batch.History, h)
+	}
+	if err := hrows.Err(); err != nil {
+		return batch, err
+	}
+
+	batch.More = len(batch.Changes) == limit || len(batch.History) == limit
+	return batch, nil
+}
+
+// syncValue converts a value decoded from JSON to the column's type
+func syncValue(column string, v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	switch SyncColumns[column] {
+	case "int":
+		switch n := v.(type) {
+		case float64:
+			return int64(n), nil
+		case int64:
+			return n, nil
+		}
+	case "float":
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int64:
+			return float64(n), nil
+		}
+	case "text":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	default:
+		return nil, fmt.Errorf("column %q is not synced", column)
+	}
+	return nil, fmt.Errorf("invalid value for %s: %v", column, v)
+}
+
+// ApplySyncBatch applies peer's changes where they are newer than the local value,
+// adds history rows this library doesn't have, and saves the peer's cursor in the same
+// transaction so an interrupted sync resumes where it stopped. Paths must already be
+// mapped to this library's prefixes
+func ApplySyncBatch(ctx context.Context, db *sql.DB, peer string, batch SyncBatch) (SyncResult, error) {
+	var res SyncResult
+	if _, err := GetSyncID(ctx, db); err != nil {
+		return res, err
+	}
+	cursor, err := GetSyncCursor(ctx, db, peer)
+	if err != nil {
+		return res, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return res, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Other connections never see this row: it is deleted before the commit
+	if _, err := tx.ExecContext(ctx, "INSERT INTO sync_applying (peer_id) VALUES (?)", peer); err != nil {
+		return res, err
+	}
+
+	for _, c := range batch.Changes {
+		value, err := syncValue(c.Column, c.Value)
+		if err != nil {
+			return res, err
+		}
+		var local int64
+		err = tx.QueryRowContext(ctx,
+			"SELECT time_changed FROM playstate_changes WHERE media_path = ? AND column_name = ?",
+			c.Path, c.Column).Scan(&local)
+		if err == nil && local >= c.TimeChanged {
+			res.Older++
+			continue
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return res, err
+		}
+
+		// c.Column was checked against SyncColumns by syncValue
+		updated, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE media SET %s = ? WHERE path = ?", c.Column), value, c.Path)
+		if err != nil {
+			return res, err
+		}
+		if n, _ := updated.RowsAffected(); n == 0 {
+			res.Missing++
+			continue
+		}
+		// Keep the peer's timestamp so the change doesn't look newer than it is when it travels
+		// on to other libraries, and remember its source so it is not sent back
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO playstate_changes (media_path, column_name, time_changed, seq, source)
+			VALUES (?, ?, ?, (SELECT COALESCE(MAX(seq), 0) + 1 FROM playstate_changes), ?)
+			ON CONFLICT (media_path, column_name) DO UPDATE SET
+				time_changed = excluded.time_changed, seq = excluded.seq, source = excluded.source`,
+			c.Path, c.Column, c.TimeChanged, peer)
+		if err != nil {
+			return res, err
+		}
+		res.Applied++
+	}
+
+	for _, h := range batch.History {
+		inserted, err := tx.ExecContext(ctx, `
+			INSERT INTO history (media_path, time_played, playhead, done)
+			SELECT ?, ?, ?, ?
+			WHERE EXISTS (SELECT 1 FROM media WHERE path = ?)
+				AND NOT EXISTS (SELECT 1 FROM history WHERE media_path = ? AND time_played = ?)`,
+			h.Path, h.TimePlayed, h.Playhead, h.Done, h.Path, h.Path, h.TimePlayed)
+		if err != nil {
+			return res, err
+		}
+		if n, _ := inserted.RowsAffected(); n > 0 {
+			res.History++
+		}
+	}
+
+	next := batch.Cursor(cursor)
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sync_peers (peer_id, change_seq, history_id, time_synced) VALUES (?, ?, ?, ?)
+		ON CONFLICT (peer_id) DO UPDATE SET
+			change_seq = excluded.change_seq, history_id = excluded.history_id, time_synced = excluded.time_synced`,
+		peer, next.ChangeSeq, next.HistoryID, time.Now().Unix())
+	if err != nil {
+		return res, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sync_applying"); err != nil {
+		return res, err
+	}
+	return res, tx.Commit()
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Volume is the filesystem a scan root was on when it was last scanned
+type Volume struct {
+	Root       string        `json:"root"`
+	MountPoint string        `json:"mount_point"`
+	UUID       string        `json:"uuid,omitempty"`
+	Label      string        `json:"label,omitempty"`
+	Source     string        `json:"source,omitempty"`
+	FSType     string        `json:"fs_type,omitempty"`
+	TimeSeen   sql.NullInt64 `json:"time_seen"`
+}
+
+// UpsertVolume records the volume of a scan root
+func (q *Queries) UpsertVolume(ctx context.Context, arg Volume) error {
+	const query = `INSERT INTO volumes (root, mount_point, uuid, label, source, fs_type, time_seen)
+		VALUES (?, ?, NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), NULLIF(?, ''), unixepoch())
+		ON CONFLICT (root) DO UPDATE SET
+			mount_point = excluded.mount_point, uuid = excluded.uuid, label = excluded.label,
+			source = excluded.source, fs_type = excluded.fs_type, time_seen = excluded.time_seen`
+	_, err := q.db.ExecContext(ctx, query, arg.Root, arg.MountPoint, arg.UUID, arg.Label, arg.Source, arg.FSType)
+	return err
+}
+
+// GetVolume retrieves the recorded volume of a scan root
+func (q *Queries) GetVolume(ctx context.Context, root string) (Volume, error) {
+	const query = `SELECT root, mount_point, COALESCE(uuid, ''), COALESCE(label, ''), COALESCE(source, ''),
+		COALESCE(fs_type, ''), time_seen FROM volumes WHERE root = ?`
+	var i Volume
+	err := q.db.QueryRowContext(ctx, query, root).
+		Scan(&i.Root, &i.MountPoint, &i.UUID, &i.Label, &i.Source, &i.FSType, &i.TimeSeen)
+	return i, err
+}
+
+// GetVolumes retrieves the recorded scan root volumes
+func (q *Queries) GetVolumes(ctx context.Context) ([]Volume, error) {
+	const query = `SELECT root, mount_point, COALESCE(uuid, ''), COALESCE(label, ''), COALESCE(source, ''),
+		COALESCE(fs_type, ''), time_seen FROM volumes ORDER BY root`
+	rows, err := q.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []Volume
+	for rows.Next() {
+		var i Volume
+		if err := rows.Scan(&i.Root, &i.MountPoint, &i.UUID, &i.Label, &i.Source, &i.FSType, &i.TimeSeen); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,348 @@
+package feeds
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// Feed is a parsed RSS, Atom, or iTunes podcast feed
+type Feed struct {
+	Title       string
+	Description string
+	Link        string
+	ImageURL    string
+	Episodes    []Episode
+}
+
+// Episode is a single feed item. Items without an enclosure are kept so
+// callers can decide what to do with them, but most only care about EnclosureURL
+type Episode struct {
+	GUID            string
+	Title           string
+	Description     string
+	Link            string
+	EnclosureURL    string
+	EnclosureType   string
+	EnclosureLength int64
+	Duration        int64 // seconds
+	Published       int64 // unix timestamp
+	ImageURL        string
+}
+
+// MediaType maps the enclosure MIME type (or URL extension) to a media.media_type value
+func (e Episode) MediaType() string {
+	mimeType := strings.ToLower(e.EnclosureType)
+	switch {
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	}
+
+	ext := strings.ToLower(path.Ext(e.urlPath()))
+	switch {
+	case utils.AudioExtensionMap[ext]:
+		return "audio"
+	case utils.VideoExtensionMap[ext]:
+		return "video"
+	case utils.ImageExtensionMap[ext]:
+		return "image"
+	}
+	return ""
+}
+
+// Ext returns the file extension of the enclosure URL, ignoring any query string
+func (e Episode) Ext() string {
+	return strings.ToLower(path.Ext(e.urlPath()))
+}
+
+func (e Episode) urlPath() string {
+	p := e.EnclosureURL
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p = p[:i]
+	}
+	return p
+}
+
+type rssDoc struct {
+	XMLName xml.Name   `xml:"rss"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// Namespaced fields must come before their plain counterparts: encoding/xml
+// assigns an element to the first field whose local name matches
+type rssChannel struct {
+	ItunesImage hrefAttr  `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Title       string    `xml:"title"`
+	Description string    `xml:"description"`
+	Link        string    `xml:"link"`
+	Image       rssImage  `xml:"image"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	URL string `xml:"url"`
+}
+
+type hrefAttr struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	ItunesTitle   string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+	ItunesSummary string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	ItunesImage   hrefAttr     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Duration      string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	GUID          string       `xml:"guid"`
+	Title         string       `xml:"title"`
+	Description   string       `xml:"description"`
+	Link          string       `xml:"link"`
+	PubDate       string       `xml:"pubDate"`
+	Enclosure     rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomDoc struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Links    []atomLink  `xml:"link"`
+	Logo     string      `xml:"logo"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []atomLink `xml:"link"`
+	Duration  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+}
+
+// Parse reads an RSS 2.0 or Atom document
+func Parse(r io.Reader) (*Feed, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := rootElement(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root.Local {
+	case "rss":
+		return parseRSS(data)
+	case "feed":
+		return parseAtom(data)
+	}
+	return nil, fmt.Errorf("unsupported feed format: <%s>", root.Local)
+}
+
+func rootElement(data []byte) (xml.Name, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return xml.Name{}, errors.New("empty feed document")
+			}
+			return xml.Name{}, err
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			return se.Name, nil
+		}
+	}
+}
+
+func newDecoder(data []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Podcast feeds in the wild are frequently sloppy (HTML entities, bare ampersands)
+	dec.Strict = false
+	dec.Entity = xml.HTMLEntity
+	return dec
+}
+
+func parseRSS(data []byte) (*Feed, error) {
+	var doc rssDoc
+	if err := newDecoder(data).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse RSS: %w", err)
+	}
+
+	ch := doc.Channel
+	feed := &Feed{
+		Title:       strings.TrimSpace(ch.Title),
+		Description: strings.TrimSpace(ch.Description),
+		Link:        strings.TrimSpace(ch.Link),
+		ImageURL:    firstNonEmpty(ch.ItunesImage.Href, ch.Image.URL),
+	}
+
+	for _, item := range ch.Items {
+		length, _ := strconv.ParseInt(strings.TrimSpace(item.Enclosure.Length), 10, 64)
+		feed.Episodes = append(feed.Episodes, Episode{
+			GUID:            strings.TrimSpace(item.GUID),
+			Title:           strings.TrimSpace(firstNonEmpty(item.Title, item.ItunesTitle)),
+			Description:     strings.TrimSpace(firstNonEmpty(item.Description, item.ItunesSummary)),
+			Link:            strings.TrimSpace(item.Link),
+			EnclosureURL:    strings.TrimSpace(item.Enclosure.URL),
+			EnclosureType:   strings.TrimSpace(item.Enclosure.Type),
+			EnclosureLength: length,
+			Duration:        ParseDuration(item.Duration),
+			Published:       ParseDate(item.PubDate),
+			ImageURL:        item.ItunesImage.Href,
+		})
+	}
+	return feed, nil
+}
+
+func parseAtom(data []byte) (*Feed, error) {
+	var doc atomDoc
+	if err := newDecoder(data).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse Atom: %w", err)
+	}
+
+	feed := &Feed{
+		Title:       strings.TrimSpace(doc.Title),
+		Description: strings.TrimSpace(doc.Subtitle),
+		ImageURL:    strings.TrimSpace(doc.Logo),
+	}
+	for _, l := range doc.Links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			feed.Link = l.Href
+			break
+		}
+	}
+
+	for _, entry := range doc.Entries {
+		ep := Episode{
+			GUID:        strings.TrimSpace(entry.ID),
+			Title:       strings.TrimSpace(entry.Title),
+			Description: strings.TrimSpace(firstNonEmpty(entry.Summary, entry.Content)),
+			Duration:    ParseDuration(entry.Duration),
+			Published:   ParseDate(firstNonEmpty(entry.Published, entry.Updated)),
+		}
+		for _, l := range entry.Links {
+			switch l.Rel {
+			case "enclosure":
+				if ep.EnclosureURL == "" {
+					ep.EnclosureURL = strings.TrimSpace(l.Href)
+					ep.EnclosureType = l.Type
+					ep.EnclosureLength, _ = strconv.ParseInt(l.Length, 10, 64)
+				}
+			case "", "alternate":
+				if ep.Link == "" {
+					ep.Link = strings.TrimSpace(l.Href)
+				}
+			}
+		}
+		feed.Episodes = append(feed.Episodes, ep)
+	}
+	return feed, nil
+}
+
+// ParseDuration handles itunes:duration values: plain seconds, MM:SS or HH:MM:SS
+func ParseDuration(s string) int64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	if strings.Contains(s, ":") {
+		return int64(utils.FromTimestampSeconds(s))
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(f)
+	}
+	return 0
+}
+
+// ParseDate handles RFC 822 (RSS) and RFC 3339 (Atom) dates, falling back to dateparse
+func ParseDate(s string) int64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	layouts := []string{
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC3339,
+		time.RFC822Z,
+		time.RFC822,
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Unix()
+		}
+	}
+
+	if t := utils.SuperParser(s); t != nil {
+		return t.Unix()
+	}
+	return 0
+}
+
+// Fetch downloads and parses the feed at feedURL
+func Fetch(ctx context.Context, client *http.Client, feedURL string) (*Feed, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status fetching %s: %s", feedURL, resp.Status)
+	}
+
+	return Parse(resp.Body)
+}
+
+func firstNonEmpty(vals ...string) string {
+	for _, v := range vals {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,171 @@
+package feeds_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/feeds"
+)
+
+const podcastRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">
+  <channel>
+    <title>Test Podcast</title>
+    <description>A show about tests</description>
+    <link>https://example.com/</link>
+    <itunes:image href="https://example.com/cover.jpg"/>
+    <item>
+      <title>Episode 2</title>
+      <itunes:title>Ep. 2</itunes:title>
+      <description>Second &amp; final</description>
+      <guid>ep2</guid>
+      <pubDate>Tue, 02 Jan 2024 10:00:00 +0000</pubDate>
+      <enclosure url="https://example.com/ep2.mp3?token=1" length="1234" type="audio/mpeg"/>
+      <itunes:duration>01:02:03</itunes:duration>
+    </item>
+    <item>
+      <title>Episode 1</title>
+      <guid>ep1</guid>
+      <pubDate>Mon, 1 Jan 2024 10:00:00 GMT</pubDate>
+      <enclosure url="https://example.com/ep1.mp4" type="video/mp4"/>
+      <itunes:duration>125</itunes:duration>
+    </item>
+    <item>
+      <title>Blog post without media</title>
+    </item>
+  </channel>
+</rss>`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Atom Show</title>
+  <subtitle>Atom description</subtitle>
+  <link href="https://example.org/"/>
+  <entry>
+    <id>urn:uuid:1</id>
+    <title>First entry</title>
+    <summary>Summary text</summary>
+    <published>2024-03-01T12:00:00Z</published>
+    <link href="https://example.org/1"/>
+    <link rel="enclosure" href="https://example.org/1.ogg" type="audio/ogg" length="42"/>
+  </entry>
+</feed>`
+
+func TestParse_RSS(t *testing.T) {
+	feed, err := feeds.Parse(strings.NewReader(podcastRSS))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if feed.Title != "Test Podcast" {
+		t.Errorf("Expected title 'Test Podcast', got %q", feed.Title)
+	}
+	if feed.ImageURL != "https://example.com/cover.jpg" {
+		t.Errorf("Expected itunes image, got %q", feed.ImageURL)
+	}
+	if len(feed.Episodes) != 3 {
+		t.Fatalf("Expected 3 episodes, got %d", len(feed.Episodes))
+	}
+
+	ep := feed.Episodes[0]
+	if ep.Title != "Episode 2" {
+		t.Errorf("Expected plain title to win over itunes:title, got %q", ep.Title)
+	}
+	if ep.Description != "Second & final" {
+		t.Errorf("Unexpected description %q", ep.Description)
+	}
+	if ep.Duration != 3723 {
+		t.Errorf("Expected duration 3723, got %d", ep.Duration)
+	}
+	if ep.EnclosureLength != 1234 {
+		t.Errorf("Expected length 1234, got %d", ep.EnclosureLength)
+	}
+	want := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC).Unix()
+	if ep.Published != want {
+		t.Errorf("Expected published %d, got %d", want, ep.Published)
+	}
+	if ep.MediaType() != "audio" || ep.Ext() != ".mp3" {
+		t.Errorf("Expected audio .mp3, got %q %q", ep.MediaType(), ep.Ext())
+	}
+
+	if feed.Episodes[1].Duration != 125 || feed.Episodes[1].MediaType() != "video" {
+		t.Errorf("Unexpected second episode: %+v", feed.Episodes[1])
+	}
+	if feed.Episodes[2].EnclosureURL != "" {
+		t.Errorf("Expected no enclosure for text-only item")
+	}
+}
+
+func TestParse_Atom(t *testing.T) {
+	feed, err := feeds.Parse(strings.NewReader(atomFeed))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if feed.Title != "Atom Show" || feed.Link != "https://example.org/" {
+		t.Errorf("Unexpected feed header: %+v", feed)
+	}
+	if len(feed.Episodes) != 1 {
+		t.Fatalf("Expected 1 entry, got %d", len(feed.Episodes))
+	}
+	ep := feed.Episodes[0]
+	if ep.EnclosureURL != "https://example.org/1.ogg" || ep.EnclosureLength != 42 {
+		t.Errorf("Unexpected enclosure: %+v", ep)
+	}
+	if ep.Link != "https://example.org/1" {
+		t.Errorf("Expected alternate link, got %q", ep.Link)
+	}
+	if ep.Published != time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC).Unix() {
+		t.Errorf("Unexpected published %d", ep.Published)
+	}
+}
+
+func TestParse_Unsupported(t *testing.T) {
+	if _, err := feeds.Parse(strings.NewReader("<html><body/></html>")); err == nil {
+		t.Error("Expected error for non-feed document")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]int64{
+		"":        0,
+		"90":      90,
+		"90.5":    90,
+		"05:30":   330,
+		"1:00:00": 3600,
+		"garbage": 0,
+		" 12:00 ": 720,
+	}
+	for in, want := range tests {
+		if got := feeds.ParseDuration(in); got != want {
+			t.Errorf("ParseDuration(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(podcastRSS))
+	}))
+	defer server.Close()
+
+	feed, err := feeds.Fetch(context.Background(), server.Client(), server.URL+"/feed.xml")
+	if err != nil {
+		t.Fatalf("Fetch failed: %v", err)
+	}
+	if len(feed.Episodes) != 3 {
+		t.Errorf("Expected 3 episodes, got %d", len(feed.Episodes))
+	}
+
+	if _, err := feeds.Fetch(context.Background(), server.Client(), server.URL+"/missing"); err == nil {
+		t.Error("Expected error for 404")
+	}
+}
@@ -114,6 +114,99 @@ func UpdateHistorySimple(ctx context.Context, dbPath string, paths []string, pla
 	})
 }
 
+// Session records the progress of one playback while the player is still running,
+// so a crash or kill does not lose it. The first Checkpoint counts the play and inserts
+// a history row; later checkpoints move that row and media.playhead forward
+type Session struct {
+	DBPath    string
+	Path      string
+	db        *sql.DB
+	historyID int64
+}
+
+// NewSession starts tracking playback of path
+func NewSession(dbPath, path string) *Session {
+	return &Session{DBPath: dbPath, Path: path}
+}
+
+// Checkpoint saves the current playhead. The database stays open between checkpoints until Close
+func (s *Session) Checkpoint(ctx context.Context, playhead int, done bool) error {
+	if s.db == nil {
+		sqlDB, err := db.Connect(ctx, s.DBPath)
+		if err != nil {
+			return err
+		}
+		s.db = sqlDB
+	}
+	sqlDB := s.db
+
+	tx, err := sqlDB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	now := time.Now().Unix()
+	doneVal := int64(0)
+	if done {
+		doneVal = 1
+	}
+
+	if s.historyID == 0 {
+		if err := db.New(sqlDB).WithTx(tx).UpdatePlayHistory(ctx, db.UpdatePlayHistoryParams{
+			TimeLastPlayed:  sql.NullInt64{Int64: now, Valid: true},
+			TimeFirstPlayed: sql.NullInt64{Int64: now, Valid: true},
+			Playhead:        sql.NullInt64{Int64: int64(playhead), Valid: true},
+			Path:            s.Path,
+		}); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO history (media_path, time_played, playhead, done) VALUES (?, ?, ?, ?)",
+			s.Path, now, playhead, doneVal,
+		)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		s.historyID = id
+		return nil
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		"UPDATE media SET playhead = ?, time_last_played = ? WHERE path = ?",
+		playhead, now, s.Path,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(
+		ctx,
+		"UPDATE history SET playhead = ?, done = ? WHERE id = ?",
+		playhead, doneVal, s.historyID,
+	); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Close releases the database connection
+func (s *Session) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	err := s.db.Close()
+	s.db = nil
+	return err
+}
+
 // DeleteHistoryByPaths removes history records for specified paths
 func DeleteHistoryByPaths(ctx context.Context, dbPath string, paths []string) error {
 	sqlDB, err := db.Connect(ctx, dbPath)
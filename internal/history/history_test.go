@@ -218,3 +218,43 @@ func TestDeleteHistoryByPaths(t *testing.T) {
 		t.Error("Play count should be reset")
 	}
 }
+
+func TestSession_Checkpoint(t *testing.T) {
+	sqlDB, dbPath := setupTestDB(t)
+	defer os.Remove(dbPath)
+	defer sqlDB.Close()
+
+	path := "/test/long.mkv"
+	if _, err := sqlDB.Exec("INSERT INTO media (path) VALUES (?)", path); err != nil {
+		t.Fatal(err)
+	}
+
+	session := history.NewSession(dbPath, path)
+	defer session.Close()
+	for _, playhead := range []int{30, 60} {
+		if err := session.Checkpoint(context.Background(), playhead, false); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+	}
+	if err := session.Checkpoint(context.Background(), 90, true); err != nil {
+		t.Fatalf("Checkpoint failed: %v", err)
+	}
+
+	var playCount, playhead int64
+	if err := sqlDB.QueryRow("SELECT play_count, playhead FROM media WHERE path = ?", path).
+		Scan(&playCount, &playhead); err != nil {
+		t.Fatal(err)
+	}
+	if playCount != 1 || playhead != 90 {
+		t.Errorf("Expected one play at 90s, got play_count=%d playhead=%d", playCount, playhead)
+	}
+
+	var rows, hPlayhead, done int64
+	if err := sqlDB.QueryRow("SELECT COUNT(*), MAX(playhead), MAX(done) FROM history WHERE media_path = ?", path).
+		Scan(&rows, &hPlayhead, &done); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 || hPlayhead != 90 || done != 1 {
+		t.Errorf("Expected a single finished history row at 90s, got rows=%d playhead=%d done=%d", rows, hPlayhead, done)
+	}
+}
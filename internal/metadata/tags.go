@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// Tags are the text tags that can be written into a media file. Nil fields are left
+// as they are in the file; empty strings remove the tag
+type Tags struct {
+	Title      *string `json:"title,omitempty"`
+	Artist     *string `json:"artist,omitempty"`
+	Album      *string `json:"album,omitempty"`
+	Genre      *string `json:"genre,omitempty"`
+	Categories *string `json:"categories,omitempty"`
+}
+
+// IsEmpty reports whether no tag is set
+func (t Tags) IsEmpty() bool {
+	return t.Title == nil && t.Artist == nil && t.Album == nil && t.Genre == nil && t.Categories == nil
+}
+
+// tagField is one tag with the names the different formats use for it. The names are
+// chosen so that extractFormatTags reads them back from ffprobe
+type tagField struct {
+	key    string // ffmpeg -metadata key
+	vorbis string // Vorbis comment field
+	id3    string // ID3v2 frame; TXXX frames use key as description
+	mp4    string // MP4 ilst atom; "----" atoms use key as name
+	value  string
+}
+
+// fields lists the set tags in a fixed order
+func (t Tags) fields() []tagField {
+	var fields []tagField
+	add := func(v *string, f tagField) {
+		if v != nil {
+			f.value = *v
+			fields = append(fields, f)
+		}
+	}
+	add(t.Title, tagField{key: "title", vorbis: "TITLE", id3: "TIT2", mp4: "\xa9nam"})
+	add(t.Artist, tagField{key: "artist", vorbis: "ARTIST", id3: "TPE1", mp4: "\xa9ART"})
+	add(t.Album, tagField{key: "album", vorbis: "ALBUM", id3: "TALB", mp4: "\xa9alb"})
+	add(t.Genre, tagField{key: "genre", vorbis: "GENRE", id3: "TCON", mp4: "\xa9gen"})
+	add(t.Categories, tagField{key: "categories", vorbis: "CATEGORIES", id3: "TXXX", mp4: "----"})
+	return fields
+}
+
+// errUnsupportedTags is returned by the native writers for files they cannot safely
+// rewrite; WriteTags then falls back to ffmpeg
+var errUnsupportedTags = errors.New("unsupported tag layout")
+
+// tagWriters maps extensions to native writers. Each gets the open source file and
+// writes a complete copy with new tags to w
+var tagWriters = map[string]func(r io.ReadSeeker, w io.Writer, fields []tagField) error{
+	".mp3":  writeID3v2,
+	".flac": writeFLACTags,
+	".ogg":  writeOggTags,
+	".oga":  writeOggTags,
+	".opus": writeOggTags,
+	".m4a":  writeMP4Tags,
+	".m4b":  writeMP4Tags,
+	".mp4":  writeMP4Tags,
+	".m4v":  writeMP4Tags,
+	".mov":  writeMP4Tags,
+}
+
+// WriteTags writes tags into the file at path. MP3, FLAC, Ogg Vorbis/Opus and MP4
+// files are rewritten natively; other containers are remuxed with ffmpeg. The file is
+// replaced atomically so a failed write leaves the original untouched
+func WriteTags(ctx context.Context, path string, tags Tags) error {
+	fields := tags.fields()
+	if len(fields) == 0 {
+		return nil
+	}
+
+	if writer, ok := tagWriters[strings.ToLower(filepath.Ext(path))]; ok {
+		err := replaceFile(path, func(r *os.File, w *os.File) error {
+			return writer(r, w, fields)
+		})
+		if !errors.Is(err, errUnsupportedTags) {
+			return err
+		}
+		models.Log.Debug("Native tag writer cannot handle file, using ffmpeg", "path", path, "error", err)
+	}
+	return writeTagsFFmpeg(ctx, path, fields)
+}
+
+// replaceFile writes a new version of path to a temporary file next to it and renames
+// it over the original. Hard-linked files are refused: the rename would split path
+// from its other links, e.g. the ones made by dedupe --link=hard
+func replaceFile(path string, write func(r *os.File, w *os.File) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if n := utils.GetLinkCount(info); n > 1 {
+		return fmt.Errorf("%s has %d hard links; writing tags would break them", path, n)
+	}
+
+	out, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	defer os.Remove(tmp)
+
+	err = write(in, out)
+	// The ffmpeg fallback closes out early so that ffmpeg can write to it
+	if closeErr := out.Close(); err == nil && !errors.Is(closeErr, os.ErrClosed) {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Rename(tmp, path)
+}
+
+// writeTagsFFmpeg remuxes the file with stream copy and new metadata
+func writeTagsFFmpeg(ctx context.Context, path string, fields []tagField) error {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return fmt.Errorf("cannot write tags to %s: ffmpeg not found", filepath.Ext(path))
+	}
+	return replaceFile(path, func(_ *os.File, w *os.File) error {
+		w.Close()
+		args := []string{"-nostdin", "-hide_banner", "-loglevel", "error", "-y", "-i", path,
+			"-map", "0", "-c", "copy", "-map_metadata", "0"}
+		for _, f := range fields {
+			args = append(args, "-metadata", f.key+"="+f.value)
+		}
+		// Keep the container of the original; the temporary name has no usable extension
+		args = append(args, "-f", ffmpegMuxer(path), w.Name())
+
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	})
+}
+
+// ffmpegMuxer returns the ffmpeg output format for a file extension
+func ffmpegMuxer(path string) string {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), ".")
+	switch ext {
+	case "mkv", "mka", "mks":
+		return "matroska"
+	case "m4a", "m4b", "m4v", "mov", "3gp":
+		return "mp4"
+	case "oga", "opus":
+		return "ogg"
+	case "wma", "wmv":
+		return "asf"
+	case "ts", "m2ts":
+		return "mpegts"
+	case "aif", "aiff":
+		return "aiff"
+	}
+	return ext
+}
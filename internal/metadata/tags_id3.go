@@ -0,0 +1,213 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3Padding is the free space left after rewritten ID3v2 tags so that later edits by
+// other taggers can be made in place
+const id3Padding = 1024
+
+// id3Frame is a raw ID3v2 frame, header included
+type id3Frame struct {
+	id   string
+	desc string // TXXX description
+	raw  []byte
+}
+
+// writeID3v2 rewrites the ID3v2.3 or 2.4 tag at the start of an MP3, keeping the frames
+// it does not set. Files without a tag get a new ID3v2.4 tag
+func writeID3v2(r io.ReadSeeker, w io.Writer, fields []tagField) error {
+	major := byte(4)
+	var frames []id3Frame
+	var audioStart int64
+
+	header := make([]byte, 10)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	if n == 10 && string(header[:3]) == "ID3" {
+		major = header[3]
+		flags := header[5]
+		if major != 3 && major != 4 {
+			return errUnsupportedTags
+		}
+		// Unsynchronised tags and extended headers are rare enough to leave to ffmpeg
+		if flags&0x80 != 0 || flags&0x40 != 0 {
+			return errUnsupportedTags
+		}
+		size := int64(syncsafe(header[6:10]))
+		body := make([]byte, size)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		audioStart = 10 + size
+		if major == 4 && flags&0x10 != 0 {
+			audioStart += 10 // footer
+		}
+		if frames, err = parseID3Frames(body, major); err != nil {
+			return err
+		}
+	}
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	for _, f := range frames {
+		if !id3FrameReplaced(f, fields) {
+			body.Write(f.raw)
+		}
+	}
+	for _, f := range fields {
+		if f.value != "" {
+			body.Write(id3TextFrame(major, f))
+		}
+	}
+
+	out := make([]byte, 10)
+	copy(out, "ID3")
+	out[3] = major
+	putSyncsafe(out[6:], uint32(body.Len()+id3Padding))
+	if _, err := w.Write(out); err != nil {
+		return err
+	}
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(make([]byte, id3Padding)); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func parseID3Frames(body []byte, major byte) ([]id3Frame, error) {
+	var frames []id3Frame
+	for len(body) >= 10 && body[0] != 0 {
+		var size int
+		if major == 4 {
+			size = int(syncsafe(body[4:8]))
+		} else {
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		}
+		if size < 0 || 10+size > len(body) {
+			return nil, errUnsupportedTags
+		}
+		f := id3Frame{id: string(body[:4]), raw: body[:10+size]}
+		if f.id == "TXXX" {
+			f.desc = id3Description(body[10 : 10+size])
+		}
+		frames = append(frames, f)
+		body = body[10+size:]
+	}
+	return frames, nil
+}
+
+// id3FrameReplaced reports whether one of fields replaces frame f
+func id3FrameReplaced(f id3Frame, fields []tagField) bool {
+	for _, field := range fields {
+		if f.id != field.id3 {
+			continue
+		}
+		if f.id != "TXXX" || strings.EqualFold(f.desc, field.key) {
+			return true
+		}
+	}
+	return false
+}
+
+// id3Description returns the description of a TXXX frame
+func id3Description(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	enc, data := data[0], data[1:]
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return decodeUTF16(data[:i], enc == 2)
+			}
+		}
+		return ""
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFF && b[1] == 0xFE:
+			bigEndian, b = false, b[2:]
+		case b[0] == 0xFE && b[1] == 0xFF:
+			bigEndian, b = true, b[2:]
+		}
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		if bigEndian {
+			u[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			u[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+	return string(utf16.Decode(u))
+}
+
+// id3TextFrame encodes a text frame: UTF-8 for ID3v2.4, UTF-16 for ID3v2.3
+func id3TextFrame(major byte, f tagField) []byte {
+	var data bytes.Buffer
+	encode := func(s string) {
+		if major == 4 {
+			data.WriteString(s)
+			return
+		}
+		data.Write([]byte{0xFF, 0xFE})
+		for _, u := range utf16.Encode([]rune(s)) {
+			data.Write([]byte{byte(u), byte(u >> 8)})
+		}
+	}
+	if major == 4 {
+		data.WriteByte(3)
+	} else {
+		data.WriteByte(1)
+	}
+	if f.id3 == "TXXX" {
+		encode(f.key)
+		if major == 4 {
+			data.WriteByte(0)
+		} else {
+			data.Write([]byte{0, 0})
+		}
+	}
+	encode(f.value)
+
+	frame := make([]byte, 10, 10+data.Len())
+	copy(frame, f.id3)
+	if major == 4 {
+		putSyncsafe(frame[4:8], uint32(data.Len()))
+	} else {
+		binary.BigEndian.PutUint32(frame[4:8], uint32(data.Len()))
+	}
+	return append(frame, data.Bytes()...)
+}
+
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+func putSyncsafe(b []byte, v uint32) {
+	b[0] = byte(v>>21) & 0x7f
+	b[1] = byte(v>>14) & 0x7f
+	b[2] = byte(v>>7) & 0x7f
+	b[3] = byte(v) & 0x7f
+}
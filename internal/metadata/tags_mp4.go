@@ -0,0 +1,374 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+)
+
+// mp4Box is a box whose payload (without the 8 byte header) is held in memory
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// mp4Atom is a top-level box located in the file
+type mp4Atom struct {
+	typ    string
+	offset int64
+	size   int64 // including the header
+	header int64
+}
+
+// writeMP4Tags replaces the iTunes-style tags in moov/udta/meta/ilst. When moov comes
+// before the media data its size change shifts the samples, so the chunk offset
+// tables are adjusted to match
+func writeMP4Tags(r io.ReadSeeker, w io.Writer, fields []tagField) error {
+	atoms, err := readMP4Atoms(r)
+	if err != nil {
+		return err
+	}
+	var moov *mp4Atom
+	for i, a := range atoms {
+		switch a.typ {
+		case "moov":
+			if moov != nil {
+				return errUnsupportedTags
+			}
+			moov = &atoms[i]
+		case "moof":
+			// Fragmented files address samples relative to fragments; leave them to ffmpeg
+			return errUnsupportedTags
+		}
+	}
+	if moov == nil {
+		return errUnsupportedTags
+	}
+
+	moovData := make([]byte, moov.size-moov.header)
+	if _, err := r.Seek(moov.offset+moov.header, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, moovData); err != nil {
+		return err
+	}
+	children, err := parseMP4Boxes(moovData)
+	if err != nil {
+		return err
+	}
+
+	udtaIndex := -1
+	for i, b := range children {
+		if b.typ == "udta" {
+			udtaIndex = i
+		}
+	}
+	var udta []byte
+	if udtaIndex >= 0 {
+		udta = children[udtaIndex].data
+	}
+	newUdta, err := setMP4UdtaTags(udta, fields)
+	if err != nil {
+		return err
+	}
+	if udtaIndex >= 0 {
+		children[udtaIndex].data = newUdta
+	} else {
+		children = append(children, mp4Box{typ: "udta", data: newUdta})
+	}
+
+	newMoov := mp4BoxBytes(mp4Box{typ: "moov", data: mp4BoxesBytes(children)})
+	delta := int64(len(newMoov)) - moov.size
+	if delta != 0 {
+		if err := shiftMP4ChunkOffsets(children, moov.offset+moov.size, delta); err != nil {
+			return err
+		}
+		newMoov = mp4BoxBytes(mp4Box{typ: "moov", data: mp4BoxesBytes(children)})
+	}
+
+	for _, a := range atoms {
+		if a.typ == "moov" {
+			if _, err := w.Write(newMoov); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := r.Seek(a.offset, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, a.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readMP4Atoms(r io.ReadSeeker) ([]mp4Atom, error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	var atoms []mp4Atom
+	for offset := int64(0); offset < end; {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		header := make([]byte, 16)
+		if _, err := io.ReadFull(r, header[:8]); err != nil {
+			return nil, errUnsupportedTags
+		}
+		a := mp4Atom{typ: string(header[4:8]), offset: offset, header: 8}
+		switch size := binary.BigEndian.Uint32(header); size {
+		case 0:
+			a.size = end - offset
+		case 1:
+			if _, err := io.ReadFull(r, header[8:]); err != nil {
+				return nil, errUnsupportedTags
+			}
+			a.size = int64(binary.BigEndian.Uint64(header[8:]))
+			a.header = 16
+		default:
+			a.size = int64(size)
+		}
+		if a.size < a.header || offset+a.size > end {
+			return nil, errUnsupportedTags
+		}
+		atoms = append(atoms, a)
+		offset += a.size
+	}
+	return atoms, nil
+}
+
+// parseMP4Boxes splits a payload into its child boxes
+func parseMP4Boxes(b []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(b) > 0 {
+		if len(b) < 8 {
+			return nil, errUnsupportedTags
+		}
+		size := binary.BigEndian.Uint32(b)
+		if size < 8 || uint64(size) > uint64(len(b)) {
+			return nil, errUnsupportedTags
+		}
+		boxes = append(boxes, mp4Box{typ: string(b[4:8]), data: b[8:size]})
+		b = b[size:]
+	}
+	return boxes, nil
+}
+
+func mp4BoxBytes(box mp4Box) []byte {
+	b := make([]byte, 8, 8+len(box.data))
+	binary.BigEndian.PutUint32(b, uint32(8+len(box.data)))
+	copy(b[4:], box.typ)
+	return append(b, box.data...)
+}
+
+func mp4BoxesBytes(boxes []mp4Box) []byte {
+	var buf bytes.Buffer
+	for _, box := range boxes {
+		buf.Write(mp4BoxBytes(box))
+	}
+	return buf.Bytes()
+}
+
+// setMP4UdtaTags returns the udta payload with its ilst updated, creating meta and
+// ilst when missing
+func setMP4UdtaTags(udta []byte, fields []tagField) ([]byte, error) {
+	children, err := parseMP4Boxes(udta)
+	if err != nil {
+		return nil, err
+	}
+	metaIndex := -1
+	for i, b := range children {
+		if b.typ == "meta" {
+			metaIndex = i
+		}
+	}
+
+	var metaChildren []mp4Box
+	if metaIndex >= 0 {
+		meta := children[metaIndex].data
+		// QuickTime metadata has no version and flags; iTunes metadata does
+		if len(meta) < 4 || (len(meta) >= 8 && string(meta[4:8]) == "hdlr") {
+			return nil, errUnsupportedTags
+		}
+		if metaChildren, err = parseMP4Boxes(meta[4:]); err != nil {
+			return nil, err
+		}
+	} else {
+		hdlr := make([]byte, 25)
+		copy(hdlr[8:], "mdirappl")
+		metaChildren = []mp4Box{{typ: "hdlr", data: hdlr}}
+	}
+
+	ilstIndex := -1
+	for i, b := range metaChildren {
+		switch b.typ {
+		case "ilst":
+			ilstIndex = i
+		case "keys":
+			return nil, errUnsupportedTags
+		}
+	}
+	var items []mp4Box
+	if ilstIndex >= 0 {
+		if items, err = parseMP4Boxes(metaChildren[ilstIndex].data); err != nil {
+			return nil, err
+		}
+	}
+
+	kept := items[:0]
+	for _, item := range items {
+		if !mp4ItemReplaced(item, fields) {
+			kept = append(kept, item)
+		}
+	}
+	items = kept
+	for _, f := range fields {
+		if f.value != "" {
+			items = append(items, mp4TextItem(f))
+		}
+	}
+
+	ilst := mp4Box{typ: "ilst", data: mp4BoxesBytes(items)}
+	if ilstIndex >= 0 {
+		metaChildren[ilstIndex] = ilst
+	} else {
+		metaChildren = append(metaChildren, ilst)
+	}
+	meta := mp4Box{typ: "meta", data: append(make([]byte, 4), mp4BoxesBytes(metaChildren)...)}
+	if metaIndex >= 0 {
+		children[metaIndex] = meta
+	} else {
+		children = append(children, meta)
+	}
+	return mp4BoxesBytes(children), nil
+}
+
+// mp4ItemReplaced reports whether one of fields replaces ilst item
+func mp4ItemReplaced(item mp4Box, fields []tagField) bool {
+	for _, f := range fields {
+		switch {
+		case f.mp4 == "----" && item.typ == "----":
+			if strings.EqualFold(mp4FreeformName(item), f.key) {
+				return true
+			}
+		case item.typ == f.mp4:
+			return true
+		case f.mp4 == "\xa9gen" && item.typ == "gnre":
+			// Numeric ID3 genre; superseded by the text genre
+			return true
+		}
+	}
+	return false
+}
+
+func mp4FreeformName(item mp4Box) string {
+	children, err := parseMP4Boxes(item.data)
+	if err != nil {
+		return ""
+	}
+	for _, c := range children {
+		if c.typ == "name" && len(c.data) >= 4 {
+			return string(c.data[4:])
+		}
+	}
+	return ""
+}
+
+// mp4TextItem builds an ilst item holding a UTF-8 value
+func mp4TextItem(f tagField) mp4Box {
+	data := make([]byte, 8, 8+len(f.value))
+	binary.BigEndian.PutUint32(data, 1) // well-known type 1: UTF-8
+	data = append(data, f.value...)
+	value := mp4BoxBytes(mp4Box{typ: "data", data: data})
+
+	if f.mp4 != "----" {
+		return mp4Box{typ: f.mp4, data: value}
+	}
+	var buf bytes.Buffer
+	buf.Write(mp4BoxBytes(mp4Box{typ: "mean", data: append(make([]byte, 4), "com.apple.iTunes"...)}))
+	buf.Write(mp4BoxBytes(mp4Box{typ: "name", data: append(make([]byte, 4), f.key...)}))
+	buf.Write(value)
+	return mp4Box{typ: "----", data: buf.Bytes()}
+}
+
+// shiftMP4ChunkOffsets adds delta to every stco/co64 entry pointing at or after from
+func shiftMP4ChunkOffsets(moov []mp4Box, from, delta int64) error {
+	for _, trak := range moov {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl, err := findMP4Box(trak.data, "mdia", "minf", "stbl")
+		if err != nil {
+			return err
+		}
+		if stbl == nil {
+			continue
+		}
+		tables, err := parseMP4Boxes(stbl)
+		if err != nil {
+			return err
+		}
+		for _, t := range tables {
+			if t.typ != "stco" && t.typ != "co64" {
+				continue
+			}
+			if len(t.data) < 8 {
+				return errUnsupportedTags
+			}
+			count := int(binary.BigEndian.Uint32(t.data[4:]))
+			width := 4
+			if t.typ == "co64" {
+				width = 8
+			}
+			if len(t.data) < 8+count*width {
+				return errUnsupportedTags
+			}
+			for i := range count {
+				entry := t.data[8+i*width:]
+				if width == 4 {
+					v := int64(binary.BigEndian.Uint32(entry))
+					if v < from {
+						continue
+					}
+					if v+delta < 0 || v+delta > math.MaxUint32 {
+						return errUnsupportedTags
+					}
+					binary.BigEndian.PutUint32(entry, uint32(v+delta))
+				} else {
+					v := int64(binary.BigEndian.Uint64(entry))
+					if v >= from {
+						binary.BigEndian.PutUint64(entry, uint64(v+delta))
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// findMP4Box follows path from payload b and returns the payload of the last box, or
+// nil when a box on the path is missing
+func findMP4Box(b []byte, path ...string) ([]byte, error) {
+	for _, typ := range path {
+		children, err := parseMP4Boxes(b)
+		if err != nil {
+			return nil, err
+		}
+		var found []byte
+		for _, c := range children {
+			if c.typ == typ {
+				found = c.data
+				break
+			}
+		}
+		if found == nil {
+			return nil, nil
+		}
+		b = found
+	}
+	return b, nil
+}
@@ -0,0 +1,336 @@
+package metadata_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/metadata"
+)
+
+func ptr(s string) *string { return &s }
+
+func writeTagsFile(t *testing.T, name string, data []byte, tags metadata.Tags) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := metadata.WriteTags(context.Background(), path, tags); err != nil {
+		t.Fatalf("WriteTags failed: %v", err)
+	}
+	out, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected temporary files to be cleaned up, got %d entries", len(entries))
+	}
+	return out
+}
+
+func id3Frame(id string, data []byte) []byte {
+	b := make([]byte, 10)
+	copy(b, id)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(data)))
+	return append(b, data...)
+}
+
+func TestWriteTags_ID3v2(t *testing.T) {
+	audio := []byte("\xff\xfbAUDIO")
+
+	t.Run("ExistingTag", func(t *testing.T) {
+		frames := append(id3Frame("TIT2", []byte("\x00Old")), id3Frame("TPE2", []byte("\x00Band"))...)
+		frames = append(frames, id3Frame("TXXX", []byte("\x00CATEGORIES\x00;old;"))...)
+		header := []byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(frames) + 16)}
+		in := append(append(append(header, frames...), make([]byte, 16)...), audio...)
+
+		out := writeTagsFile(t, "a.mp3", in, metadata.Tags{Title: ptr("New"), Categories: ptr(";a;")})
+		if !bytes.HasPrefix(out, []byte("ID3\x03")) {
+			t.Fatalf("expected the ID3v2.3 tag to be kept, got %q", out[:4])
+		}
+		if !bytes.HasSuffix(out, audio) {
+			t.Error("audio data changed")
+		}
+		if !bytes.Contains(out, []byte("TPE2")) {
+			t.Error("untouched frame was dropped")
+		}
+		if bytes.Contains(out, []byte("Old")) || bytes.Contains(out, []byte(";old;")) {
+			t.Error("old values still present")
+		}
+		// ID3v2.3 text is written as UTF-16 with a byte order mark
+		if !bytes.Contains(out, []byte("\x01\xff\xfeN\x00e\x00w\x00")) {
+			t.Error("new title not found")
+		}
+	})
+
+	t.Run("NoTag", func(t *testing.T) {
+		out := writeTagsFile(t, "b.mp3", audio, metadata.Tags{Artist: ptr("Someone")})
+		if !bytes.HasPrefix(out, []byte("ID3\x04")) {
+			t.Fatalf("expected a new ID3v2.4 tag, got %q", out[:4])
+		}
+		if !bytes.Contains(out, []byte("TPE1")) || !bytes.Contains(out, []byte("\x03Someone")) {
+			t.Error("artist frame not found")
+		}
+		if !bytes.HasSuffix(out, audio) {
+			t.Error("audio data changed")
+		}
+	})
+}
+
+func vorbisCommentBlock(comments ...string) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len("vendor")))
+	b.WriteString("vendor")
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+func TestWriteTags_FLAC(t *testing.T) {
+	comment := vorbisCommentBlock("TITLE=Old", "ALBUM=Keep")
+	var in bytes.Buffer
+	in.WriteString("fLaC")
+	in.Write([]byte{0, 0, 0, 34})
+	in.Write(make([]byte, 34))
+	in.Write([]byte{0x80 | 4, 0, 0, byte(len(comment))})
+	in.Write(comment)
+	in.WriteString("FRAMES")
+
+	out := writeTagsFile(t, "a.flac", in.Bytes(), metadata.Tags{Title: ptr("New"), Genre: ptr("Jazz")})
+	if !bytes.HasSuffix(out, []byte("FRAMES")) {
+		t.Error("audio frames changed")
+	}
+	for _, want := range []string{"TITLE=New", "GENRE=Jazz", "ALBUM=Keep", "vendor"} {
+		if !bytes.Contains(out, []byte(want)) {
+			t.Errorf("missing %s", want)
+		}
+	}
+	if bytes.Contains(out, []byte("TITLE=Old")) {
+		t.Error("old title still present")
+	}
+
+	// Walk the metadata blocks; only the last one may have the last flag
+	b := out[4:]
+	for {
+		last := b[0]&0x80 != 0
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+		b = b[4+size:]
+		if last {
+			break
+		}
+	}
+	if string(b) != "FRAMES" {
+		t.Errorf("metadata blocks are malformed, trailing data %q", b)
+	}
+}
+
+// oggCRC is the Ogg page checksum
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+	for _, c := range b {
+		crc ^= uint32(c) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func oggPageBytes(headerType byte, granule uint64, seq uint32, packet []byte) []byte {
+	var lacing []byte
+	n := len(packet)
+	for ; n >= 255; n -= 255 {
+		lacing = append(lacing, 255)
+	}
+	lacing = append(lacing, byte(n))
+
+	b := make([]byte, 27)
+	copy(b, "OggS")
+	b[5] = headerType
+	binary.LittleEndian.PutUint64(b[6:], granule)
+	binary.LittleEndian.PutUint32(b[14:], 1234)
+	binary.LittleEndian.PutUint32(b[18:], seq)
+	b[26] = byte(len(lacing))
+	b = append(append(b, lacing...), packet...)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+	return b
+}
+
+type oggTestPage struct {
+	headerType byte
+	seq        uint32
+	data       []byte
+}
+
+func readOggTestPages(t *testing.T, b []byte) []oggTestPage {
+	t.Helper()
+	var pages []oggTestPage
+	for len(b) > 0 {
+		if !bytes.HasPrefix(b, []byte("OggS")) {
+			t.Fatalf("page %d: bad capture pattern", len(pages))
+		}
+		n := int(b[26])
+		size := 27 + n
+		for _, s := range b[27 : 27+n] {
+			size += int(s)
+		}
+		page := append([]byte(nil), b[:size]...)
+		crc := binary.LittleEndian.Uint32(page[22:])
+		binary.LittleEndian.PutUint32(page[22:], 0)
+		if oggCRC(page) != crc {
+			t.Errorf("page %d: bad checksum", len(pages))
+		}
+		pages = append(pages, oggTestPage{
+			headerType: b[5],
+			seq:        binary.LittleEndian.Uint32(b[18:]),
+			data:       b[27+n : size],
+		})
+		b = b[size:]
+	}
+	return pages
+}
+
+func TestWriteTags_Opus(t *testing.T) {
+	head := append([]byte("OpusHead"), make([]byte, 11)...)
+	tags := append([]byte("OpusTags"), vorbisCommentBlock("TITLE=Old", "ARTIST=Keep")...)
+	var in bytes.Buffer
+	in.Write(oggPageBytes(0x02, 0, 0, head))
+	in.Write(oggPageBytes(0, 0, 1, tags))
+	in.Write(oggPageBytes(0, 960, 2, []byte("AUDIO1")))
+	in.Write(oggPageBytes(0x04, 1920, 3, []byte("AUDIO2")))
+
+	// Long enough to need more than one page
+	long := strings.Repeat("x", 70000)
+	out := writeTagsFile(t, "a.opus", in.Bytes(), metadata.Tags{Title: ptr("New"), Categories: ptr(long)})
+
+	pages := readOggTestPages(t, out)
+	if len(pages) != 5 {
+		t.Fatalf("expected the comment header to span two pages, got %d pages", len(pages))
+	}
+	for i, p := range pages {
+		if p.seq != uint32(i) {
+			t.Errorf("page %d has sequence number %d", i, p.seq)
+		}
+	}
+	if pages[2].headerType&0x01 == 0 {
+		t.Error("second comment page is not marked as continued")
+	}
+	if !bytes.Equal(pages[0].data, head) {
+		t.Error("identification header changed")
+	}
+	comment := append(append([]byte(nil), pages[1].data...), pages[2].data...)
+	for _, want := range []string{"OpusTags", "TITLE=New", "ARTIST=Keep", "CATEGORIES=" + long} {
+		if !bytes.Contains(comment, []byte(want)) {
+			t.Errorf("comment header is missing %.20s", want)
+		}
+	}
+	if string(pages[3].data) != "AUDIO1" || string(pages[4].data) != "AUDIO2" || pages[4].headerType != 0x04 {
+		t.Error("audio pages changed")
+	}
+}
+
+func mp4Box(typ string, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	b := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint32(b, uint32(8+len(data)))
+	copy(b[4:], typ)
+	return append(b, data...)
+}
+
+// mp4File builds a file with one track whose single chunk is the sample in mdat
+func mp4File(moovFirst bool, chunkOffset uint32, udta ...[]byte) []byte {
+	stco := make([]byte, 12)
+	binary.BigEndian.PutUint32(stco[4:], 1)
+	binary.BigEndian.PutUint32(stco[8:], chunkOffset)
+	trak := mp4Box("trak", mp4Box("mdia", mp4Box("minf", mp4Box("stbl", mp4Box("stco", stco)))))
+	moov := mp4Box("moov", append([][]byte{mp4Box("mvhd", make([]byte, 100)), trak}, udta...)...)
+	ftyp := mp4Box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	mdat := mp4Box("mdat", []byte("SAMPLE"))
+	if moovFirst {
+		return bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	}
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func mp4ChunkOffset(t *testing.T, b []byte) uint32 {
+	t.Helper()
+	i := bytes.Index(b, []byte("stco"))
+	if i < 0 {
+		t.Fatal("stco not found")
+	}
+	return binary.BigEndian.Uint32(b[i+12:])
+}
+
+func TestWriteTags_MP4(t *testing.T) {
+	t.Run("MoovBeforeMdat", func(t *testing.T) {
+		// Find where the sample lands in the input to build a consistent file
+		in := mp4File(true, 0)
+		in = mp4File(true, uint32(bytes.Index(in, []byte("SAMPLE"))))
+
+		out := writeTagsFile(t, "a.m4a", in, metadata.Tags{Title: ptr("New"), Categories: ptr(";a;")})
+		offset := mp4ChunkOffset(t, out)
+		if string(out[offset:offset+6]) != "SAMPLE" {
+			t.Errorf("chunk offset %d does not point at the sample", offset)
+		}
+		for _, want := range []string{"\xa9nam", "New", "----", "com.apple.iTunes", "categories", ";a;", "mdir"} {
+			if !bytes.Contains(out, []byte(want)) {
+				t.Errorf("missing %q", want)
+			}
+		}
+	})
+
+	t.Run("ExistingTags", func(t *testing.T) {
+		data := func(v string) []byte { return mp4Box("data", append(make([]byte, 8), v...)) }
+		hdlr := append(make([]byte, 8), "mdirappl\x00\x00\x00\x00\x00\x00\x00\x00\x00"...)
+		ilst := mp4Box("ilst", mp4Box("\xa9nam", data("Old")), mp4Box("\xa9ART", data("Keep")))
+		udta := mp4Box("udta", mp4Box("meta", make([]byte, 4), mp4Box("hdlr", hdlr), ilst))
+
+		in := mp4File(false, 0, udta)
+		in = mp4File(false, uint32(bytes.Index(in, []byte("SAMPLE"))), udta)
+
+		out := writeTagsFile(t, "b.mp4", in, metadata.Tags{Title: ptr("New")})
+		offset := mp4ChunkOffset(t, out)
+		if string(out[offset:offset+6]) != "SAMPLE" {
+			t.Errorf("chunk offset %d does not point at the sample", offset)
+		}
+		if bytes.Contains(out, []byte("Old")) || !bytes.Contains(out, []byte("New")) {
+			t.Error("title not replaced")
+		}
+		if !bytes.Contains(out, []byte("Keep")) {
+			t.Error("untouched item was dropped")
+		}
+		if bytes.Count(out, []byte("udta")) != 1 || bytes.Count(out, []byte("ilst")) != 1 {
+			t.Error("expected the existing udta and ilst to be reused")
+		}
+	})
+}
+
+func TestWriteTags_HardLinked(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mp3")
+	in := []byte("\xff\xfbAUDIO")
+	os.WriteFile(path, in, 0o644)
+	if err := os.Link(path, filepath.Join(dir, "b.mp3")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+
+	if err := metadata.WriteTags(context.Background(), path, metadata.Tags{Title: ptr("New")}); err == nil {
+		t.Error("expected writing tags into a hard-linked file to be refused")
+	}
+	if out, _ := os.ReadFile(filepath.Join(dir, "b.mp3")); !bytes.Equal(out, in) {
+		t.Error("expected the other link to be untouched")
+	}
+}
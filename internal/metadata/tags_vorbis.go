@@ -0,0 +1,393 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// vorbisComment is a Vorbis comment block as used by FLAC, Ogg Vorbis and Opus
+type vorbisComment struct {
+	vendor   string
+	comments []string // KEY=value
+}
+
+const defaultVorbisVendor = "discoteca"
+
+// parseVorbisComment decodes a comment block and returns the bytes after it, such as
+// the Vorbis framing bit or Opus padding
+func parseVorbisComment(b []byte) (vorbisComment, []byte, error) {
+	var vc vorbisComment
+	next := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return "", false
+		}
+		s := string(b[4 : 4+n])
+		b = b[4+n:]
+		return s, true
+	}
+
+	vendor, ok := next()
+	if !ok || len(b) < 4 {
+		return vc, nil, errUnsupportedTags
+	}
+	vc.vendor = vendor
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for range count {
+		c, ok := next()
+		if !ok {
+			return vc, nil, errUnsupportedTags
+		}
+		vc.comments = append(vc.comments, c)
+	}
+	return vc, b, nil
+}
+
+// set replaces the comments of each field; fields with an empty value are removed
+func (vc *vorbisComment) set(fields []tagField) {
+	kept := vc.comments[:0]
+	for _, c := range vc.comments {
+		key, _, _ := strings.Cut(c, "=")
+		replaced := false
+		for _, f := range fields {
+			if strings.EqualFold(key, f.vorbis) {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			kept = append(kept, c)
+		}
+	}
+	vc.comments = kept
+	for _, f := range fields {
+		if f.value != "" {
+			vc.comments = append(vc.comments, f.vorbis+"="+f.value)
+		}
+	}
+}
+
+func (vc vorbisComment) bytes() []byte {
+	var buf bytes.Buffer
+	put := func(s string) {
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(len(s)))
+		buf.WriteString(s)
+	}
+	vendor := vc.vendor
+	if vendor == "" {
+		vendor = defaultVorbisVendor
+	}
+	put(vendor)
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(vc.comments)))
+	for _, c := range vc.comments {
+		put(c)
+	}
+	return buf.Bytes()
+}
+
+const (
+	flacBlockPadding       = 1
+	flacBlockVorbisComment = 4
+	flacPadding            = 1024
+)
+
+type flacBlock struct {
+	kind byte
+	data []byte
+}
+
+// writeFLACTags replaces the VORBIS_COMMENT block of a FLAC file. Old padding is
+// dropped and fresh padding is added after the comments
+func writeFLACTags(r io.ReadSeeker, w io.Writer, fields []tagField) error {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		// Includes FLAC files with an ID3v2 tag in front
+		return errUnsupportedTags
+	}
+
+	var blocks []flacBlock
+	var vc vorbisComment
+	for last := false; !last; {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return err
+		}
+		last = header[0]&0x80 != 0
+		kind := header[0] & 0x7f
+		if kind == 127 {
+			return errUnsupportedTags
+		}
+		data := make([]byte, int(header[1])<<16|int(header[2])<<8|int(header[3]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		switch kind {
+		case flacBlockVorbisComment:
+			var err error
+			if vc, _, err = parseVorbisComment(data); err != nil {
+				return err
+			}
+		case flacBlockPadding:
+		default:
+			blocks = append(blocks, flacBlock{kind: kind, data: data})
+		}
+	}
+	if len(blocks) == 0 {
+		return errUnsupportedTags // no STREAMINFO
+	}
+
+	vc.set(fields)
+	comment := vc.bytes()
+	if len(comment) >= 1<<24 {
+		return errUnsupportedTags
+	}
+	blocks = append(blocks,
+		flacBlock{kind: flacBlockVorbisComment, data: comment},
+		flacBlock{kind: flacBlockPadding, data: make([]byte, flacPadding)},
+	)
+
+	if _, err := w.Write(magic); err != nil {
+		return err
+	}
+	for i, b := range blocks {
+		kind := b.kind
+		if i == len(blocks)-1 {
+			kind |= 0x80
+		}
+		n := len(b.data)
+		if _, err := w.Write([]byte{kind, byte(n >> 16), byte(n >> 8), byte(n)}); err != nil {
+			return err
+		}
+		if _, err := w.Write(b.data); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// oggPage is one page of an Ogg bitstream
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	seq        uint32
+	segments   []byte
+	data       []byte
+}
+
+const (
+	oggContinued = 0x01
+	oggBOS       = 0x02
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for range 8 {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" || header[4] != 0 {
+		return nil, errUnsupportedTags
+	}
+	p := &oggPage{
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:]),
+		serial:     binary.LittleEndian.Uint32(header[14:]),
+		seq:        binary.LittleEndian.Uint32(header[18:]),
+		segments:   make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.segments); err != nil {
+		return nil, err
+	}
+	size := 0
+	for _, s := range p.segments {
+		size += int(s)
+	}
+	p.data = make([]byte, size)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *oggPage) bytes() []byte {
+	b := make([]byte, 27, 27+len(p.segments)+len(p.data))
+	copy(b, "OggS")
+	b[5] = p.headerType
+	binary.LittleEndian.PutUint64(b[6:], p.granule)
+	binary.LittleEndian.PutUint32(b[14:], p.serial)
+	binary.LittleEndian.PutUint32(b[18:], p.seq)
+	b[26] = byte(len(p.segments))
+	b = append(b, p.segments...)
+	b = append(b, p.data...)
+	var crc uint32
+	for _, c := range b {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^c]
+	}
+	binary.LittleEndian.PutUint32(b[22:], crc)
+	return b
+}
+
+// writeOggTags replaces the comment header of an Ogg Vorbis or Opus stream. The header
+// packets are paged again and the sequence numbers of the following pages adjusted
+func writeOggTags(r io.ReadSeeker, w io.Writer, fields []tagField) error {
+	var packets [][]byte
+	var cur []byte
+	need := 0
+	var serial uint32
+	pages := 0
+	for need == 0 || len(packets) < need {
+		p, err := readOggPage(r)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return errUnsupportedTags
+			}
+			return err
+		}
+		if pages == 0 {
+			serial = p.serial
+		} else if p.serial != serial {
+			return errUnsupportedTags // multiplexed streams
+		}
+		pages++
+
+		offset := 0
+		for _, s := range p.segments {
+			if need > 0 && len(packets) == need {
+				// The audio starts on the last header page; leave such files to ffmpeg
+				return errUnsupportedTags
+			}
+			cur = append(cur, p.data[offset:offset+int(s)]...)
+			offset += int(s)
+			if s < 255 {
+				packets = append(packets, cur)
+				cur = nil
+				if len(packets) == 1 {
+					switch {
+					case bytes.HasPrefix(packets[0], []byte("\x01vorbis")):
+						need = 3
+					case bytes.HasPrefix(packets[0], []byte("OpusHead")):
+						need = 2
+					default:
+						return errUnsupportedTags
+					}
+				}
+			}
+		}
+	}
+
+	var prefix []byte
+	switch {
+	case need == 3 && bytes.HasPrefix(packets[1], []byte("\x03vorbis")):
+		prefix = []byte("\x03vorbis")
+	case need == 2 && bytes.HasPrefix(packets[1], []byte("OpusTags")):
+		prefix = []byte("OpusTags")
+	default:
+		return errUnsupportedTags
+	}
+	vc, rest, err := parseVorbisComment(packets[1][len(prefix):])
+	if err != nil {
+		return err
+	}
+	vc.set(fields)
+	packets[1] = append(append(prefix, vc.bytes()...), rest...)
+
+	out := []*oggPage{{headerType: oggBOS, serial: serial}}
+	out[0].segments, out[0].data = oggLacing(packets[0]), packets[0]
+	out = append(out, oggPaginate(serial, packets[1:])...)
+	for i, p := range out {
+		p.seq = uint32(i)
+		if _, err := w.Write(p.bytes()); err != nil {
+			return err
+		}
+	}
+
+	delta := uint32(len(out) - pages)
+	for {
+		p, err := readOggPage(r)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if p.serial == serial {
+			p.seq += delta
+		}
+		if _, err := w.Write(p.bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+func oggLacing(packet []byte) []byte {
+	lacing := make([]byte, 0, len(packet)/255+1)
+	for n := len(packet); ; n -= 255 {
+		if n < 255 {
+			return append(lacing, byte(n))
+		}
+		lacing = append(lacing, 255)
+	}
+}
+
+// oggPaginate lays packets out on pages of at most 255 segments
+func oggPaginate(serial uint32, packets [][]byte) []*oggPage {
+	var pages []*oggPage
+	page := &oggPage{serial: serial}
+	ended := false // whether a packet ends on the current page
+	flush := func() {
+		// Pages on which no packet ends have no granule position
+		if !ended {
+			page.granule = ^uint64(0)
+		}
+		continued := page.segments[len(page.segments)-1] == 255
+		pages = append(pages, page)
+		page = &oggPage{serial: serial}
+		if continued {
+			page.headerType = oggContinued
+		}
+		ended = false
+	}
+	for _, packet := range packets {
+		lacing := oggLacing(packet)
+		data := packet
+		for i, s := range lacing {
+			if len(page.segments) == 255 {
+				flush()
+			}
+			page.segments = append(page.segments, s)
+			page.data = append(page.data, data[:s]...)
+			data = data[s:]
+			if i == len(lacing)-1 {
+				ended = true
+			}
+		}
+	}
+	if len(page.segments) > 0 {
+		flush()
+	}
+	return pages
+}
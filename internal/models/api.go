@@ -76,6 +76,9 @@ type FilterBinsResponse struct {
 
 	// Media type counts (special case - not a percentile distribution)
 	MediaType []FilterBin `json:"media_type"`
+
+	// Media-check counts: unchecked, ok and damaged
+	Damaged []FilterBin `json:"damaged"`
 }
 
 type PlaylistResponse []string
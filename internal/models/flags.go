@@ -53,6 +53,9 @@ type FilterFlags struct {
 	Completed        bool     `help:"Show only completed items"                                              group:"Filter"`
 	InProgress       bool     `help:"Show only items in progress"                                            group:"Filter"`
 	WithCaptions     bool     `help:"Show only items with captions"                                          group:"Filter"`
+	IntegrityFailed  bool     `help:"Show only items that failed disco verify"                               group:"Filter"`
+	Corrupt          []string `help:"Corruption range from media-check (e.g., >5%)"                          group:"Filter"`
+	Unchecked        bool     `help:"Show only items never checked by media-check"                           group:"Filter"`
 	FlexibleSearch   bool     `help:"Flexible search (fuzzy)"                                                group:"Filter"`
 	Exact            bool     `help:"Exact match for search"                                                 group:"Filter"`
 	Where            []string `help:"SQL where clause(s)"                                                    group:"Filter" short:"w"`
@@ -95,8 +98,14 @@ type DatabaseFlags struct {
 }
 
 type DeletedFlags struct {
-	HideDeleted bool `help:"Exclude deleted files from results"    default:"true" group:"Deleted"`
-	OnlyDeleted bool `help:"Include only deleted files in results"                group:"Deleted"`
+	HideDeleted bool `help:"Exclude deleted files from results"                 default:"true" group:"Deleted"`
+	OnlyDeleted bool `help:"Include only deleted files in results"                             group:"Deleted"`
+	HideOffline bool `help:"Exclude files on volumes that are not mounted"                     group:"Deleted"`
+	OnlyOffline bool `help:"Include only files on volumes that are not mounted"                group:"Deleted"`
+
+	// OfflineRoots are the scan roots whose volume is not mounted, resolved for
+	// HideOffline and OnlyOffline
+	OfflineRoots []string `                                                                                         kong:"-"`
 }
 
 type SortFlags struct {
@@ -163,16 +172,17 @@ type SimilarityFlags struct {
 }
 
 type DedupeFlags struct {
-	Audio              bool    `help:"Dedupe database by artist + album + title"                           group:"Dedupe"`
-	ExtractorID        bool    `help:"Dedupe database by extractor_id"                                     group:"Dedupe" alias:"id"`
-	TitleOnly          bool    `help:"Dedupe database by title"                                            group:"Dedupe"`
-	DurationOnly       bool    `help:"Dedupe database by duration"                                         group:"Dedupe"`
-	Filesystem         bool    `help:"Dedupe filesystem database (hash)"                                   group:"Dedupe" alias:"fs"`
-	CompareDirs        bool    `help:"Compare directories"                                                 group:"Dedupe"`
-	Basename           bool    `help:"Match by basename similarity"                                        group:"Dedupe"`
-	Dirname            bool    `help:"Match by dirname similarity"                                         group:"Dedupe"`
-	MinSimilarityRatio float64 `help:"Filter out matches with less than this ratio (0.7-0.9)"              group:"Dedupe"            default:"0.8"`
-	DedupeCmd          string  `help:"Command to run for deduplication (rmlint-style: cmd duplicate keep)" group:"Dedupe"`
+	Audio              bool    `help:"Dedupe database by artist + album + title"                                group:"Dedupe"`
+	ExtractorID        bool    `help:"Dedupe database by extractor_id"                                          group:"Dedupe" alias:"id"`
+	TitleOnly          bool    `help:"Dedupe database by title"                                                 group:"Dedupe"`
+	DurationOnly       bool    `help:"Dedupe database by duration"                                              group:"Dedupe"`
+	Filesystem         bool    `help:"Dedupe filesystem database (hash)"                                        group:"Dedupe" alias:"fs"`
+	CompareDirs        bool    `help:"Compare directories"                                                      group:"Dedupe"`
+	Basename           bool    `help:"Match by basename similarity"                                             group:"Dedupe"`
+	Dirname            bool    `help:"Match by dirname similarity"                                              group:"Dedupe"`
+	MinSimilarityRatio float64 `help:"Filter out matches with less than this ratio (0.7-0.9)"                   group:"Dedupe"            default:"0.8"`
+	DedupeCmd          string  `help:"Command to run for deduplication (rmlint-style: cmd duplicate keep)"      group:"Dedupe"`
+	Link               string  `help:"Replace duplicates with a link to the kept file instead of deleting them" group:"Dedupe"            default:""    enum:",hard,reflink,symlink"`
 }
 
 type FTSFlags struct {
@@ -187,7 +197,10 @@ type PlaybackFlags struct {
 	NoPlayInOrder         bool     `help:"Don't play media in order"                                                               group:"Playback"`
 	Loop                  bool     `help:"Loop playback"                                                                           group:"Playback"`
 	Mute                  bool     `help:"Start playback muted"                                                          short:"M" group:"Playback"`
-	OverridePlayer        string   `help:"Override default player (e.g. --player 'vlc')"                                           group:"Playback"`
+	OverridePlayer        string   `help:"Override default player (e.g. --override-player vlc)"                                    group:"Playback"`
+	PlayerTemplate        string   `help:"Command template for other players (e.g. 'ffplay [-ss {start}] {path}')"                 group:"Playback"`
+	VlcRC                 string   `help:"VLC remote control host:port"                             default:"127.0.0.1:4212"       group:"Playback"`
+	VlcHTTP               string   `help:"VLC HTTP interface URL (e.g. http://:password@127.0.0.1:8080)"                           group:"Playback"`
 	Start                 string   `help:"Start playback at specific time/percentage"                                              group:"Playback"`
 	End                   string   `help:"Stop playback at specific time/percentage"                                               group:"Playback"`
 	Volume                int      `help:"Set initial volume (0-100)"                                                              group:"Playback"`
@@ -199,10 +212,10 @@ type PlaybackFlags struct {
 	SavePlayhead          bool     `help:"Save playback position on quit"                           default:"true"                 group:"Playback"`
 	MpvSocket             string   `help:"Mpv socket path"                                                                         group:"Playback"`
 	WatchLaterDir         string   `help:"Mpv watch_later directory"                                                               group:"Playback"`
-	PlayerArgsSub         []string `help:"Player arguments for videos with subtitles"                                              group:"Playback"`
-	PlayerArgsNoSub       []string `help:"Player arguments for videos without subtitles"                                           group:"Playback"`
-	Cast                  bool     `help:"Cast to chromecast groups"                                                               group:"Playback"`
-	CastDevice            string   `help:"Chromecast device name"                                                                  group:"Playback" alias:"cast-to"`
+	PlayerArgsSub         []string `help:"mpv arguments for videos with subtitles"                                                 group:"Playback"`
+	PlayerArgsNoSub       []string `help:"mpv arguments for videos without subtitles"                                              group:"Playback"`
+	Cast                  bool     `help:"Cast to a Chromecast device or group"                                                    group:"Playback"`
+	CastDevice            string   `help:"Chromecast device or group: name, id, or host[:port]"                                    group:"Playback" alias:"cast-to"`
 	CastWithLocal         bool     `help:"Play music locally at the same time as chromecast"                                       group:"Playback"`
 }
 
@@ -248,14 +261,22 @@ type HashingFlags struct {
 }
 
 type MergeFlags struct {
-	OnlyTables        []string `help:"Comma separated specific table(s)"       short:"t" group:"Merge"`
-	PrimaryKeys       []string `help:"Comma separated primary keys"                      group:"Merge"`
-	BusinessKeys      []string `help:"Comma separated business keys"                     group:"Merge"`
-	Upsert            bool     `help:"Upsert rows on conflict"                           group:"Merge"`
-	Ignore            bool     `help:"Ignore rows on conflict (only-new-rows)"           group:"Merge"`
-	OnlyNewRows       bool     `                                                                       kong:"-"` // Alias for Ignore
-	OnlyTargetColumns bool     `help:"Only copy columns that exist in target"            group:"Merge"`
-	SkipColumns       []string `help:"Columns to skip during merge"                      group:"Merge"`
+	OnlyTables        []string `help:"Comma separated specific table(s)"                                                                short:"t" group:"Merge"`
+	PrimaryKeys       []string `help:"Comma separated primary keys"                                                                               group:"Merge"`
+	BusinessKeys      []string `help:"Comma separated business keys"                                                                              group:"Merge"`
+	Upsert            bool     `help:"Upsert rows on conflict"                                                                                    group:"Merge"`
+	Ignore            bool     `help:"Ignore rows on conflict (only-new-rows)"                                                                    group:"Merge"`
+	OnlyNewRows       bool     `                                                                                                                                               kong:"-"` // Alias for Ignore
+	OnlyTargetColumns bool     `help:"Only copy columns that exist in target"                                                                     group:"Merge"`
+	SkipColumns       []string `help:"Columns to skip during merge"                                                                               group:"Merge"`
+	MergePolicy       []string `help:"Column policy for --upsert conflicts: [table.]column=max|min|sum|newest:COLUMN|union|keep|source"           group:"Merge"`
+	PathMap           []string `help:"Rewrite path prefixes of source rows, e.g. /mnt/old/=/mnt/new/"                                             group:"Merge"`
+	MergeDryRun       bool     `help:"Report what would change without writing"                                                                   group:"Merge" name:"dry-run"`
+}
+
+type ImportFlags struct {
+	Map  []string `help:"Rewrite path prefixes of the other server to disco paths, e.g. /data/movies/=/mnt/movies/" group:"Import"`
+	User string   `help:"Only import playstate of this user (Jellyfin user or Plex account name)"                   group:"Import"`
 }
 
 // GlobalFlags are flags available to disco data commands (print, search, du, etc)
@@ -289,8 +310,11 @@ type GlobalFlags struct {
 
 // ControlFlags are a subset of flags for simple control commands
 type ControlFlags struct {
+	Player     string `help:"Player to control (mpv, vlc); detected when empty"  group:"Playback"`
 	MpvSocket  string `help:"Mpv socket path"                                     group:"Playback"`
-	CastDevice string `help:"Chromecast device name"                              group:"Playback" alias:"cast-to"`
+	VlcRC      string `help:"VLC remote control host:port"                        group:"Playback" default:"127.0.0.1:4212"`
+	VlcHTTP    string `help:"VLC HTTP interface URL"                              group:"Playback"`
+	CastDevice string `help:"Chromecast device name, id, or host[:port]"          group:"Playback" alias:"cast-to"`
 	Verbose    int    `help:"Enable verbose logging (-v for info, -vv for debug)"                                  short:"v" env:"DISCO_VERBOSE" type:"counter"`
 }
 
@@ -355,6 +379,27 @@ func SetupLogging(verbosity int) {
 	Log = &slogLogger{logger}
 }
 
+// NewLogger returns a logger that writes lines at level and above to w
+func NewLogger(w io.Writer, level slog.Level) Logger {
+	return &slogLogger{slog.New(&plainHandler{level: level, out: w})}
+}
+
+type loggerKey struct{}
+
+// WithLogger returns a context whose commands log to l instead of Log.
+// disco serve uses it to keep the log of a background job
+func WithLogger(ctx context.Context, l Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LogFrom returns the logger of ctx, or Log when it has none
+func LogFrom(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok {
+		return l
+	}
+	return Log
+}
+
 // plainHandler implements [slog.Handler] with plain output
 type plainHandler struct {
 	level slog.Level
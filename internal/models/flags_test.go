@@ -1,6 +1,8 @@
 package models_test
 
 import (
+	"bytes"
+	"context"
 	"log/slog"
 	"testing"
 
@@ -118,3 +120,20 @@ func TestSetupLogging(t *testing.T) {
 		})
 	}
 }
+
+func TestLogFrom(t *testing.T) {
+	models.SetupLogging(0)
+	var buf bytes.Buffer
+	ctx := models.WithLogger(context.Background(), models.NewLogger(&buf, slog.LevelInfo))
+
+	models.LogFrom(ctx).Info("scanning", "path", "/media")
+	models.LogFrom(ctx).Debug("too detailed")
+	models.LogFrom(context.Background()).Info("not a job line")
+
+	if got, want := buf.String(), "scanning\n    path=/media\n"; got != want {
+		t.Errorf("job logger wrote %q, want %q", got, want)
+	}
+	if models.LogFrom(context.Background()) != models.Log {
+		t.Error("expected LogFrom without a logger to return Log")
+	}
+}
@@ -142,7 +142,9 @@ type MediaWithDB struct {
 	Media
 
 	DB              string  `json:"db,omitempty"`
+	Peer            string  `json:"peer,omitempty"` // disco serve --peer that owns the file
 	Transcode       bool    `json:"transcode"`
+	Offline         bool    `json:"offline,omitempty"` // on a scan root whose volume is not mounted
 	CaptionText     string  `json:"caption_text"`
 	CaptionTime     float64 `json:"caption_time"`
 	CaptionCount    int64   `json:"caption_count"`
@@ -0,0 +1,123 @@
+package player
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// presetTemplates are used when a known player is given without --player-template
+var presetTemplates = map[string]string{
+	"ffplay": "ffplay -autoexit [-ss {start}] [-volume {volume}] {path}",
+}
+
+// Command launches any player from a command line template. Placeholders are
+// {path}, {start}, {end}, {volume} and {speed}; a [bracketed group] of words is
+// dropped when any placeholder inside it is empty. It cannot be remote-controlled.
+// Launch options without a placeholder (NoVideo, NoSubtitles, Fullscreen, Mute,
+// Loop, SavePosition and ExtraArgs) are not applied; put the player's own flags
+// for them in the template
+type Command struct {
+	Template string
+	// Binary is run with the paths appended when there is no Template. It is one
+	// argument, so it may contain spaces
+	Binary string
+}
+
+// NewCommand builds a template player. Without a template the binary is run with the paths appended
+func NewCommand(binary, template string) *Command {
+	if template == "" {
+		template = presetTemplates[strings.ToLower(binary)]
+	}
+	if template == "" {
+		return &Command{Binary: binary}
+	}
+	return &Command{Template: template}
+}
+
+func (p *Command) Name() string { return BackendCommand }
+
+func (p *Command) Command(ctx context.Context, paths []string, opts LaunchOptions) *exec.Cmd {
+	args := p.Expand(paths, opts)
+	if len(args) == 0 {
+		return exec.CommandContext(ctx, "false")
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...)
+}
+
+// Expand renders the template into an argument list
+func (p *Command) Expand(paths []string, opts LaunchOptions) []string {
+	if p.Template == "" {
+		if p.Binary == "" {
+			return nil
+		}
+		return append([]string{p.Binary}, paths...)
+	}
+	values := map[string]string{
+		"{start}":  opts.Start,
+		"{end}":    opts.End,
+		"{volume}": "",
+		"{speed}":  "",
+	}
+	if opts.Volume > 0 {
+		values["{volume}"] = strconv.Itoa(opts.Volume)
+	}
+	if opts.Speed != 0 && opts.Speed != 1.0 {
+		values["{speed}"] = strconv.FormatFloat(opts.Speed, 'f', -1, 64)
+	}
+
+	var args []string
+	var group []string
+	inGroup, groupEmpty := false, false
+
+	for _, word := range strings.Fields(p.Template) {
+		if strings.HasPrefix(word, "[") && !inGroup {
+			inGroup, groupEmpty, group = true, false, nil
+			word = strings.TrimPrefix(word, "[")
+		}
+		closes := inGroup && strings.HasSuffix(word, "]")
+		if closes {
+			word = strings.TrimSuffix(word, "]")
+		}
+
+		var expanded []string
+		if word == "{path}" {
+			expanded = paths
+		} else if word != "" {
+			for placeholder, value := range values {
+				if strings.Contains(word, placeholder) {
+					if value == "" {
+						groupEmpty = true
+					}
+					word = strings.ReplaceAll(word, placeholder, value)
+				}
+			}
+			if word != "" {
+				expanded = []string{word}
+			}
+		}
+
+		if !inGroup {
+			args = append(args, expanded...)
+			continue
+		}
+		group = append(group, expanded...)
+		if closes {
+			if !groupEmpty {
+				args = append(args, group...)
+			}
+			inGroup = false
+		}
+	}
+	if inGroup && !groupEmpty {
+		args = append(args, group...)
+	}
+	return args
+}
+
+func (p *Command) Pause(context.Context) error               { return ErrUnsupported }
+func (p *Command) Seek(context.Context, float64, bool) error { return ErrUnsupported }
+func (p *Command) Next(context.Context) error                { return ErrUnsupported }
+func (p *Command) Stop(context.Context) error                { return ErrUnsupported }
+func (p *Command) Status(context.Context) (Status, error)    { return Status{}, ErrUnsupported }
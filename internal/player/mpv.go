@@ -0,0 +1,115 @@
+package player
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// Mpv controls mpv over its JSON IPC socket
+type Mpv struct {
+	Binary string
+	Socket string
+}
+
+func (p *Mpv) Name() string { return BackendMpv }
+
+func (p *Mpv) Command(ctx context.Context, paths []string, opts LaunchOptions) *exec.Cmd {
+	var args []string
+	if opts.NoVideo {
+		args = append(args, "--video=no")
+	}
+	if opts.Volume > 0 {
+		args = append(args, fmt.Sprintf("--volume=%d", opts.Volume))
+	}
+	if opts.Fullscreen {
+		args = append(args, "--fullscreen")
+	}
+	if opts.Mute {
+		args = append(args, "--mute=yes")
+	}
+	if opts.Loop {
+		args = append(args, "--loop-file=inf")
+	}
+	if opts.SavePosition {
+		args = append(args, "--save-position-on-quit")
+	}
+	if opts.Speed != 0 && opts.Speed != 1.0 {
+		args = append(args, fmt.Sprintf("--speed=%.2f", opts.Speed))
+	}
+	args = append(args, "--input-ipc-server="+p.Socket)
+	if opts.NoSubtitles {
+		args = append(args, "--no-sub")
+	}
+	args = append(args, opts.ExtraArgs...)
+	if opts.Start != "" {
+		args = append(args, "--start="+opts.Start)
+	}
+	if opts.End != "" {
+		args = append(args, "--end="+opts.End)
+	}
+	args = append(args, paths...)
+
+	return exec.CommandContext(ctx, p.Binary, args...)
+}
+
+func (p *Mpv) Pause(ctx context.Context) error {
+	_, err := utils.MpvCall(ctx, p.Socket, "cycle", "pause")
+	return err
+}
+
+func (p *Mpv) Seek(ctx context.Context, seconds float64, relative bool) error {
+	mode := "absolute"
+	if relative {
+		mode = "relative"
+	}
+	return utils.MpvSeek(ctx, p.Socket, seconds, mode)
+}
+
+func (p *Mpv) Next(ctx context.Context) error {
+	_, err := utils.MpvCall(ctx, p.Socket, "playlist_next", "force")
+	return err
+}
+
+func (p *Mpv) Stop(ctx context.Context) error {
+	_, err := utils.MpvCall(ctx, p.Socket, "stop")
+	return err
+}
+
+func (p *Mpv) Status(ctx context.Context) (Status, error) {
+	st := Status{Backend: BackendMpv}
+
+	path, err := utils.MpvGetProperty(ctx, p.Socket, "path")
+	if err != nil {
+		return st, err
+	}
+	st.Path = utils.GetString(path)
+
+	if v, err := utils.MpvGetProperty(ctx, p.Socket, "time-pos"); err == nil {
+		st.Position, _ = v.(float64)
+	}
+	if v, err := utils.MpvGetProperty(ctx, p.Socket, "duration"); err == nil {
+		st.Duration, _ = v.(float64)
+	}
+	if v, err := utils.MpvGetProperty(ctx, p.Socket, "pause"); err == nil {
+		st.Paused, _ = v.(bool)
+	}
+	if v, err := utils.MpvGetProperty(ctx, p.Socket, "volume"); err == nil {
+		if f, ok := v.(float64); ok {
+			st.Volume = int(f)
+		}
+	}
+	return st, nil
+}
+
+func (p *Mpv) SetVolume(ctx context.Context, percent int) error {
+	_, err := utils.MpvCall(ctx, p.Socket, "set_property", "volume", percent)
+	return err
+}
+
+func (p *Mpv) Append(ctx context.Context, path string) error {
+	_, err := utils.MpvCall(ctx, p.Socket, "loadfile", path, "append-play")
+	return err
+}
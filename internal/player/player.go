@@ -0,0 +1,181 @@
+// Package player abstracts the media players disco can launch and remote-control
+package player
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// Backend names
+const (
+	BackendMpv     = "mpv"
+	BackendVLC     = "vlc"
+	BackendCommand = "command"
+)
+
+// DefaultVlcRC is where the VLC backend asks VLC to listen for remote control commands
+const DefaultVlcRC = "127.0.0.1:4212"
+
+var (
+	// ErrUnsupported is returned by backends which cannot perform a control action
+	ErrUnsupported = errors.New("not supported by this player")
+	// ErrNotRunning is returned when no controllable player could be found
+	ErrNotRunning = errors.New("no running player detected")
+)
+
+// LaunchOptions are the playback options every backend tries to honor.
+// Start and End are seconds, or any syntax the underlying player accepts
+type LaunchOptions struct {
+	Start        string
+	End          string
+	Volume       int
+	Speed        float64
+	Fullscreen   bool
+	Mute         bool
+	Loop         bool
+	NoVideo      bool
+	NoSubtitles  bool
+	SavePosition bool
+	ExtraArgs    []string
+}
+
+// Status is a snapshot of what a player is doing
+type Status struct {
+	Backend  string  `json:"backend"`
+	Path     string  `json:"path"`
+	Position float64 `json:"position"`
+	Duration float64 `json:"duration"`
+	Paused   bool    `json:"paused"`
+	// Volume is in percent; zero when the player does not report it
+	Volume int `json:"volume,omitempty"`
+}
+
+// Controller remote-controls something that is playing
+type Controller interface {
+	Name() string
+	// Pause toggles pause
+	Pause(ctx context.Context) error
+	Seek(ctx context.Context, seconds float64, relative bool) error
+	Next(ctx context.Context) error
+	Stop(ctx context.Context) error
+	Status(ctx context.Context) (Status, error)
+}
+
+// Mixer is implemented by players whose volume can be changed remotely
+type Mixer interface {
+	// SetVolume sets the volume in percent, 100 being unamplified
+	SetVolume(ctx context.Context, percent int) error
+}
+
+// Queuer is implemented by players which can add files to their playlist while playing
+type Queuer interface {
+	Append(ctx context.Context, path string) error
+}
+
+// Player launches media and controls a running instance
+type Player interface {
+	Controller
+	// Command prepares the player process; the caller decides whether to wait for it
+	Command(ctx context.Context, paths []string, opts LaunchOptions) *exec.Cmd
+}
+
+// Config selects and configures a backend
+type Config struct {
+	// Backend is mpv, vlc, or command. When empty it is inferred from Binary
+	Backend string
+	// Binary is the player executable; defaults to the backend name
+	Binary    string
+	MpvSocket string
+	// VlcRC is the host:port of VLC's RC interface
+	VlcRC string
+	// VlcHTTP is the URL of VLC's HTTP interface, e.g. http://:password@127.0.0.1:8080.
+	// When set it is used for control instead of RC
+	VlcHTTP string
+	// Template is the command line for the command backend
+	Template string
+}
+
+// BackendFor infers the backend from a player executable name
+func BackendFor(binary string) string {
+	if binary == "" {
+		return BackendMpv
+	}
+	name := strings.ToLower(strings.TrimSuffix(filepath.Base(binary), filepath.Ext(binary)))
+	switch name {
+	case "mpv", "mpvnet":
+		return BackendMpv
+	case "vlc", "cvlc":
+		return BackendVLC
+	}
+	return BackendCommand
+}
+
+// New returns the configured player
+func New(cfg Config) Player {
+	backend := cfg.Backend
+	if backend == "" && cfg.Template != "" {
+		backend = BackendCommand
+	}
+	if backend == "" {
+		backend = BackendFor(cfg.Binary)
+	}
+
+	switch backend {
+	case BackendVLC:
+		binary := cfg.Binary
+		if binary == "" {
+			binary = "vlc"
+		}
+		rc := cfg.VlcRC
+		if rc == "" {
+			rc = DefaultVlcRC
+		}
+		return &VLC{Binary: binary, RC: rc, HTTP: cfg.VlcHTTP}
+	case BackendCommand:
+		return NewCommand(cfg.Binary, cfg.Template)
+	default:
+		binary := cfg.Binary
+		if binary == "" {
+			binary = "mpv"
+		}
+		return &Mpv{Binary: binary, Socket: utils.GetMpvSocketPath(cfg.MpvSocket)}
+	}
+}
+
+// Running returns a player to control whatever is currently playing. An explicit
+// backend is used as-is; otherwise mpv is chosen when its IPC socket exists, then
+// VLC when its RC or HTTP interface answers
+func Running(ctx context.Context, cfg Config) (Player, error) {
+	if cfg.Backend != "" {
+		return New(cfg), nil
+	}
+
+	mpv := New(Config{Backend: BackendMpv, MpvSocket: cfg.MpvSocket}).(*Mpv)
+	if utils.FileExists(mpv.Socket) {
+		return mpv, nil
+	}
+
+	vlc := New(Config{Backend: BackendVLC, VlcRC: cfg.VlcRC, VlcHTTP: cfg.VlcHTTP}).(*VLC)
+	if vlc.reachable(ctx) {
+		return vlc, nil
+	}
+	return nil, ErrNotRunning
+}
+
+func canDial(ctx context.Context, network, addr string) bool {
+	dialCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, network, addr)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
@@ -0,0 +1,318 @@
+package player_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/player"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// recorder collects the commands a fake player receives
+type recorder struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (r *recorder) add(cmd string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, cmd)
+}
+
+func (r *recorder) all() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.commands)
+}
+
+func startFakeMpv(t *testing.T) (string, *recorder) {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "mpv.sock")
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	rec := &recorder{}
+	props := map[string]any{"path": "/media/a.mkv", "time-pos": 42.0, "duration": 100.0, "pause": true}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				scanner := bufio.NewScanner(c)
+				for scanner.Scan() {
+					var cmd utils.MpvCommand
+					json.Unmarshal(scanner.Bytes(), &cmd)
+					var parts []string
+					for _, a := range cmd.Command {
+						b, _ := json.Marshal(a)
+						parts = append(parts, strings.Trim(string(b), `"`))
+					}
+					rec.add(strings.Join(parts, " "))
+
+					resp := utils.MpvResponse{Error: "success"}
+					if len(cmd.Command) == 2 && cmd.Command[0] == "get_property" {
+						resp.Data = props[cmd.Command[1].(string)]
+					}
+					b, _ := json.Marshal(resp)
+					c.Write(append(b, '\n'))
+				}
+			}(conn)
+		}
+	}()
+	return socketPath, rec
+}
+
+func startFakeVlcRC(t *testing.T) (string, *recorder) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	rec := &recorder{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("VLC media player 3.0.20 Vetinari\nCommand Line Interface initialized. Type `help' for help.\n> "))
+				scanner := bufio.NewScanner(c)
+				for scanner.Scan() {
+					cmd := scanner.Text()
+					rec.add(cmd)
+					switch cmd {
+					case "status":
+						c.Write([]byte("( new input: file:///media/song%20one.mp3 )\n( audio volume: 256 )\n( state playing )\n> "))
+					case "get_time":
+						c.Write([]byte("17\n> "))
+					case "get_length":
+						c.Write([]byte("240\n> "))
+					case "logout":
+						c.Write([]byte("Bye-bye!\n"))
+						return
+					default:
+						c.Write([]byte("> "))
+					}
+				}
+			}(conn)
+		}
+	}()
+	return ln.Addr().String(), rec
+}
+
+func TestBackendSelection(t *testing.T) {
+	tests := []struct {
+		cfg  player.Config
+		want string
+	}{
+		{player.Config{}, player.BackendMpv},
+		{player.Config{Binary: "/usr/bin/mpv"}, player.BackendMpv},
+		{player.Config{Binary: "cvlc"}, player.BackendVLC},
+		{player.Config{Binary: "ffplay"}, player.BackendCommand},
+		{player.Config{Template: "mplayer {path}"}, player.BackendCommand},
+		{player.Config{Backend: player.BackendVLC, Binary: "/opt/vlc/vlc-wrapper"}, player.BackendVLC},
+	}
+	for _, tt := range tests {
+		if got := player.New(tt.cfg).Name(); got != tt.want {
+			t.Errorf("New(%+v) = %s, want %s", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestMpv(t *testing.T) {
+	socketPath, rec := startFakeMpv(t)
+	p := player.New(player.Config{MpvSocket: socketPath})
+	ctx := context.Background()
+
+	cmd := p.Command(ctx, []string{"/media/a.mkv"}, player.LaunchOptions{
+		Start:        "30",
+		Volume:       70,
+		NoVideo:      true,
+		SavePosition: true,
+		ExtraArgs:    []string{"--sub-file=x.srt"},
+	})
+	for _, want := range []string{
+		"--video=no", "--volume=70", "--save-position-on-quit", "--input-ipc-server=" + socketPath,
+		"--sub-file=x.srt", "--start=30",
+	} {
+		if !slices.Contains(cmd.Args, want) {
+			t.Errorf("Expected %q in %v", want, cmd.Args)
+		}
+	}
+	if cmd.Args[len(cmd.Args)-1] != "/media/a.mkv" {
+		t.Errorf("Expected path last, got %v", cmd.Args)
+	}
+
+	if err := p.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Seek(ctx, -10, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st, err := p.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Path != "/media/a.mkv" || st.Position != 42 || st.Duration != 100 || !st.Paused {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+
+	got := rec.all()
+	for _, want := range []string{"cycle pause", "seek -10 relative", "playlist_next force", "stop"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("Expected mpv to receive %q, got %v", want, got)
+		}
+	}
+}
+
+func TestVLCRemoteControl(t *testing.T) {
+	addr, rec := startFakeVlcRC(t)
+	p := player.New(player.Config{Backend: player.BackendVLC, VlcRC: addr})
+	ctx := context.Background()
+
+	cmd := p.Command(ctx, []string{"/media/a.mp3"}, player.LaunchOptions{Start: "00:01:30", NoVideo: true, Speed: 1.5})
+	for _, want := range []string{"--extraintf=rc", "--rc-host=" + addr, "--start-time=90", "--no-video", "--rate=1.50"} {
+		if !slices.Contains(cmd.Args, want) {
+			t.Errorf("Expected %q in %v", want, cmd.Args)
+		}
+	}
+
+	if err := p.Pause(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Seek(ctx, 10, true); err != nil {
+		t.Fatal(err)
+	}
+	st, err := p.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Path != "/media/song one.mp3" || st.Position != 17 || st.Duration != 240 || st.Paused {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+
+	got := rec.all()
+	for _, want := range []string{"pause", "seek +10", "status", "get_time", "get_length", "logout"} {
+		if !slices.Contains(got, want) {
+			t.Errorf("Expected VLC to receive %q, got %v", want, got)
+		}
+	}
+}
+
+func TestVLCHTTP(t *testing.T) {
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pass, _ := r.BasicAuth(); pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/requests/status.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		queries = append(queries, r.URL.RawQuery)
+		w.Write([]byte(`{"time":5,"length":60,"state":"paused","information":{"category":{"meta":{"filename":"b.mkv"}}}}`))
+	}))
+	defer srv.Close()
+
+	httpURL := strings.Replace(srv.URL, "http://", "http://:secret@", 1)
+	p := player.New(player.Config{Backend: player.BackendVLC, VlcHTTP: httpURL})
+	ctx := context.Background()
+
+	if err := p.Next(ctx); err != nil {
+		t.Fatal(err)
+	}
+	st, err := p.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Path != "b.mkv" || st.Position != 5 || st.Duration != 60 || !st.Paused {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+	if len(queries) != 2 || queries[0] != "command=pl_next" || queries[1] != "" {
+		t.Errorf("Unexpected requests: %v", queries)
+	}
+}
+
+func TestCommandTemplate(t *testing.T) {
+	p := player.NewCommand("", "myplayer --fs [-ss {start}] [--vol {volume}] {path} [--until={end}]")
+	got := p.Expand([]string{"/a b.mp4", "/c.mp4"}, player.LaunchOptions{Start: "12", End: ""})
+	want := []string{"myplayer", "--fs", "-ss", "12", "/a b.mp4", "/c.mp4"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expand() = %v, want %v", got, want)
+	}
+
+	ffplay := player.New(player.Config{Binary: "ffplay"}).(*player.Command)
+	got = ffplay.Expand([]string{"/x.mp3"}, player.LaunchOptions{Volume: 30})
+	want = []string{"ffplay", "-autoexit", "-volume", "30", "/x.mp3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ffplay Expand() = %v, want %v", got, want)
+	}
+
+	plain := player.New(player.Config{Binary: "mplayer"}).(*player.Command)
+	if got := plain.Expand([]string{"/x.mp3"}, player.LaunchOptions{}); !slices.Equal(got, []string{"mplayer", "/x.mp3"}) {
+		t.Errorf("Unexpected default template expansion: %v", got)
+	}
+	spaced := player.New(player.Config{Binary: "/opt/My Player/play"}).(*player.Command)
+	if got := spaced.Expand([]string{"/a b.mp3"}, player.LaunchOptions{}); !slices.Equal(got, []string{"/opt/My Player/play", "/a b.mp3"}) {
+		t.Errorf("Expected the binary as one argument, got %v", got)
+	}
+
+	if err := plain.Pause(context.Background()); !errors.Is(err, player.ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestRunning(t *testing.T) {
+	ctx := context.Background()
+	missingSocket := filepath.Join(t.TempDir(), "none.sock")
+
+	socketPath, _ := startFakeMpv(t)
+	p, err := player.Running(ctx, player.Config{MpvSocket: socketPath})
+	if err != nil || p.Name() != player.BackendMpv {
+		t.Errorf("Expected mpv, got %v %v", p, err)
+	}
+
+	addr, _ := startFakeVlcRC(t)
+	p, err = player.Running(ctx, player.Config{MpvSocket: missingSocket, VlcRC: addr})
+	if err != nil || p.Name() != player.BackendVLC {
+		t.Errorf("Expected vlc, got %v %v", p, err)
+	}
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedAddr := closed.Addr().String()
+	closed.Close()
+	if _, err := player.Running(ctx, player.Config{MpvSocket: missingSocket, VlcRC: closedAddr}); !errors.Is(
+		err,
+		player.ErrNotRunning,
+	) {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+}
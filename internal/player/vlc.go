@@ -0,0 +1,307 @@
+package player
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// VLC controls VLC through its RC (telnet-like) interface, or its HTTP interface when configured
+type VLC struct {
+	Binary string
+	RC     string
+	HTTP   string
+	Client *http.Client
+}
+
+func (p *VLC) Name() string { return BackendVLC }
+
+func (p *VLC) Command(ctx context.Context, paths []string, opts LaunchOptions) *exec.Cmd {
+	args := []string{"--play-and-exit"}
+
+	intf := []string{"rc"}
+	if host, port, password, ok := p.httpListen(); ok {
+		intf = append(intf, "http")
+		args = append(args, "--http-host="+host, "--http-port="+port, "--http-password="+password)
+	}
+	args = append(args, "--extraintf="+strings.Join(intf, ":"), "--rc-host="+p.RC)
+
+	if opts.NoVideo {
+		args = append(args, "--no-video")
+	}
+	if opts.Fullscreen {
+		args = append(args, "--fullscreen")
+	}
+	switch {
+	case opts.Mute:
+		args = append(args, "--gain=0")
+	case opts.Volume > 0:
+		args = append(args, fmt.Sprintf("--gain=%.2f", float64(opts.Volume)/100))
+	}
+	if opts.Loop {
+		args = append(args, "--repeat")
+	}
+	if opts.Speed != 0 && opts.Speed != 1.0 {
+		args = append(args, fmt.Sprintf("--rate=%.2f", opts.Speed))
+	}
+	if opts.NoSubtitles {
+		args = append(args, "--no-spu")
+	}
+	args = append(args, opts.ExtraArgs...)
+	if start := vlcSeconds(opts.Start); start != "" {
+		args = append(args, "--start-time="+start)
+	}
+	if end := vlcSeconds(opts.End); end != "" {
+		args = append(args, "--stop-time="+end)
+	}
+	args = append(args, paths...)
+
+	return exec.CommandContext(ctx, p.Binary, args...)
+}
+
+// vlcSeconds converts HH:MM:SS to seconds; VLC only understands plain seconds
+func vlcSeconds(s string) string {
+	if s == "" || strings.HasSuffix(s, "%") {
+		return ""
+	}
+	if strings.Contains(s, ":") {
+		return strconv.FormatFloat(utils.FromTimestampSeconds(s), 'f', -1, 64)
+	}
+	return s
+}
+
+func (p *VLC) httpListen() (host, port, password string, ok bool) {
+	if p.HTTP == "" {
+		return "", "", "", false
+	}
+	u, err := url.Parse(p.HTTP)
+	if err != nil {
+		return "", "", "", false
+	}
+	password, _ = u.User.Password()
+	port = u.Port()
+	if port == "" {
+		port = "8080"
+	}
+	return u.Hostname(), port, password, true
+}
+
+func (p *VLC) reachable(ctx context.Context) bool {
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "")
+		return err == nil
+	}
+	return canDial(ctx, "tcp", p.RC)
+}
+
+func (p *VLC) Pause(ctx context.Context) error {
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=pl_pause")
+		return err
+	}
+	_, err := p.rc(ctx, "pause")
+	return err
+}
+
+func (p *VLC) Seek(ctx context.Context, seconds float64, relative bool) error {
+	val := strconv.FormatFloat(seconds, 'f', 0, 64)
+	if relative && seconds >= 0 {
+		val = "+" + val
+	}
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=seek&val="+url.QueryEscape(val))
+		return err
+	}
+	_, err := p.rc(ctx, "seek "+val)
+	return err
+}
+
+func (p *VLC) Next(ctx context.Context) error {
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=pl_next")
+		return err
+	}
+	_, err := p.rc(ctx, "next")
+	return err
+}
+
+func (p *VLC) Stop(ctx context.Context) error {
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=pl_stop")
+		return err
+	}
+	_, err := p.rc(ctx, "stop")
+	return err
+}
+
+// vlcVolumeScale is VLC's volume value for 100%
+const vlcVolumeScale = 256
+
+func (p *VLC) SetVolume(ctx context.Context, percent int) error {
+	val := strconv.Itoa(percent * vlcVolumeScale / 100)
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=volume&val="+val)
+		return err
+	}
+	_, err := p.rc(ctx, "volume "+val)
+	return err
+}
+
+func (p *VLC) Append(ctx context.Context, path string) error {
+	if p.HTTP != "" {
+		_, err := p.httpStatus(ctx, "command=in_enqueue&input="+url.QueryEscape(path))
+		return err
+	}
+	_, err := p.rc(ctx, "enqueue "+path)
+	return err
+}
+
+func (p *VLC) Status(ctx context.Context) (Status, error) {
+	if p.HTTP != "" {
+		return p.httpStatus(ctx, "")
+	}
+
+	st := Status{Backend: BackendVLC}
+	lines, err := p.rc(ctx, "status", "get_time", "get_length")
+	if err != nil {
+		return st, err
+	}
+
+	var numbers []float64
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "( new input:"):
+			input := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "( new input:"), ")"))
+			st.Path = fileURLToPath(input)
+		case strings.HasPrefix(line, "( state "):
+			st.Paused = strings.Contains(line, "paused")
+		default:
+			if f, err := strconv.ParseFloat(line, 64); err == nil {
+				numbers = append(numbers, f)
+			}
+		}
+	}
+	if len(numbers) > 0 {
+		st.Position = numbers[0]
+	}
+	if len(numbers) > 1 {
+		st.Duration = numbers[1]
+	}
+	if st.Path == "" {
+		return st, ErrNotRunning
+	}
+	return st, nil
+}
+
+func fileURLToPath(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "file" {
+		return s
+	}
+	return u.Path
+}
+
+// rc sends commands over the RC interface and returns the non-prompt reply lines.
+// Ending with logout makes VLC close the connection so the reply has a clear end
+func (p *VLC) rc(ctx context.Context, commands ...string) ([]string, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", p.RC)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "%s\nlogout\n", strings.Join(commands, "\n")); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		for strings.HasPrefix(line, ">") {
+			line = strings.TrimSpace(strings.TrimPrefix(line, ">"))
+		}
+		if line == "" || strings.HasPrefix(line, "VLC media player") || strings.HasPrefix(line, "Command Line Interface") ||
+			strings.HasPrefix(line, "Bye-bye") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, err
+	}
+	return lines, nil
+}
+
+type vlcHTTPStatus struct {
+	Time        float64 `json:"time"`
+	Length      float64 `json:"length"`
+	Volume      int     `json:"volume"`
+	State       string  `json:"state"`
+	Information struct {
+		Category struct {
+			Meta struct {
+				Filename string `json:"filename"`
+			} `json:"meta"`
+		} `json:"category"`
+	} `json:"information"`
+}
+
+// httpStatus requests status.json, optionally running a command first
+func (p *VLC) httpStatus(ctx context.Context, rawQuery string) (Status, error) {
+	st := Status{Backend: BackendVLC}
+
+	u, err := url.Parse(p.HTTP)
+	if err != nil {
+		return st, err
+	}
+	password, _ := u.User.Password()
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/requests/status.json"
+	u.RawQuery = rawQuery
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return st, err
+	}
+	req.SetBasicAuth("", password)
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 3 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("vlc http: %s", resp.Status)
+	}
+
+	var s vlcHTTPStatus
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return st, err
+	}
+	st.Path = s.Information.Category.Meta.Filename
+	st.Position = s.Time
+	st.Duration = s.Length
+	st.Paused = s.State == "paused"
+	st.Volume = s.Volume * 100 / vlcVolumeScale
+	return st, nil
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math"
 	"math/rand/v2"
 	"path/filepath"
 	"regexp"
@@ -12,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/chapmanjacobd/discoteca/internal/db"
 	"github.com/chapmanjacobd/discoteca/internal/models"
@@ -58,6 +60,7 @@ func (fb *FilterBuilder) buildBasicFilters(whereClauses *[]string, args *[]any)
 	} else if fb.Flags.HideDeleted {
 		*whereClauses = append(*whereClauses, fmt.Sprintf("COALESCE(%s, 0) = 0", fb.col("time_deleted")))
 	}
+	fb.buildOfflineFilter(whereClauses, args)
 
 	// Content type filters (indexed column - should come before expensive searches)
 	var typeClauses []string
@@ -134,6 +137,87 @@ func (fb *FilterBuilder) buildBasicFilters(whereClauses *[]string, args *[]any)
 	}
 }
 
+// buildOfflineFilter matches files under the resolved offline scan roots
+func (fb *FilterBuilder) buildOfflineFilter(whereClauses *[]string, args *[]any) {
+	if !fb.Flags.OnlyOffline && !fb.Flags.HideOffline {
+		return
+	}
+	var rootClauses []string
+	for _, root := range fb.Flags.OfflineRoots {
+		rootClauses = append(rootClauses, fmt.Sprintf("(%[1]s = ? OR substr(%[1]s, 1, ?) IN (?, ?))", fb.col("path")))
+		*args = append(*args, root, utf8.RuneCountInString(root)+1, root+"/", root+"\\")
+	}
+	switch {
+	case fb.Flags.OnlyOffline && len(rootClauses) == 0:
+		*whereClauses = append(*whereClauses, "0")
+	case fb.Flags.OnlyOffline:
+		*whereClauses = append(*whereClauses, "("+strings.Join(rootClauses, " OR ")+")")
+	case len(rootClauses) > 0:
+		*whereClauses = append(*whereClauses, "NOT ("+strings.Join(rootClauses, " OR ")+")")
+	}
+}
+
+// buildMediaCheckFilters filters on the results saved by media-check. Corruption
+// ranges are percentages, compared in hundredths of a percent
+func (fb *FilterBuilder) buildMediaCheckFilters(whereClauses *[]string, args *[]any) {
+	if fb.Flags.Unchecked {
+		*whereClauses = append(
+			*whereClauses,
+			fmt.Sprintf("%s NOT IN (SELECT media_path FROM media_checks)", fb.col("path")),
+		)
+	}
+
+	for _, s := range fb.Flags.Corrupt {
+		r, err := parseCorruptRange(s)
+		if err != nil {
+			continue // reported by Validate
+		}
+		var subWhere []string
+		if r.Value != nil {
+			subWhere = append(subWhere, "ROUND(corruption * 10000) = ?")
+			*args = append(*args, *r.Value)
+		}
+		if r.Min != nil {
+			subWhere = append(subWhere, "ROUND(corruption * 10000) >= ?")
+			*args = append(*args, *r.Min)
+		}
+		if r.Max != nil {
+			subWhere = append(subWhere, "ROUND(corruption * 10000) <= ?")
+			*args = append(*args, *r.Max)
+		}
+		if len(subWhere) > 0 {
+			*whereClauses = append(*whereClauses, fmt.Sprintf(
+				"%s IN (SELECT media_path FROM media_checks WHERE %s)",
+				fb.col("path"),
+				strings.Join(subWhere, " AND "),
+			))
+		}
+	}
+}
+
+// Validate reports filter values that cannot be turned into SQL, which building the
+// query would otherwise skip
+func (fb *FilterBuilder) Validate() error {
+	for _, s := range fb.Flags.Corrupt {
+		if _, err := parseCorruptRange(s); err != nil {
+			return fmt.Errorf("invalid --corrupt %q: %w", s, err)
+		}
+	}
+	return nil
+}
+
+func parseCorruptRange(s string) (utils.Range, error) {
+	return utils.ParseRange(strings.ReplaceAll(s, "%", ""), percentToBasisPoints)
+}
+
+func percentToBasisPoints(s string) (int64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(v * 100)), nil
+}
+
 func (fb *FilterBuilder) buildRangeFilters(whereClauses *[]string, args *[]any) {
 	// Size filters (indexed column)
 	for _, s := range fb.Flags.Size {
@@ -463,6 +547,14 @@ func (fb *FilterBuilder) buildMiscellaneousFilters(whereClauses *[]string, args
 		)
 	}
 
+	if fb.Flags.IntegrityFailed {
+		*whereClauses = append(
+			*whereClauses,
+			fmt.Sprintf("%s IN (SELECT media_path FROM integrity WHERE status = 'failed')", fb.col("path")),
+		)
+	}
+	fb.buildMediaCheckFilters(whereClauses, args)
+
 	// Custom WHERE clauses
 	*whereClauses = append(*whereClauses, fb.Flags.Where...)
 
@@ -1885,18 +1977,30 @@ func NewQueryExecutor(flags models.GlobalFlags) *QueryExecutor {
 
 // ScanMedia maps SQL rows to MediaWithDB structs
 func ScanMedia(rows *sql.Rows, dbPath string) ([]models.MediaWithDB, error) {
-	cols, _ := rows.Columns()
 	allMedia := []models.MediaWithDB{}
+	err := scanMediaRows(rows, dbPath, func(m models.MediaWithDB) error {
+		allMedia = append(allMedia, m)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allMedia, nil
+}
 
-	for rows.Next() {
-		values := make([]any, len(cols))
-		valuePtrs := make([]any, len(cols))
-		for i := range cols {
-			valuePtrs[i] = &values[i]
-		}
+// scanMediaRows maps SQL rows to MediaWithDB structs one at a time
+func scanMediaRows(rows *sql.Rows, dbPath string, fn func(models.MediaWithDB) error) error {
+	cols, _ := rows.Columns()
+	values := make([]any, len(cols))
+	valuePtrs := make([]any, len(cols))
+	for i := range cols {
+		valuePtrs[i] = &values[i]
+	}
 
+	for rows.Next() {
+		clear(values)
 		if err := rows.Scan(valuePtrs...); err != nil {
-			return nil, err
+			return err
 		}
 
 		m := db.Media{}
@@ -1918,13 +2022,15 @@ func ScanMedia(rows *sql.Rows, dbPath string) ([]models.MediaWithDB, error) {
 			}
 		}
 
-		allMedia = append(allMedia, models.MediaWithDB{
+		if err := fn(models.MediaWithDB{
 			Media: models.FromDB(m),
 			DB:    dbPath,
-		})
+		}); err != nil {
+			return err
+		}
 	}
 
-	return allMedia, rows.Err()
+	return rows.Err()
 }
 
 func mapStringField(m *db.Media, col string, val any) bool {
@@ -2175,22 +2281,32 @@ func (qe *QueryExecutor) applyMultiDBLimitOffset(
 	allMedia []models.MediaWithDB,
 	opts postProcessOptions,
 ) []models.MediaWithDB {
-	NewSortBuilder(opts.flags).Sort(allMedia)
+	return SortAndPaginate(allMedia, opts.flags, opts.origLimit, opts.origOffset)
+}
 
-	if opts.origOffset > 0 {
-		if opts.origOffset >= len(allMedia) {
+// SortAndPaginate sorts results merged from several sources and applies the
+// caller's original limit and offset. Each source must have been queried with
+// limit+offset rows and no offset
+func SortAndPaginate(allMedia []models.MediaWithDB, flags models.GlobalFlags, limit, offset int) []models.MediaWithDB {
+	NewSortBuilder(flags).Sort(allMedia)
+
+	if offset > 0 {
+		if offset >= len(allMedia) {
 			return []models.MediaWithDB{}
 		}
-		allMedia = allMedia[opts.origOffset:]
+		allMedia = allMedia[offset:]
 	}
-	if len(allMedia) > opts.origLimit {
-		allMedia = allMedia[:opts.origLimit]
+	if limit > 0 && len(allMedia) > limit {
+		allMedia = allMedia[:limit]
 	}
 	return allMedia
 }
 
 // MediaQuery executes a query against multiple databases concurrently
 func (qe *QueryExecutor) MediaQuery(ctx context.Context, dbs []string) ([]models.MediaWithDB, error) {
+	if err := qe.filterBuilder.Validate(); err != nil {
+		return nil, err
+	}
 	flags, isEpisodic, isMultiDB, origLimit, origOffset := qe.prepareMediaQuery(dbs)
 
 	resolvedFlags, err := qe.ResolvePercentileFlags(ctx, dbs, flags)
@@ -2217,8 +2333,72 @@ func (qe *QueryExecutor) MediaQuery(ctx context.Context, dbs []string) ([]models
 	})
 }
 
+// StreamMedia calls fn for each matching media row without buffering the result set.
+// Queries whose results have to be regrouped or sorted across databases (file counts,
+// grouping, siblings, several databases) are buffered first
+func (qe *QueryExecutor) StreamMedia(ctx context.Context, dbs []string, fn func(models.MediaWithDB) error) error {
+	if err := qe.filterBuilder.Validate(); err != nil {
+		return err
+	}
+	flags := qe.filterBuilder.Flags
+	if flags.FileCounts != "" || flags.GroupByParent || flags.FetchSiblings != "" || len(dbs) > 1 {
+		media, err := qe.MediaQuery(ctx, dbs)
+		if err != nil {
+			return err
+		}
+		media = FilterMedia(media, flags)
+		if len(dbs) > 1 {
+			NewSortBuilder(flags).Sort(media)
+		}
+		for _, m := range media {
+			if err := fn(m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	resolvedFlags, err := qe.ResolvePercentileFlags(ctx, dbs, flags)
+	if err == nil {
+		flags = resolvedFlags
+	}
+	fb := NewFilterBuilder(flags)
+	query, args := fb.BuildQuery(ctx, "*")
+	filter := fb.CreateInMemoryFilter()
+
+	for _, dbPath := range dbs {
+		err := func() error {
+			sqlDB, err := db.Connect(ctx, dbPath)
+			if err != nil {
+				return err
+			}
+			defer sqlDB.Close()
+
+			rows, err := sqlDB.QueryContext(ctx, query, args...)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			return scanMediaRows(rows, dbPath, func(m models.MediaWithDB) error {
+				if !filter(m) {
+					return nil
+				}
+				return fn(m)
+			})
+		}()
+		if err != nil {
+			return fmt.Errorf("%s: %w", dbPath, err)
+		}
+	}
+	return nil
+}
+
 // MediaQueryCount executes a count query against multiple databases concurrently
 func (qe *QueryExecutor) MediaQueryCount(ctx context.Context, dbs []string) (int64, error) {
+	if err := qe.filterBuilder.Validate(); err != nil {
+		return 0, err
+	}
 	flags := qe.filterBuilder.Flags
 
 	if flags.FileCounts != "" {
@@ -2666,11 +2846,16 @@ func (qe *QueryExecutor) resolveAllPercentiles(ctx context.Context, dbs []string
 	qe.resolveEpisodePercentiles(ctx, dbs, flags)
 }
 
+// ResolvePercentileFlags resolves the filters that depend on the data: percentile
+// ranges, and the offline scan roots for HideOffline and OnlyOffline
 func (qe *QueryExecutor) ResolvePercentileFlags(
 	ctx context.Context,
 	dbs []string,
 	flags models.GlobalFlags,
 ) (models.GlobalFlags, error) {
+	if (flags.HideOffline || flags.OnlyOffline) && flags.OfflineRoots == nil {
+		flags.OfflineRoots = OfflineRoots(ctx, dbs)
+	}
 	if !qe.hasAnyPercentileFlag(flags) {
 		return flags, nil
 	}
@@ -26,6 +26,11 @@ func MediaQueryCount(ctx context.Context, dbs []string, flags models.GlobalFlags
 	return executor.MediaQueryCount(ctx, dbs)
 }
 
+// StreamMedia calls fn for each media row matching flags, reading rows as they arrive
+func StreamMedia(ctx context.Context, dbs []string, flags models.GlobalFlags, fn func(models.MediaWithDB) error) error {
+	return NewQueryExecutor(flags).StreamMedia(ctx, dbs, fn)
+}
+
 // FilterMedia applies all filters to media list in memory
 func FilterMedia(media []models.MediaWithDB, flags models.GlobalFlags) []models.MediaWithDB {
 	fb := NewFilterBuilder(flags)
@@ -67,7 +72,7 @@ func FetchSiblings(
 	return executor.FetchSiblings(ctx, media, flags)
 }
 
-// ResolvePercentileFlags resolves percentile-based filters (Re-exported for tests)
+// ResolvePercentileFlags resolves percentile-based and offline filters (Re-exported for tests)
 func ResolvePercentileFlags(ctx context.Context, dbs []string, flags models.GlobalFlags) (models.GlobalFlags, error) {
 	executor := NewQueryExecutor(flags)
 	return executor.ResolvePercentileFlags(ctx, dbs, flags)
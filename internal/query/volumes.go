@@ -0,0 +1,86 @@
+package query
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/chapmanjacobd/discoteca/internal/db"
+	"github.com/chapmanjacobd/discoteca/internal/models"
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+// OfflineRoots returns the scan roots of dbs whose volume is not mounted. Files under
+// them are offline rather than deleted. A root without a recorded volume is offline
+// while it is missing or empty, as an unplugged drive leaves its mount point behind
+func OfflineRoots(ctx context.Context, dbs []string) []string {
+	mounted := map[utils.Volume]bool{}
+	roots := []string{}
+	for _, dbPath := range dbs {
+		volumes, scanRoots, err := readVolumes(ctx, dbPath)
+		if err != nil {
+			models.Log.Debug("Failed to read volumes", "db", dbPath, "error", err)
+			continue
+		}
+		recorded := make(map[string]bool, len(volumes))
+		for _, v := range volumes {
+			recorded[v.Root] = true
+		}
+		for _, root := range scanRoots {
+			if !recorded[root.Path] && !utils.DirHasEntries(root.Path) && !slices.Contains(roots, root.Path) {
+				roots = append(roots, root.Path)
+			}
+		}
+		for _, v := range volumes {
+			vol := RecordedVolume(v)
+			ok, checked := mounted[vol]
+			if !checked {
+				ok = utils.VolumeMounted(vol)
+				mounted[vol] = ok
+			}
+			if !ok && !slices.Contains(roots, v.Root) {
+				roots = append(roots, v.Root)
+			}
+		}
+	}
+	return roots
+}
+
+// RecordedVolume returns the identity of the volume recorded for a scan root
+func RecordedVolume(v db.Volume) utils.Volume {
+	return utils.Volume{
+		MountPoint: v.MountPoint,
+		UUID:       v.UUID,
+		Label:      v.Label,
+		Source:     v.Source,
+		FSType:     v.FSType,
+	}
+}
+
+func readVolumes(ctx context.Context, dbPath string) ([]db.Volume, []db.ScanRoot, error) {
+	sqlDB, err := db.Connect(ctx, dbPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer sqlDB.Close()
+	queries := db.New(sqlDB)
+	volumes, err := queries.GetVolumes(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	scanRoots, err := queries.GetScanRoots(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return volumes, scanRoots, nil
+}
+
+// PathOffline reports whether path is under one of the offline roots
+func PathOffline(path string, offlineRoots []string) bool {
+	for _, root := range offlineRoots {
+		if rest, ok := strings.CutPrefix(path, root); ok && (rest == "" || rest[0] == '/' || rest[0] == '\\') {
+			return true
+		}
+	}
+	return false
+}
@@ -23,3 +23,11 @@ func GetDeviceID(info os.FileInfo) (uint64, bool) {
 	}
 	return 0, false
 }
+
+// GetLinkCount returns the number of hard links to a file
+func GetLinkCount(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
@@ -20,3 +20,8 @@ func GetAccessTime(info os.FileInfo) time.Time {
 func GetDeviceID(info os.FileInfo) (uint64, bool) {
 	return 0, false
 }
+
+// GetLinkCount returns the number of hard links to a file
+func GetLinkCount(info os.FileInfo) uint64 {
+	return 1
+}
@@ -289,8 +289,8 @@ func GetTempDir() string {
 	return os.TempDir()
 }
 
-func GetCattNowPlayingFile() string {
-	return filepath.Join(os.TempDir(), "catt_playing")
+func GetCastNowPlayingFile() string {
+	return filepath.Join(os.TempDir(), "disco_cast_playing.json")
 }
 
 func GetConfigDir() string {
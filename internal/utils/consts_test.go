@@ -37,7 +37,7 @@ func TestGetDirs(t *testing.T) {
 		fn   func() string
 	}{
 		{"GetTempDir", utils.GetTempDir},
-		{"GetCattNowPlayingFile", utils.GetCattNowPlayingFile},
+		{"GetCastNowPlayingFile", utils.GetCastNowPlayingFile},
 		{"GetConfigDir", utils.GetConfigDir},
 	}
 
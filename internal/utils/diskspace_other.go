@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package utils
+
+import (
+	"errors"
+	"runtime"
+)
+
+// GetDiskSpace is not supported on this platform
+func GetDiskSpace(path string) (DiskSpace, error) {
+	return DiskSpace{}, errors.New("disk space is not available on " + runtime.GOOS)
+}
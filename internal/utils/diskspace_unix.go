@@ -0,0 +1,18 @@
+//go:build linux || darwin || freebsd
+
+package utils
+
+import "syscall"
+
+// GetDiskSpace returns the size of the filesystem path is on and the space available
+// to unprivileged users
+func GetDiskSpace(path string) (DiskSpace, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{
+		Total: int64(st.Blocks) * int64(st.Bsize),
+		Free:  int64(st.Bavail) * int64(st.Bsize),
+	}, nil
+}
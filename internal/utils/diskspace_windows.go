@@ -0,0 +1,30 @@
+//go:build windows
+
+package utils
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// GetDiskSpace returns the size of the volume path is on and the space available to
+// the current user
+func GetDiskSpace(path string) (DiskSpace, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return DiskSpace{}, err
+	}
+	var free, total, totalFree uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(
+		uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&free)),
+		uintptr(unsafe.Pointer(&total)),
+		uintptr(unsafe.Pointer(&totalFree)),
+	)
+	if r == 0 {
+		return DiskSpace{}, err
+	}
+	return DiskSpace{Total: int64(total), Free: int64(free)}, nil
+}
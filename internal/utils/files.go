@@ -357,6 +357,12 @@ func GetMountPoint(path string) (string, error) {
 	}
 }
 
+// DiskSpace is the size of a filesystem and its space available for new files
+type DiskSpace struct {
+	Total int64
+	Free  int64
+}
+
 // MoveFile moves a file from source to destination, handling cross-filesystem moves
 func MoveFile(src, dst string) error {
 	// Capture source timestamps before any operations
@@ -414,6 +420,59 @@ func MoveFile(src, dst string) error {
 	return os.Remove(src)
 }
 
+// CopyFileVerified copies src to dst and compares the sha256 of both before dst is put
+// in place, so that a failed or interrupted copy never leaves a partial dst behind.
+// It returns the hash
+func CopyFileVerified(src, dst string) (string, error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return "", err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+
+	h := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, h))
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	srcHash := hex.EncodeToString(h.Sum(nil))
+	dstHash, err := FullHashFile(tmp)
+	if err != nil {
+		return "", err
+	}
+	if srcHash != dstHash {
+		return "", fmt.Errorf("checksum mismatch copying %s to %s", src, dst)
+	}
+
+	_ = os.Chmod(tmp, info.Mode().Perm())
+	_ = os.Chtimes(tmp, GetAccessTime(info), info.ModTime())
+	if err := os.Rename(tmp, dst); err != nil {
+		return "", err
+	}
+	return srcHash, nil
+}
+
 // Rename renames a file, respecting simulation mode
 func Rename(flags models.GlobalFlags, src, dst string) error {
 	if flags.Simulate {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/chapmanjacobd/discoteca/internal/models"
 	"github.com/chapmanjacobd/discoteca/internal/utils"
@@ -323,3 +324,32 @@ func TestMoveFile(t *testing.T) {
 		t.Errorf("expected content %q, got %q", content, string(got))
 	}
 }
+
+func TestCopyFileVerified(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	dst := filepath.Join(tempDir, "sub", "dst.txt")
+	os.WriteFile(src, []byte("copy test"), 0o644)
+	mtime := time.Unix(1_600_000_000, 0)
+	os.Chtimes(src, mtime, mtime)
+
+	hash, err := utils.CopyFileVerified(src, dst)
+	if err != nil {
+		t.Fatalf("utils.CopyFileVerified failed: %v", err)
+	}
+	if want, _ := utils.FullHashFile(src); hash != want {
+		t.Errorf("expected hash %s, got %s", want, hash)
+	}
+	if got, _ := os.ReadFile(dst); string(got) != "copy test" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if info, _ := os.Stat(dst); !info.ModTime().Equal(mtime) {
+		t.Errorf("expected mtime %v, got %v", mtime, info.ModTime())
+	}
+	if utils.FileExists(dst + ".part") {
+		t.Error("expected the partial file to be gone")
+	}
+	if !utils.FileExists(src) {
+		t.Error("expected the source to be kept")
+	}
+}
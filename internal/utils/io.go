@@ -26,6 +26,17 @@ func DirExists(path string) bool {
 	return err == nil && info.IsDir()
 }
 
+// DirHasEntries reports whether path is a readable folder with anything in it
+func DirHasEntries(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(1)
+	return err == nil && len(names) > 0
+}
+
 func GetDefaultBrowser() string {
 	switch runtime.GOOS {
 	case "linux":
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chapmanjacobd/discoteca/internal/models"
+)
+
+// linkFallbacks lists, for each requested link kind, the kinds to try in order.
+// Reflinks and hardlinks only work within one filesystem; symlinks work anywhere.
+// A reflink never falls back to a hardlink: the copies would stop being independent
+var linkFallbacks = map[string][]string{
+	"reflink": {"reflink", "symlink"},
+	"hard":    {"hard", "symlink"},
+	"symlink": {"symlink"},
+}
+
+// LinkFile atomically replaces dup with a link of the given kind (hard, reflink or
+// symlink) to keep. When that kind is not possible, e.g. across filesystems, the next
+// kind in linkFallbacks is used. It returns the kind of link that was made, which the
+// caller should report when it differs from the one asked for
+func LinkFile(kind, keep, dup string) (string, error) {
+	kinds, ok := linkFallbacks[kind]
+	if !ok {
+		return "", fmt.Errorf("unknown link type: %s", kind)
+	}
+	keep, err := filepath.Abs(keep)
+	if err != nil {
+		return "", err
+	}
+
+	keepMount, err := GetMountPoint(keep)
+	if err != nil {
+		return "", err
+	}
+	dupMount, err := GetMountPoint(dup)
+	if err != nil {
+		return "", err
+	}
+	sameFS := keepMount == dupMount
+
+	// Build the link next to dup, then rename it over dup so that dup is never missing
+	tmp := filepath.Join(filepath.Dir(dup), "."+filepath.Base(dup)+".disco-link")
+	var lastErr error
+	for _, k := range kinds {
+		if k != "symlink" && !sameFS {
+			continue
+		}
+		_ = os.Remove(tmp)
+		switch k {
+		case "hard":
+			lastErr = os.Link(keep, tmp)
+		case "reflink":
+			lastErr = reflinkFile(keep, tmp)
+		case "symlink":
+			lastErr = os.Symlink(keep, tmp)
+		}
+		if lastErr != nil {
+			models.Log.Debug("link failed", "type", k, "path", dup, "error", lastErr)
+			continue
+		}
+		if err := os.Rename(tmp, dup); err != nil {
+			_ = os.Remove(tmp)
+			return "", err
+		}
+		return k, nil
+	}
+	_ = os.Remove(tmp)
+	return "", fmt.Errorf("could not link %s to %s: %w", dup, keep, lastErr)
+}
+
+// reflinkFile creates dst as a copy-on-write clone of src
+func reflinkFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = cloneFile(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
+	}
+	_ = os.Chtimes(dst, GetAccessTime(info), info.ModTime())
+	return nil
+}
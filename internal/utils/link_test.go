@@ -0,0 +1,62 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+func TestLinkFile(t *testing.T) {
+	for _, kind := range []string{"hard", "reflink", "symlink"} {
+		t.Run(kind, func(t *testing.T) {
+			dir := t.TempDir()
+			keep := filepath.Join(dir, "keep.mkv")
+			dup := filepath.Join(dir, "dup.mkv")
+			if err := os.WriteFile(keep, []byte("same"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(dup, []byte("same"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			used, err := utils.LinkFile(kind, keep, dup)
+			if err != nil {
+				t.Fatalf("LinkFile failed: %v", err)
+			}
+			// Reflinks fall back to symlinks on filesystems without FICLONE
+			if used != kind && (kind != "reflink" || used != "symlink") {
+				t.Errorf("expected %s link, got %s", kind, used)
+			}
+
+			data, err := os.ReadFile(dup)
+			if err != nil || string(data) != "same" {
+				t.Errorf("duplicate unreadable after linking: %q %v", data, err)
+			}
+			keepInfo, _ := os.Stat(keep)
+			dupInfo, _ := os.Lstat(dup)
+			switch used {
+			case "hard":
+				if !os.SameFile(keepInfo, dupInfo) {
+					t.Error("expected a hardlink")
+				}
+			case "symlink":
+				if dupInfo.Mode()&os.ModeSymlink == 0 {
+					t.Error("expected a symlink")
+				}
+			}
+
+			entries, _ := os.ReadDir(dir)
+			if len(entries) != 2 {
+				t.Errorf("expected no temporary files left, got %d entries", len(entries))
+			}
+		})
+	}
+
+	t.Run("UnknownType", func(t *testing.T) {
+		if _, err := utils.LinkFile("junction", "a", "b"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
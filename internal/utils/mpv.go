@@ -6,10 +6,10 @@ import (
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -35,17 +35,6 @@ func GetMpvSocketPath(provided string) string {
 	return GetMpvWatchSocket()
 }
 
-// CastCommand builds and executes a catt command with optional device specification
-func CastCommand(ctx context.Context, castDevice string, args ...string) error {
-	cmdArgs := []string{"catt"}
-	if castDevice != "" {
-		cmdArgs = append(cmdArgs, "-d", castDevice)
-	}
-	cmdArgs = append(cmdArgs, args...)
-	cmd := exec.CommandContext(ctx, cmdArgs[0], cmdArgs[1:]...)
-	return cmd.Run()
-}
-
 // MpvCall sends a command to mpv via IPC socket and returns the response
 func MpvCall(ctx context.Context, socketPath string, args ...any) (*MpvResponse, error) {
 	dialCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
@@ -87,6 +76,77 @@ func MpvCall(ctx context.Context, socketPath string, args ...any) (*MpvResponse,
 	return nil, scanner.Err()
 }
 
+// MpvEvent is an asynchronous message from mpv IPC such as a property-change
+type MpvEvent struct {
+	Event string `json:"event"`
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Data  any    `json:"data"`
+}
+
+// dialMpvWhenReady retries until mpv has created and is listening on the socket
+func dialMpvWhenReady(ctx context.Context, socketPath string) (net.Conn, error) {
+	for {
+		dialCtx, cancel := context.WithTimeout(ctx, time.Second)
+		conn, err := (&net.Dialer{}).DialContext(dialCtx, "unix", socketPath)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// ObserveMpv waits for mpv to open its IPC socket, observes the named properties,
+// and calls fn for every property-change until mpv exits or ctx is cancelled
+func ObserveMpv(ctx context.Context, socketPath string, properties []string, fn func(MpvEvent)) error {
+	conn, err := dialMpvWhenReady(ctx, socketPath)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for i, name := range properties {
+		jsonData, err := json.Marshal(MpvCommand{Command: []any{"observe_property", i + 1, name}})
+		if err != nil {
+			return err
+		}
+		if _, err := conn.Write(append(jsonData, '\n')); err != nil {
+			return err
+		}
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var ev MpvEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			continue
+		}
+		if ev.Event == "property-change" {
+			fn(ev)
+		}
+	}
+
+	// mpv closing the socket on exit, or our own close on cancel, is the normal end of a session
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
 // MpvSetProperty sets a property in mpv
 func MpvSetProperty(ctx context.Context, socketPath, name string, value any) error {
 	_, err := MpvCall(ctx, socketPath, "set_property", name, value)
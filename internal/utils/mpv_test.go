@@ -197,3 +197,78 @@ func TestMpvArgsToMap(t *testing.T) {
 		}
 	}
 }
+
+func TestObserveMpv(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "mpv-observe.sock")
+
+	observed := make(chan []any, 1)
+	ready := make(chan net.Listener, 1)
+	go func() {
+		// Start listening late to exercise waiting for mpv to create the socket
+		time.Sleep(200 * time.Millisecond)
+		ln, err := net.Listen("unix", socketPath)
+		if err != nil {
+			t.Error(err)
+			close(ready)
+			return
+		}
+		ready <- ln
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		var names []any
+		for len(names) < 2 && scanner.Scan() {
+			var cmd utils.MpvCommand
+			json.Unmarshal(scanner.Bytes(), &cmd)
+			if len(cmd.Command) == 3 && cmd.Command[0] == "observe_property" {
+				names = append(names, cmd.Command[2])
+			}
+		}
+		observed <- names
+
+		conn.Write([]byte(`{"request_id":0,"error":"success"}` + "\n"))
+		conn.Write([]byte(`{"event":"property-change","id":1,"name":"time-pos","data":12.5}` + "\n"))
+		conn.Write([]byte(`{"event":"end-file"}` + "\n"))
+		conn.Write([]byte(`{"event":"property-change","id":2,"name":"pause","data":true}` + "\n"))
+	}()
+
+	var events []utils.MpvEvent
+	err := utils.ObserveMpv(context.Background(), socketPath, []string{"time-pos", "pause"}, func(ev utils.MpvEvent) {
+		events = append(events, ev)
+	})
+	if err != nil {
+		t.Fatalf("ObserveMpv failed: %v", err)
+	}
+	if ln, ok := <-ready; ok {
+		ln.Close()
+	}
+
+	if names := <-observed; len(names) != 2 || names[0] != "time-pos" || names[1] != "pause" {
+		t.Errorf("Unexpected observe_property commands: %v", names)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 property-change events, got %+v", events)
+	}
+	if events[0].Name != "time-pos" || events[0].Data.(float64) != 12.5 {
+		t.Errorf("Unexpected first event: %+v", events[0])
+	}
+	if events[1].Name != "pause" || events[1].Data != true {
+		t.Errorf("Unexpected second event: %+v", events[1])
+	}
+}
+
+func TestObserveMpvCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	// Nothing ever listens: ObserveMpv should give up quietly when the player exits
+	err := utils.ObserveMpv(ctx, filepath.Join(t.TempDir(), "missing.sock"), []string{"time-pos"}, func(utils.MpvEvent) {})
+	if err != nil {
+		t.Errorf("Expected nil on cancel, got %v", err)
+	}
+}
@@ -221,3 +221,30 @@ func FromURL(urlPath string) string {
 	}
 	return clean
 }
+
+// SeparatorOf returns the separator style of a path: '\\' for paths that only use
+// backslashes, or that start with a drive letter or UNC prefix and do not only use
+// forward slashes. Otherwise '/'.
+func SeparatorOf(path string) byte {
+	hasBack := strings.Contains(path, "\\")
+	hasFwd := strings.Contains(path, "/")
+	windowsPrefix := (len(path) >= 2 && path[1] == ':') || strings.HasPrefix(path, "\\\\")
+	switch {
+	case hasBack && !hasFwd:
+		return '\\'
+	case hasFwd && !hasBack:
+		return '/'
+	case windowsPrefix:
+		return '\\'
+	default:
+		return '/'
+	}
+}
+
+// ToSeparator converts every forward and back slash in path to sep.
+func ToSeparator(path string, sep byte) string {
+	if sep == '\\' {
+		return strings.ReplaceAll(path, "/", "\\")
+	}
+	return strings.ReplaceAll(path, "\\", "/")
+}
@@ -486,3 +486,33 @@ func TestToURLFromURLRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestSeparatorOf(t *testing.T) {
+	tests := []struct {
+		path string
+		want byte
+	}{
+		{"/mnt/media", '/'},
+		{"C:\\Users\\user", '\\'},
+		{"\\\\server\\share", '\\'},
+		{"C:/Users/user", '/'},
+		{"C:\\Users/mixed", '\\'},
+		{"dir/sub\\mixed", '/'},
+		{"C:", '\\'},
+		{"", '/'},
+	}
+	for _, tt := range tests {
+		if got := pathutil.SeparatorOf(tt.path); got != tt.want {
+			t.Errorf("SeparatorOf(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestToSeparator(t *testing.T) {
+	if got := pathutil.ToSeparator("a/b\\c", '\\'); got != "a\\b\\c" {
+		t.Errorf("ToSeparator to backslash = %q", got)
+	}
+	if got := pathutil.ToSeparator("a/b\\c", '/'); got != "a/b/c" {
+		t.Errorf("ToSeparator to slash = %q", got)
+	}
+}
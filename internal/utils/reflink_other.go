@@ -0,0 +1,13 @@
+//go:build !linux
+
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// cloneFile is only implemented on Linux
+func cloneFile(_, _ *os.File) error {
+	return errors.ErrUnsupported
+}
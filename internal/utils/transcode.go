@@ -18,7 +18,7 @@ type TranscodeStrategy struct {
 	TargetMime     string
 }
 
-func GenerateHLSPlaylist(path string, duration float64, segmentDuration int) string {
+func GenerateHLSPlaylist(path string, duration float64, segmentDuration int, extraQuery string) string {
 	segments := int(math.Ceil(duration / float64(segmentDuration)))
 
 	var sb strings.Builder
@@ -37,7 +37,7 @@ func GenerateHLSPlaylist(path string, duration float64, segmentDuration int) str
 			}
 		}
 		fmt.Fprintf(&sb, "#EXTINF:%f,\n", segDuration)
-		fmt.Fprintf(&sb, "/api/hls/segment?path=%s&index=%d\n", url.QueryEscape(path), i)
+		fmt.Fprintf(&sb, "/api/hls/segment?path=%s&index=%d%s\n", url.QueryEscape(path), i, extraQuery)
 	}
 
 	sb.WriteString("#EXT-X-ENDLIST\n")
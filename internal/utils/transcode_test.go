@@ -13,7 +13,7 @@ func TestGenerateHLSPlaylist(t *testing.T) {
 	duration := 15.0
 	segmentDuration := 6
 
-	playlist := utils.GenerateHLSPlaylist(path, duration, segmentDuration)
+	playlist := utils.GenerateHLSPlaylist(path, duration, segmentDuration, "")
 
 	if !strings.Contains(playlist, "#EXTM3U") {
 		t.Error("Playlist missing #EXTM3U")
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"path/filepath"
+)
+
+// Volume identifies the filesystem a path is on
+type Volume struct {
+	MountPoint string
+	UUID       string // filesystem UUID, when known
+	Label      string
+	Source     string // device or network share, e.g. /dev/sdb1 or nas:/media
+	FSType     string
+}
+
+// ID returns the most specific identity known for the volume
+func (v Volume) ID() string {
+	switch {
+	case v.UUID != "":
+		return v.UUID
+	case v.Label != "":
+		return v.Label
+	default:
+		return v.Source
+	}
+}
+
+// GetVolume returns the volume path is on
+func GetVolume(path string) (Volume, error) {
+	mountPoint, err := GetMountPoint(path)
+	if err != nil {
+		return Volume{}, err
+	}
+	v := Volume{MountPoint: mountPoint}
+	readVolumeInfo(&v)
+	return v, nil
+}
+
+// VolumeMounted reports whether the volume recorded as v is mounted at its mount point.
+// A mount point directory that is left behind by an unplugged drive belongs to the
+// parent filesystem, and a different drive mounted there has a different identity
+func VolumeMounted(v Volume) bool {
+	current, err := GetVolume(v.MountPoint)
+	if err != nil {
+		return false
+	}
+	if filepath.Clean(current.MountPoint) != filepath.Clean(v.MountPoint) {
+		return false
+	}
+	// Compare the most specific identity both sides know
+	for _, ids := range [][2]string{{v.UUID, current.UUID}, {v.Label, current.Label}, {v.Source, current.Source}} {
+		if ids[0] != "" && ids[1] != "" {
+			return ids[0] == ids[1]
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+//go:build linux
+
+package utils
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// readVolumeInfo fills in the filesystem type and source of v's mount point from
+// /proc/self/mountinfo, and the UUID and label from the /dev/disk symlinks
+func readVolumeInfo(v *Volume) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		pre, post, ok := strings.Cut(scanner.Text(), " - ")
+		if !ok {
+			continue
+		}
+		fields, postFields := strings.Fields(pre), strings.Fields(post)
+		if len(fields) < 5 || len(postFields) < 2 {
+			continue
+		}
+		// Later entries are mounted over earlier ones
+		if unescapeMountInfo(fields[4]) == filepath.Clean(v.MountPoint) {
+			v.FSType = postFields[0]
+			v.Source = unescapeMountInfo(postFields[1])
+		}
+	}
+
+	if !strings.HasPrefix(v.Source, "/dev/") {
+		return
+	}
+	device, err := filepath.EvalSymlinks(v.Source)
+	if err != nil {
+		return
+	}
+	v.UUID = diskLinkName("/dev/disk/by-uuid", device)
+	v.Label = diskLinkName("/dev/disk/by-label", device)
+}
+
+// diskLinkName returns the name of the link in dir that points to device
+func diskLinkName(dir, device string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name()))
+		if err == nil && target == device {
+			return unescapeMountInfo(e.Name())
+		}
+	}
+	return ""
+}
+
+// unescapeMountInfo decodes the octal (\040) and hex (\x20) escapes used in
+// mountinfo fields and udev link names
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if n, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		if s[i] == '\\' && i+3 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
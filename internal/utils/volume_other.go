@@ -0,0 +1,6 @@
+//go:build !linux
+
+package utils
+
+// readVolumeInfo has nothing to add to the mount point outside Linux
+func readVolumeInfo(_ *Volume) {}
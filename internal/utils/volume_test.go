@@ -0,0 +1,36 @@
+package utils_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/chapmanjacobd/discoteca/internal/utils"
+)
+
+func TestVolumeMounted(t *testing.T) {
+	v, err := utils.GetVolume(t.TempDir())
+	if err != nil {
+		t.Fatalf("GetVolume failed: %v", err)
+	}
+	if v.MountPoint == "" {
+		t.Fatal("expected a mount point")
+	}
+	if !utils.VolumeMounted(v) {
+		t.Errorf("expected the volume of the temp dir to be mounted: %+v", v)
+	}
+
+	// A plain directory is not a mount point, like one left behind by an unplugged drive
+	unplugged := v
+	unplugged.MountPoint = filepath.Join(t.TempDir(), "drive")
+	if utils.VolumeMounted(unplugged) {
+		t.Error("expected a directory that is not a mount point to be unmounted")
+	}
+
+	if v.ID() != "" {
+		other := v
+		other.UUID, other.Label, other.Source = "other-uuid", "other", "other"
+		if utils.VolumeMounted(other) {
+			t.Error("expected a different volume at the mount point to be unmounted")
+		}
+	}
+}
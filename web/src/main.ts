@@ -76,6 +76,9 @@ document.addEventListener('DOMContentLoaded', () => {
     const historyInProgressBtn = document.getElementById('history-in-progress-btn');
     const historyUnplayedBtn = document.getElementById('history-unplayed-btn');
     const historyCompletedBtn = document.getElementById('history-completed-btn');
+    const integrityFailedBtn = document.getElementById('integrity-failed-btn');
+    const damagedBtn = document.getElementById('damaged-btn');
+    const uncheckedBtn = document.getElementById('unchecked-btn');
 
     const allMediaBtn = document.getElementById('all-media-btn');
     const trashBtn = document.getElementById('trash-btn');
@@ -411,7 +414,8 @@ document.addEventListener('DOMContentLoaded', () => {
                 created_percentiles: data.created_percentiles || [],
                 downloaded_percentiles: data.downloaded_percentiles || [],
                 // Type counts (special case)
-                media_type: data.media_type || []
+                media_type: data.media_type || [],
+                damaged: data.damaged || []
             };
 
             updateSlidersFromAbsolute('episodes', 'episodes');
@@ -463,7 +467,13 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     function renderFilterBins() {
-        // Sliders are static in index.html, no need to render bins here.
+        // Sliders are static in index.html; only the media-check counts need updating
+        const damagedBins = state.filterBins.damaged || [];
+        const countOf = (label) => damagedBins.find(b => b.label === label)?.value || 0;
+        const damagedCount = document.getElementById('damaged-count');
+        const uncheckedCount = document.getElementById('unchecked-count');
+        if (damagedCount) damagedCount.textContent = damagedBins.length ? `(${countOf('damaged')})` : '';
+        if (uncheckedCount) uncheckedCount.textContent = damagedBins.length ? `(${countOf('unchecked')})` : '';
     }
 
     function resetSidebar() {
@@ -485,6 +495,9 @@ document.addEventListener('DOMContentLoaded', () => {
         state.filters.unplayed = false;
         state.filters.unfinished = false;
         state.filters.completed = false;
+        state.filters.integrityFailed = false;
+        state.filters.damaged = false;
+        state.filters.unchecked = false;
         if (searchInput) (searchInput as HTMLInputElement).value = '';
 
         details.forEach(det => {
@@ -516,6 +529,9 @@ document.addEventListener('DOMContentLoaded', () => {
         state.filters.unplayed = false;
         state.filters.unfinished = false;
         state.filters.completed = false;
+        state.filters.integrityFailed = false;
+        state.filters.damaged = false;
+        state.filters.unchecked = false;
 
         // Clear sidebar filter UI
         document.querySelectorAll('#sidebar .category-btn.active').forEach(btn => {
@@ -764,6 +780,9 @@ document.addEventListener('DOMContentLoaded', () => {
         if (state.filters.unplayed) params.append('unplayed', String('true'));
         if (state.filters.unfinished) params.append('unfinished', String('true'));
         if (state.filters.completed) params.append('completed', String('true'));
+        if (state.filters.integrityFailed) params.append('integrity', String('failed'));
+        if (state.filters.damaged) params.append('corrupt', String('>0'));
+        if (state.filters.unchecked) params.append('unchecked', String('true'));
         if (state.filters.min_score) params.append('min_score', String(state.filters.min_score));
         if (state.filters.max_score) params.append('max_score', String(state.filters.max_score));
 
@@ -1788,7 +1807,8 @@ document.addEventListener('DOMContentLoaded', () => {
                     created_percentiles: response.counts.created_percentiles || [],
                     downloaded_percentiles: response.counts.downloaded_percentiles || [],
                     // Type counts (special case)
-                    media_type: response.counts.media_type || []
+                    media_type: response.counts.media_type || [],
+                    damaged: response.counts.damaged || []
                 };
 
                 updateSlidersFromAbsolute('episodes', 'episodes');
@@ -5841,6 +5861,7 @@ document.addEventListener('DOMContentLoaded', () => {
         (card as HTMLElement).dataset.path = item.path;
         (card as HTMLElement).dataset.media_type = item.media_type || '';
         if (item.is_dir) (card as HTMLElement).dataset.isDir = 'true';
+        if (item.offline) card.classList.add('is-offline');
         (card as any)._item = item;
         card.draggable = true;
 
@@ -5944,6 +5965,7 @@ document.addEventListener('DOMContentLoaded', () => {
             <div class="media-thumb ${item.is_dir ? '' : 'skeleton'}">
                 ${thumbHtml}
                 ${duration ? `<span class="media-duration">${duration}</span>` : ''}
+                ${item.offline ? `<span class="media-offline" title="The volume holding this file is not mounted">Offline</span>` : ''}
                 <div class="media-actions">
                     ${actionBtns}
                 </div>
@@ -6576,6 +6598,7 @@ document.addEventListener('DOMContentLoaded', () => {
                         <span class="table-icon">${getIcon(item.media_type)}</span>
                         <div style="display: flex; flex-direction: column;">
                             <span class="media-title-span">${title}</span>
+                            ${item.offline ? `<span class="table-offline" title="The volume holding this file is not mounted">Offline</span>` : ''}
 
                         </div>
                     </div>
@@ -8459,7 +8482,7 @@ document.addEventListener('DOMContentLoaded', () => {
 
         // Note: Bin buttons removed - sliders now use percentile-based ranges
 
-        if (allMediaBtn) allMediaBtn.classList.toggle('active', state.page === 'search' && state.filters.categories.length === 0 && state.filters.genre === '' && state.filters.languages.length === 0 && state.filters.ratings.length === 0 && !state.filters.playlist && !state.filters.unplayed && !state.filters.unfinished && !state.filters.completed && state.filters.sizes.length === 0 && state.filters.durations.length === 0 && state.filters.episodes.length === 0 && state.filters.media_types.length === 0);
+        if (allMediaBtn) allMediaBtn.classList.toggle('active', state.page === 'search' && state.filters.categories.length === 0 && state.filters.genre === '' && state.filters.languages.length === 0 && state.filters.ratings.length === 0 && !state.filters.playlist && !state.filters.unplayed && !state.filters.unfinished && !state.filters.completed && !state.filters.integrityFailed && !state.filters.damaged && !state.filters.unchecked && state.filters.sizes.length === 0 && state.filters.durations.length === 0 && state.filters.episodes.length === 0 && state.filters.media_types.length === 0);
         if (trashBtn) trashBtn.classList.toggle('active', state.page === 'trash');
         if (duBtn) duBtn.classList.toggle('active', state.page === 'du');
         if (captionsBtn) captionsBtn.classList.toggle('active', state.page === 'captions');
@@ -8467,6 +8490,9 @@ document.addEventListener('DOMContentLoaded', () => {
         if (historyInProgressBtn) historyInProgressBtn.classList.toggle('active', state.filters.unfinished);
         if (historyUnplayedBtn) historyUnplayedBtn.classList.toggle('active', state.filters.unplayed);
         if (historyCompletedBtn) historyCompletedBtn.classList.toggle('active', state.filters.completed);
+        if (integrityFailedBtn) integrityFailedBtn.classList.toggle('active', state.filters.integrityFailed);
+        if (damagedBtn) damagedBtn.classList.toggle('active', state.filters.damaged);
+        if (uncheckedBtn) uncheckedBtn.classList.toggle('active', state.filters.unchecked);
 
         // View Toggles
         if (viewGrid) viewGrid.classList.toggle('active', state.view === 'grid');
@@ -8480,7 +8506,7 @@ document.addEventListener('DOMContentLoaded', () => {
         // Handle playlists and categories in the sidebar lists
         document.querySelectorAll('#sidebar .category-btn').forEach(btn => {
             if (btn === allMediaBtn || btn === trashBtn || btn === duBtn || btn === captionsBtn || btn === historyInProgressBtn || btn === historyUnplayedBtn || btn === historyCompletedBtn) return;
-            if (btn.closest('#media-type-list')) return;
+            if (btn.closest('#media-type-list') || btn.closest('#health-list')) return;
             if (btn.closest('#episodes-list') || btn.closest('#size-list') || btn.closest('#duration-list')) return;
 
             const cat = (btn as any).dataset.cat;
@@ -8620,6 +8646,36 @@ document.addEventListener('DOMContentLoaded', () => {
         };
     }
 
+    if (integrityFailedBtn) {
+        integrityFailedBtn.onclick = () => {
+            state.filters.integrityFailed = !state.filters.integrityFailed;
+            state.currentPage = 1;
+            updateNavActiveStates();
+            performSearch();
+        };
+    }
+
+    if (damagedBtn) {
+        damagedBtn.onclick = () => {
+            // Mutually exclusive with unchecked, which has no result to be damaged
+            state.filters.damaged = !state.filters.damaged;
+            if (state.filters.damaged) state.filters.unchecked = false;
+            state.currentPage = 1;
+            updateNavActiveStates();
+            performSearch();
+        };
+    }
+
+    if (uncheckedBtn) {
+        uncheckedBtn.onclick = () => {
+            state.filters.unchecked = !state.filters.unchecked;
+            if (state.filters.unchecked) state.filters.damaged = false;
+            state.currentPage = 1;
+            updateNavActiveStates();
+            performSearch();
+        };
+    }
+
     if (duBtn) {
         duBtn.onclick = () => {
             if (state.page === 'du') {
@@ -43,6 +43,9 @@ export const state: State = {
         unplayed: getLocalStorageItem('disco-unplayed') === 'true',
         unfinished: false,
         completed: false,
+        integrityFailed: false,
+        damaged: false,
+        unchecked: false,
         captions: false,
         searchType: (getLocalStorageItem('disco-search-type', 'fts') as 'fts' | 'substring'),
         browseCol: '',
@@ -108,7 +111,8 @@ export const state: State = {
         modified_percentiles: [],
         created_percentiles: [],
         downloaded_percentiles: [],
-        media_type: []
+        media_type: [],
+        damaged: []
     },
     playlists: [],
     newCategories: [],
@@ -20,6 +20,7 @@ export interface MediaItem {
     similarity?: number;
     parent_path?: string;
     transcode?: boolean;
+    offline?: boolean;
 }
 
 export interface FilterBin {
@@ -54,6 +55,9 @@ export interface FilterBins {
 
     // MediaType counts (special case - not a percentile distribution)
     media_type: FilterBin[];
+
+    // Media-check counts: unchecked, ok and damaged
+    damaged: FilterBin[];
 }
 
 export interface PlaybackState {
@@ -121,6 +125,9 @@ export interface State {
         unplayed: boolean;
         unfinished: boolean;
         completed: boolean;
+        integrityFailed: boolean;
+        damaged: boolean;
+        unchecked: boolean;
         captions: boolean;
         searchType: 'fts' | 'substring';
         browseCol: string;